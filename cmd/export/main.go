@@ -0,0 +1,90 @@
+// Command export writes the consented, labeled training set as x_new/y_new matrices,
+// ready to send to the ML service's UpdateModel call or to train offline.
+//
+//	go run ./cmd/export -out training.json
+package main
+
+import (
+	"diabetify/database"
+	"diabetify/internal/ml"
+	"diabetify/internal/repository"
+	"encoding/json"
+	"flag"
+	"io"
+	"log"
+	"os"
+
+	"github.com/joho/godotenv"
+)
+
+func init() {
+	// Load .env file from project root
+	if err := godotenv.Load(); err != nil {
+		// Try loading from parent directory (in case running from cmd/export/)
+		if err := godotenv.Load("../../.env"); err != nil {
+			log.Printf("Warning: No .env file found: %v", err)
+		}
+	}
+}
+
+// trainingSet mirrors the x_new/y_new fields of models.UpdateModelRequest
+type trainingSet struct {
+	FeatureSchemaVersion string      `json:"feature_schema_version"`
+	FeatureNames         []string    `json:"feature_names"`
+	XNew                 [][]float64 `json:"x_new"`
+	YNew                 []float64   `json:"y_new"`
+}
+
+func main() {
+	out := flag.String("out", "", "Output file (default: stdout)")
+	limit := flag.Int("limit", 100000, "Maximum number of samples")
+	useSharding := flag.Bool("sharded", os.Getenv("USE_SHARDING") == "true", "Read from all shards")
+	flag.Parse()
+
+	var outcomeRepo repository.OutcomeRepository
+	if *useSharding {
+		database.ConnectShardedDatabase()
+		outcomeRepo = repository.NewShardedOutcomeRepository()
+	} else {
+		database.ConnectDatabase()
+		outcomeRepo = repository.NewOutcomeRepository(database.DB)
+	}
+
+	samples, err := outcomeRepo.GetConsentedLabeledSamples(*limit)
+	if err != nil {
+		log.Fatalf("Failed to load labeled samples: %v", err)
+	}
+
+	set := trainingSet{
+		FeatureSchemaVersion: ml.FeatureSchemaVersion,
+		FeatureNames:         ml.FeatureNames,
+		XNew:                 make([][]float64, 0, len(samples)),
+		YNew:                 make([]float64, 0, len(samples)),
+	}
+	positives := 0
+	for _, sample := range samples {
+		set.XNew = append(set.XNew, sample.Features)
+		set.YNew = append(set.YNew, sample.Label)
+		if sample.Label == 1 {
+			positives++
+		}
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			log.Fatalf("Failed to create %s: %v", *out, err)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(set); err != nil {
+		log.Fatalf("Failed to write training set: %v", err)
+	}
+
+	log.Printf("Exported %d labeled samples (%d positive)", len(samples), positives)
+}
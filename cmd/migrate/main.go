@@ -0,0 +1,49 @@
+// Command migrate runs the migrations that are not safe to run on startup, because
+// binaries still serving traffic may depend on what they remove.
+//
+//	go run ./cmd/migrate -drop-legacy-prediction-columns
+package main
+
+import (
+	"diabetify/database"
+	"flag"
+	"log"
+	"os"
+
+	"github.com/joho/godotenv"
+)
+
+func init() {
+	// Load .env file from project root
+	if err := godotenv.Load(); err != nil {
+		// Try loading from parent directory (in case running from cmd/migrate/)
+		if err := godotenv.Load("../../.env"); err != nil {
+			log.Printf("Warning: No .env file found: %v", err)
+		}
+	}
+}
+
+func main() {
+	dropLegacyPredictionColumns := flag.Bool("drop-legacy-prediction-columns", false,
+		"Drop the per-feature prediction columns once every instance reads prediction_factors")
+	useSharding := flag.Bool("sharded", os.Getenv("USE_SHARDING") == "true", "Migrate every shard")
+	flag.Parse()
+
+	if !*dropLegacyPredictionColumns {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var err error
+	if *useSharding {
+		database.ConnectShardedDatabase()
+		err = database.DropLegacyPredictionColumnsOnAllShards()
+	} else {
+		database.ConnectDatabase()
+		err = database.DropLegacyPredictionColumns()
+	}
+	if err != nil {
+		log.Fatalf("Failed to drop legacy prediction columns: %v", err)
+	}
+	log.Println("Legacy prediction columns dropped")
+}
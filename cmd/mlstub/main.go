@@ -0,0 +1,43 @@
+// Command mlstub serves an exported model over the ML gRPC API so the API server's
+// gRPC transport can be exercised without the Python ML service.
+package main
+
+import (
+	"diabetify/internal/ml"
+	"diabetify/internal/ml/pb"
+	"flag"
+	"log"
+	"net"
+
+	"google.golang.org/grpc"
+)
+
+func main() {
+	addr := flag.String("addr", ":50051", "Address to listen on")
+	modelPath := flag.String("model", "", "Path to the exported model JSON (see ml.LocalModel)")
+	flag.Parse()
+
+	if *modelPath == "" {
+		log.Fatal("--model is required")
+	}
+
+	scorer, err := ml.NewLocalScorerFromFile(*modelPath)
+	if err != nil {
+		log.Fatalf("Failed to load model: %v", err)
+	}
+
+	lis, err := net.Listen("tcp", *addr)
+	if err != nil {
+		log.Fatalf("Failed to listen on %s: %v", *addr, err)
+	}
+
+	server := grpc.NewServer()
+	pb.RegisterMLServiceServer(server, ml.NewScorerServer(scorer))
+
+	name, version := scorer.ModelInfo()
+	log.Printf("ML stub serving %s (%s) on %s", name, version, *addr)
+
+	if err := server.Serve(lis); err != nil {
+		log.Fatalf("gRPC server stopped: %v", err)
+	}
+}
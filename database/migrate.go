@@ -11,6 +11,11 @@ import (
 func MigrateDatabase() error {
 	log.Println("Running database migrations...")
 
+	if err := prepareIdempotencyKeyIndex(DB); err != nil {
+		log.Printf("Error preparing idempotency key index: %v", err)
+		return err
+	}
+
 	err := DB.AutoMigrate(
 		&models.User{},
 		&models.UserProfile{},
@@ -18,8 +23,16 @@ func MigrateDatabase() error {
 		&models.Article{},
 		&models.Verification{},
 		&models.ResetPassword{},
+		&models.Outcome{},
 		&models.Prediction{},
+		&models.PredictionFactor{},
 		&models.PredictionJob{},
+		&models.ModelUpdate{},
+		&models.ModelMonitoringReport{},
+		&models.Notification{},
+		&models.ModelExperimentResult{},
+		&models.WhatIfScenario{},
+		&models.LLMUsage{},
 	)
 
 	if err != nil {
@@ -27,6 +40,11 @@ func MigrateDatabase() error {
 		return err
 	}
 
+	if err := migratePredictionFactors(DB); err != nil {
+		log.Printf("Error migrating prediction factors: %v", err)
+		return err
+	}
+
 	log.Println("Database migrations completed successfully")
 	return nil
 }
@@ -48,6 +66,11 @@ func MigrateAllShards() error {
 func migrateOnShard(db *gorm.DB) error {
 	log.Println("Running database migrations on shard...")
 
+	if err := prepareIdempotencyKeyIndex(db); err != nil {
+		log.Printf("Error preparing idempotency key index on shard: %v", err)
+		return err
+	}
+
 	err := db.AutoMigrate(
 		&models.User{},
 		&models.UserProfile{},
@@ -55,8 +78,16 @@ func migrateOnShard(db *gorm.DB) error {
 		&models.Article{},
 		&models.Verification{},
 		&models.ResetPassword{},
+		&models.Outcome{},
 		&models.Prediction{},
+		&models.PredictionFactor{},
 		&models.PredictionJob{},
+		&models.ModelUpdate{},
+		&models.ModelMonitoringReport{},
+		&models.Notification{},
+		&models.ModelExperimentResult{},
+		&models.WhatIfScenario{},
+		&models.LLMUsage{},
 	)
 
 	if err != nil {
@@ -64,6 +95,160 @@ func migrateOnShard(db *gorm.DB) error {
 		return err
 	}
 
+	if err := migratePredictionFactors(db); err != nil {
+		log.Printf("Error migrating prediction factors on shard: %v", err)
+		return err
+	}
+
 	log.Println("Shard migration completed successfully")
 	return nil
 }
+
+// prepareIdempotencyKeyIndex lets AutoMigrate create the unique (user_id, idempotency_key)
+// index: keys used more than once by a user are kept on their first job only, and the
+// plain index it replaces is dropped
+func prepareIdempotencyKeyIndex(db *gorm.DB) error {
+	if !db.Migrator().HasColumn(&models.PredictionJob{}, "idempotency_key") {
+		return nil
+	}
+
+	err := db.Exec(`
+		UPDATE prediction_jobs SET idempotency_key = NULL
+		WHERE id IN (
+			SELECT id FROM (
+				SELECT id, ROW_NUMBER() OVER (PARTITION BY user_id, idempotency_key ORDER BY created_at, id) AS n
+				FROM prediction_jobs
+				WHERE idempotency_key IS NOT NULL
+			) used WHERE used.n > 1
+		)
+	`).Error
+	if err != nil {
+		return fmt.Errorf("failed to clear duplicate idempotency keys: %w", err)
+	}
+
+	if db.Migrator().HasIndex(&models.PredictionJob{}, "idx_prediction_jobs_idempotency_key") {
+		if err := db.Migrator().DropIndex(&models.PredictionJob{}, "idx_prediction_jobs_idempotency_key"); err != nil {
+			return fmt.Errorf("failed to drop idempotency key index: %w", err)
+		}
+	}
+	return nil
+}
+
+// legacyPredictionFactor is a factor stored as columns of the predictions table before
+// the prediction_factors table existed
+type legacyPredictionFactor struct {
+	factor string
+	// value is the SQL expression of the input value; prefix names the _shap,
+	// _contribution, _impact and _explanation columns
+	value  string
+	prefix string
+}
+
+var legacyPredictionFactors = []legacyPredictionFactor{
+	{"age", "age", "age"},
+	{"smoking_status", "smoking_status", "smoking_status"},
+	{"is_cholesterol", "CASE WHEN is_cholesterol THEN 1 ELSE 0 END", "is_cholesterol"},
+	{"is_macrosomic_baby", "macrosomic_baby", "is_macrosomic_baby"},
+	{"physical_activity_frequency", "physical_activity_frequency", "physical_activity_frequency"},
+	{"is_bloodline", "CASE WHEN is_bloodline THEN 1 ELSE 0 END", "is_bloodline"},
+	{"brinkman_score", "brinkman_score", "brinkman_score"},
+	{"bmi", "bmi", "bmi"},
+	{"is_hypertension", "CASE WHEN is_hypertension THEN 1 ELSE 0 END", "is_hypertension"},
+}
+
+// migratePredictionFactors copies the per-feature columns of predictions into
+// prediction_factors and checks every prediction has every factor. The columns stay,
+// so binaries from before the move keep working, and it runs on every startup while
+// they exist to pick up what those binaries wrote; DropLegacyPredictionColumns removes
+// them once no such binary is left.
+func migratePredictionFactors(db *gorm.DB) error {
+	if !db.Migrator().HasColumn("predictions", "age_shap") {
+		return nil
+	}
+
+	log.Println("Copying prediction factor columns to prediction_factors...")
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, legacy := range legacyPredictionFactors {
+			// Explanations an older binary generated after the copy are filled in too
+			err := tx.Exec(fmt.Sprintf(`
+				INSERT INTO prediction_factors (prediction_id, user_id, factor, value, shap, contribution, impact, explanation)
+				SELECT p.id, p.user_id, ?, COALESCE(%[1]s, 0), COALESCE(%[2]s_shap, 0), COALESCE(%[2]s_contribution, 0),
+					COALESCE(%[2]s_impact, 0), COALESCE(%[2]s_explanation, '')
+				FROM predictions p
+				ON CONFLICT (prediction_id, factor) DO UPDATE SET explanation = EXCLUDED.explanation
+				WHERE prediction_factors.explanation = '' AND EXCLUDED.explanation <> ''
+			`, legacy.value, legacy.prefix), legacy.factor).Error
+			if err != nil {
+				return fmt.Errorf("failed to copy factor %s: %w", legacy.factor, err)
+			}
+		}
+		return verifyPredictionFactors(tx)
+	})
+}
+
+// verifyPredictionFactors fails when a prediction lacks one of the legacy factors
+func verifyPredictionFactors(db *gorm.DB) error {
+	for _, legacy := range legacyPredictionFactors {
+		var missing int64
+		err := db.Raw(`
+			SELECT COUNT(*) FROM predictions p
+			WHERE NOT EXISTS (
+				SELECT 1 FROM prediction_factors f WHERE f.prediction_id = p.id AND f.factor = ?
+			)
+		`, legacy.factor).Scan(&missing).Error
+		if err != nil {
+			return fmt.Errorf("failed to verify factor %s: %w", legacy.factor, err)
+		}
+		if missing > 0 {
+			return fmt.Errorf("%d predictions have no %s factor after the copy", missing, legacy.factor)
+		}
+	}
+	return nil
+}
+
+// DropLegacyPredictionColumns drops the per-feature columns of predictions once their
+// values are in prediction_factors. Run it, through cmd/migrate, after every instance
+// runs a binary that reads prediction_factors: older binaries still need the columns.
+func DropLegacyPredictionColumns() error {
+	return dropLegacyPredictionColumns(DB)
+}
+
+// DropLegacyPredictionColumnsOnAllShards is DropLegacyPredictionColumns for every shard
+func DropLegacyPredictionColumnsOnAllShards() error {
+	if Manager == nil {
+		return fmt.Errorf("shard manager not initialized")
+	}
+	return Manager.ExecuteOnAllShards(dropLegacyPredictionColumns)
+}
+
+func dropLegacyPredictionColumns(db *gorm.DB) error {
+	if !db.Migrator().HasColumn("predictions", "age_shap") {
+		log.Println("Legacy prediction factor columns already dropped")
+		return nil
+	}
+
+	// Copy what was written since the last startup and verify before anything is dropped
+	if err := migratePredictionFactors(db); err != nil {
+		return err
+	}
+
+	log.Println("Dropping legacy prediction factor columns...")
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, legacy := range legacyPredictionFactors {
+			columns := []string{legacy.prefix + "_shap", legacy.prefix + "_contribution", legacy.prefix + "_impact", legacy.prefix + "_explanation"}
+			if legacy.factor == "is_macrosomic_baby" {
+				columns = append(columns, "macrosomic_baby")
+			} else {
+				columns = append(columns, legacy.factor)
+			}
+			for _, column := range columns {
+				if err := tx.Migrator().DropColumn("predictions", column); err != nil {
+					return fmt.Errorf("failed to drop column %s: %w", column, err)
+				}
+			}
+		}
+		return nil
+	})
+}
@@ -2,6 +2,7 @@ package cache
 
 import (
 	"context"
+	"diabetify/internal/models"
 	"encoding/json"
 	"fmt"
 	"os"
@@ -92,6 +93,36 @@ func (r *RedisClient) DeleteWhatIfResult(jobID string) error {
 	return r.client.Del(r.ctx, key).Err()
 }
 
+// StoreExplanation caches a generated explanation under the content hash of its prediction
+func (r *RedisClient) StoreExplanation(hash string, explanation *models.PredictionExplanation, duration time.Duration) error {
+	jsonData, err := json.Marshal(explanation)
+	if err != nil {
+		return fmt.Errorf("failed to marshal explanation: %w", err)
+	}
+
+	if err := r.client.Set(r.ctx, fmt.Sprintf("explanation:%s", hash), jsonData, duration).Err(); err != nil {
+		return fmt.Errorf("failed to store explanation in Redis: %w", err)
+	}
+	return nil
+}
+
+// GetExplanation returns the cached explanation for a content hash
+func (r *RedisClient) GetExplanation(hash string) (*models.PredictionExplanation, bool, error) {
+	data, err := r.client.Get(r.ctx, fmt.Sprintf("explanation:%s", hash)).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, false, nil
+		}
+		return nil, false, fmt.Errorf("failed to get explanation from Redis: %w", err)
+	}
+
+	var explanation models.PredictionExplanation
+	if err := json.Unmarshal([]byte(data), &explanation); err != nil {
+		return nil, false, fmt.Errorf("failed to unmarshal explanation: %w", err)
+	}
+	return &explanation, true, nil
+}
+
 // Get Redis status
 func (r *RedisClient) GetStatus() (map[string]interface{}, error) {
 	info, err := r.client.Info(r.ctx).Result()
@@ -0,0 +1,543 @@
+package controllers
+
+import (
+	"diabetify/internal/repository"
+	"diabetify/internal/services"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type AdminController struct {
+	modelUpdates     services.ModelUpdateService
+	monitor          services.ModelMonitor
+	notificationRepo repository.NotificationRepository
+	experiments      services.ModelExperimentService
+	explanations     services.ExplanationService
+}
+
+func NewAdminController(
+	modelUpdates services.ModelUpdateService,
+	monitor services.ModelMonitor,
+	notificationRepo repository.NotificationRepository,
+	experiments services.ModelExperimentService,
+) *AdminController {
+	return &AdminController{
+		modelUpdates:     modelUpdates,
+		monitor:          monitor,
+		notificationRepo: notificationRepo,
+		experiments:      experiments,
+	}
+}
+
+// SetExplanations enables the explanation stats and LLM usage endpoints; without it
+// they answer 503
+func (ac *AdminController) SetExplanations(explanations services.ExplanationService) {
+	ac.explanations = explanations
+}
+
+// parseLimit reads the limit query parameter; it writes the 400 response and returns false when invalid
+func parseLimit(c *gin.Context, defaultLimit int) (int, bool) {
+	limitStr := c.Query("limit")
+	if limitStr == "" {
+		return defaultLimit, true
+	}
+	limit, err := strconv.Atoi(limitStr)
+	if err != nil || limit <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  "error",
+			"message": "Invalid limit parameter",
+			"error":   "Limit must be a positive integer",
+		})
+		return 0, false
+	}
+	return limit, true
+}
+
+// StartModelUpdateRequest is the body of POST /admin/model-updates
+type StartModelUpdateRequest struct {
+	Epochs int `json:"epochs" binding:"omitempty,min=1,max=100" example:"5"`
+}
+
+// StartModelUpdate godoc
+// @Summary Start an incremental model update
+// @Description Assemble a training batch from consented, labeled data and fine-tune the ML model. The candidate is promoted only if AUC and PR-AUC do not regress.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param request body StartModelUpdateRequest false "Update options (epochs defaults to 5)"
+// @Success 202 {object} map[string]interface{} "Model update started"
+// @Failure 400 {object} map[string]interface{} "Invalid request data"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]interface{} "Admin access required"
+// @Failure 409 {object} map[string]interface{} "A model update is already in progress"
+// @Failure 422 {object} map[string]interface{} "Not enough labeled data"
+// @Failure 500 {object} map[string]interface{} "Failed to start model update"
+// @Router /admin/model-updates [post]
+func (ac *AdminController) StartModelUpdate(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"status":  "error",
+			"message": "Unauthorized access",
+		})
+		return
+	}
+
+	var request StartModelUpdateRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"status":  "error",
+				"message": "Invalid request data",
+				"error":   err.Error(),
+			})
+			return
+		}
+	}
+	if request.Epochs == 0 {
+		request.Epochs = 5
+	}
+
+	update, err := ac.modelUpdates.StartUpdate(userID.(uint), request.Epochs)
+	if err != nil {
+		status := http.StatusInternalServerError
+		message := "Failed to start model update"
+		switch {
+		case errors.Is(err, services.ErrModelUpdateInProgress):
+			status = http.StatusConflict
+			message = "A model update is already in progress"
+		case errors.Is(err, services.ErrNoTrainingDataSource), errors.Is(err, services.ErrInsufficientTrainingData):
+			status = http.StatusUnprocessableEntity
+			message = "Not enough labeled data to update the model"
+		}
+		c.JSON(status, gin.H{
+			"status":  "error",
+			"message": message,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"status":  "success",
+		"message": "Model update started",
+		"data":    update,
+	})
+}
+
+// GetModelUpdates godoc
+// @Summary List model updates
+// @Description List recent model update runs with their metrics and promotion decisions
+// @Tags admin
+// @Produce json
+// @Security ApiKeyAuth
+// @Param limit query int false "Limit number of updates returned (default: 20)"
+// @Success 200 {object} map[string]interface{} "Model updates retrieved successfully"
+// @Failure 400 {object} map[string]interface{} "Invalid limit parameter"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]interface{} "Admin access required"
+// @Failure 500 {object} map[string]interface{} "Failed to retrieve model updates"
+// @Router /admin/model-updates [get]
+func (ac *AdminController) GetModelUpdates(c *gin.Context) {
+	limit, ok := parseLimit(c, 20)
+	if !ok {
+		return
+	}
+
+	updates, err := ac.modelUpdates.ListUpdates(limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  "error",
+			"message": "Failed to retrieve model updates",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "Model updates retrieved successfully",
+		"data": gin.H{
+			"updates": updates,
+			"count":   len(updates),
+		},
+	})
+}
+
+// GetModelUpdateByID godoc
+// @Summary Get a model update
+// @Description Get one model update run with its metrics and promotion decision
+// @Tags admin
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path int true "Model update ID"
+// @Success 200 {object} map[string]interface{} "Model update retrieved successfully"
+// @Failure 400 {object} map[string]interface{} "Invalid model update ID"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]interface{} "Admin access required"
+// @Failure 404 {object} map[string]interface{} "Model update not found"
+// @Router /admin/model-updates/{id} [get]
+func (ac *AdminController) GetModelUpdateByID(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  "error",
+			"message": "Invalid model update ID",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	update, err := ac.modelUpdates.GetUpdate(uint(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"status":  "error",
+			"message": "Model update not found",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "Model update retrieved successfully",
+		"data":    update,
+	})
+}
+
+// GetModelMonitoring godoc
+// @Summary Get model monitoring reports
+// @Description Daily drift (PSI/KS) of the 9 model inputs and the risk score against a reference window, with AUC and calibration where outcomes exist
+// @Tags admin
+// @Produce json
+// @Security ApiKeyAuth
+// @Param limit query int false "Number of daily reports returned (default: 30)"
+// @Success 200 {object} map[string]interface{} "Model monitoring retrieved successfully"
+// @Failure 400 {object} map[string]interface{} "Invalid limit parameter"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]interface{} "Admin access required"
+// @Failure 500 {object} map[string]interface{} "Failed to retrieve model monitoring"
+// @Router /admin/model-monitoring [get]
+func (ac *AdminController) GetModelMonitoring(c *gin.Context) {
+	limit, ok := parseLimit(c, 30)
+	if !ok {
+		return
+	}
+
+	reports, err := ac.monitor.GetReports(limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  "error",
+			"message": "Failed to retrieve model monitoring",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	data := gin.H{
+		"thresholds": ac.monitor.Config(),
+		"reports":    reports,
+		"count":      len(reports),
+	}
+	if len(reports) > 0 {
+		data["latest"] = reports[0]
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "Model monitoring retrieved successfully",
+		"data":    data,
+	})
+}
+
+// RunModelMonitoring godoc
+// @Summary Compute a model monitoring report
+// @Description Compute (or recompute) the monitoring report for one day, raising notifications if drift is detected
+// @Tags admin
+// @Produce json
+// @Security ApiKeyAuth
+// @Param date query string false "Report day in YYYY-MM-DD (default: yesterday, UTC)"
+// @Success 200 {object} map[string]interface{} "Model monitoring report computed"
+// @Failure 400 {object} map[string]interface{} "Invalid date"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]interface{} "Admin access required"
+// @Failure 500 {object} map[string]interface{} "Failed to compute model monitoring report"
+// @Router /admin/model-monitoring/run [post]
+func (ac *AdminController) RunModelMonitoring(c *gin.Context) {
+	date := time.Now().UTC().AddDate(0, 0, -1)
+	if dateStr := c.Query("date"); dateStr != "" {
+		var err error
+		date, err = time.Parse("2006-01-02", dateStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"status":  "error",
+				"message": "Invalid date",
+				"error":   "Use format YYYY-MM-DD",
+			})
+			return
+		}
+	}
+
+	report, err := ac.monitor.RunForDate(date)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  "error",
+			"message": "Failed to compute model monitoring report",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "Model monitoring report computed",
+		"data":    report,
+	})
+}
+
+// GetModelExperimentReport godoc
+// @Summary Compare model versions in the running experiment
+// @Description Score distributions of the control and candidate models (mean, quantiles, histogram, PSI/KS) and, in shadow mode, the per-job difference between the two scores
+// @Tags admin
+// @Produce json
+// @Security ApiKeyAuth
+// @Param since query string false "Include scores from this day, YYYY-MM-DD (default: 7 days ago, UTC)"
+// @Success 200 {object} map[string]interface{} "Model experiment report retrieved successfully"
+// @Failure 400 {object} map[string]interface{} "Invalid date"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]interface{} "Admin access required"
+// @Failure 500 {object} map[string]interface{} "Failed to build model experiment report"
+// @Router /admin/model-experiments [get]
+func (ac *AdminController) GetModelExperimentReport(c *gin.Context) {
+	now := time.Now().UTC()
+	since := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC).AddDate(0, 0, -7)
+	if sinceStr := c.Query("since"); sinceStr != "" {
+		var err error
+		since, err = time.Parse("2006-01-02", sinceStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"status":  "error",
+				"message": "Invalid date",
+				"error":   "Use format YYYY-MM-DD",
+			})
+			return
+		}
+	}
+
+	report, err := ac.experiments.Report(since)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  "error",
+			"message": "Failed to build model experiment report",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "Model experiment report retrieved successfully",
+		"data":    report,
+	})
+}
+
+// GetExplanationStats godoc
+// @Summary Explanation quality counters
+// @Description Explanations stored per provider, cache hits, fallbacks, and LLM replies and explanations rejected by the schema, completeness, safety and disclaimer checks since the server started
+// @Tags admin
+// @Produce json
+// @Security ApiKeyAuth
+// @Success 200 {object} map[string]interface{} "Explanation stats retrieved successfully"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]interface{} "Admin access required"
+// @Failure 503 {object} map[string]interface{} "Prediction explanations are not configured"
+// @Router /admin/explanations/stats [get]
+func (ac *AdminController) GetExplanationStats(c *gin.Context) {
+	if ac.explanations == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"status":  "error",
+			"message": "Prediction explanations are not configured",
+			"error":   services.ErrExplanationUnavailable.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "Explanation stats retrieved successfully",
+		"data":    ac.explanations.Stats(),
+	})
+}
+
+// GetLLMUsageReport godoc
+// @Summary LLM usage and budget report
+// @Description Tokens, calls and estimated cost of the LLM calls made for explanations, per day and model and for the top users, with today's token budget
+// @Tags admin
+// @Produce json
+// @Security ApiKeyAuth
+// @Param since query string false "First day to include, YYYY-MM-DD (default: 7 days ago, UTC)"
+// @Param until query string false "Last day to include, YYYY-MM-DD (default: today, UTC)"
+// @Success 200 {object} map[string]interface{} "LLM usage report retrieved successfully"
+// @Failure 400 {object} map[string]interface{} "Invalid date"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]interface{} "Admin access required"
+// @Failure 500 {object} map[string]interface{} "Failed to build LLM usage report"
+// @Failure 503 {object} map[string]interface{} "LLM usage is not tracked"
+// @Router /admin/llm-usage [get]
+func (ac *AdminController) GetLLMUsageReport(c *gin.Context) {
+	now := time.Now().UTC()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	since, until := today.AddDate(0, 0, -7), today
+	for _, param := range []struct {
+		name string
+		day  *time.Time
+	}{{"since", &since}, {"until", &until}} {
+		value := c.Query(param.name)
+		if value == "" {
+			continue
+		}
+		day, err := time.Parse("2006-01-02", value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"status":  "error",
+				"message": "Invalid date",
+				"error":   "Use format YYYY-MM-DD",
+			})
+			return
+		}
+		*param.day = day
+	}
+	if until.Before(since) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  "error",
+			"message": "Invalid date",
+			"error":   "until must not be before since",
+		})
+		return
+	}
+
+	if ac.explanations == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"status":  "error",
+			"message": "LLM usage is not tracked",
+			"error":   services.ErrLLMUsageUntracked.Error(),
+		})
+		return
+	}
+
+	// until is inclusive, so the report runs to the start of the following day
+	report, err := ac.explanations.UsageReport(since, until.AddDate(0, 0, 1))
+	if err != nil {
+		if errors.Is(err, services.ErrLLMUsageUntracked) {
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"status":  "error",
+				"message": "LLM usage is not tracked",
+				"error":   err.Error(),
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  "error",
+			"message": "Failed to build LLM usage report",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "LLM usage report retrieved successfully",
+		"data":    report,
+	})
+}
+
+// GetNotifications godoc
+// @Summary List admin notifications
+// @Description List alerts such as detected model drift, newest first
+// @Tags admin
+// @Produce json
+// @Security ApiKeyAuth
+// @Param unread query bool false "Only unread notifications"
+// @Param limit query int false "Limit number of notifications returned (default: 50)"
+// @Success 200 {object} map[string]interface{} "Notifications retrieved successfully"
+// @Failure 400 {object} map[string]interface{} "Invalid limit parameter"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]interface{} "Admin access required"
+// @Failure 500 {object} map[string]interface{} "Failed to retrieve notifications"
+// @Router /admin/notifications [get]
+func (ac *AdminController) GetNotifications(c *gin.Context) {
+	limit, ok := parseLimit(c, 50)
+	if !ok {
+		return
+	}
+
+	notifications, err := ac.notificationRepo.GetNotifications(limit, c.Query("unread") == "true")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  "error",
+			"message": "Failed to retrieve notifications",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "Notifications retrieved successfully",
+		"data": gin.H{
+			"notifications": notifications,
+			"count":         len(notifications),
+		},
+	})
+}
+
+// MarkNotificationRead godoc
+// @Summary Mark a notification as read
+// @Tags admin
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path int true "Notification ID"
+// @Success 200 {object} map[string]interface{} "Notification marked as read"
+// @Failure 400 {object} map[string]interface{} "Invalid notification ID"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]interface{} "Admin access required"
+// @Failure 404 {object} map[string]interface{} "Notification not found"
+// @Failure 500 {object} map[string]interface{} "Failed to update notification"
+// @Router /admin/notifications/{id}/read [post]
+func (ac *AdminController) MarkNotificationRead(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  "error",
+			"message": "Invalid notification ID",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	if err := ac.notificationRepo.MarkNotificationRead(uint(id)); err != nil {
+		status := http.StatusInternalServerError
+		message := "Failed to update notification"
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			status = http.StatusNotFound
+			message = "Notification not found"
+		}
+		c.JSON(status, gin.H{
+			"status":  "error",
+			"message": message,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "Notification marked as read",
+	})
+}
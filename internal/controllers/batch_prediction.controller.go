@@ -0,0 +1,214 @@
+package controllers
+
+import (
+	"bytes"
+	"diabetify/internal/models"
+	"diabetify/internal/services"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+type BatchPredictionController struct {
+	batches services.BatchPredictionService
+	// maxItems is applied while parsing uploads, before the whole file is read into items
+	maxItems int
+}
+
+func NewBatchPredictionController(batches services.BatchPredictionService, maxItems int) *BatchPredictionController {
+	return &BatchPredictionController{
+		batches:  batches,
+		maxItems: maxItems,
+	}
+}
+
+// StartBatchPredictionRequest is the JSON body of POST /admin/batch-predictions
+type StartBatchPredictionRequest struct {
+	UserIDs []uint `json:"user_ids" binding:"required,min=1,dive,min=1"`
+}
+
+// StartBatchPrediction godoc
+// @Summary Start a batch prediction
+// @Description Score a cohort in the background, either registered users (JSON body with user_ids) or anonymous feature rows (multipart upload of a CSV in the "file" field whose header names every model feature, plus an optional "ref" column). Poll the returned job for progress and download the result CSV when it completes.
+// @Tags admin
+// @Accept json,mpfd
+// @Produce json
+// @Security ApiKeyAuth
+// @Param request body StartBatchPredictionRequest false "User IDs to score"
+// @Param file formData file false "Feature CSV"
+// @Success 202 {object} map[string]interface{} "Batch prediction started"
+// @Failure 400 {object} map[string]interface{} "Invalid batch"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]interface{} "Admin access required"
+// @Failure 413 {object} map[string]interface{} "Batch too large"
+// @Failure 429 {object} map[string]interface{} "Daily batch item quota reached"
+// @Failure 500 {object} map[string]interface{} "Failed to start batch prediction"
+// @Router /admin/batch-predictions [post]
+func (bc *BatchPredictionController) StartBatchPrediction(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"status":  "error",
+			"message": "Unauthorized access",
+		})
+		return
+	}
+
+	var items []services.BatchItem
+	if strings.HasPrefix(c.ContentType(), "multipart/form-data") {
+		fileHeader, err := c.FormFile("file")
+		if err != nil {
+			bc.invalidBatch(c, err)
+			return
+		}
+		file, err := fileHeader.Open()
+		if err != nil {
+			bc.invalidBatch(c, err)
+			return
+		}
+		defer file.Close()
+
+		items, err = services.ParseBatchFeatureCSV(file, bc.maxItems)
+		if err != nil {
+			bc.invalidBatch(c, err)
+			return
+		}
+	} else {
+		var request StartBatchPredictionRequest
+		if err := c.ShouldBindJSON(&request); err != nil {
+			bc.invalidBatch(c, err)
+			return
+		}
+		for _, id := range request.UserIDs {
+			items = append(items, services.BatchItem{Ref: fmt.Sprint(id), UserID: &id})
+		}
+	}
+
+	job, err := bc.batches.StartBatch(userID.(uint), items)
+	if err != nil {
+		if errors.Is(err, services.ErrBatchTooLarge) || errors.Is(err, services.ErrEmptyBatch) {
+			bc.invalidBatch(c, err)
+			return
+		}
+		var exceeded *services.QuotaExceededError
+		if errors.As(err, &exceeded) {
+			respondQuotaExceeded(c, exceeded)
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  "error",
+			"message": "Failed to start batch prediction",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"status":  "success",
+		"message": "Batch prediction started",
+		"data":    job,
+	})
+}
+
+func (bc *BatchPredictionController) invalidBatch(c *gin.Context, err error) {
+	status := http.StatusBadRequest
+	message := "Invalid batch"
+	if errors.Is(err, services.ErrBatchTooLarge) {
+		status = http.StatusRequestEntityTooLarge
+		message = "Batch too large"
+	}
+	c.JSON(status, gin.H{
+		"status":  "error",
+		"message": message,
+		"error":   err.Error(),
+	})
+}
+
+// GetBatchPrediction godoc
+// @Summary Get batch prediction progress
+// @Description Get a batch job with the number of finished and failed items
+// @Tags admin
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "Batch job ID"
+// @Success 200 {object} map[string]interface{} "Batch prediction retrieved successfully"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]interface{} "Admin access required"
+// @Failure 404 {object} map[string]interface{} "Batch prediction not found"
+// @Router /admin/batch-predictions/{id} [get]
+func (bc *BatchPredictionController) GetBatchPrediction(c *gin.Context) {
+	job, err := bc.batches.GetBatch(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"status":  "error",
+			"message": "Batch prediction not found",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	progress := 0.0
+	if job.TotalItems > 0 {
+		progress = float64(job.CompletedItems+job.FailedItems) / float64(job.TotalItems) * 100
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "Batch prediction retrieved successfully",
+		"data": gin.H{
+			"job":                 job,
+			"progress_percentage": progress,
+			"result_available":    job.Status == models.JobStatusCompleted,
+		},
+	})
+}
+
+// DownloadBatchPredictionResult godoc
+// @Summary Download batch prediction results
+// @Description CSV with one row per item: ref, user_id, status, risk_score, model_version, the model inputs and their SHAP values, and the error for failed items
+// @Tags admin
+// @Produce text/csv
+// @Security ApiKeyAuth
+// @Param id path string true "Batch job ID"
+// @Success 200 {file} file "Result CSV"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]interface{} "Admin access required"
+// @Failure 404 {object} map[string]interface{} "Batch prediction not found"
+// @Failure 409 {object} map[string]interface{} "Batch prediction has not finished"
+// @Failure 500 {object} map[string]interface{} "Failed to build batch prediction results"
+// @Router /admin/batch-predictions/{id}/result [get]
+func (bc *BatchPredictionController) DownloadBatchPredictionResult(c *gin.Context) {
+	job, err := bc.batches.GetBatch(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"status":  "error",
+			"message": "Batch prediction not found",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	var result bytes.Buffer
+	if err := bc.batches.WriteResults(job, &result); err != nil {
+		if errors.Is(err, services.ErrBatchNotFinished) {
+			c.JSON(http.StatusConflict, gin.H{
+				"status":  "error",
+				"message": "Batch prediction has not finished",
+				"error":   err.Error(),
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  "error",
+			"message": "Failed to build batch prediction results",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"batch-prediction-%s.csv\"", job.ID))
+	c.Data(http.StatusOK, "text/csv", result.Bytes())
+}
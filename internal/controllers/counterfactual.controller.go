@@ -0,0 +1,82 @@
+package controllers
+
+import (
+	"diabetify/internal/models"
+	"diabetify/internal/services"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type CounterfactualController struct {
+	counterfactuals services.CounterfactualService
+}
+
+func NewCounterfactualController(counterfactuals services.CounterfactualService) *CounterfactualController {
+	return &CounterfactualController{counterfactuals: counterfactuals}
+}
+
+// GetCounterfactuals godoc
+// @Summary Suggest changes that reach a target risk
+// @Description Search the modifiable what-if fields (weight, smoking, physical activity, hypertension and cholesterol control) for the smallest plausible changes that bring the user's risk below the target. Suggestions are ranked by effort, each with its projected risk. When no plausible change reaches the target, the lowest risks found are returned with reaches_target false.
+// @Tags prediction
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param request body models.CounterfactualRequest false "Target risk and number of suggestions"
+// @Success 200 {object} map[string]interface{} "Suggestions generated"
+// @Failure 400 {object} map[string]interface{} "Invalid request or incomplete profile"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 429 {object} map[string]interface{} "Active job or daily what-if quota reached"
+// @Failure 500 {object} map[string]interface{} "Failed to generate suggestions"
+// @Router /prediction/what-if/counterfactuals [post]
+func (cc *CounterfactualController) GetCounterfactuals(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"status":  "error",
+			"message": "Unauthorized access",
+		})
+		return
+	}
+
+	var request models.CounterfactualRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"status":  "error",
+				"message": "Invalid request data",
+				"error":   err.Error(),
+			})
+			return
+		}
+	}
+
+	result, err := cc.counterfactuals.Suggest(c.Request.Context(), userID.(uint), &request)
+	if err != nil {
+		var exceeded *services.QuotaExceededError
+		if errors.As(err, &exceeded) {
+			respondQuotaExceeded(c, exceeded)
+			return
+		}
+		status := http.StatusInternalServerError
+		message := "Failed to generate suggestions"
+		if errors.Is(err, services.ErrCounterfactualInput) {
+			status = http.StatusBadRequest
+			message = "Incomplete user profile"
+		}
+		c.JSON(status, gin.H{
+			"status":  "error",
+			"message": message,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "Suggestions generated",
+		"data":    result,
+	})
+}
@@ -0,0 +1,136 @@
+package controllers
+
+import (
+	"diabetify/internal/models"
+	"diabetify/internal/repository"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type OutcomeController struct {
+	repo repository.OutcomeRepository
+}
+
+func NewOutcomeController(repo repository.OutcomeRepository) *OutcomeController {
+	return &OutcomeController{repo: repo}
+}
+
+// CreateOutcome godoc
+// @Summary Report a diagnosis outcome
+// @Description Record a diagnosis (type and date, with optional HbA1c or fasting glucose). Predictions made before the diagnosis date are linked to it.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param outcome body models.OutcomeRequest true "Diagnosis outcome"
+// @Success 201 {object} map[string]interface{} "Outcome recorded successfully"
+// @Failure 400 {object} map[string]interface{} "Invalid request data"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 500 {object} map[string]interface{} "Failed to record outcome"
+// @Router /users/me/outcomes [post]
+func (oc *OutcomeController) CreateOutcome(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"status":  "error",
+			"message": "Unauthorized",
+			"error":   "User ID not found in token",
+		})
+		return
+	}
+
+	var request models.OutcomeRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  "error",
+			"message": "Invalid request data",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	diagnosisDate, err := time.Parse("2006-01-02", request.DiagnosisDate)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  "error",
+			"message": "Invalid diagnosis date",
+			"error":   "Use format YYYY-MM-DD",
+		})
+		return
+	}
+	if diagnosisDate.After(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  "error",
+			"message": "Invalid diagnosis date",
+			"error":   "Diagnosis date cannot be in the future",
+		})
+		return
+	}
+
+	outcome := &models.Outcome{
+		UserID:         userID.(uint),
+		DiagnosisType:  request.DiagnosisType,
+		DiagnosisDate:  diagnosisDate,
+		HbA1c:          request.HbA1c,
+		FastingGlucose: request.FastingGlucose,
+	}
+
+	linked, err := oc.repo.SaveOutcome(outcome)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  "error",
+			"message": "Failed to record outcome",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"status":  "success",
+		"message": "Outcome recorded successfully",
+		"data": gin.H{
+			"outcome":            outcome,
+			"linked_predictions": linked,
+		},
+	})
+}
+
+// GetUserOutcomes godoc
+// @Summary Get reported outcomes
+// @Description Get the diagnosis outcomes reported by the authenticated user
+// @Tags users
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} map[string]interface{} "Outcomes retrieved successfully"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 500 {object} map[string]interface{} "Failed to retrieve outcomes"
+// @Router /users/me/outcomes [get]
+func (oc *OutcomeController) GetUserOutcomes(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"status":  "error",
+			"message": "Unauthorized",
+			"error":   "User ID not found in token",
+		})
+		return
+	}
+
+	outcomes, err := oc.repo.GetOutcomesByUserID(userID.(uint))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  "error",
+			"message": "Failed to retrieve outcomes",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "Outcomes retrieved successfully",
+		"data":    outcomes,
+	})
+}
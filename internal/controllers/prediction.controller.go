@@ -2,19 +2,37 @@ package controllers
 
 import (
 	"context"
+	"crypto/sha256"
 	"diabetify/internal/ml"
 	"diabetify/internal/models"
-	"diabetify/internal/openai"
 	"diabetify/internal/repository"
 	"diabetify/internal/services"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// IdempotencyKeyHeader lets clients retry a submission without creating a second job
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+const maxIdempotencyKeyLength = 100
+
+// Why an earlier job was returned instead of creating one
+const (
+	reuseIdempotencyKey = "idempotency_key"
+	reuseSameInput      = "same_input"
 )
 
 type PredictionController struct {
@@ -25,6 +43,28 @@ type PredictionController struct {
 	jobRepo      repository.PredictionJobRepository
 	jobWorker    services.PredictionJobWorker
 	mlClient     ml.MLClient
+	quota        services.PredictionQuota
+	explanations services.ExplanationService
+
+	// coalesceWindow is how long a completed job is reused for a resubmission with the
+	// same feature vector
+	coalesceWindow time.Duration
+	// submitLocks serialises submissions per user so double-taps see each other's jobs;
+	// a user's entry is removed when no submission holds or waits for it
+	submitMu    sync.Mutex
+	submitLocks map[uint]*submitLock
+}
+
+type submitLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+// submissionKeys identify a new job for later deduplication
+type submissionKeys struct {
+	IdempotencyKey *string
+	RequestHash    string
+	InputHash      string
 }
 
 func NewPredictionController(
@@ -35,6 +75,8 @@ func NewPredictionController(
 	jobRepo repository.PredictionJobRepository,
 	jobWorker services.PredictionJobWorker,
 	mlClient ml.MLClient,
+	quota services.PredictionQuota,
+	explanations services.ExplanationService,
 ) *PredictionController {
 	return &PredictionController{
 		repo:         repo,
@@ -44,12 +86,25 @@ func NewPredictionController(
 		jobRepo:      jobRepo,
 		jobWorker:    jobWorker,
 		mlClient:     mlClient,
+		quota:        quota,
+		explanations: explanations,
+
+		coalesceWindow: coalesceWindowFromEnv(),
+		submitLocks:    make(map[uint]*submitLock),
+	}
+}
+
+// coalesceWindowFromEnv reads PREDICTION_COALESCE_WINDOW (e.g. "30s")
+func coalesceWindowFromEnv() time.Duration {
+	if v, err := time.ParseDuration(os.Getenv("PREDICTION_COALESCE_WINDOW")); err == nil && v >= 0 {
+		return v
 	}
+	return 30 * time.Second
 }
 
 // TestMLConnection godoc
 // @Summary Test ML service connection
-// @Description Test the connection to the async ML service via RabbitMQ
+// @Description Round-trips a health probe through the async ML service via RabbitMQ and reports model version and latency
 // @Tags prediction
 // @Produce json
 // @Success 200 {object} map[string]interface{} "ML service is healthy"
@@ -72,45 +127,100 @@ func (pc *PredictionController) TestMLConnection(c *gin.Context) {
 	isRunning, runningOk := status["running"].(bool)
 	isRabbitMQConnected, rabbitOk := status["rabbitmq_connected"].(bool)
 
-	if runningOk && isRunning && rabbitOk && isRabbitMQConnected {
-		// Optionally test async health check
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
+	if !(runningOk && isRunning && rabbitOk && isRabbitMQConnected) || pc.mlClient == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  "error",
+			"message": "Async ML service is not reachable via RabbitMQ",
+			"details": gin.H{
+				"service_type":  "async_only",
+				"worker_status": status,
+			},
+		})
+		return
+	}
 
-		var healthCheckStatus string = "not_tested"
-		if pc.mlClient != nil {
-			// Try async health check (non-blocking)
-			if err := pc.mlClient.HealthCheckAsync(ctx); err == nil {
-				healthCheckStatus = "message_sent"
-			} else {
-				healthCheckStatus = "failed_to_send"
-			}
-		}
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
 
-		c.JSON(http.StatusOK, gin.H{
-			"status":    "success",
-			"message":   "Async ML service is healthy via RabbitMQ",
-			"timestamp": time.Now(),
+	health, err := pc.mlClient.ProbeHealth(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  "error",
+			"message": "ML service did not respond to the health probe",
+			"error":   err.Error(),
 			"details": gin.H{
 				"worker_status": status,
 				"communication": "rabbitmq",
-				"health_check":  healthCheckStatus,
+				"health_check":  health,
 			},
 		})
 		return
 	}
 
-	// Service is not healthy
-	c.JSON(http.StatusInternalServerError, gin.H{
-		"status":  "error",
-		"message": "Async ML service is not reachable via RabbitMQ",
+	c.JSON(http.StatusOK, gin.H{
+		"status":    "success",
+		"message":   "Async ML service is healthy via RabbitMQ",
+		"timestamp": time.Now(),
 		"details": gin.H{
-			"service_type":  "async_only",
 			"worker_status": status,
+			"communication": "rabbitmq",
+			"health_check":  health,
 		},
 	})
 }
 
+// GetReadiness godoc
+// @Summary Readiness probe
+// @Description Reports whether the API can serve predictions: job worker running, RabbitMQ connected and the ML service answering health probes
+// @Tags prediction
+// @Produce json
+// @Success 200 {object} map[string]interface{} "Ready"
+// @Failure 503 {object} map[string]interface{} "Not ready"
+// @Router /prediction/ready [get]
+func (pc *PredictionController) GetReadiness(c *gin.Context) {
+	checks := gin.H{
+		"job_worker": false,
+		"rabbitmq":   false,
+		"ml_service": false,
+	}
+
+	if pc.jobWorker != nil {
+		status := pc.jobWorker.GetStatus()
+		isRunning, _ := status["running"].(bool)
+		isRabbitMQConnected, _ := status["rabbitmq_connected"].(bool)
+		checks["job_worker"] = isRunning
+		checks["rabbitmq"] = isRabbitMQConnected
+	}
+
+	var health *ml.HealthStatus
+	if pc.mlClient != nil {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+		defer cancel()
+
+		var err error
+		health, err = pc.mlClient.ProbeHealth(ctx)
+		checks["ml_service"] = err == nil
+	}
+
+	ready := checks["job_worker"] == true && checks["rabbitmq"] == true && checks["ml_service"] == true
+	if !ready {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"status":  "error",
+			"message": "Service is not ready",
+			"checks":  checks,
+			"health":  health,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "Service is ready",
+		"checks":  checks,
+		"health":  health,
+	})
+}
+
 // MakePrediction godoc
 // @Summary Make an asynchronous prediction using user's profile data
 // @Description Submit a prediction job using RabbitMQ for asynchronous processing
@@ -118,11 +228,16 @@ func (pc *PredictionController) TestMLConnection(c *gin.Context) {
 // @Accept json
 // @Produce json
 // @Security ApiKeyAuth
+// @Param Idempotency-Key header string false "Repeating a key returns the job created by its first use"
+// @Success 200 {object} map[string]interface{} "Existing job returned for a repeated key or identical input"
 // @Success 202 {object} map[string]interface{} "Prediction job submitted"
 // @Failure 400 {object} map[string]interface{} "Incomplete user profile"
 // @Failure 401 {object} map[string]interface{} "Unauthorized"
 // @Failure 404 {object} map[string]interface{} "User profile not found"
+// @Failure 422 {object} map[string]interface{} "Idempotency-Key already used for a different request"
+// @Failure 429 {object} map[string]interface{} "Active job or daily prediction quota reached"
 // @Failure 500 {object} map[string]interface{} "Failed to submit job"
+// @Failure 503 {object} map[string]interface{} "ML service temporarily unavailable"
 // @Router /prediction [post]
 func (pc *PredictionController) MakePrediction(c *gin.Context) {
 	userID, exists := c.Get("user_id")
@@ -145,25 +260,42 @@ func (pc *PredictionController) MakePrediction(c *gin.Context) {
 		return
 	}
 
+	if pc.rejectIfMLUnavailable(c) {
+		return
+	}
+
+	unlock := pc.lockSubmissions(userID.(uint))
+	defer unlock()
+
+	keys, handled := pc.reuseExistingJob(c, userID.(uint), models.JobTypePrediction, nil, func() ([]float64, error) {
+		return pc.jobWorker.FeaturesForUser(userID.(uint))
+	})
+	if handled {
+		return
+	}
+
+	if pc.rejectIfOverQuota(c, userID.(uint), models.JobTypePrediction) {
+		return
+	}
+
 	// Generate job ID
 	jobID := uuid.New().String()
 
 	// Create job record in database
 	job := &models.PredictionJob{
-		ID:        jobID,
-		UserID:    userID.(uint),
-		Status:    models.JobStatusPending,
-		IsWhatIf:  false,
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
-	}
-
-	if err := pc.jobRepo.SaveJob(job); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"status":  "error",
-			"message": "Failed to create prediction job",
-			"error":   err.Error(),
-		})
+		ID:             jobID,
+		UserID:         userID.(uint),
+		Status:         models.JobStatusPending,
+		JobType:        models.JobTypePrediction,
+		IsWhatIf:       false,
+		InputHash:      keys.InputHash,
+		IdempotencyKey: keys.IdempotencyKey,
+		RequestHash:    keys.RequestHash,
+		CreatedAt:      time.Now(),
+		UpdatedAt:      time.Now(),
+	}
+
+	if pc.saveSubmittedJob(c, job, "Failed to create prediction job") {
 		return
 	}
 
@@ -171,6 +303,7 @@ func (pc *PredictionController) MakePrediction(c *gin.Context) {
 		JobID:       jobID,
 		UserID:      userID.(uint),
 		WhatIfInput: nil, // Regular prediction
+		Priority:    models.JobPriorityStandard,
 	}
 
 	if err := pc.jobWorker.SubmitJob(jobRequest); err != nil {
@@ -206,10 +339,15 @@ func (pc *PredictionController) MakePrediction(c *gin.Context) {
 // @Produce json
 // @Security ApiKeyAuth
 // @Param input body models.WhatIfInput true "What-if prediction parameters"
+// @Param Idempotency-Key header string false "Repeating a key returns the job created by its first use"
+// @Success 200 {object} map[string]interface{} "Existing job returned for a repeated key or identical input"
 // @Success 202 {object} map[string]interface{} "What-if prediction job submitted"
 // @Failure 400 {object} map[string]interface{} "Invalid input or incomplete profile"
 // @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 422 {object} map[string]interface{} "Idempotency-Key already used for a different request"
+// @Failure 429 {object} map[string]interface{} "Active job or daily what-if quota reached"
 // @Failure 500 {object} map[string]interface{} "Failed to submit job"
+// @Failure 503 {object} map[string]interface{} "ML service temporarily unavailable"
 // @Router /prediction/what-if [post]
 func (pc *PredictionController) WhatIfPrediction(c *gin.Context) {
 	var input models.WhatIfInput
@@ -241,25 +379,42 @@ func (pc *PredictionController) WhatIfPrediction(c *gin.Context) {
 		return
 	}
 
+	if pc.rejectIfMLUnavailable(c) {
+		return
+	}
+
+	unlock := pc.lockSubmissions(userID.(uint))
+	defer unlock()
+
+	keys, handled := pc.reuseExistingJob(c, userID.(uint), models.JobTypeWhatIf, input, func() ([]float64, error) {
+		return pc.jobWorker.FeaturesForWhatIf(userID.(uint), &input)
+	})
+	if handled {
+		return
+	}
+
+	if pc.rejectIfOverQuota(c, userID.(uint), models.JobTypeWhatIf) {
+		return
+	}
+
 	// Generate job ID
 	jobID := uuid.New().String()
 
 	// Create job record in database
 	job := &models.PredictionJob{
-		ID:        jobID,
-		UserID:    userID.(uint),
-		Status:    models.JobStatusPending,
-		IsWhatIf:  true,
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
-	}
-
-	if err := pc.jobRepo.SaveJob(job); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"status":  "error",
-			"message": "Failed to create what-if prediction job",
-			"error":   err.Error(),
-		})
+		ID:             jobID,
+		UserID:         userID.(uint),
+		Status:         models.JobStatusPending,
+		JobType:        models.JobTypeWhatIf,
+		IsWhatIf:       true,
+		InputHash:      keys.InputHash,
+		IdempotencyKey: keys.IdempotencyKey,
+		RequestHash:    keys.RequestHash,
+		CreatedAt:      time.Now(),
+		UpdatedAt:      time.Now(),
+	}
+
+	if pc.saveSubmittedJob(c, job, "Failed to create what-if prediction job") {
 		return
 	}
 
@@ -267,6 +422,7 @@ func (pc *PredictionController) WhatIfPrediction(c *gin.Context) {
 		JobID:       jobID,
 		UserID:      userID.(uint),
 		WhatIfInput: &input, // What-if prediction with custom parameters
+		Priority:    models.JobPriorityInteractive,
 	}
 
 	if err := pc.jobWorker.SubmitJob(jobRequest); err != nil {
@@ -350,6 +506,7 @@ func (pc *PredictionController) GetJobStatus(c *gin.Context) {
 		"message": "Job status retrieved successfully",
 		"data": gin.H{
 			"job_id":     job.ID,
+			"job_type":   job.JobType,
 			"status":     job.Status, // Only status
 			"created_at": job.CreatedAt,
 			"updated_at": job.UpdatedAt,
@@ -465,8 +622,8 @@ func (pc *PredictionController) GetJobResult(c *gin.Context) {
 		if !exists {
 			c.JSON(http.StatusNotFound, gin.H{
 				"status":  "error",
-				"message": "What-if result has expired or not found",
-				"help":    "What-if results are only available for 1 hours after completion",
+				"message": "What-if result not found",
+				"help":    "The scenario may have been deleted, see /prediction/what-if/scenarios",
 			})
 			return
 		}
@@ -510,51 +667,30 @@ func (pc *PredictionController) GetJobResult(c *gin.Context) {
 
 	// Build feature explanations map
 	featureExplanations := make(map[string]map[string]interface{})
-	features := []struct {
-		name         string
-		shap         float64
-		contribution float64
-		impact       float64
-	}{
-		{"age", prediction.AgeShap, prediction.AgeContribution, prediction.AgeImpact},
-		{"BMI", prediction.BMIShap, prediction.BMIContribution, prediction.BMIImpact},
-		{"brinkman_index", prediction.BrinkmanScoreShap, prediction.BrinkmanScoreContribution, prediction.BrinkmanScoreImpact},
-		{"is_hypertension", prediction.IsHypertensionShap, prediction.IsHypertensionContribution, prediction.IsHypertensionImpact},
-		{"is_cholesterol", prediction.IsCholesterolShap, prediction.IsCholesterolContribution, prediction.IsCholesterolImpact},
-		{"is_bloodline", prediction.IsBloodlineShap, prediction.IsBloodlineContribution, prediction.IsBloodlineImpact},
-		{"is_macrosomic_baby", prediction.IsMacrosomicBabyShap, prediction.IsMacrosomicBabyContribution, prediction.IsMacrosomicBabyImpact},
-		{"smoking_status", prediction.SmokingStatusShap, prediction.SmokingStatusContribution, prediction.SmokingStatusImpact},
-		{"moderate_physical_activity_frequency", prediction.PhysicalActivityFrequencyShap, prediction.PhysicalActivityFrequencyContribution, prediction.PhysicalActivityFrequencyImpact},
-	}
-
-	for _, feature := range features {
-		featureExplanations[feature.name] = map[string]interface{}{
-			"shap":         feature.shap,
-			"contribution": feature.contribution,
-			"impact":       int(feature.impact),
+	userDataUsed := gin.H{}
+	for _, feature := range models.FeatureRegistry {
+		factor := prediction.Factor(feature.Name)
+		if factor == nil {
+			factor = &models.PredictionFactor{Factor: feature.Name}
 		}
+		featureExplanations[feature.ModelName] = map[string]interface{}{
+			"shap":         factor.Shap,
+			"contribution": factor.Contribution,
+			"impact":       int(factor.Impact),
+		}
+		userDataUsed[feature.Name] = feature.TypedValue(factor.Value)
 	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"status":  "success",
 		"message": "Job result retrieved successfully",
 		"data": gin.H{
-			"job_id":          job.ID,
-			"prediction_id":   prediction.ID,
-			"risk_score":      prediction.RiskScore,
-			"risk_percentage": prediction.RiskScore * 100,
-			"timestamp":       prediction.CreatedAt,
-			"user_data_used": gin.H{
-				"age":                         prediction.Age,
-				"smoking_status":              prediction.SmokingStatus,
-				"is_macrosomic_baby":          prediction.IsMacrosomicBaby,
-				"brinkman_score":              prediction.BrinkmanScore,
-				"bmi":                         prediction.BMI,
-				"is_hypertension":             prediction.IsHypertension,
-				"is_cholesterol":              prediction.IsCholesterol,
-				"is_bloodline":                prediction.IsBloodline,
-				"physical_activity_frequency": prediction.PhysicalActivityFrequency,
-			},
+			"job_id":               job.ID,
+			"prediction_id":        prediction.ID,
+			"risk_score":           prediction.RiskScore,
+			"risk_percentage":      prediction.RiskScore * 100,
+			"timestamp":            prediction.CreatedAt,
+			"user_data_used":       userDataUsed,
 			"feature_explanations": featureExplanations,
 			"job_info": gin.H{
 				"completed_at":    job.UpdatedAt,
@@ -756,6 +892,209 @@ func (pc *PredictionController) validateUserProfile(userID uint) error {
 	return nil
 }
 
+// rejectIfMLUnavailable answers 503 with Retry-After while the ML circuit breaker is
+// open, so jobs are not queued behind a consumer that is known to be down
+func (pc *PredictionController) rejectIfMLUnavailable(c *gin.Context) bool {
+	reporter, ok := pc.mlClient.(ml.AvailabilityReporter)
+	if !ok {
+		return false
+	}
+
+	available, retryAfter := reporter.Available()
+	if available {
+		return false
+	}
+
+	retrySeconds := int(math.Ceil(retryAfter.Seconds()))
+	if retrySeconds < 1 {
+		retrySeconds = 1
+	}
+
+	c.Header("Retry-After", strconv.Itoa(retrySeconds))
+	c.JSON(http.StatusServiceUnavailable, gin.H{
+		"status":  "error",
+		"message": "Prediction service is temporarily unavailable, please try again later",
+		"error":   "ML service circuit breaker is open",
+		"data": gin.H{
+			"retry_after_seconds": retrySeconds,
+		},
+	})
+	return true
+}
+
+// rejectIfOverQuota writes a 429 when the user has too many active jobs or has used
+// today's allowance for this job type. Count failures are logged and let through.
+func (pc *PredictionController) rejectIfOverQuota(c *gin.Context, userID uint, jobType string) bool {
+	err := pc.quota.Check(userID, jobType)
+	if err == nil {
+		return false
+	}
+
+	var exceeded *services.QuotaExceededError
+	if !errors.As(err, &exceeded) {
+		fmt.Printf("Warning: Quota check failed for user %d: %v\n", userID, err)
+		return false
+	}
+	respondQuotaExceeded(c, exceeded)
+	return true
+}
+
+// respondQuotaExceeded writes the 429 for a quota error, with a Retry-After header
+// when the quota resets at a known time
+func respondQuotaExceeded(c *gin.Context, exceeded *services.QuotaExceededError) {
+	data := gin.H{
+		"quota": exceeded.Quota,
+		"limit": exceeded.Limit,
+		"used":  exceeded.Used,
+	}
+	message := "Too many prediction jobs in progress, wait for one to finish"
+	if !exceeded.ResetAt.IsZero() {
+		retrySeconds := int(math.Ceil(time.Until(exceeded.ResetAt).Seconds()))
+		if retrySeconds < 1 {
+			retrySeconds = 1
+		}
+		c.Header("Retry-After", strconv.Itoa(retrySeconds))
+		data["reset_at"] = exceeded.ResetAt
+		message = "Daily prediction limit reached"
+	}
+
+	c.JSON(http.StatusTooManyRequests, gin.H{
+		"status":  "error",
+		"message": message,
+		"error":   exceeded.Error(),
+		"data":    data,
+	})
+}
+
+// lockSubmissions serialises job submission for one user, so the second of two
+// concurrent identical requests finds the job created by the first
+func (pc *PredictionController) lockSubmissions(userID uint) func() {
+	pc.submitMu.Lock()
+	lock, ok := pc.submitLocks[userID]
+	if !ok {
+		lock = &submitLock{}
+		pc.submitLocks[userID] = lock
+	}
+	lock.refs++
+	pc.submitMu.Unlock()
+
+	lock.mu.Lock()
+	return func() {
+		lock.mu.Unlock()
+
+		pc.submitMu.Lock()
+		lock.refs--
+		if lock.refs == 0 {
+			delete(pc.submitLocks, userID)
+		}
+		pc.submitMu.Unlock()
+	}
+}
+
+// requestHash fingerprints what a submission asked for, stored with its Idempotency-Key
+func requestHash(jobType string, request interface{}) string {
+	body, _ := json.Marshal(request)
+	sum := sha256.Sum256(append([]byte(jobType+":"), body...))
+	return hex.EncodeToString(sum[:])
+}
+
+// reuseExistingJob answers with an earlier job when the request repeats an
+// Idempotency-Key, or when its feature vector matches a job of the user that is still
+// running or completed within coalesceWindow. Otherwise it returns the keys to store on
+// the new job. handled is true when a response has been written.
+func (pc *PredictionController) reuseExistingJob(c *gin.Context, userID uint, jobType string, request interface{}, features func() ([]float64, error)) (keys submissionKeys, handled bool) {
+	if key := strings.TrimSpace(c.GetHeader(IdempotencyKeyHeader)); key != "" {
+		if len(key) > maxIdempotencyKeyLength {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"status":  "error",
+				"message": "Invalid Idempotency-Key",
+				"error":   fmt.Sprintf("Idempotency-Key must be at most %d characters", maxIdempotencyKeyLength),
+			})
+			return keys, true
+		}
+
+		keys.RequestHash = requestHash(jobType, request)
+		job, err := pc.jobRepo.GetJobByIdempotencyKey(userID, key)
+		if err == nil {
+			pc.replayIdempotentJob(c, job, jobType, keys.RequestHash)
+			return keys, true
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			fmt.Printf("Warning: Idempotency-Key lookup failed for user %d: %v\n", userID, err)
+		}
+		keys.IdempotencyKey = &key
+	}
+
+	// The worker reports feature errors on the job itself, so a failure here only skips coalescing
+	vector, err := features()
+	if err != nil {
+		return keys, false
+	}
+	keys.InputHash = ml.HashFeatures(vector)
+
+	job, err := pc.jobRepo.FindRecentJobByInputHash(userID, jobType, keys.InputHash, time.Now().Add(-pc.coalesceWindow))
+	if err == nil {
+		respondWithExistingJob(c, job, reuseSameInput)
+		return keys, true
+	}
+	return keys, false
+}
+
+// replayIdempotentJob answers a repeated Idempotency-Key with its job, or with 422 when the
+// key was used for a different request. Jobs saved before request hashes were stored are
+// only compared by type.
+func (pc *PredictionController) replayIdempotentJob(c *gin.Context, job *models.PredictionJob, jobType, requestHash string) {
+	if job.JobType != jobType || (job.RequestHash != "" && job.RequestHash != requestHash) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"status":  "error",
+			"message": "Idempotency-Key was already used for a different request",
+			"error":   fmt.Sprintf("key belongs to %s job %s with a different request", job.JobType, job.ID),
+		})
+		return
+	}
+	c.Header("Idempotent-Replayed", "true")
+	respondWithExistingJob(c, job, reuseIdempotencyKey)
+}
+
+// saveSubmittedJob creates the job. When a concurrent request with the same
+// Idempotency-Key won the race for the unique index, that request's job is replayed.
+// handled is true when a response has been written.
+func (pc *PredictionController) saveSubmittedJob(c *gin.Context, job *models.PredictionJob, failureMessage string) (handled bool) {
+	err := pc.jobRepo.SaveJob(job)
+	if err == nil {
+		return false
+	}
+
+	if job.IdempotencyKey != nil {
+		if existing, lookupErr := pc.jobRepo.GetJobByIdempotencyKey(job.UserID, *job.IdempotencyKey); lookupErr == nil {
+			pc.replayIdempotentJob(c, existing, job.JobType, job.RequestHash)
+			return true
+		}
+	}
+
+	c.JSON(http.StatusInternalServerError, gin.H{
+		"status":  "error",
+		"message": failureMessage,
+		"error":   err.Error(),
+	})
+	return true
+}
+
+func respondWithExistingJob(c *gin.Context, job *models.PredictionJob, reason string) {
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "Prediction job already submitted",
+		"data": gin.H{
+			"job_id":       job.ID,
+			"status":       job.Status,
+			"submit_time":  job.CreatedAt,
+			"poll_url":     fmt.Sprintf("/prediction/job/%s/status", job.ID),
+			"reused":       true,
+			"reuse_reason": reason,
+		},
+	})
+}
+
 // ========== EXISTING METHODS (unchanged for backward compatibility) ==========
 
 // GetUserPredictions godoc
@@ -764,6 +1103,8 @@ func (pc *PredictionController) validateUserProfile(userID uint) error {
 // @Tags prediction
 // @Produce json
 // @Security ApiKeyAuth
+// @Param limit query int false "Maximum number of predictions (default 10)"
+// @Param model_version query string false "Only predictions produced by this model version"
 // @Success 200 {object} map[string]interface{} "Prediction history retrieved successfully"
 // @Failure 401 {object} map[string]interface{} "Unauthorized"
 // @Failure 500 {object} map[string]interface{} "Failed to retrieve prediction history"
@@ -795,7 +1136,15 @@ func (pc *PredictionController) GetUserPredictions(c *gin.Context) {
 		}
 	}
 
-	predictions, err := pc.repo.GetPredictionsByUserID(userID.(uint), limit)
+	var (
+		predictions []models.Prediction
+		err         error
+	)
+	if modelVersion := c.Query("model_version"); modelVersion != "" {
+		predictions, err = pc.repo.GetPredictionsByUserIDAndModelVersion(userID.(uint), modelVersion, limit)
+	} else {
+		predictions, err = pc.repo.GetPredictionsByUserID(userID.(uint), limit)
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"status":  "error",
@@ -812,6 +1161,47 @@ func (pc *PredictionController) GetUserPredictions(c *gin.Context) {
 	})
 }
 
+// GetPredictionModels godoc
+// @Summary List model versions behind the user's predictions
+// @Description List every model name/version that produced the authenticated user's predictions, with counts and first/last use
+// @Tags prediction
+// @Produce json
+// @Security ApiKeyAuth
+// @Success 200 {object} map[string]interface{} "Model versions retrieved successfully"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 500 {object} map[string]interface{} "Failed to retrieve model versions"
+// @Router /prediction/models [get]
+func (pc *PredictionController) GetPredictionModels(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"status":  "error",
+			"message": "Unauthorized",
+			"error":   "User ID not found in token",
+		})
+		return
+	}
+
+	versions, err := pc.repo.GetModelVersionsByUserID(userID.(uint))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  "error",
+			"message": "Failed to retrieve model versions",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "Model versions retrieved successfully",
+		"data": gin.H{
+			"current_feature_schema_version": ml.FeatureSchemaVersion,
+			"models":                         versions,
+		},
+	})
+}
+
 // GetPredictionsByDateRange - unchanged
 func (pc *PredictionController) GetPredictionsByDateRange(c *gin.Context) {
 	userID, exists := c.Get("user_id")
@@ -866,6 +1256,77 @@ func (pc *PredictionController) GetPredictionsByDateRange(c *gin.Context) {
 	})
 }
 
+// ComparePredictions godoc
+// @Summary Compare two predictions
+// @Description Explain the change between two of the user's predictions: the risk score delta, the input, SHAP and contribution changes of all nine factors, and a plain-language summary of the main drivers
+// @Tags prediction
+// @Produce json
+// @Security ApiKeyAuth
+// @Param from query int true "ID of the earlier prediction"
+// @Param to query int true "ID of the later prediction"
+// @Success 200 {object} map[string]interface{} "Predictions compared successfully"
+// @Failure 400 {object} map[string]interface{} "Invalid prediction IDs"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]interface{} "Prediction belongs to a different user"
+// @Failure 404 {object} map[string]interface{} "Prediction not found"
+// @Router /prediction/compare [get]
+func (pc *PredictionController) ComparePredictions(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"status":  "error",
+			"message": "Unauthorized access",
+		})
+		return
+	}
+
+	fromID, fromErr := strconv.ParseUint(c.Query("from"), 10, 32)
+	toID, toErr := strconv.ParseUint(c.Query("to"), 10, 32)
+	if fromErr != nil || toErr != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  "error",
+			"message": "Invalid prediction IDs",
+			"error":   "from and to must be valid positive integers",
+		})
+		return
+	}
+	if fromID == toID {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  "error",
+			"message": "Invalid prediction IDs",
+			"error":   "from and to must be different predictions",
+		})
+		return
+	}
+
+	predictions := make([]*models.Prediction, 2)
+	for i, id := range []uint64{fromID, toID} {
+		prediction, err := pc.repo.GetPredictionByID(uint(id))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{
+				"status":  "error",
+				"message": "Prediction not found",
+				"error":   fmt.Sprintf("prediction %d not found", id),
+			})
+			return
+		}
+		if prediction.UserID != userID.(uint) {
+			c.JSON(http.StatusForbidden, gin.H{
+				"status":  "error",
+				"message": "Access denied: prediction belongs to a different user",
+			})
+			return
+		}
+		predictions[i] = prediction
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "Predictions compared successfully",
+		"data":    services.ComparePredictions(predictions[0], predictions[1]),
+	})
+}
+
 // GetPredictionByID - unchanged
 func (pc *PredictionController) GetPredictionByID(c *gin.Context) {
 	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
@@ -911,6 +1372,78 @@ func (pc *PredictionController) GetPredictionByID(c *gin.Context) {
 	})
 }
 
+// GetPredictionSnapshot godoc
+// @Summary Get the input snapshot of a prediction
+// @Description Get the exact feature vector sent to the model for a prediction and the derivation trace of every feature: the profile fields, what-if inputs and activity date ranges it was computed from. input_hash_matches tells whether the snapshot reproduces the stored input hash.
+// @Tags prediction
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path int true "Prediction ID"
+// @Success 200 {object} map[string]interface{} "Prediction snapshot retrieved successfully"
+// @Failure 400 {object} map[string]interface{} "Invalid prediction ID"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]interface{} "Prediction belongs to a different user"
+// @Failure 404 {object} map[string]interface{} "Prediction or snapshot not found"
+// @Router /prediction/{id}/snapshot [get]
+func (pc *PredictionController) GetPredictionSnapshot(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"status":  "error",
+			"message": "Unauthorized access",
+		})
+		return
+	}
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  "error",
+			"message": "Invalid prediction ID",
+			"error":   "ID must be a valid positive integer",
+		})
+		return
+	}
+
+	prediction, err := pc.repo.GetPredictionByID(uint(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"status":  "error",
+			"message": "Prediction not found",
+		})
+		return
+	}
+
+	if prediction.UserID != userID.(uint) {
+		c.JSON(http.StatusForbidden, gin.H{
+			"status":  "error",
+			"message": "Access denied: prediction belongs to a different user",
+		})
+		return
+	}
+
+	if prediction.FeatureSnapshot == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"status":  "error",
+			"message": "No input snapshot was stored for this prediction",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "Prediction snapshot retrieved successfully",
+		"data": gin.H{
+			"prediction_id":      prediction.ID,
+			"model_version":      prediction.ModelVersion,
+			"input_hash":         prediction.InputHash,
+			"input_hash_matches": prediction.InputHash == ml.HashFeatures(prediction.FeatureSnapshot.Features),
+			"feature_names":      ml.FeatureNames,
+			"snapshot":           prediction.FeatureSnapshot,
+		},
+	})
+}
+
 // DeletePrediction - unchanged
 func (pc *PredictionController) DeletePrediction(c *gin.Context) {
 	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
@@ -1017,8 +1550,22 @@ func (pc *PredictionController) GetPredictionScoreByDate(c *gin.Context) {
 	})
 }
 
-// GetLatestPredictionExplanation - unchanged
-func (pc *PredictionController) GetLatestPredictionExplanation(c *gin.Context) {
+// GetRiskTrend godoc
+// @Summary Get risk trend analytics
+// @Description Analyze the authenticated user's daily risk scores: weekly or monthly aggregates, a trailing moving average, the least-squares slope with its 95% confidence band, and change points annotated with the profile edits and activity changes around them
+// @Tags prediction
+// @Produce json
+// @Security ApiKeyAuth
+// @Param start_date query string false "Start date (YYYY-MM-DD), default 180 days before end_date"
+// @Param end_date query string false "End date (YYYY-MM-DD), default today"
+// @Param interval query string false "Aggregation interval: week (default) or month"
+// @Param window query int false "Predictions in the moving average (default 3, max 30)"
+// @Success 200 {object} map[string]interface{} "Risk trend retrieved successfully"
+// @Failure 400 {object} map[string]interface{} "Invalid query parameter"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 500 {object} map[string]interface{} "Failed to retrieve risk trend"
+// @Router /prediction/me/trend [get]
+func (pc *PredictionController) GetRiskTrend(c *gin.Context) {
 	userID, exists := c.Get("user_id")
 	if !exists {
 		c.JSON(http.StatusUnauthorized, gin.H{
@@ -1028,187 +1575,242 @@ func (pc *PredictionController) GetLatestPredictionExplanation(c *gin.Context) {
 		return
 	}
 
-	prediction, err := pc.repo.GetLatestPredictionByUserID(userID.(uint))
-	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{
+	now := time.Now()
+	endDate := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	if v := c.Query("end_date"); v != "" {
+		parsed, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"status":  "error",
+				"message": "Invalid end date format",
+				"error":   "Date must be in YYYY-MM-DD format",
+			})
+			return
+		}
+		endDate = parsed
+	}
+	startDate := endDate.AddDate(0, 0, -180)
+	if v := c.Query("start_date"); v != "" {
+		parsed, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"status":  "error",
+				"message": "Invalid start date format",
+				"error":   "Date must be in YYYY-MM-DD format",
+			})
+			return
+		}
+		startDate = parsed
+	}
+	if startDate.After(endDate) {
+		c.JSON(http.StatusBadRequest, gin.H{
 			"status":  "error",
-			"message": "No prediction found",
-			"error":   err.Error(),
+			"message": "Invalid date range",
+			"error":   "start_date must not be after end_date",
 		})
 		return
 	}
+	endDate = endDate.Add(24 * time.Hour).Add(-time.Second)
 
-	hasExplanations := prediction.AgeExplanation != "" &&
-		prediction.BMIExplanation != "" &&
-		prediction.BrinkmanScoreExplanation != "" &&
-		prediction.IsHypertensionExplanation != "" &&
-		prediction.IsCholesterolExplanation != "" &&
-		prediction.IsBloodlineExplanation != "" &&
-		prediction.IsMacrosomicBabyExplanation != "" &&
-		prediction.SmokingStatusExplanation != "" &&
-		prediction.PhysicalActivityFrequencyExplanation != ""
-
-	if hasExplanations {
-		factorExplanations := map[string]string{
-			"age":                         prediction.AgeExplanation,
-			"bmi":                         prediction.BMIExplanation,
-			"brinkman_score":              prediction.BrinkmanScoreExplanation,
-			"is_hypertension":             prediction.IsHypertensionExplanation,
-			"is_cholesterol":              prediction.IsCholesterolExplanation,
-			"is_bloodline":                prediction.IsBloodlineExplanation,
-			"is_macrosomic_baby":          prediction.IsMacrosomicBabyExplanation,
-			"smoking_status":              prediction.SmokingStatusExplanation,
-			"physical_activity_frequency": prediction.PhysicalActivityFrequencyExplanation,
-		}
-
-		c.JSON(http.StatusOK, gin.H{
-			"status":  "success",
-			"message": "Latest prediction explanation retrieved successfully",
-			"data": gin.H{
-				"explanations":       factorExplanations,
-				"prediction_summary": prediction.PredictionSummary,
-			},
+	interval := c.DefaultQuery("interval", models.TrendIntervalWeek)
+	if interval != models.TrendIntervalWeek && interval != models.TrendIntervalMonth {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  "error",
+			"message": "Invalid interval parameter",
+			"error":   "Interval must be week or month",
 		})
 		return
 	}
 
-	if os.Getenv("OPENAI_API_KEY") == "" {
+	window := 3
+	if v := c.Query("window"); v != "" {
+		parsed, err := strconv.Atoi(v)
+		if err != nil || parsed <= 0 || parsed > 30 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"status":  "error",
+				"message": "Invalid window parameter",
+				"error":   "Window must be an integer between 1 and 30",
+			})
+			return
+		}
+		window = parsed
+	}
+
+	predictions, err := pc.repo.GetPredictionsByUserIDAndDateRange(userID.(uint), startDate, endDate)
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"status":  "error",
-			"message": "OpenAI API key is not configured",
+			"message": "Failed to retrieve risk trend",
+			"error":   err.Error(),
 		})
 		return
 	}
 
-	openaiClient, err := openai.NewClient()
+	// Change points are annotated with the activity of the weeks before each prediction
+	activities, err := pc.activityRepo.FindByUserIDAndActivityDateRange(userID.(uint), startDate.Add(-services.TrendActivityWindow), endDate)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"status":  "error",
-			"message": "Failed to initialize OpenAI client",
+			"message": "Failed to retrieve risk trend",
 			"error":   err.Error(),
 		})
 		return
 	}
 
-	factors := map[string]struct {
-		Value        string
-		Shap         float64
-		Contribution float64
-		Impact       float64
-	}{
-		"age": {
-			Value:        fmt.Sprintf("%d years", prediction.Age),
-			Shap:         prediction.AgeShap,
-			Contribution: prediction.AgeContribution,
-			Impact:       prediction.AgeImpact,
-		},
-		"bmi": {
-			Value:        fmt.Sprintf("%.1f", prediction.BMI),
-			Shap:         prediction.BMIShap,
-			Contribution: prediction.BMIContribution,
-			Impact:       prediction.BMIImpact,
-		},
-		"brinkman_score": {
-			Value:        fmt.Sprintf("%.1f", prediction.BrinkmanScore),
-			Shap:         prediction.BrinkmanScoreShap,
-			Contribution: prediction.BrinkmanScoreContribution,
-			Impact:       prediction.BrinkmanScoreImpact,
-		},
-		"is_hypertension": {
-			Value:        fmt.Sprintf("%v", prediction.IsHypertension),
-			Shap:         prediction.IsHypertensionShap,
-			Contribution: prediction.IsHypertensionContribution,
-			Impact:       prediction.IsHypertensionImpact,
-		},
-		"is_cholesterol": {
-			Value:        fmt.Sprintf("%v", prediction.IsCholesterol),
-			Shap:         prediction.IsCholesterolShap,
-			Contribution: prediction.IsCholesterolContribution,
-			Impact:       prediction.IsCholesterolImpact,
-		},
-		"is_bloodline": {
-			Value:        fmt.Sprintf("%v", prediction.IsBloodline),
-			Shap:         prediction.IsBloodlineShap,
-			Contribution: prediction.IsBloodlineContribution,
-			Impact:       prediction.IsBloodlineImpact,
-		},
-		"is_macrosomic_baby": {
-			Value:        fmt.Sprintf("%v", prediction.IsMacrosomicBaby),
-			Shap:         prediction.IsMacrosomicBabyShap,
-			Contribution: prediction.IsMacrosomicBabyContribution,
-			Impact:       prediction.IsMacrosomicBabyImpact,
-		},
-		"smoking_status": {
-			Value:        fmt.Sprintf("%v", prediction.SmokingStatus),
-			Shap:         prediction.SmokingStatusShap,
-			Contribution: prediction.SmokingStatusContribution,
-			Impact:       prediction.SmokingStatusImpact,
-		},
-		"physical_activity_frequency": {
-			Value:        fmt.Sprintf("%d times", prediction.PhysicalActivityFrequency),
-			Shap:         prediction.PhysicalActivityFrequencyShap,
-			Contribution: prediction.PhysicalActivityFrequencyContribution,
-			Impact:       prediction.PhysicalActivityFrequencyImpact,
-		},
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "Risk trend retrieved successfully",
+		"data": services.AnalyzeRiskTrend(predictions, activities, services.RiskTrendOptions{
+			StartDate: startDate,
+			EndDate:   endDate,
+			Interval:  interval,
+			Window:    window,
+		}),
+	})
+}
+
+// explanationRetryBackoff is how long a failed explanation is reported before a poll
+// starts a new one
+const explanationRetryBackoff = 10 * time.Minute
+
+// respondExplanationFailed writes the 503 for a failed explanation job, with a
+// Retry-After header for when a poll will start a new one
+func respondExplanationFailed(c *gin.Context, job *models.PredictionJob, predictionID uint, wait time.Duration) {
+	retrySeconds := int(math.Ceil(wait.Seconds()))
+	if retrySeconds < 1 {
+		retrySeconds = 1
+	}
+	var errMsg string
+	if job.ErrorMessage != nil {
+		errMsg = *job.ErrorMessage
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
-	defer cancel()
+	c.Header("Retry-After", strconv.Itoa(retrySeconds))
+	c.JSON(http.StatusServiceUnavailable, gin.H{
+		"status":  "error",
+		"message": "Prediction explanation failed, please try again later",
+		"error":   errMsg,
+		"data": gin.H{
+			"job_id":              job.ID,
+			"job_status":          job.Status,
+			"prediction_id":       predictionID,
+			"retry_after_seconds": retrySeconds,
+		},
+	})
+}
 
-	explanations, summary, tokenUsage, err := openaiClient.GeneratePredictionExplanation(ctx, prediction.RiskScore, factors)
+// GetLatestPredictionExplanation godoc
+// @Summary Get latest prediction explanation
+// @Description Get the plain-language explanation of the latest prediction. Explanations are generated in a background job; while it runs the endpoint answers 202 with the job to poll. A failed job is reported with its error and started again only with retry=true or once the retry backoff has passed.
+// @Tags prediction
+// @Produce json
+// @Security ApiKeyAuth
+// @Param retry query bool false "Start a new explanation job when the last one failed"
+// @Success 200 {object} map[string]interface{} "Explanation retrieved"
+// @Success 202 {object} map[string]interface{} "Explanation is being generated"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 404 {object} map[string]interface{} "No prediction found"
+// @Failure 503 {object} map[string]interface{} "Explanations are not configured, or the last explanation failed"
+// @Router /prediction/me/explanation [get]
+func (pc *PredictionController) GetLatestPredictionExplanation(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"status":  "error",
+			"message": "Unauthorized access",
+		})
+		return
+	}
+
+	prediction, err := pc.repo.GetLatestPredictionByUserID(userID.(uint))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
+		c.JSON(http.StatusNotFound, gin.H{
 			"status":  "error",
-			"message": "Failed to generate LLM explanation",
+			"message": "No prediction found",
 			"error":   err.Error(),
 		})
 		return
 	}
 
-	if len(explanations) == 0 {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"status":  "error",
-			"message": "No explanations were generated",
+	if prediction.HasExplanations() {
+		factorExplanations := make(map[string]string, len(prediction.Factors))
+		for _, factor := range prediction.Factors {
+			factorExplanations[factor.Factor] = factor.Explanation
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"status":  "success",
+			"message": "Latest prediction explanation retrieved successfully",
+			"data": gin.H{
+				"explanations":       factorExplanations,
+				"prediction_summary": prediction.PredictionSummary,
+			},
 		})
 		return
 	}
 
-	prediction.AgeExplanation = explanations["age"].Explanation
-	prediction.BMIExplanation = explanations["bmi"].Explanation
-	prediction.BrinkmanScoreExplanation = explanations["brinkman_score"].Explanation
-	prediction.IsHypertensionExplanation = explanations["is_hypertension"].Explanation
-	prediction.IsCholesterolExplanation = explanations["is_cholesterol"].Explanation
-	prediction.IsBloodlineExplanation = explanations["is_bloodline"].Explanation
-	prediction.IsMacrosomicBabyExplanation = explanations["is_macrosomic_baby"].Explanation
-	prediction.SmokingStatusExplanation = explanations["smoking_status"].Explanation
-	prediction.PhysicalActivityFrequencyExplanation = explanations["physical_activity_frequency"].Explanation
-	prediction.PredictionSummary = summary
+	if pc.explanations == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"status":  "error",
+			"message": "Prediction explanations are not configured",
+		})
+		return
+	}
 
-	if err := pc.repo.UpdatePrediction(prediction); err != nil {
+	// Explanations are generated in the background; report the job that is already
+	// running, or start one, and let the client poll its status
+	job, err := pc.explanations.LatestJob(prediction)
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"status":  "error",
-			"message": "Failed to update prediction with explanations",
+			"message": "Failed to retrieve explanation job",
 			"error":   err.Error(),
 		})
 		return
 	}
 
-	factorExplanations := make(map[string]string)
-	for factor, exp := range explanations {
-		factorExplanations[factor] = exp.Explanation
+	// A failed explanation is reported instead of being started again on every poll
+	if job != nil && job.Status == models.JobStatusFailed && c.Query("retry") != "true" {
+		if wait := explanationRetryBackoff - time.Since(job.UpdatedAt); wait > 0 {
+			respondExplanationFailed(c, job, prediction.ID, wait)
+			return
+		}
 	}
 
-	c.JSON(http.StatusOK, gin.H{
+	if job == nil || (job.Status != models.JobStatusPending && job.Status != models.JobStatusProcessing) {
+		job, err = pc.explanations.Enqueue(prediction)
+		if err != nil {
+			switch {
+			case errors.Is(err, services.ErrExplanationUnavailable):
+				c.JSON(http.StatusServiceUnavailable, gin.H{
+					"status":  "error",
+					"message": "Prediction explanations are not configured",
+				})
+			case errors.Is(err, services.ErrExplanationQueueFull):
+				c.JSON(http.StatusServiceUnavailable, gin.H{
+					"status":  "error",
+					"message": "Explanation queue is full, please try again later",
+					"error":   err.Error(),
+				})
+			default:
+				c.JSON(http.StatusInternalServerError, gin.H{
+					"status":  "error",
+					"message": "Failed to start explanation job",
+					"error":   err.Error(),
+				})
+			}
+			return
+		}
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
 		"status":  "success",
-		"message": "Latest prediction explanation generated successfully",
+		"message": "Prediction explanation is being generated",
 		"data": gin.H{
-			"explanations":       factorExplanations,
-			"prediction_summary": summary,
-			"token_usage": gin.H{
-				"prompt_tokens":     tokenUsage.PromptTokens,
-				"completion_tokens": tokenUsage.CompletionTokens,
-				"total_tokens":      tokenUsage.TotalTokens,
-			},
+			"job_id":        job.ID,
+			"job_status":    job.Status,
+			"prediction_id": prediction.ID,
+			"poll_url":      fmt.Sprintf("/prediction/job/%s/status", job.ID),
 		},
 	})
 }
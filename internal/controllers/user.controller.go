@@ -8,14 +8,17 @@ import (
 	"diabetify/internal/repository"
 	"diabetify/internal/utils"
 	"encoding/hex"
+	"errors"
 	"log"
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
 )
 
 type UserController struct {
@@ -36,6 +39,11 @@ type ForgotPasswordRequest struct {
 	Email string `json:"email" binding:"required"`
 }
 
+// ResearchConsentRequest gives or withdraws consent to use the user's data for research
+type ResearchConsentRequest struct {
+	Consent *bool `json:"consent" binding:"required" example:"true"`
+}
+
 type ResetPasswordRequest struct {
 	Email       string `json:"email" binding:"required,email"`
 	Code        string `json:"code" binding:"required"`
@@ -617,6 +625,18 @@ func (uc *UserController) PatchUser(c *gin.Context) {
 		patchData["password"] = hashedPassword
 	}
 
+	// Consent is only given or withdrawn through its own endpoint
+	for key := range patchData {
+		if isResearchConsentField(key) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"status":  "error",
+				"message": "Research consent is changed through PUT /users/me/research-consent",
+				"error":   "Field " + key + " cannot be patched",
+			})
+			return
+		}
+	}
+
 	// Prevent changing email to one that already exists
 	if email, hasEmail := patchData["email"].(string); hasEmail && email != existingUser.Email {
 		// Check if email already exists
@@ -701,3 +721,78 @@ func (uc *UserController) GetCurrentUser(c *gin.Context) {
 		"data":    user,
 	})
 }
+
+// UpdateResearchConsent godoc
+// @Summary Give or withdraw research consent
+// @Description Allow or stop the use of the authenticated user's labeled data for model retraining
+// @Tags users
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param consent body ResearchConsentRequest true "Research consent"
+// @Success 200 {object} map[string]interface{} "Research consent updated successfully"
+// @Failure 400 {object} map[string]interface{} "Invalid request data"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 404 {object} map[string]interface{} "User not found"
+// @Failure 500 {object} map[string]interface{} "Failed to update research consent"
+// @Router /users/me/research-consent [put]
+func (uc *UserController) UpdateResearchConsent(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"status":  "error",
+			"message": "Unauthorized",
+			"error":   "User ID not found in token",
+		})
+		return
+	}
+
+	var req ResearchConsentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  "error",
+			"message": "Invalid request data",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	now := time.Now().UTC()
+	if err := uc.repo.UpdateResearchConsent(userID.(uint), *req.Consent, now); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"status":  "error",
+				"message": "User not found",
+				"error":   "No user exists with this ID",
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  "error",
+			"message": "Failed to update research consent",
+			"error":   "Database update failed",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "Research consent updated successfully",
+		"data": gin.H{
+			"research_consent":    *req.Consent,
+			"research_consent_at": now,
+		},
+	})
+}
+
+// isResearchConsentField matches a patch key against the consent columns and their
+// field names
+func isResearchConsentField(key string) bool {
+	normalized := strings.ToLower(strings.ReplaceAll(key, "_", ""))
+	for _, column := range models.ResearchConsentColumns() {
+		if normalized == strings.ReplaceAll(column, "_", "") {
+			return true
+		}
+	}
+	return false
+}
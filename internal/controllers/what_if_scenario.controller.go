@@ -0,0 +1,249 @@
+package controllers
+
+import (
+	"diabetify/internal/models"
+	"diabetify/internal/repository"
+	"diabetify/internal/services"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const maxScenarioListLimit = 100
+
+type WhatIfScenarioController struct {
+	repo      repository.WhatIfScenarioRepository
+	jobWorker services.PredictionJobWorker
+}
+
+func NewWhatIfScenarioController(repo repository.WhatIfScenarioRepository, jobWorker services.PredictionJobWorker) *WhatIfScenarioController {
+	return &WhatIfScenarioController{repo: repo, jobWorker: jobWorker}
+}
+
+// GetScenarios godoc
+// @Summary List saved what-if scenarios
+// @Description List the authenticated user's completed what-if scenarios, pinned first, then newest first
+// @Tags prediction
+// @Produce json
+// @Security ApiKeyAuth
+// @Param pinned query bool false "Only pinned scenarios"
+// @Param limit query int false "Maximum number of scenarios (default 20, max 100)"
+// @Success 200 {object} map[string]interface{} "Scenarios retrieved successfully"
+// @Failure 400 {object} map[string]interface{} "Invalid query parameter"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 500 {object} map[string]interface{} "Failed to retrieve scenarios"
+// @Router /prediction/what-if/scenarios [get]
+func (sc *WhatIfScenarioController) GetScenarios(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"status":  "error",
+			"message": "Unauthorized",
+			"error":   "User ID not found in token",
+		})
+		return
+	}
+
+	limit := 20
+	if v := c.Query("limit"); v != "" {
+		parsed, err := strconv.Atoi(v)
+		if err != nil || parsed <= 0 || parsed > maxScenarioListLimit {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"status":  "error",
+				"message": "Invalid limit parameter",
+				"error":   "Limit must be an integer between 1 and 100",
+			})
+			return
+		}
+		limit = parsed
+	}
+
+	pinnedOnly := false
+	if v := c.Query("pinned"); v != "" {
+		parsed, err := strconv.ParseBool(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"status":  "error",
+				"message": "Invalid pinned parameter",
+				"error":   "Pinned must be true or false",
+			})
+			return
+		}
+		pinnedOnly = parsed
+	}
+
+	scenarios, err := sc.repo.GetScenariosByUserID(userID.(uint), pinnedOnly, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  "error",
+			"message": "Failed to retrieve scenarios",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "Scenarios retrieved successfully",
+		"data":    scenarios,
+	})
+}
+
+// GetScenario godoc
+// @Summary Get a saved what-if scenario
+// @Description Get one what-if scenario of the authenticated user, with its input, features, risk and SHAP explanation
+// @Tags prediction
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path int true "Scenario ID"
+// @Success 200 {object} map[string]interface{} "Scenario retrieved successfully"
+// @Failure 400 {object} map[string]interface{} "Invalid scenario ID"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 404 {object} map[string]interface{} "Scenario not found"
+// @Router /prediction/what-if/scenarios/{id} [get]
+func (sc *WhatIfScenarioController) GetScenario(c *gin.Context) {
+	scenario, ok := sc.findScenario(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "Scenario retrieved successfully",
+		"data":    scenario,
+	})
+}
+
+// UpdateScenario godoc
+// @Summary Name or pin a what-if scenario
+// @Description Set the name and/or pinned flag of a what-if scenario; omitted fields are unchanged
+// @Tags prediction
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path int true "Scenario ID"
+// @Param scenario body models.WhatIfScenarioUpdateRequest true "Fields to change"
+// @Success 200 {object} map[string]interface{} "Scenario updated successfully"
+// @Failure 400 {object} map[string]interface{} "Invalid request data"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 404 {object} map[string]interface{} "Scenario not found"
+// @Failure 500 {object} map[string]interface{} "Failed to update scenario"
+// @Router /prediction/what-if/scenarios/{id} [patch]
+func (sc *WhatIfScenarioController) UpdateScenario(c *gin.Context) {
+	var request models.WhatIfScenarioUpdateRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  "error",
+			"message": "Invalid request data",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	scenario, ok := sc.findScenario(c)
+	if !ok {
+		return
+	}
+
+	if request.Name != nil {
+		scenario.Name = *request.Name
+	}
+	if request.Pinned != nil {
+		scenario.Pinned = *request.Pinned
+	}
+
+	if err := sc.repo.SaveScenario(scenario); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  "error",
+			"message": "Failed to update scenario",
+			"error":   err.Error(),
+		})
+		return
+	}
+	sc.jobWorker.InvalidateWhatIfResult(scenario.JobID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "Scenario updated successfully",
+		"data":    scenario,
+	})
+}
+
+// DeleteScenario godoc
+// @Summary Delete a what-if scenario
+// @Description Delete a what-if scenario of the authenticated user
+// @Tags prediction
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path int true "Scenario ID"
+// @Success 200 {object} map[string]interface{} "Scenario deleted successfully"
+// @Failure 400 {object} map[string]interface{} "Invalid scenario ID"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 404 {object} map[string]interface{} "Scenario not found"
+// @Failure 500 {object} map[string]interface{} "Failed to delete scenario"
+// @Router /prediction/what-if/scenarios/{id} [delete]
+func (sc *WhatIfScenarioController) DeleteScenario(c *gin.Context) {
+	scenario, ok := sc.findScenario(c)
+	if !ok {
+		return
+	}
+
+	if err := sc.repo.DeleteScenario(scenario.UserID, scenario.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  "error",
+			"message": "Failed to delete scenario",
+			"error":   err.Error(),
+		})
+		return
+	}
+	sc.jobWorker.InvalidateWhatIfResult(scenario.JobID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "Scenario deleted successfully",
+	})
+}
+
+// findScenario loads the scenario in the :id path parameter for the authenticated user,
+// writing an error response when it cannot
+func (sc *WhatIfScenarioController) findScenario(c *gin.Context) (*models.WhatIfScenario, bool) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"status":  "error",
+			"message": "Unauthorized",
+			"error":   "User ID not found in token",
+		})
+		return nil, false
+	}
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  "error",
+			"message": "Invalid scenario ID",
+			"error":   "Scenario ID must be a positive integer",
+		})
+		return nil, false
+	}
+
+	scenario, err := sc.repo.GetScenarioByID(userID.(uint), uint(id))
+	if err != nil {
+		status := http.StatusInternalServerError
+		message := "Failed to retrieve scenario"
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			status = http.StatusNotFound
+			message = "Scenario not found"
+		}
+		c.JSON(status, gin.H{
+			"status":  "error",
+			"message": message,
+			"error":   err.Error(),
+		})
+		return nil, false
+	}
+	return scenario, true
+}
@@ -0,0 +1,88 @@
+package controllers
+
+import (
+	"diabetify/internal/models"
+	"diabetify/internal/services"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type WhatIfSweepController struct {
+	sweeps services.WhatIfSweepService
+}
+
+func NewWhatIfSweepController(sweeps services.WhatIfSweepService) *WhatIfSweepController {
+	return &WhatIfSweepController{sweeps: sweeps}
+}
+
+// RunWhatIfSweep godoc
+// @Summary Sweep what-if parameters
+// @Description Score a what-if input while one or two fields move across a range, e.g. weight from 90 to 70 kg in 5 kg steps. Returns a risk series for one axis, or a grid for two, ready to chart.
+// @Tags prediction
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param sweep body models.WhatIfSweepRequest true "Base input and axes"
+// @Success 200 {object} map[string]interface{} "Sweep completed"
+// @Failure 400 {object} map[string]interface{} "Invalid sweep or incomplete profile"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 413 {object} map[string]interface{} "Sweep has too many points"
+// @Failure 429 {object} map[string]interface{} "Active job or daily what-if quota reached"
+// @Failure 500 {object} map[string]interface{} "Failed to run sweep"
+// @Router /prediction/what-if/sweep [post]
+func (sc *WhatIfSweepController) RunWhatIfSweep(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"status":  "error",
+			"message": "Unauthorized access",
+		})
+		return
+	}
+
+	var request models.WhatIfSweepRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  "error",
+			"message": "Invalid sweep",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	result, err := sc.sweeps.Run(c.Request.Context(), userID.(uint), &request)
+	if err != nil {
+		var exceeded *services.QuotaExceededError
+		if errors.As(err, &exceeded) {
+			respondQuotaExceeded(c, exceeded)
+			return
+		}
+		status := http.StatusInternalServerError
+		message := "Failed to run sweep"
+		switch {
+		case errors.Is(err, services.ErrSweepTooLarge):
+			status = http.StatusRequestEntityTooLarge
+			message = "Sweep has too many points"
+		case errors.Is(err, services.ErrInvalidSweep):
+			status = http.StatusBadRequest
+			message = "Invalid sweep"
+		case errors.Is(err, services.ErrSweepFeatures):
+			status = http.StatusBadRequest
+			message = "Incomplete user profile"
+		}
+		c.JSON(status, gin.H{
+			"status":  "error",
+			"message": message,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "Sweep completed",
+		"data":    result,
+	})
+}
@@ -74,3 +74,27 @@ func AuthMiddleware() gin.HandlerFunc {
 		}
 	}
 }
+
+// AdminMiddleware allows only users whose email is listed in ADMIN_EMAILS
+// (comma-separated). It must run after AuthMiddleware.
+func AdminMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		email, _ := c.Get("email")
+		emailStr, _ := email.(string)
+
+		for _, admin := range strings.Split(os.Getenv("ADMIN_EMAILS"), ",") {
+			admin = strings.TrimSpace(admin)
+			if admin != "" && strings.EqualFold(admin, emailStr) {
+				c.Next()
+				return
+			}
+		}
+
+		c.JSON(http.StatusForbidden, gin.H{
+			"status":  "error",
+			"message": "Admin access required",
+			"error":   "Your account is not allowed to access this resource",
+		})
+		c.Abort()
+	}
+}
@@ -0,0 +1,493 @@
+package ml
+
+import (
+	"context"
+	"diabetify/internal/models"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// BreakerState is the state of the ML circuit breaker
+type BreakerState string
+
+const (
+	BreakerClosed   BreakerState = "closed"
+	BreakerOpen     BreakerState = "open"
+	BreakerHalfOpen BreakerState = "half_open"
+)
+
+// ErrResponseTimeout is recorded when a submitted job never receives an ML response
+var ErrResponseTimeout = errors.New("ML service did not respond in time")
+
+// CircuitOpenError is returned while the breaker rejects calls and no fallback is configured
+type CircuitOpenError struct {
+	RetryAfter time.Duration
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("ML service circuit breaker is open, retry after %s", e.RetryAfter.Round(time.Second))
+}
+
+// ResponseObserver is notified when an ML response arrives (or never does) so
+// wrappers such as the circuit breaker can track the health of the consumer side.
+type ResponseObserver interface {
+	RecordResponse(latency time.Duration, err error)
+}
+
+// AvailabilityReporter tells callers up front whether a prediction can be served.
+// When unavailable, retryAfter is a hint for the Retry-After header.
+type AvailabilityReporter interface {
+	Available() (ok bool, retryAfter time.Duration)
+}
+
+// CircuitBreakerConfig tunes when the breaker trips and recovers
+type CircuitBreakerConfig struct {
+	// Consecutive failures (publish errors, failed probes, timed out responses) before opening
+	FailureThreshold int
+	// Responses slower than this count towards SlowCallThreshold
+	SlowCallDuration time.Duration
+	// Consecutive slow responses before opening
+	SlowCallThreshold int
+	// How long the breaker stays open before allowing a trial call
+	OpenTimeout time.Duration
+	// How often the breaker probes ML health in the background
+	ProbeInterval time.Duration
+}
+
+// CircuitBreakerConfigFromEnv returns the default configuration with ML_BREAKER_* overrides applied
+func CircuitBreakerConfigFromEnv() CircuitBreakerConfig {
+	cfg := CircuitBreakerConfig{
+		FailureThreshold:  5,
+		SlowCallDuration:  30 * time.Second,
+		SlowCallThreshold: 5,
+		OpenTimeout:       60 * time.Second,
+		ProbeInterval:     30 * time.Second,
+	}
+
+	if v, err := strconv.Atoi(os.Getenv("ML_BREAKER_FAILURE_THRESHOLD")); err == nil && v > 0 {
+		cfg.FailureThreshold = v
+	}
+	if v, err := time.ParseDuration(os.Getenv("ML_BREAKER_SLOW_CALL_DURATION")); err == nil && v > 0 {
+		cfg.SlowCallDuration = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("ML_BREAKER_SLOW_CALL_THRESHOLD")); err == nil && v > 0 {
+		cfg.SlowCallThreshold = v
+	}
+	if v, err := time.ParseDuration(os.Getenv("ML_BREAKER_OPEN_TIMEOUT")); err == nil && v > 0 {
+		cfg.OpenTimeout = v
+	}
+	if v, err := time.ParseDuration(os.Getenv("ML_BREAKER_PROBE_INTERVAL")); err == nil && v > 0 {
+		cfg.ProbeInterval = v
+	}
+
+	return cfg
+}
+
+// CircuitBreakerClient wraps an MLClient and stops sending work to it while the ML
+// service is failing. While open, predictions go to the fallback client if one is
+// configured; otherwise they are rejected with a CircuitOpenError.
+type CircuitBreakerClient struct {
+	inner    MLClient
+	fallback MLClient
+	cfg      CircuitBreakerConfig
+
+	mu                  sync.Mutex
+	state               BreakerState
+	openedAt            time.Time
+	consecutiveFailures int
+	consecutiveSlow     int
+	trialInFlight       bool
+	// slowTrip is set when the breaker opened on slow responses, which a fast health
+	// probe says nothing about
+	slowTrip    bool
+	lastFailure string
+	lastLatency time.Duration
+
+	// Counters
+	trips         int64
+	successes     int64
+	failures      int64
+	slowCalls     int64
+	rejected      int64
+	fallbackCalls int64
+
+	stopChan chan struct{}
+	wg       sync.WaitGroup
+}
+
+// NewCircuitBreakerClient wraps inner with a circuit breaker; fallback may be nil
+func NewCircuitBreakerClient(inner, fallback MLClient, cfg CircuitBreakerConfig) *CircuitBreakerClient {
+	cb := &CircuitBreakerClient{
+		inner:    inner,
+		fallback: fallback,
+		cfg:      cfg,
+		state:    BreakerClosed,
+		stopChan: make(chan struct{}),
+	}
+
+	if cfg.ProbeInterval > 0 {
+		cb.wg.Add(1)
+		go cb.monitor()
+	}
+
+	return cb
+}
+
+// ========== MLClient IMPLEMENTATION ==========
+
+func (cb *CircuitBreakerClient) PredictAsync(ctx context.Context, jobID string, features []float64) error {
+	if !cb.allow() {
+		if cb.fallback != nil {
+			cb.countFallback()
+			return cb.fallback.PredictAsync(ctx, jobID, features)
+		}
+		return &CircuitOpenError{RetryAfter: cb.retryAfter()}
+	}
+
+	if err := cb.inner.PredictAsync(ctx, jobID, features); err != nil {
+		cb.recordFailure(err)
+		if cb.fallback != nil {
+			cb.countFallback()
+			return cb.fallback.PredictAsync(ctx, jobID, features)
+		}
+		return err
+	}
+
+	// Publishing succeeded; the breaker is judged on the response via RecordResponse
+	return nil
+}
+
+func (cb *CircuitBreakerClient) HealthCheckAsync(ctx context.Context) error {
+	return cb.inner.HealthCheckAsync(ctx)
+}
+
+func (cb *CircuitBreakerClient) ProbeHealth(ctx context.Context) (*HealthStatus, error) {
+	start := time.Now()
+	status, err := cb.inner.ProbeHealth(ctx)
+	if status != nil && status.Cached {
+		// Cached results were already recorded when they were fresh
+		return status, err
+	}
+	if err != nil {
+		cb.recordFailure(err)
+	} else {
+		cb.recordProbe(time.Since(start))
+	}
+	return status, err
+}
+
+func (cb *CircuitBreakerClient) Close() error {
+	select {
+	case <-cb.stopChan:
+	default:
+		close(cb.stopChan)
+	}
+	cb.wg.Wait()
+
+	var errs []error
+	if err := cb.inner.Close(); err != nil {
+		errs = append(errs, err)
+	}
+	if cb.fallback != nil {
+		if err := cb.fallback.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ========== OPTIONAL INTERFACES ==========
+
+// PredictSync is guarded like PredictAsync; since the answer arrives in the same call
+// its latency is recorded directly. Calls the inner client cannot answer synchronously
+// go to the fallback.
+func (cb *CircuitBreakerClient) PredictSync(ctx context.Context, features []float64) (*ScoreResult, error) {
+	if !cb.allow() {
+		if predictor, ok := cb.fallback.(SyncPredictor); ok {
+			cb.countFallback()
+			return predictor.PredictSync(ctx, features)
+		}
+		return nil, &CircuitOpenError{RetryAfter: cb.retryAfter()}
+	}
+
+	predictor, ok := cb.inner.(SyncPredictor)
+	if !ok {
+		cb.releaseTrial()
+		return cb.syncFallback(ctx, features)
+	}
+
+	start := time.Now()
+	result, err := predictor.PredictSync(ctx, features)
+	if errors.Is(err, ErrSyncUnsupported) {
+		cb.releaseTrial()
+		return cb.syncFallback(ctx, features)
+	}
+	cb.RecordResponse(time.Since(start), err)
+	return result, err
+}
+
+// syncFallback scores with the fallback when the inner client has no synchronous path
+func (cb *CircuitBreakerClient) syncFallback(ctx context.Context, features []float64) (*ScoreResult, error) {
+	predictor, ok := cb.fallback.(SyncPredictor)
+	if !ok {
+		return nil, ErrSyncUnsupported
+	}
+	cb.countFallback()
+	return predictor.PredictSync(ctx, features)
+}
+
+func (cb *CircuitBreakerClient) countFallback() {
+	cb.mu.Lock()
+	cb.fallbackCalls++
+	cb.mu.Unlock()
+}
+
+// UpdateModel is passed through; model updates are rare, admin-triggered calls
+func (cb *CircuitBreakerClient) UpdateModel(ctx context.Context, request *models.UpdateModelRequest) (*models.UpdateModelResponse, error) {
+	updater, ok := cb.inner.(ModelUpdater)
+	if !ok {
+		return nil, errors.New("ML client does not support model updates")
+	}
+	return updater.UpdateModel(ctx, request)
+}
+
+func (cb *CircuitBreakerClient) PromoteModel(ctx context.Context, candidateVersion string) (*models.PromoteModelResponse, error) {
+	updater, ok := cb.inner.(ModelUpdater)
+	if !ok {
+		return nil, errors.New("ML client does not support model updates")
+	}
+	return updater.PromoteModel(ctx, candidateVersion)
+}
+
+// RecordResponse is called by the job worker when an ML response arrives or times out
+func (cb *CircuitBreakerClient) RecordResponse(latency time.Duration, err error) {
+	if err != nil {
+		cb.recordFailure(err)
+		return
+	}
+	cb.recordSuccess(latency)
+}
+
+// Available reports whether a prediction submitted now can be served
+func (cb *CircuitBreakerClient) Available() (bool, time.Duration) {
+	if cb.fallback != nil {
+		return true, 0
+	}
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	switch cb.state {
+	case BreakerClosed:
+		return true, 0
+	case BreakerHalfOpen:
+		if cb.trialInFlight {
+			// Calls are rejected until the trial call has been answered
+			return false, time.Second
+		}
+		return true, 0
+	}
+	if time.Since(cb.openedAt) >= cb.cfg.OpenTimeout {
+		// A trial call is allowed
+		return true, 0
+	}
+	return false, cb.cfg.OpenTimeout - time.Since(cb.openedAt)
+}
+
+// ConnectionStats passes through the wrapped client's connection counters
+func (cb *CircuitBreakerClient) ConnectionStats() map[string]interface{} {
+	if provider, ok := cb.inner.(ConnectionStatsProvider); ok {
+		return provider.ConnectionStats()
+	}
+	return nil
+}
+
+// SetResponseHandler forwards the handler to wrapped clients that deliver responses in-process
+func (cb *CircuitBreakerClient) SetResponseHandler(handler func(body []byte) error) {
+	for _, client := range []MLClient{cb.inner, cb.fallback} {
+		if sink, ok := client.(ResponseSink); ok {
+			sink.SetResponseHandler(handler)
+		}
+	}
+}
+
+// State returns the current breaker state
+func (cb *CircuitBreakerClient) State() BreakerState {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return cb.state
+}
+
+// Stats returns breaker state and counters for debug endpoints
+func (cb *CircuitBreakerClient) Stats() map[string]interface{} {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	stats := map[string]interface{}{
+		"state":                cb.state,
+		"consecutive_failures": cb.consecutiveFailures,
+		"consecutive_slow":     cb.consecutiveSlow,
+		"trips":                cb.trips,
+		"successes":            cb.successes,
+		"failures":             cb.failures,
+		"slow_calls":           cb.slowCalls,
+		"rejected":             cb.rejected,
+		"fallback_configured":  cb.fallback != nil,
+		"fallback_calls":       cb.fallbackCalls,
+		"last_latency_ms":      cb.lastLatency.Milliseconds(),
+		"failure_threshold":    cb.cfg.FailureThreshold,
+		"slow_call_duration":   cb.cfg.SlowCallDuration.String(),
+		"open_timeout":         cb.cfg.OpenTimeout.String(),
+	}
+	if cb.lastFailure != "" {
+		stats["last_failure"] = cb.lastFailure
+	}
+	if cb.state == BreakerOpen {
+		stats["opened_at"] = cb.openedAt
+		stats["retry_after_seconds"] = int((cb.cfg.OpenTimeout - time.Since(cb.openedAt)).Seconds())
+	}
+	return stats
+}
+
+// ========== STATE MACHINE ==========
+
+// allow decides whether a call may go to the inner client
+func (cb *CircuitBreakerClient) allow() bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	switch cb.state {
+	case BreakerClosed:
+		return true
+	case BreakerOpen:
+		if time.Since(cb.openedAt) < cb.cfg.OpenTimeout {
+			cb.rejected++
+			return false
+		}
+		cb.state = BreakerHalfOpen
+		cb.trialInFlight = true
+		return true
+	default: // half-open: only one trial at a time
+		if cb.trialInFlight {
+			cb.rejected++
+			return false
+		}
+		cb.trialInFlight = true
+		return true
+	}
+}
+
+func (cb *CircuitBreakerClient) recordSuccess(latency time.Duration) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	cb.successes++
+	cb.lastLatency = latency
+	cb.consecutiveFailures = 0
+
+	if cb.cfg.SlowCallDuration > 0 && latency > cb.cfg.SlowCallDuration {
+		cb.slowCalls++
+		cb.consecutiveSlow++
+		// A slow trial reopens the breaker like a failed one; otherwise half-open would
+		// wait for SlowCallThreshold trials that allow never lets through
+		if cb.state == BreakerHalfOpen || cb.consecutiveSlow >= cb.cfg.SlowCallThreshold {
+			cb.lastFailure = fmt.Sprintf("%d consecutive responses slower than %s", cb.consecutiveSlow, cb.cfg.SlowCallDuration)
+			cb.trip()
+			cb.slowTrip = true
+		}
+		return
+	}
+
+	cb.consecutiveSlow = 0
+	if cb.state != BreakerClosed {
+		cb.state = BreakerClosed
+		cb.trialInFlight = false
+	}
+}
+
+// recordProbe records a successful health probe. A probe does not close a breaker that
+// opened on slow responses; only a trial prediction that answers in time does.
+func (cb *CircuitBreakerClient) recordProbe(latency time.Duration) {
+	cb.mu.Lock()
+	if cb.state != BreakerClosed && cb.slowTrip {
+		cb.lastLatency = latency
+		cb.mu.Unlock()
+		return
+	}
+	cb.mu.Unlock()
+
+	cb.recordSuccess(latency)
+}
+
+func (cb *CircuitBreakerClient) recordFailure(err error) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	// A caller that gave up says nothing about the ML service; only free a trial slot
+	if errors.Is(err, context.Canceled) {
+		cb.trialInFlight = false
+		return
+	}
+
+	cb.failures++
+	cb.consecutiveFailures++
+	cb.lastFailure = err.Error()
+
+	if cb.state == BreakerHalfOpen || cb.consecutiveFailures >= cb.cfg.FailureThreshold {
+		cb.trip()
+	}
+}
+
+// trip opens the breaker; callers must hold cb.mu
+func (cb *CircuitBreakerClient) trip() {
+	if cb.state != BreakerOpen {
+		cb.trips++
+	}
+	cb.state = BreakerOpen
+	cb.openedAt = time.Now()
+	cb.trialInFlight = false
+	cb.consecutiveSlow = 0
+	cb.slowTrip = false
+}
+
+// releaseTrial gives back a half-open trial slot that was not used for a real call
+func (cb *CircuitBreakerClient) releaseTrial() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.trialInFlight = false
+}
+
+func (cb *CircuitBreakerClient) retryAfter() time.Duration {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	remaining := cb.cfg.OpenTimeout - time.Since(cb.openedAt)
+	if remaining < time.Second {
+		remaining = time.Second
+	}
+	return remaining
+}
+
+// monitor probes ML health in the background so the breaker opens without user
+// traffic and closes again as soon as the ML service answers
+func (cb *CircuitBreakerClient) monitor() {
+	defer cb.wg.Done()
+
+	ticker := time.NewTicker(cb.cfg.ProbeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-cb.stopChan:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			_, _ = cb.ProbeHealth(ctx)
+			cancel()
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package ml
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Experiment modes accepted in ML_EXPERIMENT_MODE
+const (
+	ExperimentOff    = "off"
+	ExperimentAB     = "ab"
+	ExperimentShadow = "shadow"
+)
+
+// ControlVariant names the current production model in experiment results
+const ControlVariant = "control"
+
+// shadowSuffix marks the correlation ID of a shadow request so its response is not
+// mistaken for the job's own result
+const shadowSuffix = ":shadow"
+
+// Candidate model names become part of a RabbitMQ queue name
+var modelVariantPattern = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// ExperimentConfig routes a share of users to a candidate model, either instead of
+// the production model (ab) or alongside it with only the production score shown (shadow)
+type ExperimentConfig struct {
+	// Name salts the user assignment, so renaming the experiment reshuffles users
+	Name string `json:"name"`
+	Mode string `json:"mode"`
+	// CandidateModel is served from the ml.prediction.request.<CandidateModel> queue
+	CandidateModel string `json:"candidate_model"`
+	// Percent of users (0-100) who get the candidate (ab) or are also scored by it (shadow)
+	Percent int `json:"percent"`
+}
+
+// ExperimentConfigFromEnv reads ML_EXPERIMENT_NAME, ML_EXPERIMENT_MODE,
+// ML_CANDIDATE_MODEL and ML_CANDIDATE_PERCENT
+func ExperimentConfigFromEnv() (ExperimentConfig, error) {
+	cfg := ExperimentConfig{
+		Name:           os.Getenv("ML_EXPERIMENT_NAME"),
+		Mode:           strings.ToLower(os.Getenv("ML_EXPERIMENT_MODE")),
+		CandidateModel: os.Getenv("ML_CANDIDATE_MODEL"),
+	}
+	if cfg.Mode == "" {
+		cfg.Mode = ExperimentOff
+	}
+	if v := os.Getenv("ML_CANDIDATE_PERCENT"); v != "" {
+		percent, err := strconv.Atoi(v)
+		if err != nil || percent < 0 || percent > 100 {
+			return cfg, fmt.Errorf("ML_CANDIDATE_PERCENT must be between 0 and 100, got %q", v)
+		}
+		cfg.Percent = percent
+	}
+	if cfg.Name == "" {
+		cfg.Name = cfg.CandidateModel
+	}
+
+	switch cfg.Mode {
+	case ExperimentOff:
+	case ExperimentAB, ExperimentShadow:
+		if !modelVariantPattern.MatchString(cfg.CandidateModel) || cfg.CandidateModel == ControlVariant {
+			return cfg, fmt.Errorf("ML_CANDIDATE_MODEL %q is not a valid model name", cfg.CandidateModel)
+		}
+	default:
+		return cfg, fmt.Errorf("unknown ML_EXPERIMENT_MODE %q", cfg.Mode)
+	}
+	return cfg, nil
+}
+
+// Active reports whether any user is routed to the candidate
+func (c ExperimentConfig) Active() bool {
+	return (c.Mode == ExperimentAB || c.Mode == ExperimentShadow) && c.CandidateModel != "" && c.Percent > 0
+}
+
+// ModelVariants lists the non-control variants whose request queues must exist
+func (c ExperimentConfig) ModelVariants() []string {
+	if !c.Active() {
+		return nil
+	}
+	return []string{c.CandidateModel}
+}
+
+// Bucket places the user in [0, 100). It depends only on the experiment name and the
+// user ID, so assignment is sticky across requests and restarts.
+func (c ExperimentConfig) Bucket(userID uint) int {
+	h := fnv.New32a()
+	fmt.Fprintf(h, "%s:%d", c.Name, userID)
+	return int(h.Sum32() % 100)
+}
+
+// Assignment is the model a user's prediction is shown from and, in shadow mode,
+// whether the candidate scores the same input in the background
+type Assignment struct {
+	Variant string
+	Shadow  bool
+}
+
+// Assign returns the user's assignment; everyone gets the control when the experiment is off
+func (c ExperimentConfig) Assign(userID uint) Assignment {
+	if !c.Active() || c.Bucket(userID) >= c.Percent {
+		return Assignment{Variant: ControlVariant}
+	}
+	if c.Mode == ExperimentShadow {
+		return Assignment{Variant: ControlVariant, Shadow: true}
+	}
+	return Assignment{Variant: c.CandidateModel}
+}
+
+type modelVariantKey struct{}
+
+// WithModelVariant asks the transport to score with the named model instead of the
+// production one. Only the RabbitMQ transport routes variants.
+func WithModelVariant(ctx context.Context, variant string) context.Context {
+	if variant == ControlVariant {
+		variant = ""
+	}
+	return context.WithValue(ctx, modelVariantKey{}, variant)
+}
+
+// ModelVariantFromContext returns the variant set by WithModelVariant, or "" for the control
+func ModelVariantFromContext(ctx context.Context) string {
+	variant, _ := ctx.Value(modelVariantKey{}).(string)
+	return variant
+}
+
+// RequestQueueFor is the RabbitMQ queue (routing key on the default exchange) the
+// variant's model consumes from
+func RequestQueueFor(variant string) string {
+	if variant == "" || variant == ControlVariant {
+		return PredictionRequestQueue
+	}
+	return PredictionRequestQueue + "." + variant
+}
+
+// ShadowCorrelationID is the correlation ID used for the shadow request of a job
+func ShadowCorrelationID(jobID string) string {
+	return jobID + shadowSuffix
+}
+
+// ParseShadowCorrelationID returns the job ID of a shadow response
+func ParseShadowCorrelationID(correlationID string) (jobID string, ok bool) {
+	return strings.CutSuffix(correlationID, shadowSuffix)
+}
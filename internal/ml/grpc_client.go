@@ -0,0 +1,263 @@
+package ml
+
+import (
+	"context"
+	"diabetify/internal/ml/pb"
+	"diabetify/internal/models"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// DefaultMLServiceAddress is used when ML_SERVICE_ADDRESS is not configured
+const DefaultMLServiceAddress = "localhost:50051"
+
+// SourceGRPC marks prediction responses received over gRPC
+const SourceGRPC = "grpc"
+
+// ErrSyncUnsupported is returned when the selected transport cannot answer synchronously
+var ErrSyncUnsupported = errors.New("synchronous prediction is not supported by this ML transport")
+
+// SyncPredictor returns a prediction in the same call; used for low-latency what-if requests
+type SyncPredictor interface {
+	PredictSync(ctx context.Context, features []float64) (*ScoreResult, error)
+}
+
+// ModelUpdater is implemented by transports that can fine-tune the served model.
+// A dry-run UpdateModel returns a candidate version that PromoteModel starts serving.
+type ModelUpdater interface {
+	UpdateModel(ctx context.Context, request *models.UpdateModelRequest) (*models.UpdateModelResponse, error)
+	PromoteModel(ctx context.Context, candidateVersion string) (*models.PromoteModelResponse, error)
+}
+
+// GRPCClient talks to the ML service's gRPC endpoint. PredictAsync performs a unary
+// call and hands the result to the registered response handler, so jobs complete
+// through the same path as RabbitMQ replies.
+type GRPCClient struct {
+	address string
+	conn    *grpc.ClientConn
+	client  pb.MLServiceClient
+
+	predictTimeout time.Duration
+	healthTimeout  time.Duration
+	updateTimeout  time.Duration
+
+	mu      sync.RWMutex
+	handler func(body []byte) error
+}
+
+// NewGRPCClient creates a client for the given address. The connection is established
+// lazily, so an unreachable ML service does not prevent startup.
+func NewGRPCClient(address string, opts ...grpc.DialOption) (*GRPCClient, error) {
+	if address == "" {
+		address = DefaultMLServiceAddress
+	}
+
+	dialOpts := append([]grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}, opts...)
+	conn, err := grpc.NewClient(address, dialOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create gRPC client for %s: %w", address, err)
+	}
+
+	return &GRPCClient{
+		address:        address,
+		conn:           conn,
+		client:         pb.NewMLServiceClient(conn),
+		predictTimeout: 5 * time.Second,
+		healthTimeout:  3 * time.Second,
+		updateTimeout:  10 * time.Minute,
+	}, nil
+}
+
+// PredictSync performs a unary prediction call
+func (c *GRPCClient) PredictSync(ctx context.Context, features []float64) (*ScoreResult, error) {
+	return c.predict(ctx, "", features)
+}
+
+func (c *GRPCClient) predict(ctx context.Context, correlationID string, features []float64) (*ScoreResult, error) {
+	if err := validateFeatures(features); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.predictTimeout)
+	defer cancel()
+
+	inputHash := HashFeatures(features)
+	response, err := c.client.Predict(ctx, &pb.PredictRequest{
+		CorrelationId:        correlationID,
+		Features:             features,
+		FeatureSchemaVersion: FeatureSchemaVersion,
+		InputHash:            inputHash,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("gRPC prediction failed: %w", err)
+	}
+
+	result := &ScoreResult{
+		Prediction:           response.GetPrediction(),
+		Explanation:          make(map[string]FeatureContribution, len(response.GetExplanation())),
+		ModelName:            response.GetModelName(),
+		ModelVersion:         response.GetModelVersion(),
+		FeatureSchemaVersion: response.GetFeatureSchemaVersion(),
+		InputHash:            response.GetInputHash(),
+	}
+	// Older servers do not echo provenance; fall back to what was sent
+	if result.FeatureSchemaVersion == "" {
+		result.FeatureSchemaVersion = FeatureSchemaVersion
+	}
+	if result.InputHash == "" {
+		result.InputHash = inputHash
+	}
+	for name, item := range response.GetExplanation() {
+		result.Explanation[name] = FeatureContribution{
+			Value:        item.GetValue(),
+			Shap:         item.GetShap(),
+			Contribution: item.GetContribution(),
+			Impact:       int(item.GetImpact()),
+		}
+	}
+
+	return result, nil
+}
+
+// SetResponseHandler registers where PredictAsync delivers its responses
+func (c *GRPCClient) SetResponseHandler(handler func(body []byte) error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.handler = handler
+}
+
+func (c *GRPCClient) PredictAsync(ctx context.Context, jobID string, features []float64) error {
+	c.mu.RLock()
+	handler := c.handler
+	c.mu.RUnlock()
+
+	if handler == nil {
+		return ErrNoResponseHandler
+	}
+
+	start := time.Now()
+	result, err := c.predict(ctx, jobID, features)
+	if err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(predictionMessage{
+		Prediction:           result.Prediction,
+		Explanation:          result.Explanation,
+		ElapsedTime:          time.Since(start).Seconds(),
+		Timestamp:            time.Now().UTC().Format(time.RFC3339Nano),
+		CorrelationID:        jobID,
+		ModelName:            result.ModelName,
+		ModelVersion:         result.ModelVersion,
+		FeatureSchemaVersion: result.FeatureSchemaVersion,
+		InputHash:            result.InputHash,
+		Source:               SourceGRPC,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal gRPC prediction: %w", err)
+	}
+
+	return handler(body)
+}
+
+func (c *GRPCClient) HealthCheckAsync(ctx context.Context) error {
+	_, err := c.ProbeHealth(ctx)
+	return err
+}
+
+func (c *GRPCClient) ProbeHealth(ctx context.Context) (*HealthStatus, error) {
+	probeCtx, cancel := context.WithTimeout(ctx, c.healthTimeout)
+	defer cancel()
+
+	start := time.Now()
+	status := &HealthStatus{Status: "unreachable", CheckedAt: start}
+
+	response, err := c.client.Health(probeCtx, &pb.HealthRequest{})
+	status.LatencyMs = float64(time.Since(start).Microseconds()) / 1000
+	if errors.Is(ctx.Err(), context.Canceled) {
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		status.Error = err.Error()
+		return status, fmt.Errorf("gRPC health check failed: %w", err)
+	}
+
+	status.Status = response.GetStatus()
+	status.ModelName = response.GetModelName()
+	status.ModelVersion = response.GetModelVersion()
+	status.Healthy = isHealthyStatus(status.Status)
+
+	return status, statusError(status)
+}
+
+// UpdateModel fine-tunes the served model on new labeled data
+func (c *GRPCClient) UpdateModel(ctx context.Context, request *models.UpdateModelRequest) (*models.UpdateModelResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.updateTimeout)
+	defer cancel()
+
+	response, err := c.client.UpdateModel(ctx, &pb.UpdateModelRequest{
+		XNew:   toFeatureRows(request.XNew),
+		YNew:   request.YNew,
+		XVal:   toFeatureRows(request.XVal),
+		YVal:   request.YVal,
+		Epochs: int32(request.Epochs),
+		DryRun: request.DryRun,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("gRPC model update failed: %w", err)
+	}
+
+	return &models.UpdateModelResponse{
+		Status:           response.GetStatus(),
+		AUCBefore:        response.GetAucBefore(),
+		AUCAfter:         response.GetAucAfter(),
+		PRAUCBefore:      response.GetPrAucBefore(),
+		PRAUCAfter:       response.GetPrAucAfter(),
+		ElapsedTime:      response.GetElapsedTime(),
+		Timestamp:        time.Now(),
+		ModelVersion:     response.GetModelVersion(),
+		CandidateVersion: response.GetCandidateVersion(),
+	}, nil
+}
+
+// PromoteModel starts serving a candidate produced by a dry-run UpdateModel
+func (c *GRPCClient) PromoteModel(ctx context.Context, candidateVersion string) (*models.PromoteModelResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.healthTimeout)
+	defer cancel()
+
+	response, err := c.client.PromoteModel(ctx, &pb.PromoteModelRequest{CandidateVersion: candidateVersion})
+	if err != nil {
+		return nil, fmt.Errorf("gRPC model promotion failed: %w", err)
+	}
+
+	return &models.PromoteModelResponse{
+		Status:       response.GetStatus(),
+		ModelVersion: response.GetModelVersion(),
+	}, nil
+}
+
+// ConnectionStats reports the gRPC channel state
+func (c *GRPCClient) ConnectionStats() map[string]interface{} {
+	return map[string]interface{}{
+		"address": c.address,
+		"state":   c.conn.GetState().String(),
+	}
+}
+
+func (c *GRPCClient) Close() error {
+	return c.conn.Close()
+}
+
+func toFeatureRows(rows [][]float64) []*pb.FeatureRow {
+	out := make([]*pb.FeatureRow, len(rows))
+	for i, row := range rows {
+		out[i] = &pb.FeatureRow{Values: row}
+	}
+	return out
+}
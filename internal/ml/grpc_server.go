@@ -0,0 +1,113 @@
+package ml
+
+import (
+	"context"
+	"diabetify/internal/ml/pb"
+	"diabetify/internal/models"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ScorerServer serves a Scorer over the ML gRPC API. It stands in for the Python ML
+// service during local development and in tests.
+type ScorerServer struct {
+	pb.UnimplementedMLServiceServer
+
+	scorer Scorer
+
+	// UpdateFunc handles UpdateModel calls; nil answers Unimplemented
+	UpdateFunc func(ctx context.Context, request *models.UpdateModelRequest) (*models.UpdateModelResponse, error)
+	// PromoteFunc handles PromoteModel calls; nil answers Unimplemented
+	PromoteFunc func(ctx context.Context, candidateVersion string) (*models.PromoteModelResponse, error)
+}
+
+// NewScorerServer wraps a scorer, typically a LocalScorer
+func NewScorerServer(scorer Scorer) *ScorerServer {
+	return &ScorerServer{scorer: scorer}
+}
+
+func (s *ScorerServer) Predict(ctx context.Context, request *pb.PredictRequest) (*pb.PredictResponse, error) {
+	result, err := s.scorer.Score(request.GetFeatures())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	response := &pb.PredictResponse{
+		CorrelationId:        request.GetCorrelationId(),
+		Prediction:           result.Prediction,
+		Explanation:          make(map[string]*pb.FeatureExplanation, len(result.Explanation)),
+		ModelName:            result.ModelName,
+		ModelVersion:         result.ModelVersion,
+		FeatureSchemaVersion: result.FeatureSchemaVersion,
+		InputHash:            result.InputHash,
+	}
+	for name, item := range result.Explanation {
+		response.Explanation[name] = &pb.FeatureExplanation{
+			Value:        item.Value,
+			Shap:         item.Shap,
+			Contribution: item.Contribution,
+			Impact:       int32(item.Impact),
+		}
+	}
+
+	return response, nil
+}
+
+func (s *ScorerServer) Health(ctx context.Context, request *pb.HealthRequest) (*pb.HealthResponse, error) {
+	response := &pb.HealthResponse{Status: "SERVING"}
+	if info, ok := s.scorer.(interface{ ModelInfo() (string, string) }); ok {
+		response.ModelName, response.ModelVersion = info.ModelInfo()
+	}
+	return response, nil
+}
+
+func (s *ScorerServer) UpdateModel(ctx context.Context, request *pb.UpdateModelRequest) (*pb.UpdateModelResponse, error) {
+	if s.UpdateFunc == nil {
+		return nil, status.Error(codes.Unimplemented, "model updates are not supported by this server")
+	}
+
+	result, err := s.UpdateFunc(ctx, &models.UpdateModelRequest{
+		XNew:   fromFeatureRows(request.GetXNew()),
+		YNew:   request.GetYNew(),
+		XVal:   fromFeatureRows(request.GetXVal()),
+		YVal:   request.GetYVal(),
+		Epochs: int(request.GetEpochs()),
+		DryRun: request.GetDryRun(),
+	})
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	return &pb.UpdateModelResponse{
+		Status:           result.Status,
+		AucBefore:        result.AUCBefore,
+		AucAfter:         result.AUCAfter,
+		PrAucBefore:      result.PRAUCBefore,
+		PrAucAfter:       result.PRAUCAfter,
+		ElapsedTime:      result.ElapsedTime,
+		ModelVersion:     result.ModelVersion,
+		CandidateVersion: result.CandidateVersion,
+	}, nil
+}
+
+func (s *ScorerServer) PromoteModel(ctx context.Context, request *pb.PromoteModelRequest) (*pb.PromoteModelResponse, error) {
+	if s.PromoteFunc == nil {
+		return nil, status.Error(codes.Unimplemented, "model promotion is not supported by this server")
+	}
+
+	result, err := s.PromoteFunc(ctx, request.GetCandidateVersion())
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	return &pb.PromoteModelResponse{Status: result.Status, ModelVersion: result.ModelVersion}, nil
+}
+
+func fromFeatureRows(rows []*pb.FeatureRow) [][]float64 {
+	out := make([][]float64, len(rows))
+	for i, row := range rows {
+		out[i] = row.GetValues()
+	}
+	return out
+}
@@ -0,0 +1,209 @@
+package ml
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/streadway/amqp"
+)
+
+// HealthStatus is the outcome of a request/reply health probe against the ML service
+type HealthStatus struct {
+	Healthy      bool      `json:"healthy"`
+	Status       string    `json:"status"`
+	ModelName    string    `json:"model_name,omitempty"`
+	ModelVersion string    `json:"model_version,omitempty"`
+	LatencyMs    float64   `json:"latency_ms"`
+	CheckedAt    time.Time `json:"checked_at"`
+	Cached       bool      `json:"cached"`
+	Error        string    `json:"error,omitempty"`
+}
+
+// ErrHealthProbeTimeout is returned when the ML service does not answer a probe in time
+var ErrHealthProbeTimeout = errors.New("ML service did not answer the health probe in time")
+
+// healthProber correlates health requests with replies on a per-process reply queue
+// and caches the last result so frequent health endpoints don't flood the ML service.
+type healthProber struct {
+	rabbit       *ConnectionManager
+	requestQueue string
+	replyQueue   string
+	timeout      time.Duration
+	cacheTTL     time.Duration
+
+	mu      sync.Mutex
+	pending map[string]chan HealthCheckResponse
+	last    *HealthStatus
+
+	// probeMu ensures only one probe is in flight; concurrent callers share its result
+	probeMu sync.Mutex
+}
+
+func newHealthProber(rabbit *ConnectionManager, requestQueue string) *healthProber {
+	hostname, _ := os.Hostname()
+	if hostname == "" {
+		hostname = "api"
+	}
+
+	return &healthProber{
+		rabbit:       rabbit,
+		requestQueue: requestQueue,
+		replyQueue:   fmt.Sprintf("ml.health.response.%s.%s", hostname, uuid.New().String()[:8]),
+		timeout:      5 * time.Second,
+		cacheTTL:     15 * time.Second,
+		pending:      make(map[string]chan HealthCheckResponse),
+	}
+}
+
+func (p *healthProber) start() error {
+	return p.rabbit.ConsumeExclusive(p.replyQueue, "health_probe", p.handleReplies)
+}
+
+func (p *healthProber) handleReplies(msgs <-chan amqp.Delivery) {
+	for msg := range msgs {
+		var response HealthCheckResponse
+		if err := json.Unmarshal(msg.Body, &response); err != nil {
+			log.Printf("Failed to unmarshal ML health response %s: %v", msg.CorrelationId, err)
+			_ = msg.Ack(false)
+			continue
+		}
+		if response.CorrelationID == "" {
+			response.CorrelationID = msg.CorrelationId
+		}
+
+		p.mu.Lock()
+		waiter, ok := p.pending[response.CorrelationID]
+		if ok {
+			delete(p.pending, response.CorrelationID)
+		}
+		p.mu.Unlock()
+
+		if ok {
+			waiter <- response
+		}
+		_ = msg.Ack(false)
+	}
+}
+
+// cached returns the last probe result if it is still fresh
+func (p *healthProber) cached() *HealthStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.last == nil || time.Since(p.last.CheckedAt) > p.cacheTTL {
+		return nil
+	}
+	status := *p.last
+	status.Cached = true
+	return &status
+}
+
+func (p *healthProber) probe(ctx context.Context) (*HealthStatus, error) {
+	if status := p.cached(); status != nil {
+		return status, statusError(status)
+	}
+
+	p.probeMu.Lock()
+	defer p.probeMu.Unlock()
+
+	// Another caller may have refreshed the cache while we waited
+	if status := p.cached(); status != nil {
+		return status, statusError(status)
+	}
+
+	status := p.roundTrip(ctx)
+	// A caller that left says nothing about the ML service, so its probe is not cached
+	if errors.Is(ctx.Err(), context.Canceled) {
+		return nil, ctx.Err()
+	}
+
+	p.mu.Lock()
+	p.last = status
+	p.mu.Unlock()
+
+	return status, statusError(status)
+}
+
+func (p *healthProber) roundTrip(ctx context.Context) *HealthStatus {
+	start := time.Now()
+	correlationID := fmt.Sprintf("health_%d", start.UnixNano())
+
+	waiter := make(chan HealthCheckResponse, 1)
+	p.mu.Lock()
+	p.pending[correlationID] = waiter
+	p.mu.Unlock()
+
+	defer func() {
+		p.mu.Lock()
+		delete(p.pending, correlationID)
+		p.mu.Unlock()
+	}()
+
+	status := &HealthStatus{Status: "unreachable", CheckedAt: start}
+
+	body, err := json.Marshal(HealthCheckRequest{CorrelationID: correlationID, Timestamp: start})
+	if err != nil {
+		status.Error = fmt.Sprintf("failed to marshal health check request: %v", err)
+		return status
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	err = p.rabbit.Publish(ctx, p.requestQueue, amqp.Publishing{
+		ContentType:   "application/json",
+		Body:          body,
+		CorrelationId: correlationID,
+		ReplyTo:       p.replyQueue,
+		Timestamp:     start,
+		Expiration:    fmt.Sprintf("%d", p.timeout.Milliseconds()),
+	})
+	if err != nil {
+		status.Error = fmt.Sprintf("failed to publish health probe: %v", err)
+		return status
+	}
+
+	select {
+	case response := <-waiter:
+		status.LatencyMs = float64(time.Since(start).Microseconds()) / 1000
+		status.Status = response.Status
+		status.ModelName = response.ModelName
+		status.ModelVersion = response.ModelVersion
+		status.CheckedAt = time.Now()
+		if response.Error != nil {
+			status.Error = *response.Error
+		}
+		status.Healthy = status.Error == "" && isHealthyStatus(response.Status)
+	case <-ctx.Done():
+		status.Status = "timeout"
+		status.Error = ErrHealthProbeTimeout.Error()
+		status.LatencyMs = float64(time.Since(start).Microseconds()) / 1000
+	}
+
+	return status
+}
+
+func isHealthyStatus(status string) bool {
+	switch status {
+	case "healthy", "ok", "OK", "SERVING", "serving":
+		return true
+	}
+	return false
+}
+
+func statusError(status *HealthStatus) error {
+	if status.Healthy {
+		return nil
+	}
+	if status.Error != "" {
+		return errors.New(status.Error)
+	}
+	return fmt.Errorf("ML service reported status %q", status.Status)
+}
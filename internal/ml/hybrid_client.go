@@ -0,0 +1,267 @@
+package ml
+
+import (
+	"context"
+	"diabetify/internal/models"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// CallType identifies the kind of ML call so each can use its own transport
+type CallType string
+
+const (
+	CallPrediction  CallType = "prediction"
+	CallWhatIf      CallType = "what_if"
+	CallHealth      CallType = "health"
+	CallModelUpdate CallType = "model_update"
+)
+
+// Transport names accepted in ML_TRANSPORT and ML_TRANSPORT_<CALL TYPE>
+const (
+	TransportRabbitMQ = "rabbitmq"
+	TransportGRPC     = "grpc"
+	TransportLocal    = "local"
+)
+
+type callTypeKey struct{}
+
+// WithCallType tags the context so the hybrid client routes the call accordingly
+func WithCallType(ctx context.Context, callType CallType) context.Context {
+	return context.WithValue(ctx, callTypeKey{}, callType)
+}
+
+// CallTypeFromContext returns the call type set by WithCallType, or fallback
+func CallTypeFromContext(ctx context.Context, fallback CallType) CallType {
+	if callType, ok := ctx.Value(callTypeKey{}).(CallType); ok {
+		return callType
+	}
+	return fallback
+}
+
+// TransportConfig maps each call type to a transport name
+type TransportConfig map[CallType]string
+
+// TransportConfigFromEnv reads ML_TRANSPORT as the default for every call type and
+// ML_TRANSPORT_PREDICTION, ML_TRANSPORT_WHAT_IF, ML_TRANSPORT_HEALTH and
+// ML_TRANSPORT_MODEL_UPDATE as per-call overrides. Model updates default to gRPC since
+// RabbitMQ has no request/reply for them. Setting ML_SERVICE_ADDRESS only says where gRPC
+// is; what-if calls move to it through ML_TRANSPORT_WHAT_IF.
+func TransportConfigFromEnv() TransportConfig {
+	defaultTransport := strings.ToLower(os.Getenv("ML_TRANSPORT"))
+	if defaultTransport == "" {
+		defaultTransport = TransportRabbitMQ
+	}
+
+	cfg := TransportConfig{
+		CallPrediction:  defaultTransport,
+		CallWhatIf:      defaultTransport,
+		CallHealth:      defaultTransport,
+		CallModelUpdate: TransportGRPC,
+	}
+	for callType := range cfg {
+		if v := os.Getenv("ML_TRANSPORT_" + strings.ToUpper(string(callType))); v != "" {
+			cfg[callType] = strings.ToLower(v)
+		}
+	}
+
+	return cfg
+}
+
+// HybridConfig describes how to reach each transport
+type HybridConfig struct {
+	Transports    TransportConfig
+	RabbitMQURL   string
+	ResponseQueue string
+	GRPCAddress   string
+	// RabbitMQDialer opens broker connections; nil dials RabbitMQURL
+	RabbitMQDialer Dialer
+	// ModelVariants are candidate models reachable over RabbitMQ (see ExperimentConfig)
+	ModelVariants []string
+	// Local is required when any call type uses the local transport
+	Local *LocalScorer
+}
+
+// HybridMLClient routes each call to the transport configured for its call type
+type HybridMLClient struct {
+	transports TransportConfig
+	clients    map[string]MLClient
+}
+
+// NewHybridMLClient creates only the transports that are actually configured
+func NewHybridMLClient(cfg HybridConfig) (*HybridMLClient, error) {
+	h := &HybridMLClient{
+		transports: cfg.Transports,
+		clients:    make(map[string]MLClient),
+	}
+
+	for _, transport := range cfg.Transports {
+		if _, ok := h.clients[transport]; ok {
+			continue
+		}
+
+		var (
+			client MLClient
+			err    error
+		)
+		switch transport {
+		case TransportRabbitMQ:
+			dial := cfg.RabbitMQDialer
+			if dial == nil {
+				dial = DialAMQP
+			}
+			client, err = NewAsyncMLClientWithDialer(cfg.RabbitMQURL, cfg.ResponseQueue, dial, cfg.ModelVariants...)
+		case TransportGRPC:
+			client, err = NewGRPCClient(cfg.GRPCAddress)
+		case TransportLocal:
+			if cfg.Local == nil {
+				err = errors.New("local transport requires a fallback model (ML_FALLBACK_MODEL_PATH)")
+			} else {
+				client = cfg.Local
+			}
+		default:
+			err = fmt.Errorf("unknown ML transport %q", transport)
+		}
+		if err != nil {
+			h.Close()
+			return nil, err
+		}
+		h.clients[transport] = client
+	}
+
+	return h, nil
+}
+
+func (h *HybridMLClient) transportFor(callType CallType) string {
+	transport, ok := h.transports[callType]
+	if !ok {
+		transport = h.transports[CallPrediction]
+	}
+	return transport
+}
+
+func (h *HybridMLClient) clientFor(callType CallType) (MLClient, error) {
+	client, ok := h.clients[h.transportFor(callType)]
+	if !ok {
+		return nil, fmt.Errorf("no ML transport configured for %s calls", callType)
+	}
+	return client, nil
+}
+
+func (h *HybridMLClient) PredictAsync(ctx context.Context, jobID string, features []float64) error {
+	callType := CallTypeFromContext(ctx, CallPrediction)
+	client, err := h.clientFor(callType)
+	if err != nil {
+		return err
+	}
+	// Only RabbitMQ has a queue per model; other transports would silently score with production
+	if variant := ModelVariantFromContext(ctx); variant != "" && h.transportFor(callType) != TransportRabbitMQ {
+		return fmt.Errorf("model variant %q requires the %s transport for %s calls", variant, TransportRabbitMQ, callType)
+	}
+	return client.PredictAsync(ctx, jobID, features)
+}
+
+func (h *HybridMLClient) HealthCheckAsync(ctx context.Context) error {
+	client, err := h.clientFor(CallHealth)
+	if err != nil {
+		return err
+	}
+	return client.HealthCheckAsync(ctx)
+}
+
+func (h *HybridMLClient) ProbeHealth(ctx context.Context) (*HealthStatus, error) {
+	client, err := h.clientFor(CallHealth)
+	if err != nil {
+		return nil, err
+	}
+	return client.ProbeHealth(ctx)
+}
+
+// PredictSync uses the what-if transport unless the context says otherwise
+func (h *HybridMLClient) PredictSync(ctx context.Context, features []float64) (*ScoreResult, error) {
+	client, err := h.clientFor(CallTypeFromContext(ctx, CallWhatIf))
+	if err != nil {
+		return nil, err
+	}
+	predictor, ok := client.(SyncPredictor)
+	if !ok {
+		return nil, ErrSyncUnsupported
+	}
+	return predictor.PredictSync(ctx, features)
+}
+
+func (h *HybridMLClient) modelUpdater() (ModelUpdater, error) {
+	client, err := h.clientFor(CallModelUpdate)
+	if err != nil {
+		return nil, err
+	}
+	updater, ok := client.(ModelUpdater)
+	if !ok {
+		return nil, fmt.Errorf("ML transport %q does not support model updates", h.transports[CallModelUpdate])
+	}
+	return updater, nil
+}
+
+func (h *HybridMLClient) UpdateModel(ctx context.Context, request *models.UpdateModelRequest) (*models.UpdateModelResponse, error) {
+	updater, err := h.modelUpdater()
+	if err != nil {
+		return nil, err
+	}
+	return updater.UpdateModel(ctx, request)
+}
+
+func (h *HybridMLClient) PromoteModel(ctx context.Context, candidateVersion string) (*models.PromoteModelResponse, error) {
+	updater, err := h.modelUpdater()
+	if err != nil {
+		return nil, err
+	}
+	return updater.PromoteModel(ctx, candidateVersion)
+}
+
+// SetResponseHandler forwards the handler to every transport that delivers in-process
+func (h *HybridMLClient) SetResponseHandler(handler func(body []byte) error) {
+	for _, client := range h.clients {
+		if sink, ok := client.(ResponseSink); ok {
+			sink.SetResponseHandler(handler)
+		}
+	}
+}
+
+// ConnectionStats reports per-transport connection stats and the routing table
+func (h *HybridMLClient) ConnectionStats() map[string]interface{} {
+	routing := make(map[string]string, len(h.transports))
+	for callType, transport := range h.transports {
+		routing[string(callType)] = transport
+	}
+
+	stats := map[string]interface{}{"routing": routing}
+	for transport, client := range h.clients {
+		if provider, ok := client.(ConnectionStatsProvider); ok {
+			stats[transport] = provider.ConnectionStats()
+		}
+	}
+	return stats
+}
+
+// Transports returns the names of the transports in use, sorted
+func (h *HybridMLClient) Transports() []string {
+	names := make([]string, 0, len(h.clients))
+	for name := range h.clients {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (h *HybridMLClient) Close() error {
+	var errs []error
+	for _, client := range h.clients {
+		if err := client.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,447 @@
+package ml
+
+import (
+	"context"
+	"diabetify/internal/models"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sync"
+	"time"
+)
+
+// FeatureNames is the order of the feature vector sent to every model, from the
+// feature registry
+var FeatureNames = models.ModelFeatureNames()
+
+// Supported local model types
+const (
+	ModelTypeLogisticRegression = "logistic_regression"
+	ModelTypeGradientBoosting   = "gradient_boosting"
+)
+
+// SourceLocal marks prediction responses produced in-process by a LocalScorer
+const SourceLocal = "local"
+
+// ErrNoResponseHandler is returned when a local prediction has nowhere to be delivered
+var ErrNoResponseHandler = errors.New("no response handler registered for local predictions")
+
+// Scorer computes a prediction synchronously
+type Scorer interface {
+	Score(features []float64) (*ScoreResult, error)
+}
+
+// ResponseSink is implemented by in-process clients that deliver prediction responses
+// directly to a handler instead of through the RabbitMQ response queue. The handler
+// receives the same JSON body the ML service would publish.
+type ResponseSink interface {
+	SetResponseHandler(handler func(body []byte) error)
+}
+
+// FeatureContribution is one feature's share of a prediction
+type FeatureContribution struct {
+	Value        float64 `json:"value"`
+	Shap         float64 `json:"shap"`
+	Contribution float64 `json:"contribution"`
+	Impact       int     `json:"impact"`
+}
+
+// ScoreResult is the outcome of scoring one feature vector
+type ScoreResult struct {
+	Prediction           float64                        `json:"prediction"`
+	BaseValue            float64                        `json:"base_value"`
+	Explanation          map[string]FeatureContribution `json:"explanation"`
+	ModelName            string                         `json:"model_name"`
+	ModelVersion         string                         `json:"model_version"`
+	FeatureSchemaVersion string                         `json:"feature_schema_version"`
+	InputHash            string                         `json:"input_hash"`
+}
+
+// LocalModel is the JSON export format understood by LocalScorer.
+//
+// Logistic regression: margin = intercept + sum(coefficients[i] * z[i]) where z is the
+// raw feature, or (x - scaler.mean) / scaler.scale when a scaler is exported.
+//
+// Gradient boosting: margin = base_score + learning_rate * sum(leaf values). Trees are
+// flat node lists with node 0 as root; a sample goes left when x[feature] < threshold.
+// Leaves have feature -1. Node cover (training sample weight) is used to compute the
+// expected value of internal nodes for path-based contributions.
+type LocalModel struct {
+	ModelType    string   `json:"model_type"`
+	ModelName    string   `json:"model_name"`
+	ModelVersion string   `json:"model_version"`
+	FeatureNames []string `json:"feature_names"`
+
+	// Logistic regression
+	Intercept    float64      `json:"intercept,omitempty"`
+	Coefficients []float64    `json:"coefficients,omitempty"`
+	FeatureMeans []float64    `json:"feature_means,omitempty"`
+	Scaler       *ModelScaler `json:"scaler,omitempty"`
+
+	// Gradient boosting
+	BaseScore    float64      `json:"base_score,omitempty"`
+	LearningRate float64      `json:"learning_rate,omitempty"`
+	Trees        [][]TreeNode `json:"trees,omitempty"`
+}
+
+// ModelScaler holds standardisation parameters exported with a linear model
+type ModelScaler struct {
+	Mean  []float64 `json:"mean"`
+	Scale []float64 `json:"scale"`
+}
+
+// TreeNode is one node of an exported regression tree
+type TreeNode struct {
+	Feature   int     `json:"feature"`
+	Threshold float64 `json:"threshold"`
+	Left      int     `json:"left"`
+	Right     int     `json:"right"`
+	Value     float64 `json:"value"`
+	Cover     float64 `json:"cover"`
+}
+
+// LocalScorer is a pure-Go MLClient that scores exported models in-process. It is
+// used as a fallback while the ML service is unreachable and as the transport in tests.
+type LocalScorer struct {
+	model *LocalModel
+
+	// expected holds the cover-weighted mean output of every tree node
+	expected [][]float64
+
+	mu      sync.RWMutex
+	handler func(body []byte) error
+}
+
+// NewLocalScorerFromFile loads a model exported to JSON
+func NewLocalScorerFromFile(path string) (*LocalScorer, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open local model: %w", err)
+	}
+	defer f.Close()
+
+	return NewLocalScorer(f)
+}
+
+// NewLocalScorer reads and validates a JSON model export
+func NewLocalScorer(r io.Reader) (*LocalScorer, error) {
+	var model LocalModel
+	if err := json.NewDecoder(r).Decode(&model); err != nil {
+		return nil, fmt.Errorf("failed to decode local model: %w", err)
+	}
+
+	if err := model.validate(); err != nil {
+		return nil, fmt.Errorf("invalid local model: %w", err)
+	}
+
+	scorer := &LocalScorer{model: &model}
+	if model.ModelType == ModelTypeGradientBoosting {
+		scorer.expected = make([][]float64, len(model.Trees))
+		for i, tree := range model.Trees {
+			scorer.expected[i] = expectedNodeValues(tree)
+		}
+	}
+
+	return scorer, nil
+}
+
+func (m *LocalModel) validate() error {
+	if len(m.FeatureNames) != 0 {
+		if len(m.FeatureNames) != len(FeatureNames) {
+			return fmt.Errorf("expected %d features, got %d", len(FeatureNames), len(m.FeatureNames))
+		}
+		for i, name := range FeatureNames {
+			if m.FeatureNames[i] != name {
+				return fmt.Errorf("feature %d must be %q, got %q", i, name, m.FeatureNames[i])
+			}
+		}
+	}
+
+	switch m.ModelType {
+	case ModelTypeLogisticRegression:
+		if len(m.Coefficients) != len(FeatureNames) {
+			return fmt.Errorf("expected %d coefficients, got %d", len(FeatureNames), len(m.Coefficients))
+		}
+		if m.FeatureMeans != nil && len(m.FeatureMeans) != len(FeatureNames) {
+			return fmt.Errorf("expected %d feature means, got %d", len(FeatureNames), len(m.FeatureMeans))
+		}
+		if m.Scaler != nil {
+			if len(m.Scaler.Mean) != len(FeatureNames) || len(m.Scaler.Scale) != len(FeatureNames) {
+				return errors.New("scaler mean and scale must have one entry per feature")
+			}
+			for i, scale := range m.Scaler.Scale {
+				if scale == 0 {
+					return fmt.Errorf("scaler scale for %s is zero", FeatureNames[i])
+				}
+			}
+		}
+	case ModelTypeGradientBoosting:
+		if len(m.Trees) == 0 {
+			return errors.New("gradient boosting model has no trees")
+		}
+		if m.LearningRate == 0 {
+			m.LearningRate = 1
+		}
+		for t, tree := range m.Trees {
+			if len(tree) == 0 {
+				return fmt.Errorf("tree %d is empty", t)
+			}
+			for n, node := range tree {
+				if node.Feature < 0 {
+					continue
+				}
+				if node.Feature >= len(FeatureNames) {
+					return fmt.Errorf("tree %d node %d splits on unknown feature %d", t, n, node.Feature)
+				}
+				// Children must come after their parent, which also rules out cycles
+				if node.Left <= n || node.Right <= n || node.Left >= len(tree) || node.Right >= len(tree) {
+					return fmt.Errorf("tree %d node %d has invalid children", t, n)
+				}
+			}
+		}
+	default:
+		return fmt.Errorf("unsupported model type %q", m.ModelType)
+	}
+
+	return nil
+}
+
+// ========== SCORING ==========
+
+// Score computes the risk and per-feature SHAP-style contributions. Contributions are
+// computed on the log-odds margin and rescaled so they sum to prediction - base value.
+func (s *LocalScorer) Score(features []float64) (*ScoreResult, error) {
+	if err := validateFeatures(features); err != nil {
+		return nil, err
+	}
+
+	var margin, bias float64
+	var phi []float64
+
+	switch s.model.ModelType {
+	case ModelTypeLogisticRegression:
+		margin, bias, phi = s.scoreLinear(features)
+	default:
+		margin, bias, phi = s.scoreTrees(features)
+	}
+
+	prediction := sigmoid(margin)
+	baseValue := sigmoid(bias)
+
+	// Map log-odds contributions to probability space
+	var scale float64
+	if delta := margin - bias; math.Abs(delta) > 1e-12 {
+		scale = (prediction - baseValue) / delta
+	} else {
+		scale = prediction * (1 - prediction)
+	}
+
+	shap := make([]float64, len(phi))
+	var total float64
+	for i, p := range phi {
+		shap[i] = p * scale
+		total += math.Abs(shap[i])
+	}
+
+	explanation := make(map[string]FeatureContribution, len(FeatureNames))
+	for i, name := range FeatureNames {
+		item := FeatureContribution{Value: features[i], Shap: shap[i]}
+		if total > 0 {
+			item.Contribution = math.Abs(shap[i]) / total
+		}
+		switch {
+		case shap[i] > 0:
+			item.Impact = 1
+		case shap[i] < 0:
+			item.Impact = -1
+		}
+		explanation[name] = item
+	}
+
+	return &ScoreResult{
+		Prediction:           prediction,
+		BaseValue:            baseValue,
+		Explanation:          explanation,
+		ModelName:            s.model.ModelName,
+		ModelVersion:         s.model.ModelVersion,
+		FeatureSchemaVersion: FeatureSchemaVersion,
+		InputHash:            HashFeatures(features),
+	}, nil
+}
+
+func (s *LocalScorer) scoreLinear(features []float64) (margin, bias float64, phi []float64) {
+	m := s.model
+	margin, bias = m.Intercept, m.Intercept
+	phi = make([]float64, len(features))
+
+	for i, x := range features {
+		background := 0.0
+		if m.FeatureMeans != nil {
+			background = m.FeatureMeans[i]
+		} else if m.Scaler != nil {
+			background = m.Scaler.Mean[i]
+		}
+		if m.Scaler != nil {
+			x = (x - m.Scaler.Mean[i]) / m.Scaler.Scale[i]
+			background = (background - m.Scaler.Mean[i]) / m.Scaler.Scale[i]
+		}
+
+		margin += m.Coefficients[i] * x
+		bias += m.Coefficients[i] * background
+		phi[i] = m.Coefficients[i] * (x - background)
+	}
+
+	return margin, bias, phi
+}
+
+// scoreTrees attributes each split on the decision path to its feature (Saabas method)
+func (s *LocalScorer) scoreTrees(features []float64) (margin, bias float64, phi []float64) {
+	m := s.model
+	margin, bias = m.BaseScore, m.BaseScore
+	phi = make([]float64, len(features))
+
+	for t, tree := range m.Trees {
+		expected := s.expected[t]
+		bias += m.LearningRate * expected[0]
+
+		n := 0
+		for tree[n].Feature >= 0 {
+			node := tree[n]
+			next := node.Right
+			if features[node.Feature] < node.Threshold {
+				next = node.Left
+			}
+			phi[node.Feature] += m.LearningRate * (expected[next] - expected[n])
+			n = next
+		}
+		margin += m.LearningRate * tree[n].Value
+	}
+
+	return margin, bias, phi
+}
+
+// expectedNodeValues computes the cover-weighted mean leaf value under every node.
+// Nodes without cover fall back to the exported value (or a plain mean of children).
+func expectedNodeValues(tree []TreeNode) []float64 {
+	expected := make([]float64, len(tree))
+	// Children always have larger indices, so a reverse pass sees them first
+	for n := len(tree) - 1; n >= 0; n-- {
+		node := tree[n]
+		if node.Feature < 0 {
+			expected[n] = node.Value
+			continue
+		}
+		left, right := tree[node.Left], tree[node.Right]
+		switch {
+		case left.Cover+right.Cover > 0:
+			expected[n] = (left.Cover*expected[node.Left] + right.Cover*expected[node.Right]) / (left.Cover + right.Cover)
+		case node.Value != 0:
+			expected[n] = node.Value
+		default:
+			expected[n] = (expected[node.Left] + expected[node.Right]) / 2
+		}
+	}
+	return expected
+}
+
+// PredictSync scores in-process; it exists so the local scorer can serve what-if calls
+func (s *LocalScorer) PredictSync(ctx context.Context, features []float64) (*ScoreResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return s.Score(features)
+}
+
+// ModelInfo returns the exported model's name and version
+func (s *LocalScorer) ModelInfo() (name, version string) {
+	return s.model.ModelName, s.model.ModelVersion
+}
+
+func sigmoid(x float64) float64 {
+	return 1 / (1 + math.Exp(-x))
+}
+
+// ========== MLClient IMPLEMENTATION ==========
+
+// SetResponseHandler registers where PredictAsync delivers its responses
+func (s *LocalScorer) SetResponseHandler(handler func(body []byte) error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handler = handler
+}
+
+// PredictAsync scores the features and delivers a response body shaped like the ML
+// service's RabbitMQ reply to the registered handler
+func (s *LocalScorer) PredictAsync(ctx context.Context, jobID string, features []float64) error {
+	s.mu.RLock()
+	handler := s.handler
+	s.mu.RUnlock()
+
+	if handler == nil {
+		return ErrNoResponseHandler
+	}
+
+	start := time.Now()
+	result, err := s.Score(features)
+	if err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(predictionMessage{
+		Prediction:           result.Prediction,
+		Explanation:          result.Explanation,
+		ElapsedTime:          time.Since(start).Seconds(),
+		Timestamp:            time.Now().UTC().Format(time.RFC3339Nano),
+		CorrelationID:        jobID,
+		ModelName:            result.ModelName,
+		ModelVersion:         result.ModelVersion,
+		FeatureSchemaVersion: FeatureSchemaVersion,
+		InputHash:            HashFeatures(features),
+		Source:               SourceLocal,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal local prediction: %w", err)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return handler(body)
+}
+
+func (s *LocalScorer) HealthCheckAsync(ctx context.Context) error {
+	return nil
+}
+
+// ProbeHealth always succeeds; the model is loaded in-process
+func (s *LocalScorer) ProbeHealth(ctx context.Context) (*HealthStatus, error) {
+	return &HealthStatus{
+		Healthy:      true,
+		Status:       "healthy",
+		ModelName:    s.model.ModelName,
+		ModelVersion: s.model.ModelVersion,
+		CheckedAt:    time.Now(),
+	}, nil
+}
+
+func (s *LocalScorer) Close() error {
+	return nil
+}
+
+type predictionMessage struct {
+	Prediction           float64                        `json:"prediction"`
+	Explanation          map[string]FeatureContribution `json:"explanation"`
+	ElapsedTime          float64                        `json:"elapsed_time"`
+	Timestamp            string                         `json:"timestamp"`
+	CorrelationID        string                         `json:"correlation_id"`
+	ModelName            string                         `json:"model_name,omitempty"`
+	ModelVersion         string                         `json:"model_version,omitempty"`
+	FeatureSchemaVersion string                         `json:"feature_schema_version"`
+	InputHash            string                         `json:"input_hash"`
+	Source               string                         `json:"source"`
+	Error                *string                        `json:"error"`
+}
@@ -0,0 +1,28 @@
+package ml
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+)
+
+// FeatureSchemaVersion identifies the layout of the feature vector described by
+// FeatureNames. Bump it whenever a feature is added, removed, reordered or re-encoded.
+const FeatureSchemaVersion = "v1"
+
+// UnknownModelVersion is stored when the ML service did not report its model
+const UnknownModelVersion = "unknown"
+
+// HashFeatures returns a stable SHA-256 of the schema version and feature values, so
+// identical inputs can be recognised across predictions and model versions
+func HashFeatures(features []float64) string {
+	var b strings.Builder
+	b.WriteString(FeatureSchemaVersion)
+	for _, f := range features {
+		b.WriteByte('|')
+		b.WriteString(strconv.FormatFloat(f, 'g', -1, 64))
+	}
+	sum := sha256.Sum256([]byte(b.String()))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,180 @@
+package ml
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/streadway/amqp"
+)
+
+// ErrSyncPredictionTimeout is returned when the ML service does not answer a synchronous
+// prediction before its context ends
+var ErrSyncPredictionTimeout = errors.New("ML service did not answer the prediction in time")
+
+// syncPredictor turns a RabbitMQ prediction into a request/reply call: the request names
+// a per-process reply queue and the caller waits for the reply with its correlation ID.
+// The ML service answers on the reply_to of every prediction request, so this needs no
+// support beyond what PredictAsync already relies on.
+type syncPredictor struct {
+	rabbit       *ConnectionManager
+	requestQueue string
+	replyQueue   string
+	// timeout bounds calls whose context has no deadline
+	timeout time.Duration
+
+	mu      sync.Mutex
+	pending map[string]chan PredictionResponse
+}
+
+func newSyncPredictor(rabbit *ConnectionManager, requestQueue string) *syncPredictor {
+	hostname, _ := os.Hostname()
+	if hostname == "" {
+		hostname = "api"
+	}
+
+	return &syncPredictor{
+		rabbit:       rabbit,
+		requestQueue: requestQueue,
+		replyQueue:   fmt.Sprintf("ml.prediction.reply.%s.%s", hostname, uuid.New().String()[:8]),
+		timeout:      30 * time.Second,
+		pending:      make(map[string]chan PredictionResponse),
+	}
+}
+
+func (p *syncPredictor) start() error {
+	return p.rabbit.ConsumeExclusive(p.replyQueue, "sync_prediction", p.handleReplies)
+}
+
+func (p *syncPredictor) handleReplies(msgs <-chan amqp.Delivery) {
+	for msg := range msgs {
+		var response PredictionResponse
+		if err := json.Unmarshal(msg.Body, &response); err != nil {
+			log.Printf("Failed to unmarshal ML prediction reply %s: %v", msg.CorrelationId, err)
+			_ = msg.Ack(false)
+			continue
+		}
+		if response.CorrelationID == "" {
+			response.CorrelationID = msg.CorrelationId
+		}
+
+		p.mu.Lock()
+		waiter, ok := p.pending[response.CorrelationID]
+		if ok {
+			delete(p.pending, response.CorrelationID)
+		}
+		p.mu.Unlock()
+
+		// Replies that arrive after their caller gave up are dropped
+		if ok {
+			waiter <- response
+		}
+		_ = msg.Ack(false)
+	}
+}
+
+func (p *syncPredictor) predict(ctx context.Context, features []float64) (*ScoreResult, error) {
+	if err := validateFeatures(features); err != nil {
+		return nil, err
+	}
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.timeout)
+		defer cancel()
+	}
+	deadline, _ := ctx.Deadline()
+
+	correlationID := "sync_" + uuid.New().String()
+	waiter := make(chan PredictionResponse, 1)
+	p.mu.Lock()
+	p.pending[correlationID] = waiter
+	p.mu.Unlock()
+
+	defer func() {
+		p.mu.Lock()
+		delete(p.pending, correlationID)
+		p.mu.Unlock()
+	}()
+
+	inputHash := HashFeatures(features)
+	body, err := json.Marshal(PredictionRequest{
+		Features:             features,
+		FeatureSchemaVersion: FeatureSchemaVersion,
+		InputHash:            inputHash,
+		CorrelationID:        correlationID,
+		Timestamp:            time.Now(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	// Requests nobody waits for any more expire in the queue instead of being scored
+	expiration := time.Until(deadline).Milliseconds()
+	if expiration < 1 {
+		expiration = 1
+	}
+	err = p.rabbit.Publish(ctx, p.requestQueue, amqp.Publishing{
+		ContentType:   "application/json",
+		Body:          body,
+		CorrelationId: correlationID,
+		ReplyTo:       p.replyQueue,
+		Timestamp:     time.Now(),
+		Expiration:    fmt.Sprintf("%d", expiration),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to publish prediction request: %w", err)
+	}
+
+	select {
+	case response := <-waiter:
+		return scoreResultFromResponse(&response, features, inputHash)
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, ErrSyncPredictionTimeout
+		}
+		return nil, ctx.Err()
+	}
+}
+
+// scoreResultFromResponse converts a RabbitMQ prediction reply
+func scoreResultFromResponse(response *PredictionResponse, features []float64, inputHash string) (*ScoreResult, error) {
+	if response.Error != nil && *response.Error != "" {
+		return nil, fmt.Errorf("ML service error: %s", *response.Error)
+	}
+
+	result := &ScoreResult{
+		Prediction:           response.Prediction,
+		Explanation:          make(map[string]FeatureContribution, len(response.Explanation)),
+		ModelName:            response.ModelName,
+		ModelVersion:         response.ModelVersion,
+		FeatureSchemaVersion: response.FeatureSchemaVersion,
+		InputHash:            response.InputHash,
+	}
+	// Older services do not echo provenance; fall back to what was sent
+	if result.FeatureSchemaVersion == "" {
+		result.FeatureSchemaVersion = FeatureSchemaVersion
+	}
+	if result.InputHash == "" {
+		result.InputHash = inputHash
+	}
+	for i, name := range FeatureNames {
+		item, ok := response.Explanation[name]
+		if !ok {
+			continue
+		}
+		result.Explanation[name] = FeatureContribution{
+			Value:        features[i],
+			Shap:         item.Shap,
+			Contribution: item.Contribution,
+			Impact:       item.Impact,
+		}
+	}
+	return result, nil
+}
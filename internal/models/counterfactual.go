@@ -0,0 +1,52 @@
+package models
+
+// CounterfactualRequest is the body of POST /prediction/what-if/counterfactuals
+type CounterfactualRequest struct {
+	// TargetRisk is the risk score to get below; defaults to 0.3
+	TargetRisk float64 `json:"target_risk" binding:"omitempty,gt=0,lt=1" example:"0.3"`
+	// MaxSuggestions defaults to 3
+	MaxSuggestions int `json:"max_suggestions" binding:"omitempty,min=1,max=5" example:"3"`
+}
+
+// CounterfactualChange is one field of the user's current what-if input that a
+// suggestion changes
+type CounterfactualChange struct {
+	Field string  `json:"field" example:"weight"`
+	From  float64 `json:"from" example:"92"`
+	To    float64 `json:"to" example:"84"`
+}
+
+// CounterfactualSuggestion is a scenario that changes as little as possible, ranked by
+// the effort its changes take
+type CounterfactualSuggestion struct {
+	Rank    int                    `json:"rank" example:"1"`
+	Changes []CounterfactualChange `json:"changes"`
+	// Input is the full what-if input, ready to submit to POST /prediction/what-if
+	Input         WhatIfInput `json:"input"`
+	RiskScore     float64     `json:"risk_score" example:"0.28"`
+	RiskReduction float64     `json:"risk_reduction" example:"0.09"`
+	// Effort is the search cost of the changes; lower is easier
+	Effort        float64 `json:"effort" example:"1.6"`
+	ReachesTarget bool    `json:"reaches_target"`
+}
+
+// CounterfactualResult lists the suggestions for one user. When no plausible change
+// reaches the target, the suggestions are the lowest risks found, with ReachesTarget false.
+type CounterfactualResult struct {
+	// JobID is the job recording the search
+	JobID            string                     `json:"job_id"`
+	CurrentInput     WhatIfInput                `json:"current_input"`
+	CurrentRiskScore float64                    `json:"current_risk_score" example:"0.37"`
+	TargetRisk       float64                    `json:"target_risk" example:"0.3"`
+	AlreadyBelow     bool                       `json:"already_below_target"`
+	Suggestions      []CounterfactualSuggestion `json:"suggestions"`
+	// Evaluations is how many candidate scenarios were sent for scoring
+	Evaluations int `json:"evaluations" example:"42"`
+	// FailedEvaluations counts the scenarios that could not be scored; the search skips
+	// them and reports the result as truncated
+	FailedEvaluations int `json:"failed_evaluations" example:"0"`
+	// Truncated is set when the search ran out of evaluations or time before it finished;
+	// the suggestions are the best found so far
+	Truncated    bool   `json:"truncated"`
+	ModelVersion string `json:"model_version,omitempty" example:"2024.06.1"`
+}
@@ -0,0 +1,48 @@
+package models
+
+import "time"
+
+// PredictionExplanation is the generated plain-language explanation of a prediction:
+// a summary and one explanation per factor, keyed by feature name. Explanations are
+// cached by the content hash of the risk score, factor values and SHAP values, so
+// predictions with identical inputs share them.
+type PredictionExplanation struct {
+	Summary string            `json:"summary"`
+	Factors map[string]string `json:"factors"`
+	// Provider and Model record what wrote the explanation, e.g. "openai" and "gpt-4o"
+	Provider    string    `json:"provider"`
+	Model       string    `json:"model,omitempty"`
+	GeneratedAt time.Time `json:"generated_at"`
+}
+
+// ApplyExplanation stores the explanation on the prediction, adding factors that are
+// missing so every registry feature can carry its explanation
+func (p *Prediction) ApplyExplanation(explanation *PredictionExplanation) {
+	for _, feature := range FeatureRegistry {
+		text, ok := explanation.Factors[feature.Name]
+		if !ok {
+			continue
+		}
+		if factor := p.Factor(feature.Name); factor != nil {
+			factor.Explanation = text
+			continue
+		}
+		p.Factors = append(p.Factors, PredictionFactor{
+			PredictionID: p.ID,
+			UserID:       p.UserID,
+			Factor:       feature.Name,
+			Explanation:  text,
+		})
+	}
+	p.PredictionSummary = explanation.Summary
+}
+
+// HasExplanations reports whether every registry feature has an explanation
+func (p *Prediction) HasExplanations() bool {
+	for _, feature := range FeatureRegistry {
+		if factor := p.Factor(feature.Name); factor == nil || factor.Explanation == "" {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,201 @@
+package models
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Feature value types
+const (
+	FeatureTypeInt      = "int"
+	FeatureTypeFloat    = "float"
+	FeatureTypeBool     = "bool"
+	FeatureTypeCategory = "category"
+)
+
+// FeatureDefinition describes one model feature. Name is used in the API, the prompts
+// and the prediction_factors table; ModelName is the feature's name in the ML service's
+// input schema and explanations.
+type FeatureDefinition struct {
+	Name                    string
+	ModelName               string
+	Alias                   string
+	Description             string
+	GlobalImportanceInsight string
+	ImportanceRank          int
+	Type                    string
+	Unit                    string
+	// Modifiable features can be changed by the user in a what-if scenario
+	Modifiable bool
+}
+
+// FeatureRegistry lists the model features in the order of the model input vector.
+// Adding a feature here, with its words in the template catalogs, and computing it in
+// the job worker is all a new feature needs.
+var FeatureRegistry = []FeatureDefinition{
+	{
+		Name:                    "age",
+		ModelName:               "age",
+		Alias:                   "Usia",
+		Description:             "User's age in years (e.g., 50).",
+		GlobalImportanceInsight: "Most significant feature. Higher age strongly increases diabetes risk (SHAP up to +0.15), while lower age decreases it (SHAP down to -0.15).",
+		ImportanceRank:          1,
+		Type:                    FeatureTypeInt,
+		Unit:                    "years",
+	},
+	{
+		Name:                    "smoking_status",
+		ModelName:               "smoking_status",
+		Alias:                   "Status Merokok",
+		Description:             "User's smoking status: 0=Never smoked, 1=Former smoker, 2=Active smoker.",
+		GlobalImportanceInsight: "Being a current or former smoker increases diabetes risk (SHAP up to +0.05). Never smoking slightly decreases the risk.",
+		ImportanceRank:          4,
+		Type:                    FeatureTypeCategory,
+		Modifiable:              true,
+	},
+	{
+		Name:                    "is_cholesterol",
+		ModelName:               "is_cholesterol",
+		Alias:                   "Kolesterol Tinggi",
+		Description:             "Indicates if the user has been diagnosed with high cholesterol: 0=No, 1=Yes.",
+		GlobalImportanceInsight: "A 'Yes' diagnosis increases diabetes risk (SHAP up to +0.03). Normal cholesterol levels have a slight risk-reducing effect.",
+		ImportanceRank:          7,
+		Type:                    FeatureTypeBool,
+		Modifiable:              true,
+	},
+	{
+		Name:                    "is_macrosomic_baby",
+		ModelName:               "is_macrosomic_baby",
+		Alias:                   "Riwayat Melahirkan Bayi Besar",
+		Description:             "History of giving birth to a baby over 4 kg: 0=No, 1=Yes, 2=Not applicable (never pregnant).",
+		GlobalImportanceInsight: "A 'Yes' history increases diabetes risk (SHAP up to +0.03), while 'No' has a reducing effect (SHAP ~-0.025).",
+		ImportanceRank:          5,
+		Type:                    FeatureTypeCategory,
+	},
+	{
+		Name:                    "physical_activity_frequency",
+		ModelName:               "moderate_physical_activity_frequency",
+		Alias:                   "Frekuensi Aktivitas Fisik Sedang",
+		Description:             "Days per week the user performs moderate-intensity physical activity.",
+		GlobalImportanceInsight: "Least impactful predictor. More activity slightly decreases risk (SHAP ~-0.02), while less activity slightly increases it.",
+		ImportanceRank:          9,
+		Type:                    FeatureTypeInt,
+		Unit:                    "times",
+		Modifiable:              true,
+	},
+	{
+		Name:                    "is_bloodline",
+		ModelName:               "is_bloodline",
+		Alias:                   "Riwayat Keluarga dengan Diabetes",
+		Description:             "Indicates if a parent died from diabetes: 0=No, 1=Yes.",
+		GlobalImportanceInsight: "A 'Yes' history increases diabetes risk (SHAP up to +0.05). A 'No' history has a minimal risk-reducing impact.",
+		ImportanceRank:          8,
+		Type:                    FeatureTypeBool,
+	},
+	{
+		Name:                    "brinkman_score",
+		ModelName:               "brinkman_index",
+		Alias:                   "Indeks Brinkman",
+		Description:             "Measures lifetime tobacco exposure, represented as a categorized value: 0=Never smoked, 1=Mild smoker, 2=Moderate smoker, 3=Heavy smoker. This is a preprocessed category, not the raw Brinkman Index.",
+		GlobalImportanceInsight: "Higher scores increase diabetes risk (SHAP up to +0.05), while lower scores decrease it (SHAP down to -0.05).",
+		ImportanceRank:          6,
+		Type:                    FeatureTypeCategory,
+	},
+	{
+		Name:                    "bmi",
+		ModelName:               "BMI",
+		Alias:                   "Indeks Massa Tubuh",
+		Description:             "Body Mass Index (e.g., 20.5), based on Asian population classifications: <18.5=Underweight, 18.5-22.9=Normal, 23.0-24.9=Overweight, 25.0-29.9=Obese I, ≥30.0=Obese II.",
+		GlobalImportanceInsight: "Second most influential feature. High BMI values strongly increase diabetes risk (SHAP up to +0.20), while low BMI values decrease it (SHAP down to -0.10).",
+		ImportanceRank:          2,
+		Type:                    FeatureTypeFloat,
+		Modifiable:              true,
+	},
+	{
+		Name:                    "is_hypertension",
+		ModelName:               "is_hypertension",
+		Alias:                   "Hipertensi",
+		Description:             "Indicates if the user has hypertension (high blood pressure): 0=No, 1=Yes.",
+		GlobalImportanceInsight: "A 'Yes' diagnosis increases diabetes risk (SHAP ~+0.05), while 'No' decreases it (SHAP ~-0.05).",
+		ImportanceRank:          3,
+		Type:                    FeatureTypeBool,
+		Modifiable:              true,
+	},
+}
+
+// FeatureByName returns the feature with the given API name
+func FeatureByName(name string) (FeatureDefinition, bool) {
+	for _, feature := range FeatureRegistry {
+		if feature.Name == name {
+			return feature, true
+		}
+	}
+	return FeatureDefinition{}, false
+}
+
+// FeatureByModelName returns the feature with the given model input name
+func FeatureByModelName(modelName string) (FeatureDefinition, bool) {
+	for _, feature := range FeatureRegistry {
+		if feature.ModelName == modelName {
+			return feature, true
+		}
+	}
+	return FeatureDefinition{}, false
+}
+
+// ModelFeatureNames returns the model input names in input order
+func ModelFeatureNames() []string {
+	names := make([]string, len(FeatureRegistry))
+	for i, feature := range FeatureRegistry {
+		names[i] = feature.ModelName
+	}
+	return names
+}
+
+// FeaturesByImportance returns the registry ordered by global importance
+func FeaturesByImportance() []FeatureDefinition {
+	features := append([]FeatureDefinition{}, FeatureRegistry...)
+	sort.SliceStable(features, func(i, j int) bool {
+		return features[i].ImportanceRank < features[j].ImportanceRank
+	})
+	return features
+}
+
+// FeatureVector orders feature values, keyed by API name, into the model input.
+// Missing values are 0.
+func FeatureVector(values map[string]float64) []float64 {
+	vector := make([]float64, len(FeatureRegistry))
+	for i, feature := range FeatureRegistry {
+		vector[i] = values[feature.Name]
+	}
+	return vector
+}
+
+// TypedValue converts a stored feature value back to the feature's type
+func (f FeatureDefinition) TypedValue(value float64) interface{} {
+	switch f.Type {
+	case FeatureTypeBool:
+		return value == 1
+	case FeatureTypeFloat:
+		return value
+	default:
+		return int(value)
+	}
+}
+
+// FormatValue writes a feature value with its unit, as the explanation prompts show it
+func (f FeatureDefinition) FormatValue(value float64) string {
+	var formatted string
+	switch f.Type {
+	case FeatureTypeBool:
+		formatted = fmt.Sprintf("%v", value == 1)
+	case FeatureTypeFloat:
+		formatted = fmt.Sprintf("%.1f", value)
+	default:
+		formatted = fmt.Sprintf("%d", int(value))
+	}
+	if f.Unit != "" {
+		formatted += " " + f.Unit
+	}
+	return formatted
+}
@@ -0,0 +1,50 @@
+package models
+
+import "time"
+
+// Where a feature value came from
+const (
+	DerivationProfile    = "profile"
+	DerivationActivities = "activities"
+	DerivationWhatIf     = "what_if"
+	DerivationComputed   = "computed"
+)
+
+// ActivityWindow describes the activities a feature was derived from
+type ActivityWindow struct {
+	ActivityType string    `json:"activity_type" example:"smoke"`
+	Start        time.Time `json:"start" example:"2024-04-15T10:00:00Z"`
+	End          time.Time `json:"end" example:"2024-06-10T10:00:00Z"`
+	Count        int       `json:"count" example:"12"`
+	Total        int       `json:"total" example:"96"`
+}
+
+// FeatureDerivation records how one feature value was computed
+type FeatureDerivation struct {
+	Feature string  `json:"feature" example:"smoking_status"`
+	Value   float64 `json:"value" example:"2"`
+	Source  string  `json:"source" example:"activities"`
+	// Inputs are the user and profile fields, and intermediate values, that were read
+	Inputs     map[string]interface{} `json:"inputs,omitempty"`
+	Activities []ActivityWindow       `json:"activities,omitempty"`
+	Rule       string                 `json:"rule" example:"smoke activity in the last 56 days: active smoker"`
+}
+
+// FeatureSnapshot is the exact feature vector sent to the model for a prediction, with
+// the derivation of every feature, so that the prediction can be audited and reproduced
+type FeatureSnapshot struct {
+	// Features is in model input order (see FeatureRegistry)
+	Features   []float64           `json:"features"`
+	Trace      []FeatureDerivation `json:"trace"`
+	ComputedAt time.Time           `json:"computed_at" example:"2024-06-10T10:00:00Z"`
+}
+
+// Derivation returns the trace entry of a feature, or nil
+func (s *FeatureSnapshot) Derivation(feature string) *FeatureDerivation {
+	for i := range s.Trace {
+		if s.Trace[i].Feature == feature {
+			return &s.Trace[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+// LLMUsage is one request to an LLM provider made to explain a prediction. Rejected
+// replies that were asked again get a row each.
+type LLMUsage struct {
+	ID           uint   `gorm:"primaryKey" json:"id"`
+	UserID       uint   `gorm:"not null;index" json:"user_id"`
+	PredictionID uint   `gorm:"index" json:"prediction_id"`
+	JobID        string `gorm:"type:varchar(40);index" json:"job_id"`
+
+	Provider         string `gorm:"type:varchar(30);not null" json:"provider"`
+	Model            string `gorm:"type:varchar(100)" json:"model"`
+	PromptTokens     int    `gorm:"not null;default:0" json:"prompt_tokens"`
+	CompletionTokens int    `gorm:"not null;default:0" json:"completion_tokens"`
+	TotalTokens      int    `gorm:"not null;default:0" json:"total_tokens"`
+	LatencyMs        int64  `json:"latency_ms"`
+	// CostEstimate is in US dollars, from the configured or list price of the model
+	CostEstimate float64 `gorm:"not null;default:0" json:"cost_estimate_usd"`
+
+	CreatedAt time.Time `gorm:"index" json:"created_at"`
+}
+
+func (u *LLMUsage) TableName() string {
+	return "llm_usage"
+}
@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+// ModelExperimentResult is one score produced during an A/B or shadow experiment.
+// Shadow jobs get two rows: the control score that was shown and the candidate score
+// that was not.
+type ModelExperimentResult struct {
+	ID         uint   `gorm:"primaryKey" json:"id"`
+	Experiment string `gorm:"type:varchar(100);not null;index" json:"experiment"`
+	Mode       string `gorm:"type:varchar(10);not null" json:"mode"`
+	JobID      string `gorm:"type:varchar(40);index" json:"job_id"`
+	UserID     uint   `gorm:"not null;index" json:"user_id"`
+
+	// Variant is "control" or the candidate model name
+	Variant string `gorm:"type:varchar(50);not null" json:"variant"`
+	// Shown is false for shadow scores the user never saw
+	Shown bool `gorm:"not null" json:"shown"`
+
+	ModelName    string  `gorm:"type:varchar(100)" json:"model_name"`
+	ModelVersion string  `gorm:"type:varchar(50)" json:"model_version"`
+	RiskScore    float64 `gorm:"not null" json:"risk_score"`
+	InputHash    string  `gorm:"type:varchar(64)" json:"input_hash,omitempty"`
+
+	CreatedAt time.Time `gorm:"index" json:"created_at"`
+}
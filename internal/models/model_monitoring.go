@@ -0,0 +1,43 @@
+package models
+
+import (
+	"diabetify/internal/monitoring"
+	"time"
+)
+
+// ModelMonitoringReport is one day of production model monitoring: input and score
+// drift against a reference window, plus AUC and calibration where outcomes exist
+type ModelMonitoringReport struct {
+	ID             uint      `gorm:"primaryKey" json:"id"`
+	Date           time.Time `gorm:"type:DATE;uniqueIndex" json:"date"`
+	ReferenceStart time.Time `json:"reference_start"`
+	ReferenceEnd   time.Time `json:"reference_end"`
+	SampleCount    int       `json:"sample_count"`
+	ReferenceCount int       `json:"reference_count"`
+
+	// Features holds one entry per model input plus risk_score
+	Features      []FeatureDrift `gorm:"type:text;serializer:json" json:"features"`
+	DriftDetected bool           `gorm:"index" json:"drift_detected"`
+
+	// Performance over labeled predictions in the reference and current windows
+	LabeledCount     int                         `json:"labeled_count"`
+	AUC              *float64                    `json:"auc,omitempty"`
+	CalibrationError *float64                    `json:"calibration_error,omitempty"`
+	Calibration      []monitoring.CalibrationBin `gorm:"type:text;serializer:json" json:"calibration,omitempty"`
+
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (r *ModelMonitoringReport) TableName() string {
+	return "model_monitoring_reports"
+}
+
+// FeatureDrift compares one feature's distribution on the report day with the reference window
+type FeatureDrift struct {
+	Feature       string  `json:"feature"`
+	PSI           float64 `json:"psi"`
+	KS            float64 `json:"ks"`
+	ReferenceMean float64 `json:"reference_mean"`
+	CurrentMean   float64 `json:"current_mean"`
+	Drifted       bool    `json:"drifted"`
+}
@@ -0,0 +1,55 @@
+package models
+
+import "time"
+
+// ModelUpdate records one admin-triggered fine-tuning run and its promotion decision
+type ModelUpdate struct {
+	ID          uint   `gorm:"primaryKey" json:"id"`
+	JobID       string `gorm:"type:varchar(36);index" json:"job_id"`
+	RequestedBy uint   `gorm:"not null" json:"requested_by"`
+	Status      string `gorm:"type:varchar(20);not null;default:'pending';index" json:"status"`
+	Epochs      int    `json:"epochs"`
+
+	// Training batch
+	TrainingSamples   int `json:"training_samples"`
+	ValidationSamples int `json:"validation_samples"`
+	PositiveSamples   int `json:"positive_samples"`
+
+	// Metrics reported by the ML service
+	AUCBefore   float64 `json:"auc_before"`
+	AUCAfter    float64 `json:"auc_after"`
+	PRAUCBefore float64 `json:"pr_auc_before"`
+	PRAUCAfter  float64 `json:"pr_auc_after"`
+	ElapsedTime float64 `json:"elapsed_time"`
+
+	// Versions
+	BaseModelVersion string  `gorm:"type:varchar(50)" json:"base_model_version,omitempty"`
+	CandidateVersion string  `gorm:"type:varchar(50)" json:"candidate_version,omitempty"`
+	Promoted         bool    `gorm:"default:false" json:"promoted"`
+	Reason           *string `gorm:"type:text" json:"reason,omitempty"`
+
+	CreatedAt   time.Time  `gorm:"index" json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+}
+
+// Model update status constants
+const (
+	ModelUpdateStatusPending  = "pending"
+	ModelUpdateStatusTraining = "training"
+	ModelUpdateStatusPromoted = "promoted"
+	ModelUpdateStatusRejected = "rejected"
+	ModelUpdateStatusFailed   = "failed"
+)
+
+func (mu *ModelUpdate) TableName() string {
+	return "model_updates"
+}
+
+// LabeledSample is one training example: the feature vector used at prediction time
+// and the outcome later observed for that user
+type LabeledSample struct {
+	UserID   uint      `json:"user_id"`
+	Features []float64 `json:"features"`
+	Label    float64   `json:"label"`
+}
@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+// Notification is an operational alert for admins, such as detected model drift
+type Notification struct {
+	ID        uint       `gorm:"primaryKey" json:"id"`
+	Type      string     `gorm:"type:varchar(50);not null;index" json:"type"`
+	Severity  string     `gorm:"type:varchar(20);not null;default:'warning'" json:"severity"`
+	Title     string     `gorm:"type:varchar(200);not null" json:"title"`
+	Message   string     `gorm:"type:text" json:"message"`
+	ReadAt    *time.Time `json:"read_at,omitempty"`
+	CreatedAt time.Time  `gorm:"index" json:"created_at"`
+}
+
+// Notification types and severities
+const (
+	NotificationTypeModelDrift       = "model_drift"
+	NotificationTypeModelPerformance = "model_performance"
+
+	NotificationSeverityWarning  = "warning"
+	NotificationSeverityCritical = "critical"
+)
+
+func (n *Notification) TableName() string {
+	return "notifications"
+}
@@ -0,0 +1,60 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Outcome is a diagnosis the user reported after using the app. Predictions made
+// before the diagnosis date are linked to it and become labeled training data.
+type Outcome struct {
+	ID             uint           `gorm:"primaryKey" json:"id" example:"1"`
+	CreatedAt      time.Time      `json:"created_at" example:"2023-01-01T00:00:00Z"`
+	UpdatedAt      time.Time      `json:"updated_at" example:"2023-01-01T00:00:00Z"`
+	DeletedAt      gorm.DeletedAt `gorm:"index" json:"-" swaggerignore:"true"`
+	UserID         uint           `gorm:"not null;index" json:"user_id" example:"1"`
+	DiagnosisType  string         `gorm:"type:varchar(30);not null;check:diagnosis_type IN ('type2_diabetes', 'type1_diabetes', 'gestational_diabetes', 'prediabetes', 'no_diabetes')" json:"diagnosis_type" example:"type2_diabetes"`
+	DiagnosisDate  time.Time      `gorm:"type:DATE;not null;index" json:"diagnosis_date" example:"2024-05-01T00:00:00Z"`
+	HbA1c          *float64       `json:"hba1c,omitempty" example:"6.8"`
+	FastingGlucose *float64       `json:"fasting_glucose,omitempty" example:"132"`
+}
+
+// Diagnosis types accepted by POST /users/me/outcomes
+const (
+	DiagnosisType2Diabetes       = "type2_diabetes"
+	DiagnosisType1Diabetes       = "type1_diabetes"
+	DiagnosisGestationalDiabetes = "gestational_diabetes"
+	DiagnosisPrediabetes         = "prediabetes"
+	DiagnosisNoDiabetes          = "no_diabetes"
+)
+
+func (o *Outcome) GetShardKey() int {
+	return int(o.UserID)
+}
+
+func (o *Outcome) TableName() string {
+	return "outcomes"
+}
+
+// Label returns the training label for the risk model, which predicts type 2 diabetes.
+// Other diagnoses are recorded but not used for training, so ok is false for them.
+func (o *Outcome) Label() (label float64, ok bool) {
+	switch o.DiagnosisType {
+	case DiagnosisType2Diabetes:
+		return 1, true
+	case DiagnosisNoDiabetes:
+		return 0, true
+	default:
+		return 0, false
+	}
+}
+
+// OutcomeRequest is the body of POST /users/me/outcomes
+type OutcomeRequest struct {
+	DiagnosisType string `json:"diagnosis_type" binding:"required,oneof=type2_diabetes type1_diabetes gestational_diabetes prediabetes no_diabetes" example:"type2_diabetes"`
+	// DiagnosisDate is YYYY-MM-DD
+	DiagnosisDate  string   `json:"diagnosis_date" binding:"required" example:"2024-05-01"`
+	HbA1c          *float64 `json:"hba1c" binding:"omitempty,gt=2,lt=20" example:"6.8"`
+	FastingGlucose *float64 `json:"fasting_glucose" binding:"omitempty,gt=20,lt=700" example:"132"`
+}
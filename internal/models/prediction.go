@@ -1,66 +1,38 @@
 package models
 
 import (
+	"bytes"
+	"encoding/json"
 	"time"
 
 	"gorm.io/gorm"
 )
 
 type Prediction struct {
-	ID                                    uint           `gorm:"primaryKey" json:"id" example:"1"`
-	CreatedAt                             time.Time      `gorm:"index" json:"created_at" example:"2023-01-01T00:00:00Z"`
-	UpdatedAt                             time.Time      `json:"updated_at" example:"2023-01-01T00:00:00Z"`
-	DeletedAt                             gorm.DeletedAt `gorm:"index" json:"-" swaggerignore:"true"`
-	UserID                                uint           `gorm:"index" json:"user_id" example:"1"`
-	User                                  User           `gorm:"foreignKey:UserID" json:"-"`
-	RiskScore                             float64        `json:"risk_score" example:"0.75"`
-	Age                                   int            `json:"age" example:"30"`
-	AgeShap                               float64        `json:"age_shap" example:"0.05"`
-	AgeContribution                       float64        `json:"age_contribution" example:"0.1"`
-	AgeImpact                             float64        `json:"age_impact" example:"0.2"`
-	AgeExplanation                        string         `gorm:"type:text" json:"age_explanation"`
-	BMI                                   float64        `json:"bmi" example:"22.5"`
-	BMIShap                               float64        `json:"bmi_shap" example:"0.05"`
-	BMIContribution                       float64        `json:"bmi_contribution" example:"0.15"`
-	BMIImpact                             float64        `json:"bmi_impact" example:"0.25"`
-	BMIExplanation                        string         `gorm:"type:text" json:"bmi_explanation"`
-	AvgSmokeCount                         int            `json:"avg_smoke_count" example:"14"`
-	BrinkmanScore                         int            `gorm:"column:brinkman_score;check:brinkman_score IN (0,1,2,3)" json:"brinkman_score" example:"0" validate:"min=0,max=3"`
-	BrinkmanScoreShap                     float64        `json:"brinkman_score_shap" example:"0.05"`
-	BrinkmanScoreContribution             float64        `json:"brinkman_score_contribution" example:"0.2"`
-	BrinkmanScoreImpact                   float64        `json:"brinkman_score_impact" example:"0.3"`
-	BrinkmanScoreExplanation              string         `gorm:"type:text" json:"brinkman_score_explanation"`
-	IsHypertension                        bool           `json:"is_hypertension" example:"true"`
-	IsHypertensionShap                    float64        `json:"is_hypertension_shap" example:"0.05"`
-	IsHypertensionContribution            float64        `json:"is_hypertension_contribution" example:"0.1"`
-	IsHypertensionImpact                  float64        `json:"is_hypertension_impact" example:"0.2"`
-	IsHypertensionExplanation             string         `gorm:"type:text" json:"is_hypertension_explanation"`
-	IsCholesterol                         bool           `json:"is_cholesterol" example:"true"`
-	IsCholesterolShap                     float64        `json:"is_cholesterol_shap" example:"0.05"`
-	IsCholesterolContribution             float64        `json:"is_cholesterol_contribution" example:"0.1"`
-	IsCholesterolImpact                   float64        `json:"is_cholesterol_impact" example:"0.2"`
-	IsCholesterolExplanation              string         `gorm:"type:text" json:"is_cholesterol_explanation"`
-	IsBloodline                           bool           `json:"is_bloodline" example:"true"`
-	IsBloodlineShap                       float64        `json:"is_bloodline_shap" example:"0.05"`
-	IsBloodlineContribution               float64        `json:"is_bloodline_contribution" example:"0.1"`
-	IsBloodlineImpact                     float64        `json:"is_bloodline_impact" example:"0.2"`
-	IsBloodlineExplanation                string         `gorm:"type:text" json:"is_bloodline_explanation"`
-	IsMacrosomicBaby                      int            `gorm:"column:macrosomic_baby" json:"macrosomic_baby" example:"0"`
-	IsMacrosomicBabyShap                  float64        `json:"is_macrosomic_baby_shap" example:"0.05"`
-	IsMacrosomicBabyContribution          float64        `json:"is_macrosomic_baby_contribution" example:"0.05"`
-	IsMacrosomicBabyImpact                float64        `json:"is_macrosomic_baby_impact" example:"0.1"`
-	IsMacrosomicBabyExplanation           string         `gorm:"type:text" json:"is_macrosomic_baby_explanation"`
-	SmokingStatus                         int            `gorm:"column:smoking_status;check:smoking_status IN (0,1,2)" json:"smoking_status" example:"0" validate:"min=0,max=2"`
-	SmokingStatusShap                     float64        `json:"smoking_status_shap" example:"0.05"`
-	SmokingStatusContribution             float64        `json:"smoking_status_contribution" example:"0.1"`
-	SmokingStatusImpact                   float64        `json:"smoking_status_impact" example:"0.2"`
-	SmokingStatusExplanation              string         `gorm:"type:text" json:"smoking_status_explanation"`
-	PhysicalActivityFrequency             int            `json:"physical_activity_frequency" example:"150"`
-	PhysicalActivityFrequencyShap         float64        `json:"physical_activity_frequency_shap" example:"0.05"`
-	PhysicalActivityFrequencyContribution float64        `json:"physical_activity_frequency_contribution" example:"0.1"`
-	PhysicalActivityFrequencyImpact       float64        `json:"physical_activity_frequency_impact" example:"0.2"`
-	PhysicalActivityFrequencyExplanation  string         `gorm:"type:text" json:"physical_activity_frequency_explanation"`
-	PredictionSummary                     string         `gorm:"type:text" json:"prediction_summary" example:"This user has a moderate risk of diabetes."`
+	ID            uint           `gorm:"primaryKey" json:"id" example:"1"`
+	CreatedAt     time.Time      `gorm:"index" json:"created_at" example:"2023-01-01T00:00:00Z"`
+	UpdatedAt     time.Time      `json:"updated_at" example:"2023-01-01T00:00:00Z"`
+	DeletedAt     gorm.DeletedAt `gorm:"index" json:"-" swaggerignore:"true"`
+	UserID        uint           `gorm:"index" json:"user_id" example:"1"`
+	User          User           `gorm:"foreignKey:UserID" json:"-"`
+	RiskScore     float64        `json:"risk_score" example:"0.75"`
+	AvgSmokeCount int            `json:"avg_smoke_count" example:"14"`
+
+	// Factors holds the value, SHAP explanation and LLM explanation of every model feature
+	Factors           []PredictionFactor `gorm:"foreignKey:PredictionID" json:"factors"`
+	PredictionSummary string             `gorm:"type:text" json:"prediction_summary" example:"This user has a moderate risk of diabetes."`
+
+	// Provenance: which model produced this prediction and from what input
+	ModelName            string `gorm:"type:varchar(100);default:'unknown'" json:"model_name" example:"diabetes-risk-xgb"`
+	ModelVersion         string `gorm:"type:varchar(50);default:'unknown';index" json:"model_version" example:"2024.06.1"`
+	FeatureSchemaVersion string `gorm:"type:varchar(20);default:'v1'" json:"feature_schema_version" example:"v1"`
+	InputHash            string `gorm:"type:varchar(64);index" json:"input_hash" example:"9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"`
+	// FeatureSnapshot is served by GET /prediction/:id/snapshot; nil for older predictions
+	FeatureSnapshot *FeatureSnapshot `gorm:"type:text;serializer:json" json:"-"`
+
+	// OutcomeID links the prediction to the first diagnosis reported after it
+	OutcomeID *uint    `gorm:"index" json:"outcome_id,omitempty" example:"1"`
+	Outcome   *Outcome `gorm:"foreignKey:OutcomeID" json:"-"`
 }
 
 func (p *Prediction) GetShardKey() int {
@@ -71,6 +43,119 @@ func (p *Prediction) TableName() string {
 	return "predictions"
 }
 
+// legacyValueKeys maps features whose flat JSON value field is not named after the feature
+var legacyValueKeys = map[string]string{
+	"is_macrosomic_baby": "macrosomic_baby",
+}
+
+// MarshalJSON adds the flat per-feature fields clients read before factors got their
+// own table: the value under the feature name (macrosomic_baby for is_macrosomic_baby)
+// and <feature>_shap, _contribution, _impact and _explanation
+func (p Prediction) MarshalJSON() ([]byte, error) {
+	type prediction Prediction
+	body, err := json.Marshal(prediction(p))
+	if err != nil {
+		return nil, err
+	}
+
+	legacy := make(map[string]interface{}, len(FeatureRegistry)*5)
+	for _, feature := range FeatureRegistry {
+		factor := PredictionFactor{Factor: feature.Name}
+		if stored := p.Factor(feature.Name); stored != nil {
+			factor = *stored
+		}
+		valueKey := feature.Name
+		if key, ok := legacyValueKeys[feature.Name]; ok {
+			valueKey = key
+		}
+		legacy[valueKey] = feature.TypedValue(factor.Value)
+		legacy[feature.Name+"_shap"] = factor.Shap
+		legacy[feature.Name+"_contribution"] = factor.Contribution
+		legacy[feature.Name+"_impact"] = factor.Impact
+		legacy[feature.Name+"_explanation"] = factor.Explanation
+	}
+	flat, err := json.Marshal(legacy)
+	if err != nil {
+		return nil, err
+	}
+
+	// Both are JSON objects: splice the flat fields in before the closing brace
+	var merged bytes.Buffer
+	merged.Grow(len(body) + len(flat))
+	merged.Write(body[:len(body)-1])
+	merged.WriteByte(',')
+	merged.Write(flat[1:])
+	return merged.Bytes(), nil
+}
+
+// FeatureVector returns the model input: the snapshot taken when the features were
+// sent to the ML service, or for older predictions the vector rebuilt from the factors
+func (p *Prediction) FeatureVector() []float64 {
+	if p.FeatureSnapshot != nil && len(p.FeatureSnapshot.Features) == len(FeatureRegistry) {
+		return append([]float64{}, p.FeatureSnapshot.Features...)
+	}
+	values := make(map[string]float64, len(p.Factors))
+	for _, factor := range p.Factors {
+		values[factor.Factor] = factor.Value
+	}
+	return FeatureVector(values)
+}
+
+// Factor returns the stored factor with the given feature name, or nil
+func (p *Prediction) Factor(name string) *PredictionFactor {
+	for i := range p.Factors {
+		if p.Factors[i].Factor == name {
+			return &p.Factors[i]
+		}
+	}
+	return nil
+}
+
+// FactorValue returns the value of a factor, 0 when it is not stored
+func (p *Prediction) FactorValue(name string) float64 {
+	if factor := p.Factor(name); factor != nil {
+		return factor.Value
+	}
+	return 0
+}
+
+// FactorsByImportance lists every registry feature's factor in order of global
+// importance; features without a stored factor have zero values
+func (p *Prediction) FactorsByImportance() []PredictionFactor {
+	features := FeaturesByImportance()
+	factors := make([]PredictionFactor, len(features))
+	for i, feature := range features {
+		if factor := p.Factor(feature.Name); factor != nil {
+			factors[i] = *factor
+		} else {
+			factors[i] = PredictionFactor{Factor: feature.Name}
+		}
+	}
+	return factors
+}
+
+// PredictionFactor is one model feature of a prediction: its input value, SHAP
+// explanation and the generated plain-language explanation
+type PredictionFactor struct {
+	ID           uint    `gorm:"primaryKey" json:"-"`
+	PredictionID uint    `gorm:"uniqueIndex:idx_prediction_factor" json:"-"`
+	UserID       uint    `gorm:"index" json:"-"`
+	Factor       string  `gorm:"type:varchar(50);uniqueIndex:idx_prediction_factor" json:"factor" example:"bmi"`
+	Value        float64 `json:"value" example:"27.4"`
+	Shap         float64 `json:"shap" example:"0.05"`
+	Contribution float64 `json:"contribution" example:"0.15"`
+	Impact       float64 `json:"impact" example:"1"`
+	Explanation  string  `gorm:"type:text" json:"explanation,omitempty"`
+}
+
+func (f *PredictionFactor) GetShardKey() int {
+	return int(f.UserID)
+}
+
+func (f *PredictionFactor) TableName() string {
+	return "prediction_factors"
+}
+
 type PredictionRequest struct {
 	Features []float64 `json:"features" binding:"required"`
 }
@@ -94,6 +179,8 @@ type UpdateModelRequest struct {
 	XVal   [][]float64 `json:"x_val" binding:"required"`
 	YVal   []float64   `json:"y_val" binding:"required"`
 	Epochs int         `json:"epochs" binding:"required,min=1"`
+	// DryRun trains and evaluates a candidate without serving it
+	DryRun bool `json:"dry_run,omitempty"`
 }
 
 type UpdateModelResponse struct {
@@ -104,6 +191,14 @@ type UpdateModelResponse struct {
 	PRAUCAfter  float64   `json:"pr_auc_after"`
 	ElapsedTime float64   `json:"elapsed_time"`
 	Timestamp   time.Time `json:"timestamp"`
+	// ModelVersion is the version being served; CandidateVersion is set for dry runs
+	ModelVersion     string `json:"model_version,omitempty"`
+	CandidateVersion string `json:"candidate_version,omitempty"`
+}
+
+type PromoteModelResponse struct {
+	Status       string `json:"status"`
+	ModelVersion string `json:"model_version"`
 }
 
 type JobProgressUpdate struct {
@@ -0,0 +1,39 @@
+package models
+
+import "time"
+
+// ComparedPrediction identifies one side of a prediction comparison
+type ComparedPrediction struct {
+	ID           uint      `json:"id" example:"12"`
+	CreatedAt    time.Time `json:"created_at" example:"2024-06-01T10:00:00Z"`
+	RiskScore    float64   `json:"risk_score" example:"0.32"`
+	ModelVersion string    `json:"model_version" example:"2024.06.1"`
+}
+
+// FactorDelta is the change of one risk factor between two predictions
+type FactorDelta struct {
+	Factor string `json:"factor" example:"bmi"`
+	// Alias is the factor's name as shown to users
+	Alias             string  `json:"alias" example:"Indeks Massa Tubuh"`
+	FromValue         float64 `json:"from_value" example:"24.1"`
+	ToValue           float64 `json:"to_value" example:"27.4"`
+	ValueChanged      bool    `json:"value_changed" example:"true"`
+	FromShap          float64 `json:"from_shap" example:"0.01"`
+	ToShap            float64 `json:"to_shap" example:"0.06"`
+	ShapDelta         float64 `json:"shap_delta" example:"0.05"`
+	FromContribution  float64 `json:"from_contribution" example:"0.08"`
+	ToContribution    float64 `json:"to_contribution" example:"0.21"`
+	ContributionDelta float64 `json:"contribution_delta" example:"0.13"`
+}
+
+// PredictionComparison explains the change from one prediction to a later one
+type PredictionComparison struct {
+	From           ComparedPrediction `json:"from"`
+	To             ComparedPrediction `json:"to"`
+	RiskScoreDelta float64            `json:"risk_score_delta" example:"0.13"`
+	ModelChanged   bool               `json:"model_changed"`
+	// Factors holds all nine factors, largest SHAP change first
+	Factors []FactorDelta `json:"factors"`
+	// Summary describes the main drivers of the change in plain language
+	Summary string `json:"summary" example:"Risiko diabetes Anda naik dari 32,0% menjadi 45,0%."`
+}
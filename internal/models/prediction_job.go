@@ -7,22 +7,53 @@ import (
 )
 
 type PredictionJob struct {
-	ID           string         `gorm:"primaryKey;type:varchar(36)" json:"id"`
-	UserID       uint           `gorm:"not null;index" json:"user_id"`
-	Status       string         `gorm:"type:varchar(20);not null;default:'pending';index" json:"status"`
-	IsWhatIf     bool           `json:"is_what_if"`
-	PredictionID *uint          `gorm:"index" json:"prediction_id,omitempty"`
-	ErrorMessage *string        `gorm:"type:text" json:"error_message,omitempty"`
-	CreatedAt    time.Time      `gorm:"index" json:"created_at"`
-	UpdatedAt    time.Time      `json:"updated_at"`
-	CompletedAt  *time.Time     `json:"completed_at,omitempty"`
-	DeletedAt    gorm.DeletedAt `gorm:"index" json:"-"`
+	ID           string  `gorm:"primaryKey;type:varchar(36)" json:"id"`
+	UserID       uint    `gorm:"not null;index;uniqueIndex:idx_prediction_jobs_user_idempotency_key,priority:1" json:"user_id"`
+	Status       string  `gorm:"type:varchar(20);not null;default:'pending';index" json:"status"`
+	JobType      string  `gorm:"type:varchar(20);not null;default:'prediction';index" json:"job_type"`
+	IsWhatIf     bool    `json:"is_what_if"`
+	PredictionID *uint   `gorm:"index" json:"prediction_id,omitempty"`
+	ErrorMessage *string `gorm:"type:text" json:"error_message,omitempty"`
+	InputHash    string  `gorm:"type:varchar(64)" json:"input_hash,omitempty"`
+	// FeatureSnapshot is copied to the prediction when the ML response arrives
+	FeatureSnapshot *FeatureSnapshot `gorm:"type:text;serializer:json" json:"-"`
+	CreatedAt       time.Time        `gorm:"index" json:"created_at"`
+	UpdatedAt       time.Time        `json:"updated_at"`
+	CompletedAt     *time.Time       `json:"completed_at,omitempty"`
+	DeletedAt       gorm.DeletedAt   `gorm:"index" json:"-"`
+
+	// IdempotencyKey is the client's Idempotency-Key header; repeating it returns this job.
+	// RequestHash fingerprints the request that used the key, so reusing the key for a
+	// different request is rejected.
+	IdempotencyKey *string `gorm:"type:varchar(100);uniqueIndex:idx_prediction_jobs_user_idempotency_key,priority:2" json:"-"`
+	RequestHash    string  `gorm:"type:varchar(64)" json:"-"`
+
+	// Batch and sweep jobs: items point at their parent, which tracks aggregate progress.
+	// SubjectUserID is the user being scored when the item is not an anonymous CSV row.
+	ParentJobID    *string `gorm:"type:varchar(36);index" json:"parent_job_id,omitempty"`
+	SubjectUserID  *uint   `json:"subject_user_id,omitempty"`
+	TotalItems     int     `gorm:"default:0" json:"total_items,omitempty"`
+	CompletedItems int     `gorm:"default:0" json:"completed_items,omitempty"`
+	FailedItems    int     `gorm:"default:0" json:"failed_items,omitempty"`
+	// BatchItem is the row reference of a batch item and, once scored, its result
+	BatchItem *BatchItemResult `gorm:"type:text;serializer:json" json:"-"`
 
 	// Relations
 	User       User        `gorm:"foreignKey:UserID" json:"user,omitempty"`
 	Prediction *Prediction `gorm:"foreignKey:PredictionID" json:"prediction,omitempty"`
 }
 
+// BatchItemResult is stored on each batch item job so results survive restarts and any
+// replica can build the result file
+type BatchItemResult struct {
+	// Index is the position of the item in the batch
+	Index        int                `json:"index"`
+	Ref          string             `json:"ref"`
+	RiskScore    *float64           `json:"risk_score,omitempty"`
+	ModelVersion string             `json:"model_version,omitempty"`
+	Shap         map[string]float64 `json:"shap,omitempty"`
+}
+
 // Job status constants
 const (
 	JobStatusPending    = "pending"
@@ -33,6 +64,18 @@ const (
 	JobStatusCancelled  = "cancelled"
 )
 
+// Job type constants
+const (
+	JobTypePrediction     = "prediction"
+	JobTypeWhatIf         = "what_if"
+	JobTypeModelUpdate    = "model_update"
+	JobTypeBatch          = "batch"
+	JobTypeBatchItem      = "batch_item"
+	JobTypeWhatIfSweep    = "what_if_sweep"
+	JobTypeExplanation    = "explanation"
+	JobTypeCounterfactual = "counterfactual"
+)
+
 func (pj *PredictionJob) TableName() string {
 	return "prediction_jobs"
 }
@@ -42,8 +85,19 @@ type PredictionJobRequest struct {
 	JobID       string       `json:"job_id"`
 	UserID      uint         `json:"user_id"`
 	WhatIfInput *WhatIfInput `json:"what_if_input,omitempty"`
+	// Priority picks the worker queue class; empty means JobPriorityStandard
+	Priority string `json:"priority,omitempty"`
+	// JobType selects a handler registered with the worker; empty runs a prediction
+	JobType string `json:"job_type,omitempty"`
 }
 
+// Job priority classes, highest first. Workers always take the highest non-empty class.
+const (
+	JobPriorityInteractive = "interactive"
+	JobPriorityStandard    = "standard"
+	JobPriorityBackground  = "background"
+)
+
 // What if Input
 type WhatIfInput struct {
 	SmokingStatus             int     `json:"smoking_status" binding:"oneof=0 1 2"`
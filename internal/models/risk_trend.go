@@ -0,0 +1,88 @@
+package models
+
+import "time"
+
+// Trend aggregation intervals
+const (
+	TrendIntervalWeek  = "week"
+	TrendIntervalMonth = "month"
+)
+
+// Trend directions, from the confidence interval of the slope
+const (
+	TrendRising  = "rising"
+	TrendFalling = "falling"
+	TrendStable  = "stable"
+)
+
+// Change point annotation kinds
+const (
+	AnnotationProfile  = "profile"
+	AnnotationActivity = "activity"
+)
+
+// RiskTrendPoint is the day's latest prediction with the smoothed and fitted risk
+type RiskTrendPoint struct {
+	PredictionID  uint      `json:"prediction_id" example:"12"`
+	CreatedAt     time.Time `json:"created_at" example:"2024-06-01T10:00:00Z"`
+	RiskScore     float64   `json:"risk_score" example:"0.32"`
+	MovingAverage float64   `json:"moving_average" example:"0.31"`
+	// Fitted, Lower and Upper are the regression line and its 95% confidence band;
+	// they are omitted when there are fewer than three points
+	Fitted *float64 `json:"fitted,omitempty" example:"0.3"`
+	Lower  *float64 `json:"lower,omitempty" example:"0.27"`
+	Upper  *float64 `json:"upper,omitempty" example:"0.33"`
+}
+
+// RiskTrendAggregate summarizes the predictions of one week or month
+type RiskTrendAggregate struct {
+	PeriodStart time.Time `json:"period_start" example:"2024-05-27T00:00:00Z"`
+	PeriodEnd   time.Time `json:"period_end" example:"2024-06-03T00:00:00Z"`
+	Count       int       `json:"count" example:"3"`
+	Mean        float64   `json:"mean" example:"0.31"`
+	Min         float64   `json:"min" example:"0.28"`
+	Max         float64   `json:"max" example:"0.34"`
+}
+
+// RiskTrendLine is the least-squares line through the points
+type RiskTrendLine struct {
+	// SlopePer30Days is the change of the risk score per 30 days
+	SlopePer30Days float64 `json:"slope_per_30_days" example:"0.02"`
+	// SlopeLower and SlopeUpper bound the slope per 30 days at 95% confidence
+	SlopeLower float64 `json:"slope_lower" example:"0.005"`
+	SlopeUpper float64 `json:"slope_upper" example:"0.035"`
+	Direction  string  `json:"direction" example:"rising"`
+}
+
+// RiskChangeAnnotation is a profile edit or activity change around a change point
+type RiskChangeAnnotation struct {
+	Kind        string  `json:"kind" example:"profile"`
+	Field       string  `json:"field" example:"bmi"`
+	From        float64 `json:"from" example:"24.1"`
+	To          float64 `json:"to" example:"27.4"`
+	Description string  `json:"description" example:"bmi changed from 24.1 to 27.4"`
+}
+
+// RiskChangePoint is a shift of the mean risk between two consecutive predictions
+type RiskChangePoint struct {
+	PredictionID uint      `json:"prediction_id" example:"14"`
+	At           time.Time `json:"at" example:"2024-06-10T10:00:00Z"`
+	// WindowStart is the previous prediction; the change happened after it
+	WindowStart time.Time              `json:"window_start" example:"2024-06-03T10:00:00Z"`
+	BeforeMean  float64                `json:"before_mean" example:"0.3"`
+	AfterMean   float64                `json:"after_mean" example:"0.41"`
+	Shift       float64                `json:"shift" example:"0.11"`
+	Annotations []RiskChangeAnnotation `json:"annotations"`
+}
+
+// RiskTrend is the trend analysis of a user's risk scores over a date range
+type RiskTrend struct {
+	StartDate    time.Time            `json:"start_date" example:"2024-01-01T00:00:00Z"`
+	EndDate      time.Time            `json:"end_date" example:"2024-06-30T23:59:59Z"`
+	Interval     string               `json:"interval" example:"week"`
+	Window       int                  `json:"window" example:"3"`
+	Points       []RiskTrendPoint     `json:"points"`
+	Aggregates   []RiskTrendAggregate `json:"aggregates"`
+	Trend        *RiskTrendLine       `json:"trend,omitempty"`
+	ChangePoints []RiskChangePoint    `json:"change_points"`
+}
@@ -19,6 +19,16 @@ type User struct {
 	DOB              *string        `gorm:"type:DATE;" json:"dob" example:"2000-01-30"`
 	Verified         bool           `gorm:"default:false" json:"verified" example:"false"`
 	LastPredictionAt *time.Time     `json:"last_prediction_at,omitempty" example:"2023-01-01T00:00:00Z"`
+	// ResearchConsent allows the user's labeled data to be used for model retraining. It is
+	// only changed through PUT /users/me/research-consent, never by profile updates.
+	ResearchConsent bool `gorm:"default:false;index" json:"research_consent" example:"false"`
+	// ResearchConsentAt is when ResearchConsent was last given or withdrawn
+	ResearchConsentAt *time.Time `json:"research_consent_at,omitempty" example:"2023-01-01T00:00:00Z"`
+}
+
+// ResearchConsentColumns lists the columns only UserRepository.UpdateResearchConsent writes
+func ResearchConsentColumns() []string {
+	return []string{"research_consent", "research_consent_at"}
 }
 
 func (u *User) GetShardKey() int {
@@ -0,0 +1,78 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// WhatIfScenario is a saved what-if prediction: the input the user tried, the features
+// sent to the model and the resulting risk and SHAP explanation. It is created when the
+// job is processed and completed when the ML response arrives.
+type WhatIfScenario struct {
+	ID        uint           `gorm:"primaryKey" json:"id" example:"1"`
+	CreatedAt time.Time      `gorm:"index" json:"created_at" example:"2023-01-01T00:00:00Z"`
+	UpdatedAt time.Time      `json:"updated_at" example:"2023-01-01T00:00:00Z"`
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"-" swaggerignore:"true"`
+	UserID    uint           `gorm:"not null;index" json:"user_id" example:"1"`
+	JobID     string         `gorm:"type:varchar(36);uniqueIndex" json:"job_id" example:"6f1c2a9e-3b7d-4e8a-9c0f-1a2b3c4d5e6f"`
+	Name      string         `gorm:"type:varchar(100)" json:"name" example:"Lose 10 kg"`
+	Pinned    bool           `gorm:"default:false;index" json:"pinned"`
+
+	Input    WhatIfInput `gorm:"type:text;serializer:json" json:"input"`
+	Features []float64   `gorm:"type:text;serializer:json" json:"features"`
+
+	// The user's latest regular prediction when the scenario was run, to compare against
+	BaselinePredictionID *uint    `gorm:"index" json:"baseline_prediction_id,omitempty" example:"1"`
+	BaselineRiskScore    *float64 `json:"baseline_risk_score,omitempty" example:"0.42"`
+
+	// Result; nil until the ML response arrives
+	RiskScore           *float64                          `json:"risk_score,omitempty" example:"0.31"`
+	ModelVersion        string                            `gorm:"type:varchar(50)" json:"model_version,omitempty" example:"2024.06.1"`
+	FeatureExplanations map[string]map[string]interface{} `gorm:"type:text;serializer:json" json:"feature_explanations,omitempty"`
+	UserDataUsed        map[string]interface{}            `gorm:"type:text;serializer:json" json:"user_data_used,omitempty"`
+	CompletedAt         *time.Time                        `gorm:"index" json:"completed_at,omitempty"`
+}
+
+func (s *WhatIfScenario) GetShardKey() int {
+	return int(s.UserID)
+}
+
+func (s *WhatIfScenario) TableName() string {
+	return "what_if_scenarios"
+}
+
+// Result returns the scenario in the shape of GET /prediction/job/:job_id/result
+func (s *WhatIfScenario) Result() map[string]interface{} {
+	result := map[string]interface{}{
+		"job_id":               s.JobID,
+		"job_type":             JobTypeWhatIf,
+		"scenario_id":          s.ID,
+		"name":                 s.Name,
+		"pinned":               s.Pinned,
+		"what_if_input":        s.Input,
+		"model_version":        s.ModelVersion,
+		"user_data_used":       s.UserDataUsed,
+		"feature_explanations": s.FeatureExplanations,
+		"timestamp":            s.CompletedAt,
+	}
+	if s.RiskScore != nil {
+		result["risk_score"] = *s.RiskScore
+		result["risk_percentage"] = *s.RiskScore * 100
+	}
+	if s.BaselineRiskScore != nil {
+		result["baseline_prediction_id"] = s.BaselinePredictionID
+		result["baseline_risk_score"] = *s.BaselineRiskScore
+		if s.RiskScore != nil {
+			result["risk_change"] = *s.RiskScore - *s.BaselineRiskScore
+		}
+	}
+	return result
+}
+
+// WhatIfScenarioUpdateRequest is the body of PATCH /prediction/what-if/scenarios/:id;
+// omitted fields are left unchanged
+type WhatIfScenarioUpdateRequest struct {
+	Name   *string `json:"name" binding:"omitempty,max=100" example:"Lose 10 kg"`
+	Pinned *bool   `json:"pinned" example:"true"`
+}
@@ -0,0 +1,54 @@
+package models
+
+// WhatIfInput fields that can be swept, by JSON name
+const (
+	SweepFieldWeight                    = "weight"
+	SweepFieldPhysicalActivityFrequency = "physical_activity_frequency"
+	SweepFieldSmokingStatus             = "smoking_status"
+	SweepFieldYearsOfSmoking            = "years_of_smoking"
+	SweepFieldAvgSmokeCount             = "avg_smoke_count"
+	SweepFieldIsHypertension            = "is_hypertension"
+	SweepFieldIsCholesterol             = "is_cholesterol"
+)
+
+// WhatIfSweepAxis varies one WhatIfInput field from From to To (either direction) in
+// steps of Step. Boolean fields take 0 and 1.
+type WhatIfSweepAxis struct {
+	Field string  `json:"field" binding:"required,oneof=weight physical_activity_frequency smoking_status years_of_smoking avg_smoke_count is_hypertension is_cholesterol" example:"weight"`
+	From  float64 `json:"from" example:"90"`
+	To    float64 `json:"to" example:"70"`
+	Step  float64 `json:"step" binding:"required,gt=0" example:"5"`
+}
+
+// WhatIfSweepRequest is the body of POST /prediction/what-if/sweep. Every point uses
+// Base with the axis fields replaced.
+type WhatIfSweepRequest struct {
+	Base WhatIfInput       `json:"base"`
+	Axes []WhatIfSweepAxis `json:"axes" binding:"required,min=1,max=2,dive"`
+}
+
+// WhatIfSweepPoint is the risk at one combination of axis values; Values follows the
+// order of the request's axes
+type WhatIfSweepPoint struct {
+	Values    []float64 `json:"values"`
+	RiskScore *float64  `json:"risk_score"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// WhatIfSweepAxisValues lists the values an axis took
+type WhatIfSweepAxisValues struct {
+	Field  string    `json:"field"`
+	Values []float64 `json:"values"`
+}
+
+// WhatIfSweepResult is a risk series (one axis) or grid (two axes) ready to chart.
+// Grid[i][j] is the risk at Axes[0].Values[i] and Axes[1].Values[j]; nil cells failed.
+type WhatIfSweepResult struct {
+	JobID        string                  `json:"job_id"`
+	Base         WhatIfInput             `json:"base"`
+	Axes         []WhatIfSweepAxisValues `json:"axes"`
+	Points       []WhatIfSweepPoint      `json:"points"`
+	Grid         [][]*float64            `json:"grid,omitempty"`
+	ModelVersion string                  `json:"model_version,omitempty"`
+	FailedPoints int                     `json:"failed_points"`
+}
@@ -0,0 +1,217 @@
+// Package monitoring holds the statistics used to watch the risk model in production:
+// distribution drift between two samples and discrimination/calibration against outcomes.
+package monitoring
+
+import (
+	"math"
+	"sort"
+)
+
+// psiEpsilon keeps empty bins from producing infinite PSI
+const psiEpsilon = 1e-4
+
+// PSI is the population stability index of current against reference. Bin edges are
+// the reference deciles, merged where they coincide, so binary and small ordinal
+// features get one bin per value. Common reading: < 0.1 stable, 0.1-0.2 moderate
+// shift, > 0.2 significant shift.
+func PSI(reference, current []float64, bins int) float64 {
+	if len(reference) == 0 || len(current) == 0 {
+		return 0
+	}
+
+	edges := quantileEdges(reference, bins)
+	ref := binProportions(reference, edges)
+	cur := binProportions(current, edges)
+
+	psi := 0.0
+	for i := range ref {
+		r := math.Max(ref[i], psiEpsilon)
+		c := math.Max(cur[i], psiEpsilon)
+		psi += (c - r) * math.Log(c/r)
+	}
+	return psi
+}
+
+// KS is the two-sample Kolmogorov-Smirnov statistic: the largest distance between
+// the empirical CDFs of the two samples
+func KS(reference, current []float64) float64 {
+	if len(reference) == 0 || len(current) == 0 {
+		return 0
+	}
+
+	a := sortedCopy(reference)
+	b := sortedCopy(current)
+
+	var i, j int
+	maxDiff := 0.0
+	for i < len(a) && j < len(b) {
+		// Advance past every value equal to the smaller head so ties move both CDFs together
+		x := math.Min(a[i], b[j])
+		for i < len(a) && a[i] <= x {
+			i++
+		}
+		for j < len(b) && b[j] <= x {
+			j++
+		}
+		diff := math.Abs(float64(i)/float64(len(a)) - float64(j)/float64(len(b)))
+		maxDiff = math.Max(maxDiff, diff)
+	}
+	return maxDiff
+}
+
+// KSCritical is the KS statistic above which the samples differ at roughly the 1%
+// significance level
+func KSCritical(n, m int) float64 {
+	if n == 0 || m == 0 {
+		return 1
+	}
+	return 1.63 * math.Sqrt(float64(n+m)/float64(n*m))
+}
+
+// AUC is the area under the ROC curve, computed as the Mann-Whitney U statistic with
+// tied scores counted as half. ok is false unless both classes are present.
+func AUC(scores, labels []float64) (auc float64, ok bool) {
+	type pair struct{ score, label float64 }
+	pairs := make([]pair, len(scores))
+	positives := 0
+	for i := range scores {
+		pairs[i] = pair{scores[i], labels[i]}
+		if labels[i] >= 0.5 {
+			positives++
+		}
+	}
+	negatives := len(pairs) - positives
+	if positives == 0 || negatives == 0 {
+		return 0, false
+	}
+
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i].score < pairs[j].score })
+
+	// Sum of average ranks of the positives
+	rankSum := 0.0
+	for i := 0; i < len(pairs); {
+		j := i
+		for j < len(pairs) && pairs[j].score == pairs[i].score {
+			j++
+		}
+		avgRank := float64(i+j+1) / 2
+		for k := i; k < j; k++ {
+			if pairs[k].label >= 0.5 {
+				rankSum += avgRank
+			}
+		}
+		i = j
+	}
+
+	u := rankSum - float64(positives*(positives+1))/2
+	return u / float64(positives*negatives), true
+}
+
+// CalibrationBin compares predicted risk with the observed rate in one score range
+type CalibrationBin struct {
+	Lower         float64 `json:"lower"`
+	Upper         float64 `json:"upper"`
+	Count         int     `json:"count"`
+	MeanPredicted float64 `json:"mean_predicted"`
+	ObservedRate  float64 `json:"observed_rate"`
+}
+
+// Calibration groups scores into equal-width bins over [0, 1] and returns the bins
+// with at least one sample and the expected calibration error (the count-weighted
+// mean of |mean predicted - observed rate|)
+func Calibration(scores, labels []float64, bins int) ([]CalibrationBin, float64) {
+	if len(scores) == 0 || bins <= 0 {
+		return nil, 0
+	}
+
+	counts := make([]int, bins)
+	predicted := make([]float64, bins)
+	observed := make([]float64, bins)
+	for i, score := range scores {
+		b := int(score * float64(bins))
+		if b >= bins {
+			b = bins - 1
+		}
+		if b < 0 {
+			b = 0
+		}
+		counts[b]++
+		predicted[b] += score
+		observed[b] += labels[i]
+	}
+
+	var result []CalibrationBin
+	ece := 0.0
+	for b := 0; b < bins; b++ {
+		if counts[b] == 0 {
+			continue
+		}
+		n := float64(counts[b])
+		bin := CalibrationBin{
+			Lower:         float64(b) / float64(bins),
+			Upper:         float64(b+1) / float64(bins),
+			Count:         counts[b],
+			MeanPredicted: predicted[b] / n,
+			ObservedRate:  observed[b] / n,
+		}
+		ece += n / float64(len(scores)) * math.Abs(bin.MeanPredicted-bin.ObservedRate)
+		result = append(result, bin)
+	}
+	return result, ece
+}
+
+// Mean returns the arithmetic mean, or 0 for an empty sample
+func Mean(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
+
+// Quantile returns the q-th quantile (0 <= q <= 1) with linear interpolation between
+// order statistics, or 0 for an empty sample
+func Quantile(values []float64, q float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := sortedCopy(values)
+	pos := q * float64(len(sorted)-1)
+	lower := int(math.Floor(pos))
+	upper := int(math.Ceil(pos))
+	return sorted[lower] + (pos-float64(lower))*(sorted[upper]-sorted[lower])
+}
+
+func quantileEdges(values []float64, bins int) []float64 {
+	sorted := sortedCopy(values)
+	var edges []float64
+	for q := 1; q < bins; q++ {
+		edge := sorted[(len(sorted)-1)*q/bins]
+		if len(edges) == 0 || edge > edges[len(edges)-1] {
+			edges = append(edges, edge)
+		}
+	}
+	return edges
+}
+
+// binProportions assigns each value to the first edge it does not exceed; values above
+// the last edge go to the final bin
+func binProportions(values, edges []float64) []float64 {
+	counts := make([]float64, len(edges)+1)
+	for _, v := range values {
+		counts[sort.SearchFloat64s(edges, v)]++
+	}
+	for i := range counts {
+		counts[i] /= float64(len(values))
+	}
+	return counts
+}
+
+func sortedCopy(values []float64) []float64 {
+	out := append([]float64(nil), values...)
+	sort.Float64s(out)
+	return out
+}
@@ -3,16 +3,46 @@ package openai
 import (
 	"bytes"
 	"context"
+	"diabetify/internal/models"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"os"
 	"strings"
+	"time"
 )
 
+// DefaultBaseURL and DefaultModel are used when ClientConfig leaves them empty
+const (
+	DefaultBaseURL = "https://api.openai.com/v1"
+	DefaultModel   = "gpt-4o"
+	// DefaultMaxAttempts allows one retry after an invalid reply
+	DefaultMaxAttempts = 2
+)
+
+// ClientConfig points the client at an OpenAI-compatible chat completions endpoint,
+// such as OpenAI itself or a local server
+type ClientConfig struct {
+	// BaseURL is the API root the client appends /chat/completions to
+	BaseURL string
+	Model   string
+	// APIKey is required for OpenAI; local servers usually accept requests without one
+	APIKey string
+	// MaxAttempts is how many replies are requested when a reply fails the explanation
+	// checks; zero means DefaultMaxAttempts
+	MaxAttempts int
+	// Price estimates the cost of each call; nil uses the list price of known OpenAI models
+	Price *ModelPrice
+}
+
+// Client explains predictions with an OpenAI-compatible chat completions endpoint
 type Client struct {
-	apiKey     string
-	httpClient *http.Client
+	apiKey      string
+	baseURL     string
+	model       string
+	maxAttempts int
+	price       ModelPrice
+	httpClient  *http.Client
 }
 
 type ContentItem struct {
@@ -71,6 +101,24 @@ type TokenUsage struct {
 	TotalTokens      int `json:"total_tokens"`
 }
 
+func (u TokenUsage) add(other TokenUsage) TokenUsage {
+	return TokenUsage{
+		PromptTokens:     u.PromptTokens + other.PromptTokens,
+		CompletionTokens: u.CompletionTokens + other.CompletionTokens,
+		TotalTokens:      u.TotalTokens + other.TotalTokens,
+	}
+}
+
+// FactorInput is one factor of a prediction as the explanation prompt shows it
+type FactorInput struct {
+	// Value is shown to the LLM; RawValue is the stored feature value the templates label
+	Value        string
+	RawValue     float64
+	Shap         float64
+	Contribution float64
+	Impact       float64
+}
+
 type FeatureInfo struct {
 	Name                    string
 	Alias                   string
@@ -80,71 +128,23 @@ type FeatureInfo struct {
 }
 
 func getFeatureDefinitions() map[string]FeatureInfo {
-	return map[string]FeatureInfo{
-		"age": {
-			Name:                    "age",
-			Alias:                   "Usia",
-			Description:             "User's age in years (e.g., 50).",
-			GlobalImportanceInsight: "Most significant feature. Higher age strongly increases diabetes risk (SHAP up to +0.15), while lower age decreases it (SHAP down to -0.15).",
-			ImportanceRank:          1,
-		},
-		"bmi": {
-			Name:                    "bmi",
-			Alias:                   "Indeks Massa Tubuh",
-			Description:             "Body Mass Index (e.g., 20.5), based on Asian population classifications: <18.5=Underweight, 18.5-22.9=Normal, 23.0-24.9=Overweight, 25.0-29.9=Obese I, ≥30.0=Obese II.",
-			GlobalImportanceInsight: "Second most influential feature. High BMI values strongly increase diabetes risk (SHAP up to +0.20), while low BMI values decrease it (SHAP down to -0.10).",
-			ImportanceRank:          2,
-		},
-		"is_hypertension": {
-			Name:                    "is_hypertension",
-			Alias:                   "Hipertensi",
-			Description:             "Indicates if the user has hypertension (high blood pressure): 0=No, 1=Yes.",
-			GlobalImportanceInsight: "A 'Yes' diagnosis increases diabetes risk (SHAP ~+0.05), while 'No' decreases it (SHAP ~-0.05).",
-			ImportanceRank:          3,
-		},
-		"smoking_status": {
-			Name:                    "smoking_status",
-			Alias:                   "Status Merokok",
-			Description:             "User's smoking status: 0=Never smoked, 1=Former smoker, 2=Active smoker.",
-			GlobalImportanceInsight: "Being a current or former smoker increases diabetes risk (SHAP up to +0.05). Never smoking slightly decreases the risk.",
-			ImportanceRank:          4,
-		},
-		"is_macrosomic_baby": {
-			Name:                    "is_macrosomic_baby",
-			Alias:                   "Riwayat Melahirkan Bayi Besar",
-			Description:             "History of giving birth to a baby over 4 kg: 0=No, 1=Yes, 2=Not applicable (never pregnant).",
-			GlobalImportanceInsight: "A 'Yes' history increases diabetes risk (SHAP up to +0.03), while 'No' has a reducing effect (SHAP ~-0.025).",
-			ImportanceRank:          5,
-		},
-		"brinkman_score": {
-			Name:                    "brinkman_score",
-			Alias:                   "Indeks Brinkman",
-			Description:             "Measures lifetime tobacco exposure, represented as a categorized value: 0=Never smoked, 1=Mild smoker, 2=Moderate smoker, 3=Heavy smoker. This is a preprocessed category, not the raw Brinkman Index.",
-			GlobalImportanceInsight: "Higher scores increase diabetes risk (SHAP up to +0.05), while lower scores decrease it (SHAP down to -0.05).",
-			ImportanceRank:          6,
-		},
-		"is_cholesterol": {
-			Name:                    "is_cholesterol",
-			Alias:                   "Kolesterol Tinggi",
-			Description:             "Indicates if the user has been diagnosed with high cholesterol: 0=No, 1=Yes.",
-			GlobalImportanceInsight: "A 'Yes' diagnosis increases diabetes risk (SHAP up to +0.03). Normal cholesterol levels have a slight risk-reducing effect.",
-			ImportanceRank:          7,
-		},
-		"is_bloodline": {
-			Name:                    "is_bloodline",
-			Alias:                   "Riwayat Keluarga dengan Diabetes",
-			Description:             "Indicates if a parent died from diabetes: 0=No, 1=Yes.",
-			GlobalImportanceInsight: "A 'Yes' history increases diabetes risk (SHAP up to +0.05). A 'No' history has a minimal risk-reducing impact.",
-			ImportanceRank:          8,
-		},
-		"physical_activity_frequency": {
-			Name:                    "physical_activity_frequency",
-			Alias:                   "Frekuensi Aktivitas Fisik Sedang",
-			Description:             "Days per week the user performs moderate-intensity physical activity.",
-			GlobalImportanceInsight: "Least impactful predictor. More activity slightly decreases risk (SHAP ~-0.02), while less activity slightly increases it.",
-			ImportanceRank:          9,
-		},
+	definitions := make(map[string]FeatureInfo, len(models.FeatureRegistry))
+	for _, feature := range models.FeatureRegistry {
+		definitions[feature.Name] = FeatureInfo{
+			Name:                    feature.Name,
+			Alias:                   feature.Alias,
+			Description:             feature.Description,
+			GlobalImportanceInsight: feature.GlobalImportanceInsight,
+			ImportanceRank:          feature.ImportanceRank,
+		}
 	}
+	return definitions
+}
+
+// FeatureDefinitions returns the factor vocabulary the explanation prompts use, keyed
+// by factor name
+func FeatureDefinitions() map[string]FeatureInfo {
+	return getFeatureDefinitions()
 }
 
 func getAliasToFeatureMapping() map[string]string {
@@ -178,13 +178,8 @@ func buildGlobalImportanceExplanation(features map[string]FeatureInfo) string {
 	explanation.WriteString("## Global Feature Importance Analysis\n\n")
 	explanation.WriteString("Based on the analysis of the entire dataset, here are the key insights about how each feature typically influences diabetes risk:\n\n")
 
-	importanceOrder := []string{
-		"age", "bmi", "is_hypertension", "smoking_status", "is_macrosomic_baby",
-		"brinkman_score", "is_cholesterol", "is_bloodline", "physical_activity_frequency",
-	}
-
-	for i, featureName := range importanceOrder {
-		if info, exists := features[featureName]; exists {
+	for i, feature := range models.FeaturesByImportance() {
+		if info, exists := features[feature.Name]; exists {
 			explanation.WriteString(fmt.Sprintf("%d. **%s (%s)**: %s\n\n",
 				i+1, info.Alias, info.Name, info.GlobalImportanceInsight))
 		}
@@ -193,27 +188,92 @@ func buildGlobalImportanceExplanation(features map[string]FeatureInfo) string {
 	return explanation.String()
 }
 
+// NewClient creates a client for OpenAI using OPENAI_API_KEY
 func NewClient() (*Client, error) {
 	apiKey := os.Getenv("OPENAI_API_KEY")
 	if apiKey == "" {
 		return nil, fmt.Errorf("OPENAI_API_KEY environment variable is not set")
 	}
+	return NewClientWithConfig(ClientConfig{APIKey: apiKey})
+}
+
+// NewClientWithConfig creates a client for any OpenAI-compatible endpoint
+func NewClientWithConfig(cfg ClientConfig) (*Client, error) {
+	if cfg.BaseURL == "" {
+		cfg.BaseURL = DefaultBaseURL
+	}
+	if cfg.Model == "" {
+		cfg.Model = DefaultModel
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = DefaultMaxAttempts
+	}
+	cfg.BaseURL = strings.TrimSuffix(cfg.BaseURL, "/")
+	if cfg.APIKey == "" && cfg.BaseURL == DefaultBaseURL {
+		return nil, fmt.Errorf("an API key is required for %s (set LLM_API_KEY or OPENAI_API_KEY)", DefaultBaseURL)
+	}
+
+	price := modelPrices[cfg.Model]
+	if cfg.Price != nil {
+		price = *cfg.Price
+	}
 
 	return &Client{
-		apiKey:     apiKey,
-		httpClient: &http.Client{},
+		apiKey:      cfg.APIKey,
+		baseURL:     cfg.BaseURL,
+		model:       cfg.Model,
+		maxAttempts: cfg.MaxAttempts,
+		price:       price,
+		httpClient:  &http.Client{},
 	}, nil
 }
 
-func (c *Client) GeneratePredictionExplanation(ctx context.Context, prediction float64, factors map[string]struct {
-	Value        string
-	Shap         float64
-	Contribution float64
-	Impact       float64
-}) (map[string]FactorExplanation, string, TokenUsage, error) {
+func (c *Client) Name() string {
+	return ProviderOpenAI
+}
+
+// Model returns the model the client asks for
+func (c *Client) Model() string {
+	return c.model
+}
+
+// Explain implements Explainer
+func (c *Client) Explain(ctx context.Context, prediction float64, factors map[string]FactorInput) (*Explanation, error) {
+	start := time.Now()
+	explanation, err := c.generate(ctx, prediction, factors)
+	if err != nil {
+		return nil, err
+	}
+	explanation.Provider = c.Name()
+	explanation.Model = c.model
+	explanation.Latency = time.Since(start)
+	return explanation, nil
+}
+
+// GeneratePredictionExplanation asks the LLM for an explanation and checks the reply with
+// CheckExplanation. A reply that fails the checks is asked again, up to the configured
+// attempts, and the token usage of every attempt is added up.
+func (c *Client) GeneratePredictionExplanation(ctx context.Context, prediction float64, factors map[string]FactorInput) (map[string]FactorExplanation, string, TokenUsage, error) {
+	explanation, err := c.generate(ctx, prediction, factors)
+	if err != nil {
+		var usage TokenUsage
+		for _, call := range CallsOf(err) {
+			usage = usage.add(call.Usage)
+		}
+		return nil, "", usage, err
+	}
+	return explanation.Factors, explanation.Summary, explanation.Usage, nil
+}
+
+// generate fills the factors, summary, usage, rejected replies and calls of the
+// explanation. Its errors carry the calls made so far as a *UsageError.
+func (c *Client) generate(ctx context.Context, prediction float64, factors map[string]FactorInput) (*Explanation, error) {
+	catalog, err := templateCatalogFor(DefaultTemplateLanguage)
+	if err != nil {
+		return nil, err
+	}
 
 	featureDefinitions := getFeatureDefinitions()
-	aliasToFeature := getAliasToFeatureMapping()
 
 	factorKeys := make([]string, 0, len(factors))
 	for factor := range factors {
@@ -283,9 +343,10 @@ The output MUST be a **valid JSON object** with the following structure:
 ## 5. CONTENT REQUIREMENTS
 
 ### 'summary'
-A 2-sentence summary:
+A 3-sentence summary:
 1. State the overall diabetes risk percentage and its category (Low: <35%%, Moderate: 35-55%%, High: 55-70%%, Very High: >70%%).
 2. From only the factors that **increase risk (positive SHAP value)**, identify the top 1-3 with the highest contribution percentages.
+3. The disclaimer from the SAFETY & COMPLETENESS section.
 
 ### 'features'
 An array of explanations for each feature.
@@ -303,7 +364,15 @@ An array of explanations for each feature.
 
 ---
 
-## 6. FEW-SHOT EXAMPLES
+## 6. SAFETY & COMPLETENESS
+- Explain **every** feature of the Feature Analysis table exactly once, and no other feature.
+- Output **only** the JSON object, with no other keys and no text around it.
+- Never diagnose the user (e.g. never say that they have diabetes) and never mention medication, prescriptions or doses.
+- End the summary with this exact sentence: "%s"
+
+---
+
+## 7. FEW-SHOT EXAMPLES
 
 ### Example 1: High BMI Impact
 **Input Data**: BMI = 28.5, SHAP = +0.15, Contribution = 25.0%%
@@ -318,9 +387,9 @@ An array of explanations for each feature.
 ### Example 3: Summary
 **Input Data**: Overall Risk = 65%%, Top factors: BMI (25.0%%), is_bloodline (18.0%%)
 **Expected Output**:
-"summary": "Berdasarkan analisis data, risiko diabetes Anda adalah 65.0%% yang tergolong tinggi. Faktor utama yang mendorong kenaikan risiko ini adalah indeks massa tubuh (25.0%%) dan riwayat keluarga (18.0%%)."
+"summary": "Berdasarkan analisis data, risiko diabetes Anda adalah 65.0%% yang tergolong tinggi. Faktor utama yang mendorong kenaikan risiko ini adalah indeks massa tubuh (25.0%%) dan riwayat keluarga (18.0%%). %s"
 
-`, featureTable, globalImportanceExplanation)
+`, featureTable, globalImportanceExplanation, catalog.Messages[msgDisclaimer], catalog.Messages[msgDisclaimer])
 
 	userPrompt := fmt.Sprintf(`Please analyze the user's diabetes prediction data below and generate the JSON explanation.
 
@@ -351,8 +420,75 @@ Feature Analysis:
 		},
 	}
 
+	var usage TokenUsage
+	var rejected []*CheckError
+	var calls []Call
+	for attempt := 1; ; attempt++ {
+		start := time.Now()
+		content, callUsage, err := c.complete(ctx, messages)
+		calls = append(calls, Call{
+			Provider: c.Name(),
+			Model:    c.model,
+			Usage:    callUsage,
+			Latency:  time.Since(start),
+			Cost:     c.price.Cost(callUsage),
+		})
+		usage = usage.add(callUsage)
+		if err != nil {
+			return nil, &UsageError{Calls: calls, Err: err}
+		}
+
+		explanations, summary, err := parseAndCheck(content, factors)
+		if err == nil {
+			return &Explanation{
+				Factors:  explanations,
+				Summary:  summary,
+				Usage:    usage,
+				Rejected: (&InvalidReplyError{Rejected: rejected}).Checks(),
+				Calls:    calls,
+			}, nil
+		}
+		checkErr, ok := err.(*CheckError)
+		if !ok {
+			return nil, &UsageError{Calls: calls, Err: err}
+		}
+		rejected = append(rejected, checkErr)
+		if attempt >= c.maxAttempts {
+			return nil, &UsageError{Calls: calls, Err: &InvalidReplyError{Rejected: rejected}}
+		}
+
+		// Show the model its reply and why it was rejected, and ask again
+		messages = append(messages,
+			ChatMessage{Role: "assistant", Content: []ContentItem{{Type: "text", Text: content}}},
+			ChatMessage{Role: "user", Content: []ContentItem{{Type: "text", Text: fmt.Sprintf(correctionPrompt, checkErr.Reason)}}},
+		)
+	}
+}
+
+// correctionPrompt asks again after a reply failed the checks
+const correctionPrompt = `Your previous reply was rejected: %s.
+Reply again with only the JSON object described in the instructions. Explain every feature of the table exactly once, do not diagnose the user or mention medication or doses, and end the summary with the required disclaimer.`
+
+// parseAndCheck turns an LLM reply into explanations that pass CheckExplanation
+func parseAndCheck(content string, factors map[string]FactorInput) (map[string]FactorExplanation, string, error) {
+	response, err := parseExplanationResponse(content)
+	if err != nil {
+		return nil, "", err
+	}
+	explanations, err := explanationsFromResponse(response, factors)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := CheckExplanation(&Explanation{Factors: explanations, Summary: response.Summary}, factors); err != nil {
+		return nil, "", err
+	}
+	return explanations, response.Summary, nil
+}
+
+// complete sends one chat completion request and returns the reply
+func (c *Client) complete(ctx context.Context, messages []ChatMessage) (string, TokenUsage, error) {
 	req := ChatCompletionRequest{
-		Model:       "gpt-4o",
+		Model:       c.model,
 		Messages:    messages,
 		Temperature: 0.3,
 		MaxTokens:   1500,
@@ -360,20 +496,22 @@ Feature Analysis:
 
 	jsonData, err := json.Marshal(req)
 	if err != nil {
-		return nil, "", TokenUsage{}, fmt.Errorf("failed to marshal request: %v", err)
+		return "", TokenUsage{}, fmt.Errorf("failed to marshal request: %v", err)
 	}
 
-	request, err := http.NewRequestWithContext(ctx, "POST", "https://api.openai.com/v1/chat/completions", bytes.NewBuffer(jsonData))
+	request, err := http.NewRequestWithContext(ctx, "POST", c.baseURL+"/chat/completions", bytes.NewBuffer(jsonData))
 	if err != nil {
-		return nil, "", TokenUsage{}, fmt.Errorf("failed to create request: %v", err)
+		return "", TokenUsage{}, fmt.Errorf("failed to create request: %v", err)
 	}
 
 	request.Header.Set("Content-Type", "application/json")
-	request.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.apiKey))
+	if c.apiKey != "" {
+		request.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.apiKey))
+	}
 
 	response, err := c.httpClient.Do(request)
 	if err != nil {
-		return nil, "", TokenUsage{}, fmt.Errorf("failed to send request: %v", err)
+		return "", TokenUsage{}, fmt.Errorf("failed to send request: %v", err)
 	}
 	defer response.Body.Close()
 
@@ -384,18 +522,14 @@ Feature Analysis:
 			} `json:"error"`
 		}
 		if err := json.NewDecoder(response.Body).Decode(&errorResponse); err != nil {
-			return nil, "", TokenUsage{}, fmt.Errorf("OpenAI API returned non-200 status code: %d", response.StatusCode)
+			return "", TokenUsage{}, fmt.Errorf("LLM API returned non-200 status code: %d", response.StatusCode)
 		}
-		return nil, "", TokenUsage{}, fmt.Errorf("OpenAI API error: %s", errorResponse.Error.Message)
+		return "", TokenUsage{}, fmt.Errorf("LLM API error: %s", errorResponse.Error.Message)
 	}
 
 	var result ChatCompletionResponse
 	if err := json.NewDecoder(response.Body).Decode(&result); err != nil {
-		return nil, "", TokenUsage{}, fmt.Errorf("failed to decode response: %v", err)
-	}
-
-	if len(result.Choices) == 0 {
-		return nil, "", TokenUsage{}, fmt.Errorf("no completion choices returned")
+		return "", TokenUsage{}, fmt.Errorf("failed to decode response: %v", err)
 	}
 
 	tokenUsage := TokenUsage{
@@ -403,74 +537,8 @@ Feature Analysis:
 		CompletionTokens: result.Usage.CompletionTokens,
 		TotalTokens:      result.Usage.TotalTokens,
 	}
-
-	content := result.Choices[0].Message.Content
-	cleanContent := cleanJSONResponse(content)
-
-	var predictionResponse PredictionExplanationResponse
-	if err := json.Unmarshal([]byte(cleanContent), &predictionResponse); err != nil {
-		return nil, "", tokenUsage, fmt.Errorf("failed to parse JSON response: %v", err)
-	}
-
-	explanations := make(map[string]FactorExplanation)
-	for _, feature := range predictionResponse.Features {
-		var actualFeatureName string
-		var details struct {
-			Value        string
-			Shap         float64
-			Contribution float64
-			Impact       float64
-		}
-		var found bool
-
-		if factorDetails, ok := factors[feature.FeatureName]; ok {
-			actualFeatureName = feature.FeatureName
-			details = factorDetails
-			found = true
-		} else {
-			if mappedFeatureName, aliasExists := aliasToFeature[feature.FeatureName]; aliasExists {
-				if factorDetails, ok := factors[mappedFeatureName]; ok {
-					actualFeatureName = mappedFeatureName
-					details = factorDetails
-					found = true
-				}
-			}
-		}
-
-		if found {
-			explanations[actualFeatureName] = FactorExplanation{
-				Factor:              actualFeatureName,
-				Value:               details.Value,
-				Impact:              fmt.Sprintf("%.6f", details.Impact),
-				Shap:                details.Shap,
-				Contribution:        details.Contribution,
-				ContributionPercent: fmt.Sprintf("%.2f%%", details.Contribution*100),
-				Explanation:         feature.Explanation,
-			}
-		}
-	}
-
-	if len(explanations) == 0 {
-		return nil, "", tokenUsage, fmt.Errorf("failed to parse explanations from the response. Raw content: %s", content)
-	}
-
-	return explanations, predictionResponse.Summary, tokenUsage, nil
-}
-
-func cleanJSONResponse(content string) string {
-	content = strings.TrimSpace(content)
-
-	if strings.HasPrefix(content, "```json") {
-		content = strings.TrimPrefix(content, "```json")
-	}
-	if strings.HasPrefix(content, "```") {
-		content = strings.TrimPrefix(content, "```")
-	}
-	if strings.HasSuffix(content, "```") {
-		content = strings.TrimSuffix(content, "```")
+	if len(result.Choices) == 0 {
+		return "", tokenUsage, fmt.Errorf("no completion choices returned")
 	}
-
-	content = strings.TrimSpace(content)
-
-	return content
+	return result.Choices[0].Message.Content, tokenUsage, nil
 }
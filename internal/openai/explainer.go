@@ -0,0 +1,198 @@
+package openai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Explanation providers accepted in LLM_PROVIDER and LLM_FALLBACK
+const (
+	// ProviderOpenAI is any OpenAI-compatible chat completions endpoint
+	ProviderOpenAI = "openai"
+	// ProviderTemplate writes explanations from fixed templates without a network call
+	ProviderTemplate = "template"
+	// ProviderNone disables the fallback
+	ProviderNone = "none"
+)
+
+// Explanation is the result of explaining one prediction
+type Explanation struct {
+	// Factors is keyed by feature name
+	Factors  map[string]FactorExplanation
+	Summary  string
+	Usage    TokenUsage
+	Provider string
+	Model    string
+	Latency  time.Duration
+	// FallbackReason is why the primary provider was skipped, when the fallback answered
+	FallbackReason string
+	// Rejected lists the failed check of each LLM reply that was not used, see CheckExplanation
+	Rejected []string
+	// Calls are the LLM requests behind the explanation, including rejected replies and
+	// the failed requests of the primary provider when the fallback answered
+	Calls []Call
+}
+
+// Explainer writes the plain-language explanation of a prediction from its risk score
+// and factors, keyed by feature name
+type Explainer interface {
+	// Name identifies the provider, e.g. ProviderOpenAI
+	Name() string
+	Explain(ctx context.Context, prediction float64, factors map[string]FactorInput) (*Explanation, error)
+}
+
+// ExplainerConfig selects the explanation provider and its fallback
+type ExplainerConfig struct {
+	Provider string
+	Fallback string
+	Client   ClientConfig
+	// Language selects the template catalog, see TemplateLanguages
+	Language string
+	// Timeout bounds the primary provider so the fallback still has time to answer
+	Timeout time.Duration
+}
+
+// ExplainerConfigFromEnv reads LLM_PROVIDER (openai or template, default openai),
+// LLM_FALLBACK (template or none, default template), LLM_BASE_URL, LLM_MODEL,
+// LLM_API_KEY (defaults to OPENAI_API_KEY), LLM_TIMEOUT (e.g. "30s"), LLM_MAX_ATTEMPTS
+// (default 2), LLM_PRICE_PROMPT_PER_1M and LLM_PRICE_COMPLETION_PER_1M (US dollars per
+// million tokens, defaulting to the list price of known OpenAI models) and
+// LLM_TEMPLATE_LANGUAGE (id or en, default id)
+func ExplainerConfigFromEnv() ExplainerConfig {
+	cfg := ExplainerConfig{
+		Provider: ProviderOpenAI,
+		Fallback: ProviderTemplate,
+		Client: ClientConfig{
+			BaseURL: os.Getenv("LLM_BASE_URL"),
+			Model:   os.Getenv("LLM_MODEL"),
+			APIKey:  os.Getenv("LLM_API_KEY"),
+		},
+		Language: DefaultTemplateLanguage,
+		Timeout:  30 * time.Second,
+	}
+	if v := os.Getenv("LLM_PROVIDER"); v != "" {
+		cfg.Provider = strings.ToLower(v)
+	}
+	if v := os.Getenv("LLM_FALLBACK"); v != "" {
+		cfg.Fallback = strings.ToLower(v)
+	}
+	if v := os.Getenv("LLM_TEMPLATE_LANGUAGE"); v != "" {
+		cfg.Language = strings.ToLower(v)
+	}
+	if cfg.Client.APIKey == "" {
+		cfg.Client.APIKey = os.Getenv("OPENAI_API_KEY")
+	}
+	if v, err := time.ParseDuration(os.Getenv("LLM_TIMEOUT")); err == nil && v > 0 {
+		cfg.Timeout = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("LLM_MAX_ATTEMPTS")); err == nil && v > 0 {
+		cfg.Client.MaxAttempts = v
+	}
+	promptPrice, promptErr := strconv.ParseFloat(os.Getenv("LLM_PRICE_PROMPT_PER_1M"), 64)
+	completionPrice, completionErr := strconv.ParseFloat(os.Getenv("LLM_PRICE_COMPLETION_PER_1M"), 64)
+	if promptErr == nil || completionErr == nil {
+		// A price set for one side only keeps the list price of the other
+		model := cfg.Client.Model
+		if model == "" {
+			model = DefaultModel
+		}
+		price := modelPrices[model]
+		if promptErr == nil {
+			price.PromptPerMillion = promptPrice
+		}
+		if completionErr == nil {
+			price.CompletionPerMillion = completionPrice
+		}
+		cfg.Client.Price = &price
+	}
+	return cfg
+}
+
+// NewExplainer creates the configured provider, wrapped with the fallback unless it is
+// ProviderNone
+func NewExplainer(cfg ExplainerConfig) (Explainer, error) {
+	if cfg.Language == "" {
+		cfg.Language = DefaultTemplateLanguage
+	}
+
+	var primary Explainer
+	switch cfg.Provider {
+	case ProviderOpenAI:
+		client, err := NewClientWithConfig(cfg.Client)
+		if err != nil {
+			return nil, err
+		}
+		primary = client
+	case ProviderTemplate:
+		return NewTemplateExplainer(cfg.Language)
+	default:
+		return nil, fmt.Errorf("unknown explanation provider %q", cfg.Provider)
+	}
+
+	switch cfg.Fallback {
+	case ProviderNone, "":
+		return primary, nil
+	case ProviderTemplate:
+		fallback, err := NewTemplateExplainer(cfg.Language)
+		if err != nil {
+			return nil, err
+		}
+		return NewFallbackExplainer(primary, fallback, cfg.Timeout), nil
+	default:
+		return nil, fmt.Errorf("unknown explanation fallback %q", cfg.Fallback)
+	}
+}
+
+// FallbackExplainer asks the primary provider first and the fallback when the primary
+// fails or does not answer within the timeout
+type FallbackExplainer struct {
+	primary  Explainer
+	fallback Explainer
+	timeout  time.Duration
+}
+
+// NewFallbackExplainer chains primary and fallback; a zero timeout leaves the primary
+// bounded only by the caller's context
+func NewFallbackExplainer(primary, fallback Explainer, timeout time.Duration) *FallbackExplainer {
+	return &FallbackExplainer{
+		primary:  primary,
+		fallback: fallback,
+		timeout:  timeout,
+	}
+}
+
+func (f *FallbackExplainer) Name() string {
+	return f.primary.Name()
+}
+
+func (f *FallbackExplainer) Explain(ctx context.Context, prediction float64, factors map[string]FactorInput) (*Explanation, error) {
+	primaryCtx := ctx
+	if f.timeout > 0 {
+		var cancel context.CancelFunc
+		primaryCtx, cancel = context.WithTimeout(ctx, f.timeout)
+		defer cancel()
+	}
+
+	explanation, err := f.primary.Explain(primaryCtx, prediction, factors)
+	if err == nil {
+		return explanation, nil
+	}
+
+	fallback, fallbackErr := f.fallback.Explain(ctx, prediction, factors)
+	if fallbackErr != nil {
+		calls := append(CallsOf(err), CallsOf(fallbackErr)...)
+		return nil, &UsageError{Calls: calls, Err: fmt.Errorf("%s failed: %v; %s fallback failed: %w", f.primary.Name(), err, f.fallback.Name(), fallbackErr)}
+	}
+	fallback.FallbackReason = fmt.Sprintf("%s failed: %v", f.primary.Name(), err)
+	fallback.Calls = append(CallsOf(err), fallback.Calls...)
+	var invalid *InvalidReplyError
+	if errors.As(err, &invalid) {
+		fallback.Rejected = invalid.Checks()
+	}
+	return fallback, nil
+}
@@ -0,0 +1,249 @@
+package openai
+
+import (
+	"diabetify/internal/models"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//go:embed catalogs/*.json
+var catalogFiles embed.FS
+
+// DefaultTemplateLanguage is the language of the LLM prompt and of the app
+const DefaultTemplateLanguage = "id"
+
+// Catalog keys of the risk categories and effect sizes
+const (
+	riskLow      = "low"
+	riskModerate = "moderate"
+	riskHigh     = "high"
+	riskVeryHigh = "very_high"
+
+	magnitudeSmall        = "small"
+	magnitudeModerate     = "moderate"
+	magnitudeConsiderable = "considerable"
+	magnitudeLarge        = "large"
+)
+
+// Catalog message keys; messages use {placeholders} that the explainer fills in
+const (
+	msgFactorIncrease    = "factor_increase"
+	msgFactorDecrease    = "factor_decrease"
+	msgFactorNeutral     = "factor_neutral"
+	msgRankFirst         = "rank_first"
+	msgRankLeading       = "rank_leading"
+	msgSummaryRisk       = "summary_risk"
+	msgSummaryDriver     = "summary_driver"
+	msgSummaryDrivers    = "summary_drivers"
+	msgSummaryNoDrivers  = "summary_no_drivers"
+	msgSummaryProtective = "summary_protective"
+	msgDisclaimer        = "disclaimer"
+	msgAnd               = "and"
+)
+
+var requiredMessages = []string{
+	msgFactorIncrease, msgFactorDecrease, msgFactorNeutral, msgRankFirst, msgRankLeading,
+	msgSummaryRisk, msgSummaryDriver, msgSummaryDrivers, msgSummaryNoDrivers, msgSummaryProtective, msgDisclaimer, msgAnd,
+}
+
+// valueBand labels numeric feature values below a threshold; the last band has no
+// threshold and labels everything above the others
+type valueBand struct {
+	Below *float64 `json:"below"`
+	Label string   `json:"label"`
+}
+
+// featureMessages are the words used for one feature
+type featureMessages struct {
+	// Name starts a sentence; InlineName is used inside one
+	Name       string `json:"name"`
+	InlineName string `json:"inline_name"`
+	Unit       string `json:"unit"`
+	// UnitOne is the unit after a value of exactly 1, when it differs from Unit
+	UnitOne string `json:"unit_one"`
+	// Bands label numeric features, Values label categorical and boolean ones
+	Bands           []valueBand       `json:"bands"`
+	Values          map[string]string `json:"values"`
+	InsightIncrease string            `json:"insight_increase"`
+	InsightDecrease string            `json:"insight_decrease"`
+}
+
+// templateCatalog holds every sentence of the template explainer in one language
+type templateCatalog struct {
+	Language       string                     `json:"language"`
+	RiskCategories map[string]string          `json:"risk_categories"`
+	Magnitudes     map[string]string          `json:"magnitudes"`
+	Messages       map[string]string          `json:"messages"`
+	Features       map[string]featureMessages `json:"features"`
+	// BannedPhrases are case-insensitive patterns of diagnosis and medication advice that
+	// no explanation in this language may contain
+	BannedPhrases []string `json:"banned_phrases"`
+
+	banned []*regexp.Regexp
+}
+
+var (
+	catalogsOnce sync.Once
+	catalogs     map[string]*templateCatalog
+	catalogsErr  error
+)
+
+// TemplateLanguages lists the languages the template explainer has catalogs for
+func TemplateLanguages() ([]string, error) {
+	loaded, err := loadTemplateCatalogs()
+	if err != nil {
+		return nil, err
+	}
+	return sortedLanguages(loaded), nil
+}
+
+func sortedLanguages(loaded map[string]*templateCatalog) []string {
+	languages := make([]string, 0, len(loaded))
+	for language := range loaded {
+		languages = append(languages, language)
+	}
+	sort.Strings(languages)
+	return languages
+}
+
+// FormatFeatureValue writes a feature value in a catalog language the way the user entered
+// it: numbers with their unit, categorical and boolean values by their label
+func FormatFeatureValue(language string, feature models.FeatureDefinition, value float64) (string, error) {
+	catalog, err := templateCatalogFor(language)
+	if err != nil {
+		return "", err
+	}
+	return catalog.valueText(feature, value), nil
+}
+
+func templateCatalogFor(language string) (*templateCatalog, error) {
+	loaded, err := loadTemplateCatalogs()
+	if err != nil {
+		return nil, err
+	}
+	catalog, ok := loaded[strings.ToLower(language)]
+	if !ok {
+		return nil, fmt.Errorf("no explanation templates for language %q", language)
+	}
+	return catalog, nil
+}
+
+func loadTemplateCatalogs() (map[string]*templateCatalog, error) {
+	catalogsOnce.Do(func() {
+		entries, err := catalogFiles.ReadDir("catalogs")
+		if err != nil {
+			catalogsErr = err
+			return
+		}
+		loaded := make(map[string]*templateCatalog, len(entries))
+		for _, entry := range entries {
+			data, err := catalogFiles.ReadFile("catalogs/" + entry.Name())
+			if err != nil {
+				catalogsErr = err
+				return
+			}
+			var catalog templateCatalog
+			if err := json.Unmarshal(data, &catalog); err != nil {
+				catalogsErr = fmt.Errorf("invalid explanation catalog %s: %w", entry.Name(), err)
+				return
+			}
+			if err := catalog.validate(); err != nil {
+				catalogsErr = fmt.Errorf("invalid explanation catalog %s: %w", entry.Name(), err)
+				return
+			}
+			loaded[catalog.Language] = &catalog
+		}
+		catalogs = loaded
+	})
+	return catalogs, catalogsErr
+}
+
+// validate checks that the catalog covers every message, level and registry feature
+func (c *templateCatalog) validate() error {
+	if c.Language == "" {
+		return fmt.Errorf("language is missing")
+	}
+	for _, key := range []string{riskLow, riskModerate, riskHigh, riskVeryHigh} {
+		if c.RiskCategories[key] == "" {
+			return fmt.Errorf("risk category %q is missing", key)
+		}
+	}
+	for _, key := range []string{magnitudeSmall, magnitudeModerate, magnitudeConsiderable, magnitudeLarge} {
+		if c.Magnitudes[key] == "" {
+			return fmt.Errorf("magnitude %q is missing", key)
+		}
+	}
+	for _, key := range requiredMessages {
+		if c.Messages[key] == "" {
+			return fmt.Errorf("message %q is missing", key)
+		}
+	}
+	for _, feature := range models.FeatureRegistry {
+		messages, ok := c.Features[feature.Name]
+		if !ok {
+			return fmt.Errorf("feature %q is missing", feature.Name)
+		}
+		if messages.Name == "" || messages.InlineName == "" || messages.InsightIncrease == "" || messages.InsightDecrease == "" {
+			return fmt.Errorf("feature %q is incomplete", feature.Name)
+		}
+		switch feature.Type {
+		case models.FeatureTypeInt, models.FeatureTypeFloat:
+			if len(messages.Bands) == 0 || messages.Bands[len(messages.Bands)-1].Below != nil {
+				return fmt.Errorf("feature %q needs bands ending with one without a threshold", feature.Name)
+			}
+		default:
+			if len(messages.Values) == 0 {
+				return fmt.Errorf("feature %q needs value labels", feature.Name)
+			}
+		}
+	}
+	if len(c.BannedPhrases) == 0 {
+		return fmt.Errorf("banned phrases are missing")
+	}
+	c.banned = make([]*regexp.Regexp, len(c.BannedPhrases))
+	for i, phrase := range c.BannedPhrases {
+		pattern, err := regexp.Compile("(?i)" + phrase)
+		if err != nil {
+			return fmt.Errorf("banned phrase %q: %w", phrase, err)
+		}
+		c.banned[i] = pattern
+	}
+	return nil
+}
+
+// valueText writes a feature value without its band
+func (c *templateCatalog) valueText(feature models.FeatureDefinition, value float64) string {
+	messages := c.Features[feature.Name]
+	switch feature.Type {
+	case models.FeatureTypeInt, models.FeatureTypeFloat:
+		number := strconv.Itoa(int(math.Round(value)))
+		if feature.Type == models.FeatureTypeFloat {
+			number = strconv.FormatFloat(value, 'f', 1, 64)
+		}
+		switch {
+		case value == 1 && messages.UnitOne != "":
+			number += " " + messages.UnitOne
+		case messages.Unit != "":
+			number += " " + messages.Unit
+		}
+		return number
+	default:
+		key := strconv.Itoa(int(math.Round(value)))
+		if label, ok := messages.Values[key]; ok {
+			return label
+		}
+		return key
+	}
+}
+
+// message fills the {placeholders} of a catalog message
+func (c *templateCatalog) message(key string, args ...string) string {
+	return strings.NewReplacer(args...).Replace(c.Messages[key])
+}
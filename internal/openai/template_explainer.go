@@ -0,0 +1,239 @@
+package openai
+
+import (
+	"context"
+	"diabetify/internal/models"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// neutralShap is the SHAP magnitude below which a factor is described as having no effect
+const neutralShap = 0.001
+
+// TemplateExplainer writes explanations from the message catalog of one language. The
+// sentence of each factor is chosen by its value, the sign and size of its SHAP value and
+// its contribution rank. It is deterministic and needs no network, so it stands in when
+// no LLM is configured or the LLM fails.
+type TemplateExplainer struct {
+	catalog *templateCatalog
+}
+
+// NewTemplateExplainer creates a template explainer for a language in TemplateLanguages
+func NewTemplateExplainer(language string) (*TemplateExplainer, error) {
+	catalog, err := templateCatalogFor(language)
+	if err != nil {
+		return nil, err
+	}
+	return &TemplateExplainer{catalog: catalog}, nil
+}
+
+func (t *TemplateExplainer) Name() string {
+	return ProviderTemplate
+}
+
+// Language returns the catalog language
+func (t *TemplateExplainer) Language() string {
+	return t.catalog.Language
+}
+
+func (t *TemplateExplainer) Explain(ctx context.Context, prediction float64, factors map[string]FactorInput) (*Explanation, error) {
+	start := time.Now()
+	ranks := contributionRanks(factors)
+
+	explanations := make(map[string]FactorExplanation, len(factors))
+	for _, feature := range models.FeatureRegistry {
+		details, ok := factors[feature.Name]
+		if !ok {
+			continue
+		}
+		explanations[feature.Name] = FactorExplanation{
+			Factor:              feature.Name,
+			Value:               details.Value,
+			Impact:              fmt.Sprintf("%.6f", details.Impact),
+			Shap:                details.Shap,
+			Contribution:        details.Contribution,
+			ContributionPercent: fmt.Sprintf("%.2f%%", details.Contribution*100),
+			Explanation:         t.factorText(feature, details, ranks[feature.Name]),
+		}
+	}
+	if len(explanations) == 0 {
+		return nil, fmt.Errorf("no known factors to explain")
+	}
+
+	return &Explanation{
+		Factors:  explanations,
+		Summary:  t.summary(prediction, factors),
+		Provider: t.Name(),
+		Latency:  time.Since(start),
+	}, nil
+}
+
+// factorText is the value and effect of the factor, its rank when it is among the three
+// most influential, and how the factor generally affects the risk
+func (t *TemplateExplainer) factorText(feature models.FeatureDefinition, details FactorInput, rank int) string {
+	messages := t.catalog.Features[feature.Name]
+	args := []string{
+		"{feature}", messages.Name,
+		"{value}", t.valuePhrase(feature, details.RawValue),
+		"{contribution}", formatPercent(details.Contribution),
+	}
+
+	magnitude := magnitudeLevel(details.Shap)
+	if magnitude == "" {
+		return t.catalog.message(msgFactorNeutral, args...)
+	}
+	args = append(args, "{magnitude}", t.catalog.Magnitudes[magnitude])
+
+	sentences := make([]string, 0, 3)
+	if details.Shap > 0 {
+		sentences = append(sentences, t.catalog.message(msgFactorIncrease, args...))
+	} else {
+		sentences = append(sentences, t.catalog.message(msgFactorDecrease, args...))
+	}
+	switch {
+	case rank == 1:
+		sentences = append(sentences, t.catalog.message(msgRankFirst))
+	case rank > 1 && rank <= 3:
+		sentences = append(sentences, t.catalog.message(msgRankLeading))
+	}
+	if details.Shap > 0 {
+		sentences = append(sentences, messages.InsightIncrease)
+	} else {
+		sentences = append(sentences, messages.InsightDecrease)
+	}
+	return strings.Join(sentences, " ")
+}
+
+// valuePhrase shows numeric values with their unit and band, and categorical values by
+// their label
+func (t *TemplateExplainer) valuePhrase(feature models.FeatureDefinition, value float64) string {
+	text := t.catalog.valueText(feature, value)
+	if feature.Type != models.FeatureTypeInt && feature.Type != models.FeatureTypeFloat {
+		return text
+	}
+	for _, band := range t.catalog.Features[feature.Name].Bands {
+		if band.Below == nil || value < *band.Below {
+			return text + ", " + band.Label
+		}
+	}
+	return text
+}
+
+// summary states the risk and its category, the top three risk-increasing factors, the
+// factor that lowers the risk the most and the disclaimer
+func (t *TemplateExplainer) summary(prediction float64, factors map[string]FactorInput) string {
+	sentences := []string{t.catalog.message(msgSummaryRisk,
+		"{risk}", formatPercent(prediction),
+		"{category}", t.catalog.RiskCategories[riskLevel(prediction)],
+	)}
+
+	var increasing, decreasing []string
+	for _, name := range namesByContribution(factors) {
+		switch shap := factors[name].Shap; {
+		case shap >= neutralShap:
+			increasing = append(increasing, name)
+		case shap <= -neutralShap:
+			decreasing = append(decreasing, name)
+		}
+	}
+
+	if len(increasing) == 0 {
+		sentences = append(sentences, t.catalog.message(msgSummaryNoDrivers))
+	} else {
+		if len(increasing) > 3 {
+			increasing = increasing[:3]
+		}
+		drivers := make([]string, len(increasing))
+		for i, name := range increasing {
+			drivers[i] = fmt.Sprintf("%s (%s)", t.catalog.Features[name].InlineName, formatPercent(factors[name].Contribution))
+		}
+		key := msgSummaryDrivers
+		if len(drivers) == 1 {
+			key = msgSummaryDriver
+		}
+		sentences = append(sentences, t.catalog.message(key, "{factors}", joinList(drivers, t.catalog.message(msgAnd))))
+	}
+
+	if len(decreasing) > 0 {
+		name := decreasing[0]
+		protective := fmt.Sprintf("%s (%s)", t.catalog.Features[name].InlineName, formatPercent(factors[name].Contribution))
+		sentences = append(sentences, t.catalog.message(msgSummaryProtective, "{factors}", protective))
+	}
+	sentences = append(sentences, t.catalog.message(msgDisclaimer))
+	return strings.Join(sentences, " ")
+}
+
+// namesByContribution orders the known factors by contribution, in registry order on ties
+func namesByContribution(factors map[string]FactorInput) []string {
+	names := make([]string, 0, len(factors))
+	for _, feature := range models.FeatureRegistry {
+		if _, ok := factors[feature.Name]; ok {
+			names = append(names, feature.Name)
+		}
+	}
+	sort.SliceStable(names, func(i, j int) bool {
+		return factors[names[i]].Contribution > factors[names[j]].Contribution
+	})
+	return names
+}
+
+// contributionRanks numbers the factors from 1, the largest contribution
+func contributionRanks(factors map[string]FactorInput) map[string]int {
+	names := namesByContribution(factors)
+	ranks := make(map[string]int, len(names))
+	for i, name := range names {
+		ranks[name] = i + 1
+	}
+	return ranks
+}
+
+// riskLevel uses the bands of the LLM prompt
+func riskLevel(prediction float64) string {
+	switch {
+	case prediction < 0.35:
+		return riskLow
+	case prediction < 0.55:
+		return riskModerate
+	case prediction <= 0.70:
+		return riskHigh
+	default:
+		return riskVeryHigh
+	}
+}
+
+// magnitudeLevel sizes a SHAP value; it is empty for a negligible one
+func magnitudeLevel(shap float64) string {
+	switch abs := math.Abs(shap); {
+	case abs < neutralShap:
+		return ""
+	case abs < 0.02:
+		return magnitudeSmall
+	case abs < 0.05:
+		return magnitudeModerate
+	case abs < 0.10:
+		return magnitudeConsiderable
+	default:
+		return magnitudeLarge
+	}
+}
+
+func formatPercent(fraction float64) string {
+	return fmt.Sprintf("%.1f%%", fraction*100)
+}
+
+// joinList lists items as "a", "a and b" or "a, b, and c"
+func joinList(items []string, and string) string {
+	switch len(items) {
+	case 0:
+		return ""
+	case 1:
+		return items[0]
+	case 2:
+		return items[0] + " " + and + " " + items[1]
+	default:
+		return strings.Join(items[:len(items)-1], ", ") + ", " + and + " " + items[len(items)-1]
+	}
+}
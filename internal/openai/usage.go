@@ -0,0 +1,59 @@
+package openai
+
+import (
+	"errors"
+	"time"
+)
+
+// ModelPrice is what a model charges in US dollars per million tokens
+type ModelPrice struct {
+	PromptPerMillion     float64
+	CompletionPerMillion float64
+}
+
+// modelPrices are list prices of common OpenAI models; other models, such as local ones,
+// are estimated at zero unless ClientConfig.Price is set
+var modelPrices = map[string]ModelPrice{
+	"gpt-4o":       {PromptPerMillion: 2.50, CompletionPerMillion: 10.00},
+	"gpt-4o-mini":  {PromptPerMillion: 0.15, CompletionPerMillion: 0.60},
+	"gpt-4.1":      {PromptPerMillion: 2.00, CompletionPerMillion: 8.00},
+	"gpt-4.1-mini": {PromptPerMillion: 0.40, CompletionPerMillion: 1.60},
+}
+
+// Cost estimates the price of the usage in US dollars
+func (p ModelPrice) Cost(usage TokenUsage) float64 {
+	return (float64(usage.PromptTokens)*p.PromptPerMillion + float64(usage.CompletionTokens)*p.CompletionPerMillion) / 1e6
+}
+
+// Call is one request to an LLM provider
+type Call struct {
+	Provider string
+	Model    string
+	Usage    TokenUsage
+	Latency  time.Duration
+	// Cost is the estimated price in US dollars
+	Cost float64
+}
+
+// UsageError is a failed explanation whose provider calls still used tokens
+type UsageError struct {
+	Calls []Call
+	Err   error
+}
+
+func (e *UsageError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *UsageError) Unwrap() error {
+	return e.Err
+}
+
+// CallsOf returns the provider calls behind a failed explanation
+func CallsOf(err error) []Call {
+	var usageErr *UsageError
+	if errors.As(err, &usageErr) {
+		return usageErr.Calls
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+package openai
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Checks an explanation must pass before it is stored
+const (
+	// CheckSchema fails when an LLM reply is not the JSON object the prompt asks for
+	CheckSchema = "schema"
+	// CheckCompleteness fails when the summary or the explanation of a factor is missing
+	CheckCompleteness = "completeness"
+	// CheckSafety fails when the text diagnoses the user or gives medication advice
+	CheckSafety = "safety"
+	// CheckDisclaimer fails when the summary lacks the disclaimer
+	CheckDisclaimer = "disclaimer"
+)
+
+// CheckError is an explanation that failed one of the checks
+type CheckError struct {
+	Check  string
+	Reason string
+}
+
+func (e *CheckError) Error() string {
+	return fmt.Sprintf("%s check failed: %s", e.Check, e.Reason)
+}
+
+// InvalidReplyError is returned when every LLM reply failed the checks
+type InvalidReplyError struct {
+	// Rejected holds the failed check of each reply, in order
+	Rejected []*CheckError
+}
+
+func (e *InvalidReplyError) Error() string {
+	return fmt.Sprintf("no valid explanation after %d attempts: %v", len(e.Rejected), e.last())
+}
+
+func (e *InvalidReplyError) Unwrap() error {
+	return e.last()
+}
+
+// Checks lists the failed check of each reply
+func (e *InvalidReplyError) Checks() []string {
+	checks := make([]string, len(e.Rejected))
+	for i, rejected := range e.Rejected {
+		checks[i] = rejected.Check
+	}
+	return checks
+}
+
+func (e *InvalidReplyError) last() *CheckError {
+	if len(e.Rejected) == 0 {
+		return nil
+	}
+	return e.Rejected[len(e.Rejected)-1]
+}
+
+// parseExplanationResponse decodes an LLM reply strictly: a single JSON object, optionally
+// in one code fence, holding only the fields of PredictionExplanationResponse
+func parseExplanationResponse(content string) (*PredictionExplanationResponse, error) {
+	body := strings.TrimSpace(content)
+	if strings.HasPrefix(body, "```") {
+		body = strings.TrimPrefix(body, "```json")
+		body = strings.TrimPrefix(body, "```")
+		if !strings.HasSuffix(body, "```") {
+			return nil, &CheckError{Check: CheckSchema, Reason: "code fence is not closed"}
+		}
+		body = strings.TrimSpace(strings.TrimSuffix(body, "```"))
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader([]byte(body)))
+	decoder.DisallowUnknownFields()
+
+	var response PredictionExplanationResponse
+	if err := decoder.Decode(&response); err != nil {
+		return nil, &CheckError{Check: CheckSchema, Reason: fmt.Sprintf("invalid JSON object: %v", err)}
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, &CheckError{Check: CheckSchema, Reason: "unexpected content after the JSON object"}
+	}
+	return &response, nil
+}
+
+// explanationsFromResponse maps the features of a reply to the requested factors, by
+// factor name or alias
+func explanationsFromResponse(response *PredictionExplanationResponse, factors map[string]FactorInput) (map[string]FactorExplanation, error) {
+	aliasToFeature := getAliasToFeatureMapping()
+
+	explanations := make(map[string]FactorExplanation, len(response.Features))
+	for _, feature := range response.Features {
+		name := feature.FeatureName
+		if _, ok := factors[name]; !ok {
+			name = aliasToFeature[feature.FeatureName]
+		}
+		details, ok := factors[name]
+		if !ok {
+			return nil, &CheckError{Check: CheckSchema, Reason: fmt.Sprintf("unknown feature %q", feature.FeatureName)}
+		}
+		if _, duplicate := explanations[name]; duplicate {
+			return nil, &CheckError{Check: CheckSchema, Reason: fmt.Sprintf("feature %q is explained twice", name)}
+		}
+		explanations[name] = FactorExplanation{
+			Factor:              name,
+			Value:               details.Value,
+			Impact:              fmt.Sprintf("%.6f", details.Impact),
+			Shap:                details.Shap,
+			Contribution:        details.Contribution,
+			ContributionPercent: fmt.Sprintf("%.2f%%", details.Contribution*100),
+			Explanation:         feature.Explanation,
+		}
+	}
+	return explanations, nil
+}
+
+// CheckExplanation checks that the explanation has a summary and explains every factor,
+// that no text diagnoses the user or gives medication advice in any catalog language,
+// and that the summary carries the disclaimer of a catalog language. It returns a
+// *CheckError for the first check that fails.
+func CheckExplanation(explanation *Explanation, factors map[string]FactorInput) error {
+	loaded, err := loadTemplateCatalogs()
+	if err != nil {
+		return err
+	}
+
+	if strings.TrimSpace(explanation.Summary) == "" {
+		return &CheckError{Check: CheckCompleteness, Reason: "summary is empty"}
+	}
+	var missing []string
+	for name := range factors {
+		if strings.TrimSpace(explanation.Factors[name].Explanation) == "" {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return &CheckError{Check: CheckCompleteness, Reason: "no explanation for " + strings.Join(missing, ", ")}
+	}
+	for name := range explanation.Factors {
+		if _, ok := factors[name]; !ok {
+			return &CheckError{Check: CheckCompleteness, Reason: fmt.Sprintf("unexpected factor %q", name)}
+		}
+	}
+
+	texts := map[string]string{"summary": explanation.Summary}
+	for name, factor := range explanation.Factors {
+		texts[name] = factor.Explanation
+	}
+	for _, language := range sortedLanguages(loaded) {
+		for _, pattern := range loaded[language].banned {
+			for _, field := range sortedKeys(texts) {
+				if match := pattern.FindString(texts[field]); match != "" {
+					return &CheckError{Check: CheckSafety, Reason: fmt.Sprintf("%s contains %q", field, match)}
+				}
+			}
+		}
+	}
+
+	summary := normalizeText(explanation.Summary)
+	for _, catalog := range loaded {
+		if strings.Contains(summary, normalizeText(catalog.Messages[msgDisclaimer])) {
+			return nil
+		}
+	}
+	return &CheckError{Check: CheckDisclaimer, Reason: "summary does not include the disclaimer"}
+}
+
+// normalizeText lowercases text and collapses its whitespace
+func normalizeText(text string) string {
+	return strings.ToLower(strings.Join(strings.Fields(text), " "))
+}
+
+func sortedKeys(texts map[string]string) []string {
+	keys := make([]string, 0, len(texts))
+	for key := range texts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
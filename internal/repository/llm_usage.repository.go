@@ -0,0 +1,90 @@
+package repository
+
+import (
+	"diabetify/internal/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// LLMUsageRepository stores LLM calls in the default database so budgets and reports
+// cover every user
+type LLMUsageRepository interface {
+	SaveUsage(usage *models.LLMUsage) error
+	// TotalTokensSince sums the tokens used at or after since, by one user or by everyone
+	// when userID is 0
+	TotalTokensSince(userID uint, since time.Time) (int, error)
+	// GetDailyTotals sums the calls created in [since, until) per day, provider and model
+	GetDailyTotals(since, until time.Time) ([]LLMUsageTotal, error)
+	// GetTopUsers returns the users that used the most tokens in [since, until)
+	GetTopUsers(since, until time.Time, limit int) ([]LLMUserUsage, error)
+}
+
+// LLMUsageTotal sums the LLM calls of one day, provider and model
+type LLMUsageTotal struct {
+	Day              string  `json:"day"`
+	Provider         string  `json:"provider"`
+	Model            string  `json:"model"`
+	Calls            int64   `json:"calls"`
+	PromptTokens     int64   `json:"prompt_tokens"`
+	CompletionTokens int64   `json:"completion_tokens"`
+	TotalTokens      int64   `json:"total_tokens"`
+	CostEstimate     float64 `json:"cost_estimate_usd"`
+	AvgLatencyMs     float64 `json:"avg_latency_ms"`
+}
+
+// LLMUserUsage sums the LLM calls made for one user
+type LLMUserUsage struct {
+	UserID       uint    `json:"user_id"`
+	Calls        int64   `json:"calls"`
+	TotalTokens  int64   `json:"total_tokens"`
+	CostEstimate float64 `json:"cost_estimate_usd"`
+}
+
+type llmUsageRepository struct {
+	db *gorm.DB
+}
+
+func NewLLMUsageRepository(db *gorm.DB) LLMUsageRepository {
+	return &llmUsageRepository{db: db}
+}
+
+func (r *llmUsageRepository) SaveUsage(usage *models.LLMUsage) error {
+	return r.db.Create(usage).Error
+}
+
+func (r *llmUsageRepository) TotalTokensSince(userID uint, since time.Time) (int, error) {
+	query := r.db.Model(&models.LLMUsage{}).Where("created_at >= ?", since)
+	if userID != 0 {
+		query = query.Where("user_id = ?", userID)
+	}
+	var total int
+	err := query.Select("COALESCE(SUM(total_tokens), 0)").Scan(&total).Error
+	return total, err
+}
+
+func (r *llmUsageRepository) GetDailyTotals(since, until time.Time) ([]LLMUsageTotal, error) {
+	var totals []LLMUsageTotal
+	err := r.db.Model(&models.LLMUsage{}).
+		Select(`TO_CHAR(created_at AT TIME ZONE 'UTC', 'YYYY-MM-DD') AS day, provider, model, COUNT(*) AS calls,
+			SUM(prompt_tokens) AS prompt_tokens, SUM(completion_tokens) AS completion_tokens,
+			SUM(total_tokens) AS total_tokens, SUM(cost_estimate) AS cost_estimate,
+			AVG(latency_ms) AS avg_latency_ms`).
+		Where("created_at >= ? AND created_at < ?", since, until).
+		Group("day, provider, model").
+		Order("day ASC, provider ASC, model ASC").
+		Scan(&totals).Error
+	return totals, err
+}
+
+func (r *llmUsageRepository) GetTopUsers(since, until time.Time, limit int) ([]LLMUserUsage, error) {
+	var users []LLMUserUsage
+	err := r.db.Model(&models.LLMUsage{}).
+		Select("user_id, COUNT(*) AS calls, SUM(total_tokens) AS total_tokens, SUM(cost_estimate) AS cost_estimate").
+		Where("created_at >= ? AND created_at < ?", since, until).
+		Group("user_id").
+		Order("total_tokens DESC").
+		Limit(limit).
+		Scan(&users).Error
+	return users, err
+}
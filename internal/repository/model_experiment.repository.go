@@ -0,0 +1,36 @@
+package repository
+
+import (
+	"diabetify/internal/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ModelExperimentRepository stores experiment scores in the default database so a
+// report can compare variants without visiting every shard
+type ModelExperimentRepository interface {
+	SaveResult(result *models.ModelExperimentResult) error
+	// GetResults returns the experiment's scores created at or after since, oldest first
+	GetResults(experiment string, since time.Time) ([]models.ModelExperimentResult, error)
+}
+
+type modelExperimentRepository struct {
+	db *gorm.DB
+}
+
+func NewModelExperimentRepository(db *gorm.DB) ModelExperimentRepository {
+	return &modelExperimentRepository{db: db}
+}
+
+func (r *modelExperimentRepository) SaveResult(result *models.ModelExperimentResult) error {
+	return r.db.Create(result).Error
+}
+
+func (r *modelExperimentRepository) GetResults(experiment string, since time.Time) ([]models.ModelExperimentResult, error) {
+	var results []models.ModelExperimentResult
+	err := r.db.Where("experiment = ? AND created_at >= ?", experiment, since).
+		Order("created_at ASC").
+		Find(&results).Error
+	return results, err
+}
@@ -0,0 +1,47 @@
+package repository
+
+import (
+	"diabetify/internal/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ModelMonitoringRepository stores daily monitoring reports in the default database
+type ModelMonitoringRepository interface {
+	// SaveReport replaces any existing report for the same date
+	SaveReport(report *models.ModelMonitoringReport) error
+	GetReportByDate(date time.Time) (*models.ModelMonitoringReport, error)
+	GetRecentReports(limit int) ([]models.ModelMonitoringReport, error)
+}
+
+type modelMonitoringRepository struct {
+	db *gorm.DB
+}
+
+func NewModelMonitoringRepository(db *gorm.DB) ModelMonitoringRepository {
+	return &modelMonitoringRepository{db: db}
+}
+
+func (r *modelMonitoringRepository) SaveReport(report *models.ModelMonitoringReport) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("date = ?", report.Date).Delete(&models.ModelMonitoringReport{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(report).Error
+	})
+}
+
+func (r *modelMonitoringRepository) GetReportByDate(date time.Time) (*models.ModelMonitoringReport, error) {
+	var report models.ModelMonitoringReport
+	if err := r.db.Where("date = ?", date).First(&report).Error; err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+func (r *modelMonitoringRepository) GetRecentReports(limit int) ([]models.ModelMonitoringReport, error) {
+	var reports []models.ModelMonitoringReport
+	err := r.db.Order("date DESC").Limit(limit).Find(&reports).Error
+	return reports, err
+}
@@ -0,0 +1,46 @@
+package repository
+
+import (
+	"diabetify/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// ModelUpdateRepository stores model update runs. They are platform-wide rather than
+// per user, so they live in the default database instead of a user shard.
+type ModelUpdateRepository interface {
+	SaveModelUpdate(update *models.ModelUpdate) error
+	UpdateModelUpdate(update *models.ModelUpdate) error
+	GetModelUpdateByID(id uint) (*models.ModelUpdate, error)
+	GetRecentModelUpdates(limit int) ([]models.ModelUpdate, error)
+}
+
+type modelUpdateRepository struct {
+	db *gorm.DB
+}
+
+func NewModelUpdateRepository(db *gorm.DB) ModelUpdateRepository {
+	return &modelUpdateRepository{db: db}
+}
+
+func (r *modelUpdateRepository) SaveModelUpdate(update *models.ModelUpdate) error {
+	return r.db.Create(update).Error
+}
+
+func (r *modelUpdateRepository) UpdateModelUpdate(update *models.ModelUpdate) error {
+	return r.db.Save(update).Error
+}
+
+func (r *modelUpdateRepository) GetModelUpdateByID(id uint) (*models.ModelUpdate, error) {
+	var update models.ModelUpdate
+	if err := r.db.First(&update, id).Error; err != nil {
+		return nil, err
+	}
+	return &update, nil
+}
+
+func (r *modelUpdateRepository) GetRecentModelUpdates(limit int) ([]models.ModelUpdate, error) {
+	var updates []models.ModelUpdate
+	err := r.db.Order("created_at DESC").Limit(limit).Find(&updates).Error
+	return updates, err
+}
@@ -0,0 +1,54 @@
+package repository
+
+import (
+	"diabetify/internal/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// NotificationRepository stores admin notifications in the default database
+type NotificationRepository interface {
+	SaveNotification(notification *models.Notification) error
+	GetNotifications(limit int, unreadOnly bool) ([]models.Notification, error)
+	MarkNotificationRead(id uint) error
+}
+
+type notificationRepository struct {
+	db *gorm.DB
+}
+
+func NewNotificationRepository(db *gorm.DB) NotificationRepository {
+	return &notificationRepository{db: db}
+}
+
+func (r *notificationRepository) SaveNotification(notification *models.Notification) error {
+	return r.db.Create(notification).Error
+}
+
+func (r *notificationRepository) GetNotifications(limit int, unreadOnly bool) ([]models.Notification, error) {
+	var notifications []models.Notification
+	query := r.db.Order("created_at DESC").Limit(limit)
+	if unreadOnly {
+		query = query.Where("read_at IS NULL")
+	}
+	err := query.Find(&notifications).Error
+	return notifications, err
+}
+
+func (r *notificationRepository) MarkNotificationRead(id uint) error {
+	result := r.db.Model(&models.Notification{}).Where("id = ? AND read_at IS NULL", id).Update("read_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		var count int64
+		if err := r.db.Model(&models.Notification{}).Where("id = ?", id).Count(&count).Error; err != nil {
+			return err
+		}
+		if count == 0 {
+			return gorm.ErrRecordNotFound
+		}
+	}
+	return nil
+}
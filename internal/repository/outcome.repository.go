@@ -0,0 +1,122 @@
+package repository
+
+import (
+	"diabetify/database"
+	"diabetify/internal/models"
+
+	"gorm.io/gorm"
+)
+
+type OutcomeRepository interface {
+	// SaveOutcome stores the outcome and links the user's earlier, still unlabeled
+	// predictions to it. It returns how many predictions were linked.
+	SaveOutcome(outcome *models.Outcome) (int64, error)
+	GetOutcomesByUserID(userID uint) ([]models.Outcome, error)
+
+	// GetConsentedLabeledSamples returns the feature vectors of predictions linked to a
+	// trainable outcome, from users who gave research consent, newest first
+	GetConsentedLabeledSamples(limit int) ([]models.LabeledSample, error)
+}
+
+type outcomeRepository struct {
+	db        *gorm.DB
+	useShards bool
+}
+
+func NewOutcomeRepository(db *gorm.DB) OutcomeRepository {
+	return &outcomeRepository{
+		db:        db,
+		useShards: db == nil,
+	}
+}
+
+// NewShardedOutcomeRepository creates an outcome repository that uses sharding
+func NewShardedOutcomeRepository() OutcomeRepository {
+	return &outcomeRepository{
+		db:        nil,
+		useShards: true,
+	}
+}
+
+func (r *outcomeRepository) SaveOutcome(outcome *models.Outcome) (int64, error) {
+	var linked int64
+	save := func(db *gorm.DB) error {
+		return db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Create(outcome).Error; err != nil {
+				return err
+			}
+			result := tx.Model(&models.Prediction{}).
+				Where("user_id = ? AND outcome_id IS NULL AND created_at < ?", outcome.UserID, outcome.DiagnosisDate).
+				Update("outcome_id", outcome.ID)
+			linked = result.RowsAffected
+			return result.Error
+		})
+	}
+
+	if r.useShards {
+		err := database.Manager.ExecuteOnUserShard(int(outcome.UserID), save)
+		return linked, err
+	}
+	err := save(r.db)
+	return linked, err
+}
+
+func (r *outcomeRepository) GetOutcomesByUserID(userID uint) ([]models.Outcome, error) {
+	var outcomes []models.Outcome
+	query := func(db *gorm.DB) error {
+		return db.Where("user_id = ?", userID).Order("diagnosis_date DESC").Find(&outcomes).Error
+	}
+
+	if r.useShards {
+		err := database.Manager.ExecuteOnUserShard(int(userID), query)
+		return outcomes, err
+	}
+	err := query(r.db)
+	return outcomes, err
+}
+
+func (r *outcomeRepository) GetConsentedLabeledSamples(limit int) ([]models.LabeledSample, error) {
+	var samples []models.LabeledSample
+	query := func(db *gorm.DB) error {
+		var predictions []models.Prediction
+		err := db.Joins("JOIN users ON users.id = predictions.user_id AND users.deleted_at IS NULL").
+			Joins("JOIN outcomes ON outcomes.id = predictions.outcome_id AND outcomes.deleted_at IS NULL").
+			Where("users.research_consent = ?", true).
+			Where("outcomes.diagnosis_type IN ?", []string{models.DiagnosisType2Diabetes, models.DiagnosisNoDiabetes}).
+			Preload("Outcome").
+			Order("predictions.created_at DESC").
+			Limit(limit).
+			Find(&predictions).Error
+		if err != nil {
+			return err
+		}
+
+		for i := range predictions {
+			if predictions[i].Outcome == nil {
+				continue
+			}
+			label, ok := predictions[i].Outcome.Label()
+			if !ok {
+				continue
+			}
+			samples = append(samples, models.LabeledSample{
+				UserID:   predictions[i].UserID,
+				Features: predictions[i].FeatureVector(),
+				Label:    label,
+			})
+		}
+		return nil
+	}
+
+	if r.useShards {
+		if err := database.Manager.ExecuteOnAllShards(query); err != nil {
+			return nil, err
+		}
+		if len(samples) > limit {
+			samples = samples[:limit]
+		}
+		return samples, nil
+	}
+	err := query(r.db)
+	return samples, err
+}
@@ -5,6 +5,7 @@ import (
 	"diabetify/internal/models"
 	"fmt"
 	"log"
+	"sort"
 	"time"
 
 	"gorm.io/gorm"
@@ -13,6 +14,8 @@ import (
 type PredictionJobRepository interface {
 	// Basic CRUD operations
 	SaveJob(job *models.PredictionJob) error
+	// SaveJobs creates jobs in bulk, such as the items of a batch
+	SaveJobs(jobs []*models.PredictionJob) error
 	GetJobByID(id string) (*models.PredictionJob, error)
 	UpdateJob(job *models.PredictionJob) error
 	DeleteJob(jobID string) error
@@ -20,17 +23,39 @@ type PredictionJobRepository interface {
 	// Status management
 	UpdateJobStatus(jobID, status string, errorMessage *string) error
 	UpdateJobStatusWithResult(jobID, status string, predictionID uint) error
+	SetJobInput(jobID, inputHash string, snapshot *models.FeatureSnapshot) error
+	UpdateBatchProgress(jobID string, completedItems, failedItems int) error
+	// ClaimJob moves a pending job, or a processing one not updated since staleBefore, to
+	// processing. It returns false when another process holds the job.
+	ClaimJob(userID uint, jobID string, staleBefore time.Time) (bool, error)
+	// FinishBatchItem stores the result of a batch item and marks it completed, or failed
+	// when errorMessage is set
+	FinishBatchItem(userID uint, jobID string, result *models.BatchItemResult, errorMessage *string) error
 
 	// Query operations
 	GetJobsByUserID(userID uint, limit int) ([]*models.PredictionJob, error)
 	GetJobsByUserIDAndStatus(userID uint, status string, limit int) ([]*models.PredictionJob, error)
 	GetJobsByStatus(status string, limit int) ([]*models.PredictionJob, error)
 	GetPendingJobs(limit int) ([]*models.PredictionJob, error)
+	// GetJobsByTypeAndStatus returns jobs of one type across all users, oldest first
+	GetJobsByTypeAndStatus(jobType, status string, limit int) ([]*models.PredictionJob, error)
+	// GetChildJobs returns the items of a batch or sweep job, optionally only those in
+	// one of the given statuses
+	GetChildJobs(userID uint, parentID string, statuses ...string) ([]*models.PredictionJob, error)
+	// CountChildJobsByStatus counts the items of a batch or sweep job per status
+	CountChildJobsByStatus(userID uint, parentID string) (map[string]int64, error)
 	GetJobsByDateRange(userID uint, startDate, endDate time.Time) ([]*models.PredictionJob, error)
+	GetJobByIdempotencyKey(userID uint, key string) (*models.PredictionJob, error)
+	// FindRecentJobByInputHash returns the newest job of the user with the same input that
+	// is still running, or completed at or after since
+	FindRecentJobByInputHash(userID uint, jobType, inputHash string, since time.Time) (*models.PredictionJob, error)
+	// FindLatestJobByPredictionID returns the newest job of the given type for a prediction
+	FindLatestJobByPredictionID(userID uint, jobType string, predictionID uint) (*models.PredictionJob, error)
 
 	// Utility operations
 	CancelJob(jobID string) error
 	GetActiveJobsCount(userID uint) (int64, error)
+	CountQuotaUnitsSince(userID uint, jobTypes []string, since time.Time) (int64, error)
 	CleanupOldJobs(olderThan time.Time) error
 
 	// Additional helper methods
@@ -75,6 +100,36 @@ func (r *predictionJobRepository) SaveJob(job *models.PredictionJob) error {
 	return r.db.Create(job).Error
 }
 
+func (r *predictionJobRepository) SaveJobs(jobs []*models.PredictionJob) error {
+	now := time.Now()
+	byUser := make(map[uint][]*models.PredictionJob)
+	for _, job := range jobs {
+		if job.CreatedAt.IsZero() {
+			job.CreatedAt = now
+		}
+		job.UpdatedAt = now
+		byUser[job.UserID] = append(byUser[job.UserID], job)
+	}
+
+	for userID, userJobs := range byUser {
+		userJobs := userJobs
+		create := func(db *gorm.DB) error {
+			return db.CreateInBatches(userJobs, 500).Error
+		}
+
+		var err error
+		if r.useShards {
+			err = database.Manager.ExecuteOnUserShard(int(userID), create)
+		} else {
+			err = create(r.db)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (r *predictionJobRepository) GetJobByID(id string) (*models.PredictionJob, error) {
 	if r.useShards {
 		// Since we don't know the user_id from just the job ID, we need to search all shards
@@ -137,6 +192,96 @@ func (r *predictionJobRepository) DeleteJob(jobID string) error {
 
 // ========== STATUS MANAGEMENT ==========
 
+// SetJobInput records the hash and snapshot of the feature vector sent to the ML service
+func (r *predictionJobRepository) SetJobInput(jobID, inputHash string, snapshot *models.FeatureSnapshot) error {
+	update := func(db *gorm.DB) error {
+		return db.Model(&models.PredictionJob{}).Where("id = ?", jobID).
+			Updates(&models.PredictionJob{InputHash: inputHash, FeatureSnapshot: snapshot}).Error
+	}
+
+	if r.useShards {
+		job, err := r.GetJobByID(jobID)
+		if err != nil {
+			return err
+		}
+
+		return database.Manager.ExecuteOnUserShard(int(job.UserID), update)
+	}
+
+	return update(r.db)
+}
+
+// UpdateBatchProgress records how many items of a batch job have finished
+func (r *predictionJobRepository) UpdateBatchProgress(jobID string, completedItems, failedItems int) error {
+	updates := map[string]interface{}{
+		"completed_items": completedItems,
+		"failed_items":    failedItems,
+		"updated_at":      time.Now(),
+	}
+
+	if r.useShards {
+		job, err := r.GetJobByID(jobID)
+		if err != nil {
+			return err
+		}
+
+		return database.Manager.ExecuteOnUserShard(int(job.UserID), func(db *gorm.DB) error {
+			return db.Model(&models.PredictionJob{}).Where("id = ?", jobID).Updates(updates).Error
+		})
+	}
+
+	return r.db.Model(&models.PredictionJob{}).Where("id = ?", jobID).Updates(updates).Error
+}
+
+// ClaimJob lets one worker, on any replica, take a job. Processing jobs are only taken
+// over once stale, which is how items of a replica that stopped are picked up again.
+func (r *predictionJobRepository) ClaimJob(userID uint, jobID string, staleBefore time.Time) (bool, error) {
+	var claimed bool
+	query := func(db *gorm.DB) error {
+		result := db.Model(&models.PredictionJob{}).
+			Where("id = ?", jobID).
+			Where("(status = ? OR (status = ? AND updated_at < ?))",
+				models.JobStatusPending, models.JobStatusProcessing, staleBefore).
+			Updates(map[string]interface{}{
+				"status":     models.JobStatusProcessing,
+				"updated_at": time.Now(),
+			})
+		claimed = result.RowsAffected == 1
+		return result.Error
+	}
+
+	var err error
+	if r.useShards {
+		err = database.Manager.ExecuteOnUserShard(int(userID), query)
+	} else {
+		err = query(r.db)
+	}
+	return claimed, err
+}
+
+func (r *predictionJobRepository) FinishBatchItem(userID uint, jobID string, result *models.BatchItemResult, errorMessage *string) error {
+	now := time.Now()
+	job := models.PredictionJob{
+		Status:      models.JobStatusCompleted,
+		BatchItem:   result,
+		UpdatedAt:   now,
+		CompletedAt: &now,
+	}
+	if errorMessage != nil {
+		job.Status = models.JobStatusFailed
+		job.ErrorMessage = errorMessage
+	}
+
+	query := func(db *gorm.DB) error {
+		return db.Model(&models.PredictionJob{}).Where("id = ?", jobID).Updates(&job).Error
+	}
+
+	if r.useShards {
+		return database.Manager.ExecuteOnUserShard(int(userID), query)
+	}
+	return query(r.db)
+}
+
 func (r *predictionJobRepository) UpdateJobStatus(jobID, status string, errorMessage *string) error {
 	if r.useShards {
 		// Since we don't know the user_id, we need to find it first
@@ -259,11 +404,14 @@ func (r *predictionJobRepository) UpdateJobStatusWithResult(jobID, status string
 
 // ========== QUERY OPERATIONS ==========
 
+// Items of batch and sweep jobs are reported through their parent and explanation jobs
+// through their prediction, so user job lists leave them out
+
 func (r *predictionJobRepository) GetJobsByUserID(userID uint, limit int) ([]*models.PredictionJob, error) {
 	if r.useShards {
 		var jobs []*models.PredictionJob
 		err := database.Manager.ExecuteOnUserShard(int(userID), func(db *gorm.DB) error {
-			query := db.Where("user_id = ?", userID).
+			query := db.Where("user_id = ? AND parent_job_id IS NULL AND job_type <> ?", userID, models.JobTypeExplanation).
 				Order("created_at DESC")
 
 			if limit > 0 {
@@ -281,7 +429,7 @@ func (r *predictionJobRepository) GetJobsByUserID(userID uint, limit int) ([]*mo
 	}
 
 	var jobs []*models.PredictionJob
-	query := r.db.Where("user_id = ?", userID).
+	query := r.db.Where("user_id = ? AND parent_job_id IS NULL AND job_type <> ?", userID, models.JobTypeExplanation).
 		Order("created_at DESC")
 
 	if limit > 0 {
@@ -300,7 +448,7 @@ func (r *predictionJobRepository) GetJobsByUserIDAndStatus(userID uint, status s
 	if r.useShards {
 		var jobs []*models.PredictionJob
 		err := database.Manager.ExecuteOnUserShard(int(userID), func(db *gorm.DB) error {
-			query := db.Where("user_id = ? AND status = ?", userID, status).
+			query := db.Where("user_id = ? AND status = ? AND parent_job_id IS NULL AND job_type <> ?", userID, status, models.JobTypeExplanation).
 				Order("created_at DESC")
 
 			if limit > 0 {
@@ -318,7 +466,7 @@ func (r *predictionJobRepository) GetJobsByUserIDAndStatus(userID uint, status s
 	}
 
 	var jobs []*models.PredictionJob
-	query := r.db.Where("user_id = ? AND status = ?", userID, status).
+	query := r.db.Where("user_id = ? AND status = ? AND parent_job_id IS NULL AND job_type <> ?", userID, status, models.JobTypeExplanation).
 		Order("created_at DESC")
 
 	if limit > 0 {
@@ -391,11 +539,91 @@ func (r *predictionJobRepository) GetPendingJobs(limit int) ([]*models.Predictio
 	return r.GetJobsByStatus(models.JobStatusPending, limit)
 }
 
+func (r *predictionJobRepository) GetJobsByTypeAndStatus(jobType, status string, limit int) ([]*models.PredictionJob, error) {
+	query := func(db *gorm.DB) *gorm.DB {
+		query := db.Where("job_type = ? AND status = ?", jobType, status).Order("created_at ASC")
+		if limit > 0 {
+			query = query.Limit(limit)
+		}
+		return query
+	}
+
+	if r.useShards {
+		var allJobs []*models.PredictionJob
+		for shardName, db := range database.Manager.GetAllShards() {
+			var jobs []*models.PredictionJob
+			if err := query(db).Find(&jobs).Error; err != nil {
+				return nil, fmt.Errorf("error searching shard %s: %v", shardName, err)
+			}
+			allJobs = append(allJobs, jobs...)
+		}
+
+		sort.Slice(allJobs, func(i, j int) bool { return allJobs[i].CreatedAt.Before(allJobs[j].CreatedAt) })
+		if limit > 0 && len(allJobs) > limit {
+			allJobs = allJobs[:limit]
+		}
+		return allJobs, nil
+	}
+
+	var jobs []*models.PredictionJob
+	err := query(r.db).Find(&jobs).Error
+	return jobs, err
+}
+
+func (r *predictionJobRepository) GetChildJobs(userID uint, parentID string, statuses ...string) ([]*models.PredictionJob, error) {
+	var jobs []*models.PredictionJob
+	query := func(db *gorm.DB) error {
+		query := db.Where("user_id = ? AND parent_job_id = ?", userID, parentID)
+		if len(statuses) > 0 {
+			query = query.Where("status IN ?", statuses)
+		}
+		return query.Order("created_at ASC").Find(&jobs).Error
+	}
+
+	var err error
+	if r.useShards {
+		err = database.Manager.ExecuteOnUserShard(int(userID), query)
+	} else {
+		err = query(r.db)
+	}
+	return jobs, err
+}
+
+func (r *predictionJobRepository) CountChildJobsByStatus(userID uint, parentID string) (map[string]int64, error) {
+	var rows []struct {
+		Status string
+		Count  int64
+	}
+	query := func(db *gorm.DB) error {
+		return db.Model(&models.PredictionJob{}).
+			Select("status, COUNT(*) AS count").
+			Where("user_id = ? AND parent_job_id = ?", userID, parentID).
+			Group("status").
+			Scan(&rows).Error
+	}
+
+	var err error
+	if r.useShards {
+		err = database.Manager.ExecuteOnUserShard(int(userID), query)
+	} else {
+		err = query(r.db)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]int64, len(rows))
+	for _, row := range rows {
+		counts[row.Status] = row.Count
+	}
+	return counts, nil
+}
+
 func (r *predictionJobRepository) GetJobsByDateRange(userID uint, startDate, endDate time.Time) ([]*models.PredictionJob, error) {
 	if r.useShards {
 		var jobs []*models.PredictionJob
 		err := database.Manager.ExecuteOnUserShard(int(userID), func(db *gorm.DB) error {
-			return db.Where("user_id = ? AND created_at BETWEEN ? AND ?", userID, startDate, endDate).
+			return db.Where("user_id = ? AND created_at BETWEEN ? AND ? AND job_type <> ?", userID, startDate, endDate, models.JobTypeExplanation).
 				Order("created_at DESC").
 				Preload("Prediction").
 				Find(&jobs).Error
@@ -409,7 +637,7 @@ func (r *predictionJobRepository) GetJobsByDateRange(userID uint, startDate, end
 	}
 
 	var jobs []*models.PredictionJob
-	err := r.db.Where("user_id = ? AND created_at BETWEEN ? AND ?", userID, startDate, endDate).
+	err := r.db.Where("user_id = ? AND created_at BETWEEN ? AND ? AND job_type <> ?", userID, startDate, endDate, models.JobTypeExplanation).
 		Order("created_at DESC").
 		Preload("Prediction").
 		Find(&jobs).Error
@@ -423,6 +651,69 @@ func (r *predictionJobRepository) GetJobsByDateRange(userID uint, startDate, end
 
 // ========== UTILITY OPERATIONS ==========
 
+func (r *predictionJobRepository) GetJobByIdempotencyKey(userID uint, key string) (*models.PredictionJob, error) {
+	var job models.PredictionJob
+	query := func(db *gorm.DB) error {
+		return db.Where("user_id = ? AND idempotency_key = ?", userID, key).
+			Order("created_at DESC").
+			First(&job).Error
+	}
+
+	var err error
+	if r.useShards {
+		err = database.Manager.ExecuteOnUserShard(int(userID), query)
+	} else {
+		err = query(r.db)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+func (r *predictionJobRepository) FindRecentJobByInputHash(userID uint, jobType, inputHash string, since time.Time) (*models.PredictionJob, error) {
+	var job models.PredictionJob
+	query := func(db *gorm.DB) error {
+		return db.Where("user_id = ? AND job_type = ? AND input_hash = ?", userID, jobType, inputHash).
+			Where("(status IN ? OR (status = ? AND completed_at >= ?))",
+				[]string{models.JobStatusPending, models.JobStatusProcessing, models.JobStatusSubmitted},
+				models.JobStatusCompleted, since).
+			Order("created_at DESC").
+			First(&job).Error
+	}
+
+	var err error
+	if r.useShards {
+		err = database.Manager.ExecuteOnUserShard(int(userID), query)
+	} else {
+		err = query(r.db)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+func (r *predictionJobRepository) FindLatestJobByPredictionID(userID uint, jobType string, predictionID uint) (*models.PredictionJob, error) {
+	var job models.PredictionJob
+	query := func(db *gorm.DB) error {
+		return db.Where("user_id = ? AND job_type = ? AND prediction_id = ?", userID, jobType, predictionID).
+			Order("created_at DESC").
+			First(&job).Error
+	}
+
+	var err error
+	if r.useShards {
+		err = database.Manager.ExecuteOnUserShard(int(userID), query)
+	} else {
+		err = query(r.db)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
 func (r *predictionJobRepository) CancelJob(jobID string) error {
 	if r.useShards {
 		// Since we don't know the user_id, we need to find it first
@@ -473,12 +764,19 @@ func (r *predictionJobRepository) CancelJob(jobID string) error {
 	return nil
 }
 
+// activeJobStatuses are the statuses of jobs that still hold a worker or an ML call
+var activeJobStatuses = []string{models.JobStatusPending, models.JobStatusProcessing, models.JobStatusSubmitted}
+
+// userJobTypes are the jobs users submit themselves; batch and model update jobs are excluded
+var userJobTypes = []string{models.JobTypePrediction, models.JobTypeWhatIf}
+
+// GetActiveJobsCount counts the user's own prediction and what-if jobs that have not finished
 func (r *predictionJobRepository) GetActiveJobsCount(userID uint) (int64, error) {
 	if r.useShards {
 		var count int64
 		err := database.Manager.ExecuteOnUserShard(int(userID), func(db *gorm.DB) error {
 			return db.Model(&models.PredictionJob{}).
-				Where("user_id = ? AND status IN (?)", userID, []string{models.JobStatusPending, models.JobStatusProcessing}).
+				Where("user_id = ? AND status IN (?) AND job_type IN (?)", userID, activeJobStatuses, userJobTypes).
 				Count(&count).Error
 		})
 
@@ -491,7 +789,7 @@ func (r *predictionJobRepository) GetActiveJobsCount(userID uint) (int64, error)
 
 	var count int64
 	err := r.db.Model(&models.PredictionJob{}).
-		Where("user_id = ? AND status IN (?)", userID, []string{models.JobStatusPending, models.JobStatusProcessing}).
+		Where("user_id = ? AND status IN (?) AND job_type IN (?)", userID, activeJobStatuses, userJobTypes).
 		Count(&count).Error
 
 	if err != nil {
@@ -501,6 +799,28 @@ func (r *predictionJobRepository) GetActiveJobsCount(userID uint) (int64, error)
 	return count, err
 }
 
+// CountQuotaUnitsSince sums the quota units of the user's jobs of these types created at
+// or after since, leaving out failed and cancelled jobs. A job with items (a batch, a
+// sweep, a counterfactual search) counts each item, any other job counts once.
+func (r *predictionJobRepository) CountQuotaUnitsSince(userID uint, jobTypes []string, since time.Time) (int64, error) {
+	var count int64
+	query := func(db *gorm.DB) error {
+		return db.Model(&models.PredictionJob{}).
+			Select("COALESCE(SUM(CASE WHEN total_items > 0 THEN total_items ELSE 1 END), 0)").
+			Where("user_id = ? AND job_type IN (?) AND created_at >= ?", userID, jobTypes, since).
+			Where("status NOT IN (?)", []string{models.JobStatusFailed, models.JobStatusCancelled}).
+			Scan(&count).Error
+	}
+
+	var err error
+	if r.useShards {
+		err = database.Manager.ExecuteOnUserShard(int(userID), query)
+	} else {
+		err = query(r.db)
+	}
+	return count, err
+}
+
 func (r *predictionJobRepository) CleanupOldJobs(olderThan time.Time) error {
 	if r.useShards {
 		// For cleanup operations across all users, we need to process all shards
@@ -574,7 +894,7 @@ func (r *predictionJobRepository) GetJobStatistics(userID uint) (map[string]int6
 			for _, status := range statuses {
 				var count int64
 				err := db.Model(&models.PredictionJob{}).
-					Where("user_id = ? AND status = ?", userID, status).
+					Where("user_id = ? AND status = ? AND job_type <> ?", userID, status, models.JobTypeExplanation).
 					Count(&count).Error
 				if err != nil {
 					return err
@@ -585,7 +905,7 @@ func (r *predictionJobRepository) GetJobStatistics(userID uint) (map[string]int6
 			// Total jobs
 			var totalCount int64
 			err := db.Model(&models.PredictionJob{}).
-				Where("user_id = ?", userID).
+				Where("user_id = ? AND job_type <> ?", userID, models.JobTypeExplanation).
 				Count(&totalCount).Error
 			if err != nil {
 				return err
@@ -612,7 +932,7 @@ func (r *predictionJobRepository) GetJobStatistics(userID uint) (map[string]int6
 	for _, status := range statuses {
 		var count int64
 		err := r.db.Model(&models.PredictionJob{}).
-			Where("user_id = ? AND status = ?", userID, status).
+			Where("user_id = ? AND status = ? AND job_type <> ?", userID, status, models.JobTypeExplanation).
 			Count(&count).Error
 		if err != nil {
 			return nil, err
@@ -623,7 +943,7 @@ func (r *predictionJobRepository) GetJobStatistics(userID uint) (map[string]int6
 	// Total jobs
 	var totalCount int64
 	err := r.db.Model(&models.PredictionJob{}).
-		Where("user_id = ?", userID).
+		Where("user_id = ? AND job_type <> ?", userID, models.JobTypeExplanation).
 		Count(&totalCount).Error
 	if err != nil {
 		return nil, err
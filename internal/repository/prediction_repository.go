@@ -7,17 +7,28 @@ import (
 	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type PredictionRepository interface {
 	SavePrediction(prediction *models.Prediction) error
 	GetPredictionsByUserID(userID uint, limit int) ([]models.Prediction, error)
+	GetPredictionsByUserIDAndModelVersion(userID uint, modelVersion string, limit int) ([]models.Prediction, error)
+	GetModelVersionsByUserID(userID uint) ([]ModelVersionSummary, error)
 	GetPredictionsByUserIDAndDateRange(userID uint, startDate, endDate time.Time) ([]models.Prediction, error)
 	GetPredictionByID(id uint) (*models.Prediction, error)
 	DeletePrediction(id uint) error
 	GetPredictionScoreByUserIDAndDateRange(userID uint, startDate, endDate time.Time) ([]PredictionScore, error)
 	GetLatestPredictionByUserID(userID uint) (*models.Prediction, error)
 	UpdatePrediction(prediction *models.Prediction) error
+	// SaveExplanation stores only the summary and the factor explanations of a prediction,
+	// so columns changed since it was read, such as its outcome, are kept
+	SaveExplanation(prediction *models.Prediction) error
+
+	// EachPredictionCreatedBetween calls fn with the predictions of every user (all shards)
+	// created in [start, end), batchSize at a time and with their factors and outcome
+	// preloaded. The slice passed to fn is reused for the next batch.
+	EachPredictionCreatedBetween(start, end time.Time, batchSize int, fn func([]models.Prediction) error) error
 }
 
 type predictionRepository struct {
@@ -46,6 +57,23 @@ type PredictionScore struct {
 	CreatedAt time.Time `json:"created_at"`
 }
 
+// ModelVersionSummary describes one model version that produced a user's predictions
+type ModelVersionSummary struct {
+	ModelName            string    `json:"model_name"`
+	ModelVersion         string    `json:"model_version"`
+	FeatureSchemaVersion string    `json:"feature_schema_version"`
+	PredictionCount      int64     `json:"prediction_count"`
+	FirstSeen            time.Time `json:"first_seen"`
+	LastSeen             time.Time `json:"last_seen"`
+}
+
+// withFactors preloads the factors of the queried predictions in registry order
+func withFactors(db *gorm.DB) *gorm.DB {
+	return db.Preload("Factors", func(db *gorm.DB) *gorm.DB {
+		return db.Order("prediction_factors.id")
+	})
+}
+
 func (r *predictionRepository) SavePrediction(prediction *models.Prediction) error {
 	if r.useShards {
 		return database.Manager.ExecuteOnUserShard(int(prediction.UserID), func(db *gorm.DB) error {
@@ -60,21 +88,56 @@ func (r *predictionRepository) GetPredictionsByUserID(userID uint, limit int) ([
 	if r.useShards {
 		var predictions []models.Prediction
 		err := database.Manager.ExecuteOnUserShard(int(userID), func(db *gorm.DB) error {
-			return db.Where("user_id = ?", userID).Order("created_at DESC").Limit(limit).Find(&predictions).Error
+			return withFactors(db).Where("user_id = ?", userID).Order("created_at DESC").Limit(limit).Find(&predictions).Error
+		})
+		return predictions, err
+	}
+
+	var predictions []models.Prediction
+	err := withFactors(r.db).Where("user_id = ?", userID).Order("created_at DESC").Limit(limit).Find(&predictions).Error
+	return predictions, err
+}
+
+func (r *predictionRepository) GetPredictionsByUserIDAndModelVersion(userID uint, modelVersion string, limit int) ([]models.Prediction, error) {
+	if r.useShards {
+		var predictions []models.Prediction
+		err := database.Manager.ExecuteOnUserShard(int(userID), func(db *gorm.DB) error {
+			return withFactors(db).Where("user_id = ? AND model_version = ?", userID, modelVersion).Order("created_at DESC").Limit(limit).Find(&predictions).Error
 		})
 		return predictions, err
 	}
 
 	var predictions []models.Prediction
-	err := r.db.Where("user_id = ?", userID).Order("created_at DESC").Limit(limit).Find(&predictions).Error
+	err := withFactors(r.db).Where("user_id = ? AND model_version = ?", userID, modelVersion).Order("created_at DESC").Limit(limit).Find(&predictions).Error
 	return predictions, err
 }
 
+func (r *predictionRepository) GetModelVersionsByUserID(userID uint) ([]ModelVersionSummary, error) {
+	query := func(db *gorm.DB) *gorm.DB {
+		return db.Model(&models.Prediction{}).
+			Select("model_name, model_version, feature_schema_version, COUNT(*) AS prediction_count, MIN(created_at) AS first_seen, MAX(created_at) AS last_seen").
+			Where("user_id = ?", userID).
+			Group("model_name, model_version, feature_schema_version").
+			Order("last_seen DESC")
+	}
+
+	var versions []ModelVersionSummary
+	if r.useShards {
+		err := database.Manager.ExecuteOnUserShard(int(userID), func(db *gorm.DB) error {
+			return query(db).Scan(&versions).Error
+		})
+		return versions, err
+	}
+
+	err := query(r.db).Scan(&versions).Error
+	return versions, err
+}
+
 func (r *predictionRepository) GetPredictionsByUserIDAndDateRange(userID uint, startDate, endDate time.Time) ([]models.Prediction, error) {
 	if r.useShards {
 		var predictions []models.Prediction
 		err := database.Manager.ExecuteOnUserShard(int(userID), func(db *gorm.DB) error {
-			return db.Raw(`
+			return withFactors(db).Raw(`
 				SELECT * 
 				FROM (
 					SELECT *,
@@ -90,7 +153,7 @@ func (r *predictionRepository) GetPredictionsByUserIDAndDateRange(userID uint, s
 	}
 
 	var predictions []models.Prediction
-	err := r.db.Raw(`
+	err := withFactors(r.db).Raw(`
 		SELECT * 
 		FROM (
 			SELECT *,
@@ -112,7 +175,7 @@ func (r *predictionRepository) GetPredictionByID(id uint) (*models.Prediction, e
 		shards := database.Manager.GetAllShards()
 		for shardName, db := range shards {
 			var prediction models.Prediction
-			err := db.First(&prediction, id).Error
+			err := withFactors(db).First(&prediction, id).Error
 			if err == nil {
 				foundPrediction = &prediction
 				break
@@ -129,7 +192,7 @@ func (r *predictionRepository) GetPredictionByID(id uint) (*models.Prediction, e
 	}
 
 	var prediction models.Prediction
-	err := r.db.First(&prediction, id).Error
+	err := withFactors(r.db).First(&prediction, id).Error
 	if err != nil {
 		return nil, err
 	}
@@ -190,7 +253,7 @@ func (r *predictionRepository) GetLatestPredictionByUserID(userID uint) (*models
 	if r.useShards {
 		var prediction models.Prediction
 		err := database.Manager.ExecuteOnUserShard(int(userID), func(db *gorm.DB) error {
-			return db.Where("user_id = ?", userID).Order("created_at DESC").First(&prediction).Error
+			return withFactors(db).Where("user_id = ?", userID).Order("created_at DESC").First(&prediction).Error
 		})
 		if err != nil {
 			return nil, err
@@ -199,7 +262,7 @@ func (r *predictionRepository) GetLatestPredictionByUserID(userID uint) (*models
 	}
 
 	var prediction models.Prediction
-	err := r.db.Where("user_id = ?", userID).Order("created_at DESC").First(&prediction).Error
+	err := withFactors(r.db).Where("user_id = ?", userID).Order("created_at DESC").First(&prediction).Error
 	if err != nil {
 		return nil, err
 	}
@@ -209,9 +272,58 @@ func (r *predictionRepository) GetLatestPredictionByUserID(userID uint) (*models
 func (r *predictionRepository) UpdatePrediction(prediction *models.Prediction) error {
 	if r.useShards {
 		return database.Manager.ExecuteOnUserShard(int(prediction.UserID), func(db *gorm.DB) error {
-			return db.Save(prediction).Error
+			return db.Session(&gorm.Session{FullSaveAssociations: true}).Save(prediction).Error
+		})
+	}
+
+	// FullSaveAssociations so that updated factors, such as their explanations, are saved too
+	return r.db.Session(&gorm.Session{FullSaveAssociations: true}).Save(prediction).Error
+}
+
+func (r *predictionRepository) SaveExplanation(prediction *models.Prediction) error {
+	factors := make([]models.PredictionFactor, len(prediction.Factors))
+	for i, factor := range prediction.Factors {
+		factors[i] = models.PredictionFactor{
+			PredictionID: prediction.ID,
+			UserID:       prediction.UserID,
+			Factor:       factor.Factor,
+			Explanation:  factor.Explanation,
+		}
+	}
+
+	query := func(db *gorm.DB) error {
+		return db.Transaction(func(tx *gorm.DB) error {
+			err := tx.Model(&models.Prediction{}).Where("id = ?", prediction.ID).
+				Update("prediction_summary", prediction.PredictionSummary).Error
+			if err != nil || len(factors) == 0 {
+				return err
+			}
+			// Factors without a row yet are created; existing ones only get their explanation
+			return tx.Clauses(clause.OnConflict{
+				Columns:   []clause.Column{{Name: "prediction_id"}, {Name: "factor"}},
+				DoUpdates: clause.AssignmentColumns([]string{"explanation"}),
+			}).Create(&factors).Error
 		})
 	}
 
-	return r.db.Save(prediction).Error
+	if r.useShards {
+		return database.Manager.ExecuteOnUserShard(int(prediction.UserID), query)
+	}
+	return query(r.db)
+}
+
+func (r *predictionRepository) EachPredictionCreatedBetween(start, end time.Time, batchSize int, fn func([]models.Prediction) error) error {
+	query := func(db *gorm.DB) error {
+		var batch []models.Prediction
+		return withFactors(db).Preload("Outcome").
+			Where("created_at >= ? AND created_at < ?", start, end).
+			FindInBatches(&batch, batchSize, func(tx *gorm.DB, _ int) error {
+				return fn(batch)
+			}).Error
+	}
+
+	if r.useShards {
+		return database.Manager.ExecuteOnAllShards(query)
+	}
+	return query(r.db)
 }
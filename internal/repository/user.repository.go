@@ -19,6 +19,7 @@ type UserRepository interface {
 	SetUserVerified(email string) error
 	IsUserVerified(email string) (bool, error)
 	UpdateLastPredictionTime(userID uint, lastPredictionTime *time.Time) error
+	UpdateResearchConsent(userID uint, consent bool, at time.Time) error
 }
 
 type userRepository struct {
@@ -142,14 +143,16 @@ func (ur *userRepository) PatchUser(id uint, data map[string]interface{}) error
 	return ur.db.Model(&user).Updates(data).Error
 }
 
+// UpdateUser saves every field of the user except the research consent, which has its
+// own endpoint
 func (ur *userRepository) UpdateUser(user *models.User) error {
 	if ur.useShards {
 		return database.Manager.ExecuteOnUserShard(int(user.ID), func(db *gorm.DB) error {
-			return db.Save(user).Error
+			return db.Omit(models.ResearchConsentColumns()...).Save(user).Error
 		})
 	}
 
-	return ur.db.Save(user).Error
+	return ur.db.Omit(models.ResearchConsentColumns()...).Save(user).Error
 }
 
 func (ur *userRepository) DeleteUser(id uint) error {
@@ -204,3 +207,24 @@ func (ur *userRepository) UpdateLastPredictionTime(userID uint, lastPredictionTi
 
 	return ur.db.Model(&models.User{}).Where("id = ?", userID).Update("last_prediction_at", lastPredictionTime).Error
 }
+
+func (ur *userRepository) UpdateResearchConsent(userID uint, consent bool, at time.Time) error {
+	update := func(db *gorm.DB) error {
+		result := db.Model(&models.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+			"research_consent":    consent,
+			"research_consent_at": at,
+		})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return nil
+	}
+
+	if ur.useShards {
+		return database.Manager.ExecuteOnUserShard(int(userID), update)
+	}
+	return update(ur.db)
+}
@@ -0,0 +1,111 @@
+package repository
+
+import (
+	"diabetify/database"
+	"diabetify/internal/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type WhatIfScenarioRepository interface {
+	// SaveScenario creates the scenario, or updates it when it already has an ID
+	SaveScenario(scenario *models.WhatIfScenario) error
+	GetScenarioByID(userID, id uint) (*models.WhatIfScenario, error)
+	GetScenarioByJobID(userID uint, jobID string) (*models.WhatIfScenario, error)
+	// GetScenariosByUserID returns completed scenarios, pinned first, then newest first
+	GetScenariosByUserID(userID uint, pinnedOnly bool, limit int) ([]models.WhatIfScenario, error)
+	DeleteScenario(userID, id uint) error
+	// DeleteIncompleteScenarios removes scenarios whose job never got a result
+	DeleteIncompleteScenarios(createdBefore time.Time) error
+}
+
+type whatIfScenarioRepository struct {
+	db        *gorm.DB
+	useShards bool
+}
+
+func NewWhatIfScenarioRepository(db *gorm.DB) WhatIfScenarioRepository {
+	return &whatIfScenarioRepository{
+		db:        db,
+		useShards: db == nil,
+	}
+}
+
+// NewShardedWhatIfScenarioRepository creates a what-if scenario repository that uses sharding
+func NewShardedWhatIfScenarioRepository() WhatIfScenarioRepository {
+	return &whatIfScenarioRepository{
+		db:        nil,
+		useShards: true,
+	}
+}
+
+func (r *whatIfScenarioRepository) onUserShard(userID uint, fn func(db *gorm.DB) error) error {
+	if r.useShards {
+		return database.Manager.ExecuteOnUserShard(int(userID), fn)
+	}
+	return fn(r.db)
+}
+
+func (r *whatIfScenarioRepository) SaveScenario(scenario *models.WhatIfScenario) error {
+	return r.onUserShard(scenario.UserID, func(db *gorm.DB) error {
+		return db.Save(scenario).Error
+	})
+}
+
+func (r *whatIfScenarioRepository) GetScenarioByID(userID, id uint) (*models.WhatIfScenario, error) {
+	var scenario models.WhatIfScenario
+	err := r.onUserShard(userID, func(db *gorm.DB) error {
+		return db.Where("id = ? AND user_id = ?", id, userID).First(&scenario).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &scenario, nil
+}
+
+func (r *whatIfScenarioRepository) GetScenarioByJobID(userID uint, jobID string) (*models.WhatIfScenario, error) {
+	var scenario models.WhatIfScenario
+	err := r.onUserShard(userID, func(db *gorm.DB) error {
+		return db.Where("job_id = ? AND user_id = ?", jobID, userID).First(&scenario).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &scenario, nil
+}
+
+func (r *whatIfScenarioRepository) GetScenariosByUserID(userID uint, pinnedOnly bool, limit int) ([]models.WhatIfScenario, error) {
+	var scenarios []models.WhatIfScenario
+	err := r.onUserShard(userID, func(db *gorm.DB) error {
+		query := db.Where("user_id = ? AND completed_at IS NOT NULL", userID)
+		if pinnedOnly {
+			query = query.Where("pinned = ?", true)
+		}
+		return query.Order("pinned DESC, created_at DESC").Limit(limit).Find(&scenarios).Error
+	})
+	return scenarios, err
+}
+
+func (r *whatIfScenarioRepository) DeleteScenario(userID, id uint) error {
+	return r.onUserShard(userID, func(db *gorm.DB) error {
+		result := db.Where("id = ? AND user_id = ?", id, userID).Delete(&models.WhatIfScenario{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return nil
+	})
+}
+
+func (r *whatIfScenarioRepository) DeleteIncompleteScenarios(createdBefore time.Time) error {
+	deleteIncomplete := func(db *gorm.DB) error {
+		return db.Unscoped().Where("completed_at IS NULL AND created_at < ?", createdBefore).Delete(&models.WhatIfScenario{}).Error
+	}
+	if r.useShards {
+		return database.Manager.ExecuteOnAllShards(deleteIncomplete)
+	}
+	return deleteIncomplete(r.db)
+}
@@ -0,0 +1,602 @@
+package services
+
+import (
+	"context"
+	"diabetify/internal/ml"
+	"diabetify/internal/models"
+	"diabetify/internal/repository"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrEmptyBatch is returned when a batch has no items
+	ErrEmptyBatch = errors.New("batch has no items")
+	// ErrBatchTooLarge is returned when a batch exceeds MaxItems
+	ErrBatchTooLarge = errors.New("batch exceeds the maximum number of items")
+	// ErrBatchNotFinished is returned when the result of a running batch is requested
+	ErrBatchNotFinished = errors.New("batch has not finished")
+)
+
+// batchProgressInterval is how many finished items pass between progress writes
+const batchProgressInterval = 25
+
+// BatchPredictionConfig bounds batch size and fan-out
+type BatchPredictionConfig struct {
+	// Concurrency is how many items of one batch are queued or running at the same time
+	Concurrency int
+	// MaxItems caps the number of user IDs or CSV rows in one batch
+	MaxItems int
+	// ItemTimeout bounds feature assembly and scoring of a single item
+	ItemTimeout time.Duration
+	// RecoveryInterval is how often batches left unfinished by a stopped process are resumed
+	RecoveryInterval time.Duration
+}
+
+// BatchPredictionConfigFromEnv reads BATCH_PREDICTION_CONCURRENCY and
+// BATCH_PREDICTION_MAX_ITEMS
+func BatchPredictionConfigFromEnv() BatchPredictionConfig {
+	cfg := BatchPredictionConfig{
+		Concurrency:      4,
+		MaxItems:         10000,
+		ItemTimeout:      30 * time.Second,
+		RecoveryInterval: time.Minute,
+	}
+	if v, err := strconv.Atoi(os.Getenv("BATCH_PREDICTION_CONCURRENCY")); err == nil && v > 0 {
+		cfg.Concurrency = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("BATCH_PREDICTION_MAX_ITEMS")); err == nil && v > 0 {
+		cfg.MaxItems = v
+	}
+	return cfg
+}
+
+// BatchItem is one row of a batch: either a registered user, whose features are built
+// from their profile, or an anonymous feature vector in ml.FeatureNames order
+type BatchItem struct {
+	// Ref identifies the row in the result file
+	Ref      string
+	UserID   *uint
+	Features []float64
+}
+
+// FeatureSource builds the model input for a registered user
+type FeatureSource interface {
+	FeaturesForUser(userID uint) ([]float64, error)
+}
+
+// BatchPredictionService scores cohorts in the background. Every item is stored as a
+// pending child job of the batch and runs on the prediction worker's queue at background
+// priority; results are kept on the item jobs, so a batch survives restarts and any
+// replica can resume it or serve its result file.
+type BatchPredictionService interface {
+	// Start resumes batches left unfinished, now and every RecoveryInterval
+	Start()
+	Stop()
+	StartBatch(requestedBy uint, items []BatchItem) (*models.PredictionJob, error)
+	GetBatch(jobID string) (*models.PredictionJob, error)
+	// WriteResults writes the result CSV of a finished batch
+	WriteResults(job *models.PredictionJob, w io.Writer) error
+	// Wait blocks until every batch this process is feeding has finished
+	Wait()
+}
+
+type batchPredictionService struct {
+	jobRepo   repository.PredictionJobRepository
+	features  FeatureSource
+	predictor ml.SyncPredictor
+	queue     JobQueue
+	quota     PredictionQuota
+	cfg       BatchPredictionConfig
+
+	mu sync.Mutex
+	// feeds holds the batches this process is queueing items of
+	feeds map[string]bool
+	// waiting maps a queued item to the feed that waits for its outcome
+	waiting map[string]chan<- string
+
+	stopChan chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+	routines sync.WaitGroup
+}
+
+func NewBatchPredictionService(
+	jobRepo repository.PredictionJobRepository,
+	features FeatureSource,
+	predictor ml.SyncPredictor,
+	queue JobQueue,
+	quota PredictionQuota,
+	cfg BatchPredictionConfig,
+) BatchPredictionService {
+	if cfg.Concurrency <= 0 {
+		cfg.Concurrency = 1
+	}
+	if cfg.RecoveryInterval <= 0 {
+		cfg.RecoveryInterval = time.Minute
+	}
+	s := &batchPredictionService{
+		jobRepo:   jobRepo,
+		features:  features,
+		predictor: predictor,
+		queue:     queue,
+		quota:     quota,
+		cfg:       cfg,
+		feeds:     make(map[string]bool),
+		waiting:   make(map[string]chan<- string),
+		stopChan:  make(chan struct{}),
+	}
+	queue.RegisterJobHandler(models.JobTypeBatchItem, s.handleItem)
+	return s
+}
+
+func (s *batchPredictionService) Start() {
+	s.routines.Add(1)
+	go s.recoveryRoutine()
+}
+
+func (s *batchPredictionService) Stop() {
+	s.stopOnce.Do(func() { close(s.stopChan) })
+	s.routines.Wait()
+	s.wg.Wait()
+}
+
+func (s *batchPredictionService) StartBatch(requestedBy uint, items []BatchItem) (*models.PredictionJob, error) {
+	if len(items) == 0 {
+		return nil, ErrEmptyBatch
+	}
+	if len(items) > s.cfg.MaxItems {
+		return nil, fmt.Errorf("%w (%d > %d)", ErrBatchTooLarge, len(items), s.cfg.MaxItems)
+	}
+	if err := checkQuotaUnits(s.quota, requestedBy, models.JobTypeBatch, len(items)); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	job := &models.PredictionJob{
+		ID:         uuid.New().String(),
+		UserID:     requestedBy,
+		Status:     models.JobStatusProcessing,
+		JobType:    models.JobTypeBatch,
+		TotalItems: len(items),
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+	if err := s.jobRepo.SaveJob(job); err != nil {
+		return nil, fmt.Errorf("failed to create batch job: %w", err)
+	}
+
+	// Items belong to the requester; anonymous rows keep their features as the input snapshot
+	parentID := job.ID
+	children := make([]*models.PredictionJob, len(items))
+	for i, item := range items {
+		child := &models.PredictionJob{
+			ID:            uuid.New().String(),
+			UserID:        requestedBy,
+			Status:        models.JobStatusPending,
+			JobType:       models.JobTypeBatchItem,
+			ParentJobID:   &parentID,
+			SubjectUserID: item.UserID,
+			BatchItem:     &models.BatchItemResult{Index: i, Ref: item.Ref},
+			CreatedAt:     now,
+			UpdatedAt:     now,
+		}
+		if item.UserID == nil {
+			child.InputHash = ml.HashFeatures(item.Features)
+			child.FeatureSnapshot = &models.FeatureSnapshot{Features: item.Features, ComputedAt: now}
+		}
+		children[i] = child
+	}
+	if err := s.jobRepo.SaveJobs(children); err != nil {
+		errMsg := fmt.Sprintf("Failed to create batch items: %v", err)
+		_ = s.jobRepo.UpdateJobStatus(job.ID, models.JobStatusFailed, &errMsg)
+		return nil, fmt.Errorf("failed to create batch items: %w", err)
+	}
+
+	running := *job
+	s.feed(&running, children)
+	return job, nil
+}
+
+func (s *batchPredictionService) GetBatch(jobID string) (*models.PredictionJob, error) {
+	job, err := s.jobRepo.GetJobByID(jobID)
+	if err != nil {
+		return nil, err
+	}
+	if job.JobType != models.JobTypeBatch {
+		return nil, fmt.Errorf("job %s is not a batch job", jobID)
+	}
+	return job, nil
+}
+
+func (s *batchPredictionService) WriteResults(job *models.PredictionJob, w io.Writer) error {
+	if job.Status != models.JobStatusCompleted {
+		return ErrBatchNotFinished
+	}
+
+	items, err := s.jobRepo.GetChildJobs(job.UserID, job.ID)
+	if err != nil {
+		return fmt.Errorf("failed to load batch items: %w", err)
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		return batchItemIndex(items[i]) < batchItemIndex(items[j])
+	})
+	return writeBatchResultsCSV(w, items)
+}
+
+func (s *batchPredictionService) Wait() {
+	s.wg.Wait()
+}
+
+// feed queues the given items of a batch unless this process is already feeding it
+func (s *batchPredictionService) feed(batch *models.PredictionJob, items []*models.PredictionJob) {
+	s.mu.Lock()
+	if s.feeds[batch.ID] {
+		s.mu.Unlock()
+		return
+	}
+	s.feeds[batch.ID] = true
+	s.wg.Add(1)
+	s.mu.Unlock()
+
+	go func() {
+		defer s.wg.Done()
+		defer func() {
+			s.mu.Lock()
+			delete(s.feeds, batch.ID)
+			s.mu.Unlock()
+		}()
+		s.run(batch, items)
+	}()
+}
+
+// run keeps up to Concurrency items of the batch on the worker queue and completes the
+// batch once every item has finished
+func (s *batchPredictionService) run(batch *models.PredictionJob, items []*models.PredictionJob) {
+	outcomes := make(chan string, s.cfg.Concurrency)
+	next, inFlight, finished := 0, 0, 0
+
+	for next < len(items) || inFlight > 0 {
+		var retry <-chan time.Time
+		for inFlight < s.cfg.Concurrency && next < len(items) {
+			if err := s.submit(batch, items[next], outcomes); err != nil {
+				// The queue is full; the items stay pending until there is room
+				retry = time.After(time.Second)
+				break
+			}
+			inFlight++
+			next++
+		}
+
+		select {
+		case status := <-outcomes:
+			inFlight--
+			if status == "" {
+				continue
+			}
+			finished++
+			if finished%batchProgressInterval == 0 {
+				_, _ = s.recordProgress(batch)
+			}
+		case <-retry:
+		case <-s.stopChan:
+			return
+		}
+	}
+
+	counts, err := s.recordProgress(batch)
+	if err != nil {
+		return
+	}
+	// Items still held by another process are finished there, or resumed by recovery
+	if counts[models.JobStatusCompleted]+counts[models.JobStatusFailed] < int64(batch.TotalItems) {
+		return
+	}
+	if err := s.jobRepo.UpdateJobStatus(batch.ID, models.JobStatusCompleted, nil); err != nil {
+		fmt.Printf("Warning: Failed to complete batch %s: %v\n", batch.ID, err)
+	}
+}
+
+func (s *batchPredictionService) submit(batch, item *models.PredictionJob, outcomes chan<- string) error {
+	s.mu.Lock()
+	s.waiting[item.ID] = outcomes
+	s.mu.Unlock()
+
+	err := s.queue.SubmitJob(models.PredictionJobRequest{
+		JobID:    item.ID,
+		UserID:   batch.UserID,
+		JobType:  models.JobTypeBatchItem,
+		Priority: models.JobPriorityBackground,
+	})
+	if err != nil {
+		s.mu.Lock()
+		delete(s.waiting, item.ID)
+		s.mu.Unlock()
+	}
+	return err
+}
+
+// recordProgress writes the item counts of the batch, counted from its item jobs so
+// every process feeding the batch reports the same numbers
+func (s *batchPredictionService) recordProgress(batch *models.PredictionJob) (map[string]int64, error) {
+	counts, err := s.jobRepo.CountChildJobsByStatus(batch.UserID, batch.ID)
+	if err != nil {
+		fmt.Printf("Warning: Failed to count items of batch %s: %v\n", batch.ID, err)
+		return nil, err
+	}
+	completed, failed := int(counts[models.JobStatusCompleted]), int(counts[models.JobStatusFailed])
+	if err := s.jobRepo.UpdateBatchProgress(batch.ID, completed, failed); err != nil {
+		fmt.Printf("Warning: Failed to record progress of batch %s: %v\n", batch.ID, err)
+	}
+	return counts, nil
+}
+
+// handleItem runs on a prediction worker and reports the outcome to the waiting feed
+func (s *batchPredictionService) handleItem(ctx context.Context, request models.PredictionJobRequest) {
+	status := s.scoreItem(ctx, request)
+
+	s.mu.Lock()
+	outcomes, ok := s.waiting[request.JobID]
+	delete(s.waiting, request.JobID)
+	s.mu.Unlock()
+
+	if ok {
+		select {
+		case outcomes <- status:
+		case <-s.stopChan:
+		}
+	}
+}
+
+// scoreItem claims and scores one item, storing the result on its job. It returns the
+// final status, or "" when the item was not scored here.
+func (s *batchPredictionService) scoreItem(ctx context.Context, request models.PredictionJobRequest) string {
+	// An item still processing after twice its timeout belonged to a process that stopped
+	claimed, err := s.jobRepo.ClaimJob(request.UserID, request.JobID, time.Now().Add(-2*s.cfg.ItemTimeout))
+	if err != nil {
+		fmt.Printf("Warning: Failed to claim batch item %s: %v\n", request.JobID, err)
+		return ""
+	}
+	if !claimed {
+		return ""
+	}
+
+	item, err := s.jobRepo.GetJobByID(request.JobID)
+	if err != nil {
+		fmt.Printf("Warning: Failed to load batch item %s: %v\n", request.JobID, err)
+		return ""
+	}
+	result := item.BatchItem
+	if result == nil {
+		result = &models.BatchItemResult{}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.cfg.ItemTimeout)
+	defer cancel()
+
+	score, err := s.score(ctx, item)
+	if err != nil {
+		errMsg := err.Error()
+		if err := s.jobRepo.FinishBatchItem(request.UserID, item.ID, result, &errMsg); err != nil {
+			fmt.Printf("Warning: Failed to record batch item %s: %v\n", item.ID, err)
+			return ""
+		}
+		return models.JobStatusFailed
+	}
+
+	result.RiskScore = &score.Prediction
+	result.ModelVersion = score.ModelVersion
+	result.Shap = make(map[string]float64, len(score.Explanation))
+	for name, contribution := range score.Explanation {
+		result.Shap[name] = contribution.Shap
+	}
+	if err := s.jobRepo.FinishBatchItem(request.UserID, item.ID, result, nil); err != nil {
+		fmt.Printf("Warning: Failed to record batch item %s: %v\n", item.ID, err)
+		return ""
+	}
+	return models.JobStatusCompleted
+}
+
+// score builds the features of a registered user, recording them on the item, or uses the
+// stored features of an anonymous row
+func (s *batchPredictionService) score(ctx context.Context, item *models.PredictionJob) (*ml.ScoreResult, error) {
+	if item.SubjectUserID != nil {
+		features, err := s.features.FeaturesForUser(*item.SubjectUserID)
+		if err != nil {
+			return nil, err
+		}
+		item.FeatureSnapshot = &models.FeatureSnapshot{Features: features, ComputedAt: time.Now()}
+		if err := s.jobRepo.SetJobInput(item.ID, ml.HashFeatures(features), item.FeatureSnapshot); err != nil {
+			fmt.Printf("Warning: Failed to store input of batch item %s: %v\n", item.ID, err)
+		}
+	}
+	if item.FeatureSnapshot == nil {
+		return nil, errors.New("batch item has no input features")
+	}
+	return s.predictor.PredictSync(ctx, item.FeatureSnapshot.Features)
+}
+
+func (s *batchPredictionService) recoveryRoutine() {
+	defer s.routines.Done()
+	ticker := time.NewTicker(s.cfg.RecoveryInterval)
+	defer ticker.Stop()
+	for {
+		s.resumeBatches()
+		select {
+		case <-ticker.C:
+		case <-s.stopChan:
+			return
+		}
+	}
+}
+
+// resumeBatches feeds the unfinished items of every processing batch. Batches already
+// fed by another process are shared safely, since each item is claimed before scoring.
+func (s *batchPredictionService) resumeBatches() {
+	batches, err := s.jobRepo.GetJobsByTypeAndStatus(models.JobTypeBatch, models.JobStatusProcessing, 100)
+	if err != nil {
+		fmt.Printf("Warning: Failed to look up unfinished batches: %v\n", err)
+		return
+	}
+	for _, batch := range batches {
+		items, err := s.jobRepo.GetChildJobs(batch.UserID, batch.ID, models.JobStatusPending, models.JobStatusProcessing)
+		if err != nil {
+			fmt.Printf("Warning: Failed to load items of batch %s: %v\n", batch.ID, err)
+			continue
+		}
+		s.feed(batch, items)
+	}
+}
+
+func batchItemIndex(item *models.PredictionJob) int {
+	if item.BatchItem == nil {
+		return 0
+	}
+	return item.BatchItem.Index
+}
+
+// writeBatchResultsCSV writes one row per item: identity, status, score, model version,
+// the input features and their SHAP values
+func writeBatchResultsCSV(w io.Writer, items []*models.PredictionJob) error {
+	writer := csv.NewWriter(w)
+
+	header := []string{"ref", "user_id", "status", "risk_score", "model_version"}
+	header = append(header, ml.FeatureNames...)
+	for _, name := range ml.FeatureNames {
+		header = append(header, "shap_"+name)
+	}
+	header = append(header, "error")
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+
+	for _, item := range items {
+		result := item.BatchItem
+		if result == nil {
+			result = &models.BatchItemResult{}
+		}
+
+		row := make([]string, 0, len(header))
+		userID := ""
+		if item.SubjectUserID != nil {
+			userID = strconv.FormatUint(uint64(*item.SubjectUserID), 10)
+		}
+		row = append(row, result.Ref, userID, item.Status)
+
+		if result.RiskScore != nil {
+			row = append(row, formatFloat(*result.RiskScore), result.ModelVersion)
+		} else {
+			row = append(row, "", "")
+		}
+		var features []float64
+		if item.FeatureSnapshot != nil {
+			features = item.FeatureSnapshot.Features
+		}
+		for i := range ml.FeatureNames {
+			if i < len(features) {
+				row = append(row, formatFloat(features[i]))
+			} else {
+				row = append(row, "")
+			}
+		}
+		for _, name := range ml.FeatureNames {
+			if shap, ok := result.Shap[name]; ok {
+				row = append(row, formatFloat(shap))
+			} else {
+				row = append(row, "")
+			}
+		}
+		errMsg := ""
+		if item.ErrorMessage != nil {
+			errMsg = *item.ErrorMessage
+		}
+		row = append(row, errMsg)
+
+		if err := writer.Write(row); err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// ParseBatchFeatureCSV reads anonymous feature rows. The header must name every model
+// feature (ml.FeatureNames, any order); an optional "ref" column identifies rows in the
+// result file, otherwise rows are numbered from 1.
+func ParseBatchFeatureCSV(r io.Reader, maxItems int) ([]BatchItem, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, ErrEmptyBatch
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid CSV header: %w", err)
+	}
+
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.TrimSpace(name)] = i
+	}
+	featureColumns := make([]int, len(ml.FeatureNames))
+	var missing []string
+	for i, name := range ml.FeatureNames {
+		column, ok := columns[name]
+		if !ok {
+			missing = append(missing, name)
+		}
+		featureColumns[i] = column
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("CSV is missing feature columns: %s", strings.Join(missing, ", "))
+	}
+	refColumn, hasRef := columns["ref"]
+
+	var items []BatchItem
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid CSV on line %d: %w", line, err)
+		}
+		if len(items) == maxItems {
+			return nil, fmt.Errorf("%w (more than %d rows)", ErrBatchTooLarge, maxItems)
+		}
+
+		item := BatchItem{Ref: strconv.Itoa(line - 1), Features: make([]float64, len(ml.FeatureNames))}
+		if hasRef && record[refColumn] != "" {
+			item.Ref = record[refColumn]
+		}
+		for i, column := range featureColumns {
+			value, err := strconv.ParseFloat(strings.TrimSpace(record[column]), 64)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %s must be a number, got %q", line, ml.FeatureNames[i], record[column])
+			}
+			item.Features[i] = value
+		}
+		items = append(items, item)
+	}
+
+	if len(items) == 0 {
+		return nil, ErrEmptyBatch
+	}
+	return items, nil
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
@@ -0,0 +1,519 @@
+package services
+
+import (
+	"container/heap"
+	"context"
+	"diabetify/internal/ml"
+	"diabetify/internal/models"
+	"diabetify/internal/repository"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrCounterfactualInput is returned when the user's profile cannot produce a what-if input
+var ErrCounterfactualInput = errors.New("cannot build current input")
+
+// Effort of each change, in the units the search minimizes. Quitting smoking and getting
+// hypertension or cholesterol under control weigh about as much as losing 10-15 kg.
+const (
+	counterfactualEffortPerKg       = 0.2
+	counterfactualEffortPerSession  = 0.5
+	counterfactualEffortQuitSmoking = 3
+	counterfactualEffortControl     = 2
+)
+
+// CounterfactualConfig bounds which changes count as plausible
+type CounterfactualConfig struct {
+	// MaxWeightLossPercent caps weight loss as a share of the current weight
+	MaxWeightLossPercent float64
+	// MinBMI is the lowest BMI a suggestion may reach
+	MinBMI float64
+	// MaxActivityFrequency caps the weekly moderate activity sessions
+	MaxActivityFrequency int
+	// WeightStep is the granularity of weight loss in kg
+	WeightStep float64
+	// MaxEvaluations caps the scenarios scored for one request
+	MaxEvaluations int
+	// ItemTimeout bounds the scoring of a single scenario, including its wait for a worker
+	ItemTimeout time.Duration
+	// Timeout bounds the whole search; it runs inside the request, so keep it under the
+	// server's write timeout. A search cut short returns what it found so far.
+	Timeout time.Duration
+}
+
+// CounterfactualConfigFromEnv reads COUNTERFACTUAL_MAX_WEIGHT_LOSS_PERCENT,
+// COUNTERFACTUAL_MIN_BMI, COUNTERFACTUAL_MAX_EVALUATIONS and COUNTERFACTUAL_TIMEOUT
+func CounterfactualConfigFromEnv() CounterfactualConfig {
+	cfg := CounterfactualConfig{
+		MaxWeightLossPercent: 15,
+		MinBMI:               18.5,
+		MaxActivityFrequency: 7,
+		WeightStep:           2,
+		MaxEvaluations:       200,
+		ItemTimeout:          750 * time.Millisecond,
+		Timeout:              25 * time.Second,
+	}
+	if v, err := strconv.ParseFloat(os.Getenv("COUNTERFACTUAL_MAX_WEIGHT_LOSS_PERCENT"), 64); err == nil && v >= 0 && v < 100 {
+		cfg.MaxWeightLossPercent = v
+	}
+	if v, err := strconv.ParseFloat(os.Getenv("COUNTERFACTUAL_MIN_BMI"), 64); err == nil && v > 0 {
+		cfg.MinBMI = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("COUNTERFACTUAL_MAX_EVALUATIONS")); err == nil && v > 0 {
+		cfg.MaxEvaluations = v
+	}
+	if v, err := time.ParseDuration(os.Getenv("COUNTERFACTUAL_TIMEOUT")); err == nil && v > 0 {
+		cfg.Timeout = v
+	}
+	return cfg
+}
+
+// CounterfactualInputSource provides the user's current what-if input and its features
+type CounterfactualInputSource interface {
+	WhatIfFeatureSource
+	CurrentWhatIfInput(userID uint) (*models.WhatIfInput, error)
+}
+
+// CounterfactualService searches for the smallest changes to the modifiable what-if
+// fields that bring the user's risk below a target. Each search is recorded as a job,
+// its scenarios are scored on the worker queue at background priority and every one
+// counts against the daily what-if quota.
+type CounterfactualService interface {
+	Suggest(ctx context.Context, userID uint, request *models.CounterfactualRequest) (*models.CounterfactualResult, error)
+}
+
+type counterfactualService struct {
+	jobRepo repository.PredictionJobRepository
+	inputs  CounterfactualInputSource
+	scorer  QueuedScorer
+	quota   PredictionQuota
+	cfg     CounterfactualConfig
+}
+
+func NewCounterfactualService(
+	jobRepo repository.PredictionJobRepository,
+	inputs CounterfactualInputSource,
+	scorer QueuedScorer,
+	quota PredictionQuota,
+	cfg CounterfactualConfig,
+) CounterfactualService {
+	if cfg.WeightStep <= 0 {
+		cfg.WeightStep = 1
+	}
+	if cfg.MaxEvaluations <= 0 {
+		cfg.MaxEvaluations = 1
+	}
+	if cfg.ItemTimeout <= 0 {
+		cfg.ItemTimeout = 750 * time.Millisecond
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 25 * time.Second
+	}
+	return &counterfactualService{
+		jobRepo: jobRepo,
+		inputs:  inputs,
+		scorer:  scorer,
+		quota:   quota,
+		cfg:     cfg,
+	}
+}
+
+// counterfactualState is a set of changes to the current input
+type counterfactualState struct {
+	weightSteps   int
+	activitySteps int
+	quitSmoking   bool
+	controlHyper  bool
+	controlChol   bool
+}
+
+// covers reports whether s makes every change o makes, at least as far
+func (s counterfactualState) covers(o counterfactualState) bool {
+	return s.weightSteps >= o.weightSteps && s.activitySteps >= o.activitySteps &&
+		(s.quitSmoking || !o.quitSmoking) && (s.controlHyper || !o.controlHyper) && (s.controlChol || !o.controlChol)
+}
+
+func (s counterfactualState) effort(weightStep float64) float64 {
+	effort := float64(s.weightSteps)*weightStep*counterfactualEffortPerKg +
+		float64(s.activitySteps)*counterfactualEffortPerSession
+	if s.quitSmoking {
+		effort += counterfactualEffortQuitSmoking
+	}
+	if s.controlHyper {
+		effort += counterfactualEffortControl
+	}
+	if s.controlChol {
+		effort += counterfactualEffortControl
+	}
+	return effort
+}
+
+type counterfactualCandidate struct {
+	state  counterfactualState
+	effort float64
+	seq    int
+}
+
+// counterfactualQueue orders candidates by effort, then by discovery
+type counterfactualQueue []counterfactualCandidate
+
+func (q counterfactualQueue) Len() int { return len(q) }
+func (q counterfactualQueue) Less(i, j int) bool {
+	if q[i].effort != q[j].effort {
+		return q[i].effort < q[j].effort
+	}
+	return q[i].seq < q[j].seq
+}
+func (q counterfactualQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+func (q *counterfactualQueue) Push(x interface{}) {
+	*q = append(*q, x.(counterfactualCandidate))
+}
+func (q *counterfactualQueue) Pop() interface{} {
+	old := *q
+	item := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return item
+}
+
+// counterfactualSearch holds what one request needs to derive and score candidates
+type counterfactualSearch struct {
+	current        models.WhatIfInput
+	features       []float64
+	maxWeightSteps int
+	maxActivity    int
+	canQuit        bool
+}
+
+// Indexes into ml.FeatureNames of the features the modifiable fields map onto
+var (
+	featureIndexSmokingStatus = featureIndex("smoking_status")
+	featureIndexCholesterol   = featureIndex("is_cholesterol")
+	featureIndexActivity      = featureIndex("moderate_physical_activity_frequency")
+	featureIndexBMI           = featureIndex("BMI")
+	featureIndexHypertension  = featureIndex("is_hypertension")
+)
+
+func featureIndex(name string) int {
+	for i, feature := range ml.FeatureNames {
+		if feature == name {
+			return i
+		}
+	}
+	panic("unknown model feature " + name)
+}
+
+func (s *counterfactualService) Suggest(ctx context.Context, userID uint, request *models.CounterfactualRequest) (*models.CounterfactualResult, error) {
+	target := request.TargetRisk
+	if target == 0 {
+		target = 0.3
+	}
+	maxSuggestions := request.MaxSuggestions
+	if maxSuggestions == 0 {
+		maxSuggestions = 3
+	}
+
+	current, err := s.inputs.CurrentWhatIfInput(userID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCounterfactualInput, err)
+	}
+	features, err := s.inputs.FeaturesForWhatIf(userID, current)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCounterfactualInput, err)
+	}
+
+	job, err := s.startJob(userID)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, s.cfg.Timeout)
+	defer cancel()
+	result, err := s.search(ctx, job, target, maxSuggestions, s.newSearch(*current, features))
+	if err != nil {
+		errMsg := err.Error()
+		_ = s.jobRepo.UpdateJobStatus(job.ID, models.JobStatusFailed, &errMsg)
+		return nil, err
+	}
+
+	// The job reserved the whole budget; charge only the scenarios actually scored
+	now := time.Now()
+	job.Status = models.JobStatusCompleted
+	job.TotalItems = result.Evaluations
+	job.CompletedItems = result.Evaluations
+	job.CompletedAt = &now
+	if err := s.jobRepo.UpdateJob(job); err != nil {
+		fmt.Printf("Warning: Failed to complete counterfactual job %s: %v\n", job.ID, err)
+	}
+	return result, nil
+}
+
+// startJob checks the what-if quota and records the search as a job that reserves as
+// many evaluations as the search may make
+func (s *counterfactualService) startJob(userID uint) (*models.PredictionJob, error) {
+	if err := checkQuotaUnits(s.quota, userID, models.JobTypeCounterfactual, 1); err != nil {
+		return nil, err
+	}
+	budget := s.cfg.MaxEvaluations
+	remaining, err := s.quota.Remaining(userID, models.JobTypeCounterfactual)
+	if err != nil {
+		fmt.Printf("Warning: Quota check failed for user %d: %v\n", userID, err)
+	} else if remaining >= 0 && remaining < budget {
+		budget = remaining
+	}
+
+	now := time.Now()
+	job := &models.PredictionJob{
+		ID:         uuid.New().String(),
+		UserID:     userID,
+		Status:     models.JobStatusProcessing,
+		JobType:    models.JobTypeCounterfactual,
+		TotalItems: budget,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+	if err := s.jobRepo.SaveJob(job); err != nil {
+		return nil, fmt.Errorf("failed to create counterfactual job: %w", err)
+	}
+	return job, nil
+}
+
+// search runs the search of one request, scoring at most job.TotalItems scenarios and
+// none that could not finish before ctx's deadline
+func (s *counterfactualService) search(ctx context.Context, job *models.PredictionJob, target float64, maxSuggestions int, search *counterfactualSearch) (*models.CounterfactualResult, error) {
+	baseline, err := s.score(ctx, job.UserID, search.features)
+	if err != nil {
+		return nil, fmt.Errorf("failed to score current input: %w", err)
+	}
+	result := &models.CounterfactualResult{
+		JobID:            job.ID,
+		CurrentInput:     search.current,
+		CurrentRiskScore: baseline.Prediction,
+		TargetRisk:       target,
+		AlreadyBelow:     baseline.Prediction < target,
+		Suggestions:      []models.CounterfactualSuggestion{},
+		Evaluations:      1,
+		ModelVersion:     baseline.ModelVersion,
+	}
+	if result.AlreadyBelow {
+		return result, nil
+	}
+
+	type scored struct {
+		state  counterfactualState
+		effort float64
+		risk   float64
+	}
+	var solutions, explored []scored
+
+	// Uniform-cost search: candidates come off the queue in order of effort, so the first
+	// ones to reach the target are the minimal changes. A candidate that makes every change
+	// of an earlier solution and more is never minimal and is skipped with its successors.
+	queue := &counterfactualQueue{}
+	seen := map[counterfactualState]bool{{}: true}
+	seq := 0
+	push := func(state counterfactualState) {
+		if seen[state] {
+			return
+		}
+		seen[state] = true
+		seq++
+		heap.Push(queue, counterfactualCandidate{state: state, effort: state.effort(s.cfg.WeightStep), seq: seq})
+	}
+	for _, next := range search.successors(counterfactualState{}) {
+		push(next)
+	}
+
+	deadline, _ := ctx.Deadline()
+	for queue.Len() > 0 && len(solutions) < maxSuggestions {
+		if result.Evaluations >= job.TotalItems || time.Until(deadline) < s.cfg.ItemTimeout {
+			result.Truncated = true
+			break
+		}
+		candidate := heap.Pop(queue).(counterfactualCandidate)
+
+		dominated := false
+		for _, solution := range solutions {
+			if candidate.state.covers(solution.state) {
+				dominated = true
+				break
+			}
+		}
+		if dominated {
+			continue
+		}
+
+		score, err := s.score(ctx, job.UserID, search.featuresFor(candidate.state, s.cfg.WeightStep))
+		result.Evaluations++
+		if err != nil {
+			// One scenario that cannot be scored does not throw away what was found so far
+			fmt.Printf("Warning: Counterfactual job %s failed to score a scenario: %v\n", job.ID, err)
+			result.FailedEvaluations++
+			result.Truncated = true
+			if ctx.Err() != nil {
+				break
+			}
+			continue
+		}
+
+		found := scored{state: candidate.state, effort: candidate.effort, risk: score.Prediction}
+		if found.risk < target {
+			solutions = append(solutions, found)
+			continue
+		}
+		explored = append(explored, found)
+		for _, next := range search.successors(candidate.state) {
+			push(next)
+		}
+	}
+
+	reachesTarget := len(solutions) > 0
+	if !reachesTarget {
+		// Nothing plausible gets below the target; offer the changes that lower the risk most
+		sort.SliceStable(explored, func(i, j int) bool {
+			if explored[i].risk != explored[j].risk {
+				return explored[i].risk < explored[j].risk
+			}
+			return explored[i].effort < explored[j].effort
+		})
+		for _, candidate := range explored {
+			if len(solutions) == maxSuggestions {
+				break
+			}
+			if candidate.risk < baseline.Prediction {
+				solutions = append(solutions, candidate)
+			}
+		}
+	}
+
+	for i, solution := range solutions {
+		input, changes := search.apply(solution.state, s.cfg.WeightStep)
+		result.Suggestions = append(result.Suggestions, models.CounterfactualSuggestion{
+			Rank:          i + 1,
+			Changes:       changes,
+			Input:         input,
+			RiskScore:     solution.risk,
+			RiskReduction: baseline.Prediction - solution.risk,
+			Effort:        math.Round(solution.effort*100) / 100,
+			ReachesTarget: reachesTarget,
+		})
+	}
+	return result, nil
+}
+
+func (s *counterfactualService) newSearch(current models.WhatIfInput, features []float64) *counterfactualSearch {
+	search := &counterfactualSearch{
+		current:     current,
+		features:    features,
+		maxActivity: s.cfg.MaxActivityFrequency - current.PhysicalActivityFrequency,
+		canQuit:     current.SmokingStatus == 2,
+	}
+	if search.maxActivity < 0 {
+		search.maxActivity = 0
+	}
+
+	// Weight loss stops at the percentage cap or at the minimum BMI, whichever comes first.
+	// BMI scales with weight at a fixed height, so the model's BMI feature gives the bound.
+	maxLoss := current.Weight * s.cfg.MaxWeightLossPercent / 100
+	if bmi := features[featureIndexBMI]; bmi > 0 {
+		maxLoss = math.Min(maxLoss, current.Weight*(1-s.cfg.MinBMI/bmi))
+	}
+	if maxLoss > 0 {
+		search.maxWeightSteps = int(math.Floor(maxLoss/s.cfg.WeightStep + 1e-9))
+	}
+	return search
+}
+
+func (cs *counterfactualSearch) successors(state counterfactualState) []counterfactualState {
+	var next []counterfactualState
+	if state.weightSteps < cs.maxWeightSteps {
+		n := state
+		n.weightSteps++
+		next = append(next, n)
+	}
+	if state.activitySteps < cs.maxActivity {
+		n := state
+		n.activitySteps++
+		next = append(next, n)
+	}
+	if cs.canQuit && !state.quitSmoking {
+		n := state
+		n.quitSmoking = true
+		next = append(next, n)
+	}
+	if cs.current.IsHypertension && !state.controlHyper {
+		n := state
+		n.controlHyper = true
+		next = append(next, n)
+	}
+	if cs.current.IsCholesterol && !state.controlChol {
+		n := state
+		n.controlChol = true
+		next = append(next, n)
+	}
+	return next
+}
+
+// featuresFor derives the model input of a state from the current one. Every modifiable
+// field maps onto a single feature, so the profile does not need to be read again.
+func (cs *counterfactualSearch) featuresFor(state counterfactualState, weightStep float64) []float64 {
+	input, _ := cs.apply(state, weightStep)
+	features := append([]float64{}, cs.features...)
+	features[featureIndexSmokingStatus] = float64(input.SmokingStatus)
+	features[featureIndexActivity] = float64(input.PhysicalActivityFrequency)
+	if state.weightSteps > 0 {
+		features[featureIndexBMI] = cs.features[featureIndexBMI] * input.Weight / cs.current.Weight
+	}
+	features[featureIndexHypertension] = boolToFloat(input.IsHypertension)
+	features[featureIndexCholesterol] = boolToFloat(input.IsCholesterol)
+	return features
+}
+
+// apply returns the what-if input of a state and the fields it changes
+func (cs *counterfactualSearch) apply(state counterfactualState, weightStep float64) (models.WhatIfInput, []models.CounterfactualChange) {
+	input := cs.current
+	var changes []models.CounterfactualChange
+	if state.weightSteps > 0 {
+		input.Weight = math.Round((cs.current.Weight-float64(state.weightSteps)*weightStep)*10) / 10
+		changes = append(changes, models.CounterfactualChange{Field: models.SweepFieldWeight, From: cs.current.Weight, To: input.Weight})
+	}
+	if state.activitySteps > 0 {
+		input.PhysicalActivityFrequency += state.activitySteps
+		changes = append(changes, models.CounterfactualChange{
+			Field: models.SweepFieldPhysicalActivityFrequency,
+			From:  float64(cs.current.PhysicalActivityFrequency),
+			To:    float64(input.PhysicalActivityFrequency),
+		})
+	}
+	if state.quitSmoking {
+		input.SmokingStatus = 1
+		changes = append(changes, models.CounterfactualChange{Field: models.SweepFieldSmokingStatus, From: 2, To: 1})
+	}
+	if state.controlHyper {
+		input.IsHypertension = false
+		changes = append(changes, models.CounterfactualChange{Field: models.SweepFieldIsHypertension, From: 1, To: 0})
+	}
+	if state.controlChol {
+		input.IsCholesterol = false
+		changes = append(changes, models.CounterfactualChange{Field: models.SweepFieldIsCholesterol, From: 1, To: 0})
+	}
+	return input, changes
+}
+
+func (s *counterfactualService) score(ctx context.Context, userID uint, features []float64) (*ml.ScoreResult, error) {
+	itemCtx, cancel := context.WithTimeout(ctx, s.cfg.ItemTimeout)
+	defer cancel()
+	return s.scorer.Score(itemCtx, userID, features)
+}
+
+func boolToFloat(v bool) float64 {
+	if v {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,669 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"diabetify/internal/models"
+	"diabetify/internal/openai"
+	"diabetify/internal/repository"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrExplanationUnavailable is returned when no explainer is configured
+	ErrExplanationUnavailable = errors.New("prediction explanations are not configured")
+	// ErrExplanationQueueFull is returned when too many explanation jobs are waiting
+	ErrExplanationQueueFull = errors.New("explanation queue is full")
+	// ErrExplanationBudgetExceeded is returned when an LLM token budget is spent and there
+	// is no budget fallback
+	ErrExplanationBudgetExceeded = errors.New("LLM token budget exceeded")
+	// ErrLLMUsageUntracked is returned for usage reports when LLM usage is not stored
+	ErrLLMUsageUntracked = errors.New("LLM usage is not tracked")
+)
+
+// explanationHashVersion is part of the content hash; bump it when the prompt changes so
+// cached explanations are not reused for the new prompt
+const explanationHashVersion = "v2"
+
+// ExplanationConfig bounds explanation generation
+type ExplanationConfig struct {
+	// Concurrency is how many explanations are generated at the same time
+	Concurrency int
+	// QueueSize caps the explanation jobs waiting to run
+	QueueSize int
+	// Timeout bounds a single generation
+	Timeout time.Duration
+	// CacheTTL is how long a generated explanation is reused for identical inputs
+	CacheTTL time.Duration
+	// DailyTokenBudget caps the LLM tokens used per UTC day by everyone, and
+	// UserDailyTokenBudget those used for one user; zero means no limit
+	DailyTokenBudget     int
+	UserDailyTokenBudget int
+}
+
+// ExplanationConfigFromEnv reads EXPLANATION_CONCURRENCY, EXPLANATION_TIMEOUT
+// (e.g. "60s"), EXPLANATION_CACHE_TTL (e.g. "720h"), EXPLANATION_DAILY_TOKEN_BUDGET and
+// EXPLANATION_USER_DAILY_TOKEN_BUDGET
+func ExplanationConfigFromEnv() ExplanationConfig {
+	cfg := ExplanationConfig{
+		Concurrency: 2,
+		QueueSize:   500,
+		Timeout:     60 * time.Second,
+		CacheTTL:    30 * 24 * time.Hour,
+	}
+	if v, err := strconv.Atoi(os.Getenv("EXPLANATION_CONCURRENCY")); err == nil && v > 0 {
+		cfg.Concurrency = v
+	}
+	if v, err := time.ParseDuration(os.Getenv("EXPLANATION_TIMEOUT")); err == nil && v > 0 {
+		cfg.Timeout = v
+	}
+	if v, err := time.ParseDuration(os.Getenv("EXPLANATION_CACHE_TTL")); err == nil && v > 0 {
+		cfg.CacheTTL = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("EXPLANATION_DAILY_TOKEN_BUDGET")); err == nil && v >= 0 {
+		cfg.DailyTokenBudget = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("EXPLANATION_USER_DAILY_TOKEN_BUDGET")); err == nil && v >= 0 {
+		cfg.UserDailyTokenBudget = v
+	}
+	return cfg
+}
+
+// ExplanationCache stores generated explanations by content hash
+type ExplanationCache interface {
+	GetExplanation(hash string) (*models.PredictionExplanation, bool, error)
+	StoreExplanation(hash string, explanation *models.PredictionExplanation, duration time.Duration) error
+}
+
+// ExplanationService generates prediction explanations in the background. Each
+// explanation is a job of type models.JobTypeExplanation pointing at its prediction,
+// so its status can be polled like any other job.
+type ExplanationService interface {
+	Start()
+	// Stop waits for queued explanations to finish
+	Stop()
+	// Enqueue creates an explanation job for a saved prediction
+	Enqueue(prediction *models.Prediction) (*models.PredictionJob, error)
+	// LatestJob returns the newest explanation job of a prediction, or nil
+	LatestJob(prediction *models.Prediction) (*models.PredictionJob, error)
+	// Stats counts stored, rejected and blocked explanations since the service started
+	Stats() map[string]interface{}
+	// UsageReport sums the LLM calls made in [since, until) and shows today's budgets
+	UsageReport(since, until time.Time) (*LLMUsageReport, error)
+}
+
+// LLMUsageReport sums the LLM calls made for explanations
+type LLMUsageReport struct {
+	Since    time.Time                  `json:"since"`
+	Until    time.Time                  `json:"until"`
+	Totals   LLMUsageTotals             `json:"totals"`
+	Daily    []repository.LLMUsageTotal `json:"daily"`
+	TopUsers []repository.LLMUserUsage  `json:"top_users"`
+	Budget   LLMBudgetStatus            `json:"budget"`
+}
+
+// LLMUsageTotals sums every call of the report
+type LLMUsageTotals struct {
+	Calls            int64   `json:"calls"`
+	PromptTokens     int64   `json:"prompt_tokens"`
+	CompletionTokens int64   `json:"completion_tokens"`
+	TotalTokens      int64   `json:"total_tokens"`
+	CostEstimate     float64 `json:"cost_estimate_usd"`
+}
+
+// LLMBudgetStatus is the global budget of the current UTC day; budgets of zero are
+// unlimited and have no remaining tokens
+type LLMBudgetStatus struct {
+	DailyTokens     int  `json:"daily_tokens"`
+	UserDailyTokens int  `json:"user_daily_tokens"`
+	UsedToday       int  `json:"used_today"`
+	RemainingToday  *int `json:"remaining_today,omitempty"`
+	Exceeded        bool `json:"exceeded"`
+	// Fallbacks counts explanations written by the budget fallback since the server started
+	Fallbacks int `json:"fallbacks"`
+}
+
+type explanationTask struct {
+	job        *models.PredictionJob
+	prediction *models.Prediction
+}
+
+type explanationService struct {
+	jobRepo        repository.PredictionJobRepository
+	predRepo       repository.PredictionRepository
+	usageRepo      repository.LLMUsageRepository
+	explainer      openai.Explainer
+	budgetFallback openai.Explainer
+	cache          ExplanationCache
+	cfg            ExplanationConfig
+
+	queue chan explanationTask
+	wg    sync.WaitGroup
+	mu    sync.RWMutex
+	// stopped is set once the queue is closed
+	stopped bool
+
+	stats explanationStats
+}
+
+// explanationStats counts what happened to the explanations; checks are keyed by
+// openai.CheckSchema and its siblings
+type explanationStats struct {
+	mu sync.Mutex
+	// stored is keyed by provider
+	stored    map[string]int
+	cacheHits int
+	fallbacks int
+	// budgetFallbacks are explanations written by the budget fallback
+	budgetFallbacks int
+	// rejectedReplies are LLM replies that failed the checks and were asked again or
+	// replaced by the fallback
+	rejectedReplies map[string]int
+	// blocked are explanations that failed the checks and were not stored
+	blocked map[string]int
+	failed  int
+}
+
+// NewExplanationService creates the service; a nil explainer disables explanations, a
+// nil usage repository disables usage accounting and budgets, and a nil cache disables
+// caching. The budget fallback, usually a template explainer, answers instead of the
+// explainer while a token budget is spent; without one those explanations fail.
+func NewExplanationService(
+	jobRepo repository.PredictionJobRepository,
+	predRepo repository.PredictionRepository,
+	usageRepo repository.LLMUsageRepository,
+	explainer openai.Explainer,
+	budgetFallback openai.Explainer,
+	cache ExplanationCache,
+	cfg ExplanationConfig,
+) ExplanationService {
+	if cfg.Concurrency <= 0 {
+		cfg.Concurrency = 1
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = 1
+	}
+	return &explanationService{
+		jobRepo:        jobRepo,
+		predRepo:       predRepo,
+		usageRepo:      usageRepo,
+		explainer:      explainer,
+		budgetFallback: budgetFallback,
+		cache:          cache,
+		cfg:            cfg,
+		queue:          make(chan explanationTask, cfg.QueueSize),
+		stats: explanationStats{
+			stored:          make(map[string]int),
+			rejectedReplies: make(map[string]int),
+			blocked:         make(map[string]int),
+		},
+	}
+}
+
+func (s *explanationService) Start() {
+	for i := 0; i < s.cfg.Concurrency; i++ {
+		s.wg.Add(1)
+		go s.worker()
+	}
+	s.recoverPendingJobs()
+	s.recoverStuckJobs()
+}
+
+func (s *explanationService) Stop() {
+	s.mu.Lock()
+	if !s.stopped {
+		s.stopped = true
+		close(s.queue)
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+func (s *explanationService) Enqueue(prediction *models.Prediction) (*models.PredictionJob, error) {
+	if s.explainer == nil {
+		return nil, ErrExplanationUnavailable
+	}
+
+	predictionID := prediction.ID
+	now := time.Now()
+	job := &models.PredictionJob{
+		ID:           uuid.New().String(),
+		UserID:       prediction.UserID,
+		Status:       models.JobStatusPending,
+		JobType:      models.JobTypeExplanation,
+		PredictionID: &predictionID,
+		InputHash:    ExplanationContentHash(prediction),
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+	if err := s.jobRepo.SaveJob(job); err != nil {
+		return nil, fmt.Errorf("failed to create explanation job: %w", err)
+	}
+
+	// The caller keeps using its prediction, so the job works on a copy
+	copied := *prediction
+	copied.Factors = append([]models.PredictionFactor{}, prediction.Factors...)
+
+	if err := s.push(explanationTask{job: job, prediction: &copied}); err != nil {
+		errMsg := err.Error()
+		_ = s.jobRepo.UpdateJobStatus(job.ID, models.JobStatusFailed, &errMsg)
+		job.Status = models.JobStatusFailed
+		return job, err
+	}
+	return job, nil
+}
+
+func (s *explanationService) push(task explanationTask) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.stopped {
+		return ErrExplanationQueueFull
+	}
+	select {
+	case s.queue <- task:
+		return nil
+	default:
+		return ErrExplanationQueueFull
+	}
+}
+
+func (s *explanationService) LatestJob(prediction *models.Prediction) (*models.PredictionJob, error) {
+	job, err := s.jobRepo.FindLatestJobByPredictionID(prediction.UserID, models.JobTypeExplanation, prediction.ID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return job, nil
+}
+
+// recoverPendingJobs requeues explanation jobs left pending by a restart
+func (s *explanationService) recoverPendingJobs() {
+	if s.explainer == nil {
+		return
+	}
+	jobs, err := s.jobRepo.GetPendingJobs(50)
+	if err != nil {
+		return
+	}
+	for _, job := range jobs {
+		if job.JobType != models.JobTypeExplanation || job.PredictionID == nil {
+			continue
+		}
+		prediction, err := s.predRepo.GetPredictionByID(*job.PredictionID)
+		if err != nil {
+			errMsg := fmt.Sprintf("Prediction not found: %v", err)
+			_ = s.jobRepo.UpdateJobStatus(job.ID, models.JobStatusFailed, &errMsg)
+			continue
+		}
+		// Leave the rest pending for the next restart once the queue is full
+		if err := s.push(explanationTask{job: job, prediction: prediction}); err != nil {
+			return
+		}
+	}
+}
+
+// recoverStuckJobs requeues explanation jobs left processing by a restart. Another
+// instance may still be generating a job, so each is taken over only once it has not
+// been updated for Timeout.
+func (s *explanationService) recoverStuckJobs() {
+	if s.explainer == nil {
+		return
+	}
+	jobs, err := s.jobRepo.GetJobsByTypeAndStatus(models.JobTypeExplanation, models.JobStatusProcessing, 50)
+	if err != nil {
+		return
+	}
+	for _, job := range jobs {
+		if job.PredictionID == nil {
+			continue
+		}
+		staleAt := job.UpdatedAt.Add(s.cfg.Timeout)
+		time.AfterFunc(time.Until(staleAt), func() {
+			s.recoverStuckJob(job.ID, staleAt)
+		})
+	}
+}
+
+func (s *explanationService) recoverStuckJob(jobID string, staleAt time.Time) {
+	s.mu.RLock()
+	stopped := s.stopped
+	s.mu.RUnlock()
+	if stopped {
+		return
+	}
+
+	job, err := s.jobRepo.GetJobByID(jobID)
+	// Jobs that finished or were picked up again in the meantime are left alone
+	if err != nil || job.Status != models.JobStatusProcessing || job.UpdatedAt.Add(s.cfg.Timeout).After(staleAt) {
+		return
+	}
+	prediction, err := s.predRepo.GetPredictionByID(*job.PredictionID)
+	if err != nil {
+		errMsg := fmt.Sprintf("Prediction not found: %v", err)
+		_ = s.jobRepo.UpdateJobStatus(job.ID, models.JobStatusFailed, &errMsg)
+		return
+	}
+	if err := s.push(explanationTask{job: job, prediction: prediction}); err != nil {
+		errMsg := fmt.Sprintf("Explanation was interrupted and could not be restarted: %v", err)
+		_ = s.jobRepo.UpdateJobStatus(job.ID, models.JobStatusFailed, &errMsg)
+	}
+}
+
+func (s *explanationService) worker() {
+	defer s.wg.Done()
+	for task := range s.queue {
+		s.process(task)
+	}
+}
+
+func (s *explanationService) process(task explanationTask) {
+	jobID := task.job.ID
+	if err := s.jobRepo.UpdateJobStatus(jobID, models.JobStatusProcessing, nil); err != nil {
+		return
+	}
+
+	explanation, err := s.explain(task.prediction, jobID)
+	if err != nil {
+		var checkErr *openai.CheckError
+		errMsg := fmt.Sprintf("Failed to generate explanation: %v", err)
+		if errors.As(err, &checkErr) {
+			errMsg = fmt.Sprintf("Explanation failed safety checks: %v", checkErr)
+		}
+		s.stats.mu.Lock()
+		s.stats.failed++
+		s.stats.mu.Unlock()
+		_ = s.jobRepo.UpdateJobStatus(jobID, models.JobStatusFailed, &errMsg)
+		return
+	}
+
+	task.prediction.ApplyExplanation(explanation)
+	// The prediction was read when the job was queued; only the explanation is written back
+	if err := s.predRepo.SaveExplanation(task.prediction); err != nil {
+		errMsg := fmt.Sprintf("Failed to save explanation: %v", err)
+		_ = s.jobRepo.UpdateJobStatus(jobID, models.JobStatusFailed, &errMsg)
+		return
+	}
+	s.stats.mu.Lock()
+	s.stats.stored[explanation.Provider]++
+	s.stats.mu.Unlock()
+
+	_ = s.jobRepo.UpdateJobStatus(jobID, models.JobStatusCompleted, nil)
+}
+
+func (s *explanationService) Stats() map[string]interface{} {
+	s.stats.mu.Lock()
+	defer s.stats.mu.Unlock()
+	return map[string]interface{}{
+		"enabled":          s.explainer != nil,
+		"stored":           copyCounts(s.stats.stored),
+		"cache_hits":       s.stats.cacheHits,
+		"fallbacks":        s.stats.fallbacks,
+		"budget_fallbacks": s.stats.budgetFallbacks,
+		"rejected_replies": copyCounts(s.stats.rejectedReplies),
+		"blocked":          copyCounts(s.stats.blocked),
+		"failed":           s.stats.failed,
+	}
+}
+
+func copyCounts(counts map[string]int) map[string]int {
+	copied := make(map[string]int, len(counts))
+	for key, count := range counts {
+		copied[key] = count
+	}
+	return copied
+}
+
+// explain returns the cached explanation for the prediction's content, or generates and
+// caches a new one. Cache entries are per provider. Fallback answers are not cached so
+// the primary provider is tried again for the same content, and template answers are
+// cheap to rebuild. A generated explanation that fails openai.CheckExplanation is
+// returned as the *openai.CheckError and neither cached nor stored. While a token budget
+// is spent, cached explanations are still served and the budget fallback writes the rest.
+func (s *explanationService) explain(prediction *models.Prediction, jobID string) (*models.PredictionExplanation, error) {
+	cacheKey := s.explainer.Name() + ":" + ExplanationContentHash(prediction)
+	if s.cache != nil {
+		cached, found, err := s.cache.GetExplanation(cacheKey)
+		if err != nil {
+			fmt.Printf("Warning: Failed to read cached explanation %s: %v\n", cacheKey, err)
+		} else if found {
+			s.stats.mu.Lock()
+			s.stats.cacheHits++
+			s.stats.mu.Unlock()
+			return cached, nil
+		}
+	}
+
+	factors := make(map[string]openai.FactorInput, len(models.FeatureRegistry))
+	for _, feature := range models.FeatureRegistry {
+		factor := prediction.Factor(feature.Name)
+		if factor == nil {
+			factor = &models.PredictionFactor{Factor: feature.Name}
+		}
+		factors[feature.Name] = openai.FactorInput{
+			Value:        feature.FormatValue(factor.Value),
+			RawValue:     factor.Value,
+			Shap:         factor.Shap,
+			Contribution: factor.Contribution,
+			Impact:       factor.Impact,
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.cfg.Timeout)
+	defer cancel()
+
+	explainer := s.explainer
+	if reason := s.budgetExceeded(prediction.UserID); reason != "" {
+		if s.budgetFallback == nil {
+			return nil, fmt.Errorf("%w: %s", ErrExplanationBudgetExceeded, reason)
+		}
+		s.stats.mu.Lock()
+		s.stats.budgetFallbacks++
+		s.stats.mu.Unlock()
+		fmt.Printf("Warning: %s; explaining prediction %d with the %s provider\n", reason, prediction.ID, s.budgetFallback.Name())
+		explainer = s.budgetFallback
+	}
+
+	generated, err := explainer.Explain(ctx, prediction.RiskScore, factors)
+	if err != nil {
+		s.recordCalls(prediction, jobID, openai.CallsOf(err))
+		var invalid *openai.InvalidReplyError
+		if errors.As(err, &invalid) {
+			s.recordRejected(invalid.Checks())
+		}
+		return nil, err
+	}
+	s.recordCalls(prediction, jobID, generated.Calls)
+	s.recordRejected(generated.Rejected)
+	if generated.FallbackReason != "" {
+		s.stats.mu.Lock()
+		s.stats.fallbacks++
+		s.stats.mu.Unlock()
+		fmt.Printf("Warning: Explanation for prediction %d used the %s fallback: %s\n", prediction.ID, generated.Provider, generated.FallbackReason)
+	}
+
+	// Every provider is checked again here, so nothing unchecked reaches the database
+	if err := openai.CheckExplanation(generated, factors); err != nil {
+		var checkErr *openai.CheckError
+		if errors.As(err, &checkErr) {
+			s.stats.mu.Lock()
+			s.stats.blocked[checkErr.Check]++
+			s.stats.mu.Unlock()
+			fmt.Printf("Warning: Blocked explanation for prediction %d from %s: %v\n", prediction.ID, generated.Provider, checkErr)
+		}
+		return nil, err
+	}
+
+	explanation := &models.PredictionExplanation{
+		Summary:     generated.Summary,
+		Factors:     make(map[string]string, len(generated.Factors)),
+		Provider:    generated.Provider,
+		Model:       generated.Model,
+		GeneratedAt: time.Now(),
+	}
+	for factor, exp := range generated.Factors {
+		explanation.Factors[factor] = exp.Explanation
+	}
+
+	if s.cache != nil && generated.FallbackReason == "" && generated.Provider != openai.ProviderTemplate {
+		if err := s.cache.StoreExplanation(cacheKey, explanation, s.cfg.CacheTTL); err != nil {
+			fmt.Printf("Warning: Failed to cache explanation %s: %v\n", cacheKey, err)
+		}
+	}
+	return explanation, nil
+}
+
+// budgetExceeded says which token budget is spent for the user today, or is empty. Usage
+// that cannot be read counts as spent, so an outage does not lift the budgets. A call
+// started just under a budget may still go over it.
+func (s *explanationService) budgetExceeded(userID uint) string {
+	if s.usageRepo == nil || s.explainer.Name() == openai.ProviderTemplate {
+		return ""
+	}
+	today := startOfUTCDay(time.Now())
+	if s.cfg.DailyTokenBudget > 0 {
+		used, err := s.usageRepo.TotalTokensSince(0, today)
+		if err != nil {
+			return fmt.Sprintf("failed to read LLM usage: %v", err)
+		}
+		if used >= s.cfg.DailyTokenBudget {
+			return fmt.Sprintf("daily LLM token budget of %d is spent", s.cfg.DailyTokenBudget)
+		}
+	}
+	if s.cfg.UserDailyTokenBudget > 0 {
+		used, err := s.usageRepo.TotalTokensSince(userID, today)
+		if err != nil {
+			return fmt.Sprintf("failed to read LLM usage: %v", err)
+		}
+		if used >= s.cfg.UserDailyTokenBudget {
+			return fmt.Sprintf("daily LLM token budget of %d for user %d is spent", s.cfg.UserDailyTokenBudget, userID)
+		}
+	}
+	return ""
+}
+
+// recordCalls stores the LLM calls made for a prediction
+func (s *explanationService) recordCalls(prediction *models.Prediction, jobID string, calls []openai.Call) {
+	if s.usageRepo == nil {
+		return
+	}
+	for _, call := range calls {
+		usage := &models.LLMUsage{
+			UserID:           prediction.UserID,
+			PredictionID:     prediction.ID,
+			JobID:            jobID,
+			Provider:         call.Provider,
+			Model:            call.Model,
+			PromptTokens:     call.Usage.PromptTokens,
+			CompletionTokens: call.Usage.CompletionTokens,
+			TotalTokens:      call.Usage.TotalTokens,
+			LatencyMs:        call.Latency.Milliseconds(),
+			CostEstimate:     call.Cost,
+		}
+		if err := s.usageRepo.SaveUsage(usage); err != nil {
+			fmt.Printf("Warning: Failed to record LLM usage for prediction %d: %v\n", prediction.ID, err)
+		}
+	}
+}
+
+func (s *explanationService) UsageReport(since, until time.Time) (*LLMUsageReport, error) {
+	if s.usageRepo == nil {
+		return nil, ErrLLMUsageUntracked
+	}
+
+	daily, err := s.usageRepo.GetDailyTotals(since, until)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sum LLM usage: %w", err)
+	}
+	topUsers, err := s.usageRepo.GetTopUsers(since, until, 10)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sum LLM usage per user: %w", err)
+	}
+	usedToday, err := s.usageRepo.TotalTokensSince(0, startOfUTCDay(time.Now()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read today's LLM usage: %w", err)
+	}
+
+	report := &LLMUsageReport{
+		Since:    since,
+		Until:    until,
+		Daily:    daily,
+		TopUsers: topUsers,
+		Budget: LLMBudgetStatus{
+			DailyTokens:     s.cfg.DailyTokenBudget,
+			UserDailyTokens: s.cfg.UserDailyTokenBudget,
+			UsedToday:       usedToday,
+		},
+	}
+	if report.Daily == nil {
+		report.Daily = []repository.LLMUsageTotal{}
+	}
+	if report.TopUsers == nil {
+		report.TopUsers = []repository.LLMUserUsage{}
+	}
+	for _, total := range daily {
+		report.Totals.Calls += total.Calls
+		report.Totals.PromptTokens += total.PromptTokens
+		report.Totals.CompletionTokens += total.CompletionTokens
+		report.Totals.TotalTokens += total.TotalTokens
+		report.Totals.CostEstimate += total.CostEstimate
+	}
+	if budget := s.cfg.DailyTokenBudget; budget > 0 {
+		remaining := budget - usedToday
+		if remaining < 0 {
+			remaining = 0
+		}
+		report.Budget.RemainingToday = &remaining
+		report.Budget.Exceeded = usedToday >= budget
+	}
+	s.stats.mu.Lock()
+	report.Budget.Fallbacks = s.stats.budgetFallbacks
+	s.stats.mu.Unlock()
+	return report, nil
+}
+
+func startOfUTCDay(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+func (s *explanationService) recordRejected(checks []string) {
+	if len(checks) == 0 {
+		return
+	}
+	s.stats.mu.Lock()
+	defer s.stats.mu.Unlock()
+	for _, check := range checks {
+		s.stats.rejectedReplies[check]++
+	}
+}
+
+// ExplanationContentHash hashes what an explanation depends on: the risk score and the
+// value and SHAP value of every factor, in registry order
+func ExplanationContentHash(prediction *models.Prediction) string {
+	var content strings.Builder
+	content.WriteString(explanationHashVersion)
+	content.WriteString("|risk=")
+	content.WriteString(strconv.FormatFloat(prediction.RiskScore, 'g', -1, 64))
+	for _, feature := range models.FeatureRegistry {
+		var value, shap float64
+		if factor := prediction.Factor(feature.Name); factor != nil {
+			value, shap = factor.Value, factor.Shap
+		}
+		content.WriteString(fmt.Sprintf("|%s=%s:%s", feature.Name,
+			strconv.FormatFloat(value, 'g', -1, 64), strconv.FormatFloat(shap, 'g', -1, 64)))
+	}
+	sum := sha256.Sum256([]byte(content.String()))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,149 @@
+package services
+
+import (
+	"diabetify/internal/models"
+	"errors"
+	"sync"
+)
+
+// ErrJobQueueFull is returned by SubmitJob when the scheduler is at capacity
+var ErrJobQueueFull = errors.New("job queue is full, try again later")
+
+// jobPriorities lists the priority classes in the order workers drain them
+var jobPriorities = []string{
+	models.JobPriorityInteractive,
+	models.JobPriorityStandard,
+	models.JobPriorityBackground,
+}
+
+// JobScheduler queues prediction jobs by priority class. Within a class, users are
+// served round-robin so a user with many queued jobs cannot hold up everyone else.
+type JobScheduler struct {
+	mu       sync.Mutex
+	classes  map[string]*fairQueue
+	size     int
+	capacity int
+	// ready holds one token per queued job so Next can block without polling
+	ready chan struct{}
+}
+
+func NewJobScheduler(capacity int) *JobScheduler {
+	if capacity <= 0 {
+		capacity = 2000
+	}
+	s := &JobScheduler{
+		classes:  make(map[string]*fairQueue, len(jobPriorities)),
+		capacity: capacity,
+		ready:    make(chan struct{}, capacity),
+	}
+	for _, priority := range jobPriorities {
+		s.classes[priority] = newFairQueue()
+	}
+	return s
+}
+
+// Push queues the job, or returns ErrJobQueueFull without blocking
+func (s *JobScheduler) Push(job models.PredictionJobRequest) error {
+	s.mu.Lock()
+	if s.size >= s.capacity {
+		s.mu.Unlock()
+		return ErrJobQueueFull
+	}
+	s.classes[normalizePriority(job.Priority)].push(job)
+	s.size++
+	s.mu.Unlock()
+
+	s.ready <- struct{}{}
+	return nil
+}
+
+// Next blocks until a job is queued or stop is closed. It returns false on stop.
+func (s *JobScheduler) Next(stop <-chan struct{}) (models.PredictionJobRequest, bool) {
+	select {
+	case <-stop:
+		return models.PredictionJobRequest{}, false
+	case <-s.ready:
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, priority := range jobPriorities {
+		if job, ok := s.classes[priority].pop(); ok {
+			s.size--
+			return job, true
+		}
+	}
+	// Unreachable while every push adds exactly one token
+	return models.PredictionJobRequest{}, false
+}
+
+// Len returns the number of queued jobs
+func (s *JobScheduler) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.size
+}
+
+// Cap returns the maximum number of queued jobs
+func (s *JobScheduler) Cap() int {
+	return s.capacity
+}
+
+// Sizes returns the number of queued jobs per priority class
+func (s *JobScheduler) Sizes() map[string]int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sizes := make(map[string]int, len(s.classes))
+	for priority, queue := range s.classes {
+		sizes[priority] = queue.size
+	}
+	return sizes
+}
+
+func normalizePriority(priority string) string {
+	switch priority {
+	case models.JobPriorityInteractive, models.JobPriorityBackground:
+		return priority
+	default:
+		return models.JobPriorityStandard
+	}
+}
+
+// fairQueue keeps a FIFO per user and rotates between users on every pop
+type fairQueue struct {
+	users  []uint
+	byUser map[uint][]models.PredictionJobRequest
+	size   int
+}
+
+func newFairQueue() *fairQueue {
+	return &fairQueue{byUser: make(map[uint][]models.PredictionJobRequest)}
+}
+
+func (q *fairQueue) push(job models.PredictionJobRequest) {
+	if len(q.byUser[job.UserID]) == 0 {
+		q.users = append(q.users, job.UserID)
+	}
+	q.byUser[job.UserID] = append(q.byUser[job.UserID], job)
+	q.size++
+}
+
+func (q *fairQueue) pop() (models.PredictionJobRequest, bool) {
+	if len(q.users) == 0 {
+		return models.PredictionJobRequest{}, false
+	}
+	userID := q.users[0]
+	q.users = q.users[1:]
+
+	jobs := q.byUser[userID]
+	job := jobs[0]
+	if len(jobs) > 1 {
+		q.byUser[userID] = jobs[1:]
+		// Back of the line until every other waiting user has had a turn
+		q.users = append(q.users, userID)
+	} else {
+		delete(q.byUser, userID)
+	}
+	q.size--
+	return job, true
+}
@@ -0,0 +1,236 @@
+package services
+
+import (
+	"diabetify/internal/ml"
+	"diabetify/internal/models"
+	"diabetify/internal/monitoring"
+	"diabetify/internal/repository"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// experimentDecisionThreshold is the risk score at which two models are said to agree
+// or disagree on the same input
+const experimentDecisionThreshold = 0.5
+
+// experimentHistogramBins splits [0, 1] into equal-width score bins
+const experimentHistogramBins = 10
+
+// ModelExperimentService assigns users to model variants and reports how their score
+// distributions compare
+type ModelExperimentService interface {
+	Config() ml.ExperimentConfig
+	Assign(userID uint) ml.Assignment
+	RecordResult(result *models.ModelExperimentResult) error
+	// Report compares the scores of the configured experiment recorded since the given time
+	Report(since time.Time) (*ModelExperimentReport, error)
+}
+
+// VariantScoreSummary describes the score distribution of one variant. Shown and shadow
+// scores are summarised separately.
+type VariantScoreSummary struct {
+	Variant       string   `json:"variant"`
+	Shown         bool     `json:"shown"`
+	Count         int      `json:"count"`
+	ModelVersions []string `json:"model_versions"`
+	Mean          float64  `json:"mean"`
+	P10           float64  `json:"p10"`
+	P50           float64  `json:"p50"`
+	P90           float64  `json:"p90"`
+	// HighRiskRate is the share of scores at or above 0.5
+	HighRiskRate float64 `json:"high_risk_rate"`
+	// Histogram counts scores in 10 equal-width bins over [0, 1]
+	Histogram []int `json:"histogram"`
+}
+
+// ShadowComparison compares the control and candidate scores of the same jobs
+type ShadowComparison struct {
+	Pairs int `json:"pairs"`
+	// MeanDifference is candidate minus control
+	MeanDifference    float64 `json:"mean_difference"`
+	MeanAbsDifference float64 `json:"mean_abs_difference"`
+	MaxAbsDifference  float64 `json:"max_abs_difference"`
+	// Agreement is the share of pairs on the same side of 0.5
+	Agreement float64 `json:"agreement"`
+}
+
+// ModelExperimentReport compares the candidate's scores with the control's
+type ModelExperimentReport struct {
+	Experiment ml.ExperimentConfig   `json:"experiment"`
+	Since      time.Time             `json:"since"`
+	Variants   []VariantScoreSummary `json:"variants"`
+	// PSI and KS of the candidate's scores against the control's; nil until both have scores
+	PSI    *float64          `json:"psi,omitempty"`
+	KS     *float64          `json:"ks,omitempty"`
+	Shadow *ShadowComparison `json:"shadow,omitempty"`
+}
+
+type modelExperimentService struct {
+	repo repository.ModelExperimentRepository
+	cfg  ml.ExperimentConfig
+}
+
+func NewModelExperimentService(repo repository.ModelExperimentRepository, cfg ml.ExperimentConfig) ModelExperimentService {
+	return &modelExperimentService{repo: repo, cfg: cfg}
+}
+
+func (s *modelExperimentService) Config() ml.ExperimentConfig {
+	return s.cfg
+}
+
+func (s *modelExperimentService) Assign(userID uint) ml.Assignment {
+	return s.cfg.Assign(userID)
+}
+
+func (s *modelExperimentService) RecordResult(result *models.ModelExperimentResult) error {
+	result.Experiment = s.cfg.Name
+	result.Mode = s.cfg.Mode
+	return s.repo.SaveResult(result)
+}
+
+func (s *modelExperimentService) Report(since time.Time) (*ModelExperimentReport, error) {
+	results, err := s.repo.GetResults(s.cfg.Name, since)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load experiment results: %w", err)
+	}
+	report := BuildModelExperimentReport(results)
+	report.Experiment = s.cfg
+	report.Since = since
+	return report, nil
+}
+
+// BuildModelExperimentReport summarises each variant and compares the candidate with the
+// control. Distribution statistics use every score of each model, shown or not; the
+// shadow comparison pairs the two scores of each job.
+func BuildModelExperimentReport(results []models.ModelExperimentResult) *ModelExperimentReport {
+	type groupKey struct {
+		variant string
+		shown   bool
+	}
+	groups := make(map[groupKey][]models.ModelExperimentResult)
+	scoresByVariant := make(map[string][]float64)
+	byJob := make(map[string]map[string]float64)
+
+	for _, r := range results {
+		key := groupKey{r.Variant, r.Shown}
+		groups[key] = append(groups[key], r)
+		scoresByVariant[r.Variant] = append(scoresByVariant[r.Variant], r.RiskScore)
+		if r.JobID != "" {
+			if byJob[r.JobID] == nil {
+				byJob[r.JobID] = make(map[string]float64)
+			}
+			byJob[r.JobID][r.Variant] = r.RiskScore
+		}
+	}
+
+	report := &ModelExperimentReport{Variants: []VariantScoreSummary{}}
+	for key, group := range groups {
+		report.Variants = append(report.Variants, summarizeVariant(key.variant, key.shown, group))
+	}
+	// Control first, then shown before shadow
+	sort.Slice(report.Variants, func(i, j int) bool {
+		a, b := report.Variants[i], report.Variants[j]
+		if (a.Variant == ml.ControlVariant) != (b.Variant == ml.ControlVariant) {
+			return a.Variant == ml.ControlVariant
+		}
+		if a.Variant != b.Variant {
+			return a.Variant < b.Variant
+		}
+		return a.Shown && !b.Shown
+	})
+
+	control := scoresByVariant[ml.ControlVariant]
+	var candidate []float64
+	for variant, scores := range scoresByVariant {
+		if variant != ml.ControlVariant {
+			candidate = append(candidate, scores...)
+		}
+	}
+	if len(control) > 0 && len(candidate) > 0 {
+		psi := monitoring.PSI(control, candidate, 10)
+		ks := monitoring.KS(control, candidate)
+		report.PSI = &psi
+		report.KS = &ks
+	}
+
+	var shadow ShadowComparison
+	var sumDiff, sumAbs float64
+	agree := 0
+	for _, scores := range byJob {
+		controlScore, ok := scores[ml.ControlVariant]
+		if !ok || len(scores) < 2 {
+			continue
+		}
+		for variant, candidateScore := range scores {
+			if variant == ml.ControlVariant {
+				continue
+			}
+			diff := candidateScore - controlScore
+			abs := diff
+			if abs < 0 {
+				abs = -abs
+			}
+			shadow.Pairs++
+			sumDiff += diff
+			sumAbs += abs
+			if abs > shadow.MaxAbsDifference {
+				shadow.MaxAbsDifference = abs
+			}
+			if (controlScore >= experimentDecisionThreshold) == (candidateScore >= experimentDecisionThreshold) {
+				agree++
+			}
+		}
+	}
+	if shadow.Pairs > 0 {
+		n := float64(shadow.Pairs)
+		shadow.MeanDifference = sumDiff / n
+		shadow.MeanAbsDifference = sumAbs / n
+		shadow.Agreement = float64(agree) / n
+		report.Shadow = &shadow
+	}
+
+	return report
+}
+
+func summarizeVariant(variant string, shown bool, results []models.ModelExperimentResult) VariantScoreSummary {
+	summary := VariantScoreSummary{
+		Variant:   variant,
+		Shown:     shown,
+		Count:     len(results),
+		Histogram: make([]int, experimentHistogramBins),
+	}
+
+	scores := make([]float64, len(results))
+	versions := make(map[string]bool)
+	highRisk := 0
+	for i, r := range results {
+		scores[i] = r.RiskScore
+		versions[r.ModelVersion] = true
+		if r.RiskScore >= experimentDecisionThreshold {
+			highRisk++
+		}
+		bin := int(r.RiskScore * experimentHistogramBins)
+		if bin >= experimentHistogramBins {
+			bin = experimentHistogramBins - 1
+		}
+		if bin < 0 {
+			bin = 0
+		}
+		summary.Histogram[bin]++
+	}
+
+	for version := range versions {
+		summary.ModelVersions = append(summary.ModelVersions, version)
+	}
+	sort.Strings(summary.ModelVersions)
+
+	summary.Mean = monitoring.Mean(scores)
+	summary.P10 = monitoring.Quantile(scores, 0.1)
+	summary.P50 = monitoring.Quantile(scores, 0.5)
+	summary.P90 = monitoring.Quantile(scores, 0.9)
+	if len(scores) > 0 {
+		summary.HighRiskRate = float64(highRisk) / float64(len(scores))
+	}
+	return summary
+}
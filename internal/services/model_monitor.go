@@ -0,0 +1,286 @@
+package services
+
+import (
+	"diabetify/internal/ml"
+	"diabetify/internal/models"
+	"diabetify/internal/monitoring"
+	"diabetify/internal/repository"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// riskScoreFeature is the name used for the model output in drift reports
+const riskScoreFeature = "risk_score"
+
+// monitorBatchSize is how many predictions are loaded at a time; only their feature
+// values, scores and labels are kept
+const monitorBatchSize = 500
+
+// ModelMonitorConfig holds the reference window and alert thresholds
+type ModelMonitorConfig struct {
+	// ReferenceDays is the length of the window the report day is compared against
+	ReferenceDays int `json:"reference_days"`
+	// PSIThreshold flags a feature whose PSI reaches it
+	PSIThreshold float64 `json:"psi_threshold"`
+	// KSThreshold flags a feature whose KS statistic reaches it and is also significant
+	KSThreshold float64 `json:"ks_threshold"`
+	// MinSamples is required in both windows before drift is judged, and in labeled
+	// predictions before a low AUC raises an alert
+	MinSamples int `json:"min_samples"`
+	// MinAUC raises a performance alert when labeled AUC falls below it
+	MinAUC float64 `json:"min_auc"`
+	// CheckInterval is how often the monitor looks for a missing daily report
+	CheckInterval time.Duration `json:"-"`
+}
+
+// ModelMonitorConfigFromEnv reads MONITORING_REFERENCE_DAYS, MONITORING_PSI_THRESHOLD,
+// MONITORING_KS_THRESHOLD, MONITORING_MIN_SAMPLES and MONITORING_MIN_AUC
+func ModelMonitorConfigFromEnv() ModelMonitorConfig {
+	cfg := ModelMonitorConfig{
+		ReferenceDays: 28,
+		PSIThreshold:  0.2,
+		KSThreshold:   0.1,
+		MinSamples:    50,
+		MinAUC:        0.7,
+		CheckInterval: time.Hour,
+	}
+	if v, err := strconv.Atoi(os.Getenv("MONITORING_REFERENCE_DAYS")); err == nil && v > 0 {
+		cfg.ReferenceDays = v
+	}
+	if v, err := strconv.ParseFloat(os.Getenv("MONITORING_PSI_THRESHOLD"), 64); err == nil && v > 0 {
+		cfg.PSIThreshold = v
+	}
+	if v, err := strconv.ParseFloat(os.Getenv("MONITORING_KS_THRESHOLD"), 64); err == nil && v > 0 {
+		cfg.KSThreshold = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("MONITORING_MIN_SAMPLES")); err == nil && v > 0 {
+		cfg.MinSamples = v
+	}
+	if v, err := strconv.ParseFloat(os.Getenv("MONITORING_MIN_AUC"), 64); err == nil && v >= 0 {
+		cfg.MinAUC = v
+	}
+	return cfg
+}
+
+// ModelMonitor computes a daily report of input and score drift across all shards and,
+// where outcomes exist, AUC and calibration. Alerts are stored as notifications.
+type ModelMonitor interface {
+	Start()
+	Stop()
+
+	// RunForDate computes (or recomputes) the report for the given UTC day
+	RunForDate(date time.Time) (*models.ModelMonitoringReport, error)
+	GetReports(limit int) ([]models.ModelMonitoringReport, error)
+	Config() ModelMonitorConfig
+}
+
+type modelMonitor struct {
+	predRepo         repository.PredictionRepository
+	reportRepo       repository.ModelMonitoringRepository
+	notificationRepo repository.NotificationRepository
+	cfg              ModelMonitorConfig
+
+	stopChan chan struct{}
+	wg       sync.WaitGroup
+	mu       sync.Mutex
+	running  bool
+}
+
+func NewModelMonitor(
+	predRepo repository.PredictionRepository,
+	reportRepo repository.ModelMonitoringRepository,
+	notificationRepo repository.NotificationRepository,
+	cfg ModelMonitorConfig,
+) ModelMonitor {
+	return &modelMonitor{
+		predRepo:         predRepo,
+		reportRepo:       reportRepo,
+		notificationRepo: notificationRepo,
+		cfg:              cfg,
+		stopChan:         make(chan struct{}),
+	}
+}
+
+func (m *modelMonitor) Start() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.running {
+		return
+	}
+	m.running = true
+
+	m.wg.Add(1)
+	go m.loop()
+}
+
+func (m *modelMonitor) Stop() {
+	m.mu.Lock()
+	if !m.running {
+		m.mu.Unlock()
+		return
+	}
+	m.running = false
+	m.mu.Unlock()
+
+	close(m.stopChan)
+	m.wg.Wait()
+}
+
+func (m *modelMonitor) Config() ModelMonitorConfig {
+	return m.cfg
+}
+
+func (m *modelMonitor) GetReports(limit int) ([]models.ModelMonitoringReport, error) {
+	return m.reportRepo.GetRecentReports(limit)
+}
+
+// loop makes sure yesterday's report exists, checking every CheckInterval so a
+// restart or a failed run is caught up on the next tick
+func (m *modelMonitor) loop() {
+	defer m.wg.Done()
+	ticker := time.NewTicker(m.cfg.CheckInterval)
+	defer ticker.Stop()
+
+	for {
+		yesterday := truncateToDay(time.Now()).AddDate(0, 0, -1)
+		if existing, _ := m.reportRepo.GetReportByDate(yesterday); existing == nil {
+			if _, err := m.RunForDate(yesterday); err != nil {
+				fmt.Printf("Warning: Model monitoring for %s failed: %v\n", yesterday.Format("2006-01-02"), err)
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-m.stopChan:
+			return
+		}
+	}
+}
+
+func (m *modelMonitor) RunForDate(date time.Time) (*models.ModelMonitoringReport, error) {
+	day := truncateToDay(date)
+	referenceStart := day.AddDate(0, 0, -m.cfg.ReferenceDays)
+
+	names := append(append([]string{}, ml.FeatureNames...), riskScoreFeature)
+	reference := make([][]float64, len(names))
+	current := make([][]float64, len(names))
+	var scores, labels []float64
+
+	err := m.predRepo.EachPredictionCreatedBetween(referenceStart, day.AddDate(0, 0, 1), monitorBatchSize, func(predictions []models.Prediction) error {
+		for i := range predictions {
+			p := &predictions[i]
+			values := append(p.FeatureVector(), p.RiskScore)
+			target := reference
+			if !p.CreatedAt.Before(day) {
+				target = current
+			}
+			for f, v := range values {
+				target[f] = append(target[f], v)
+			}
+
+			if p.Outcome != nil {
+				if label, ok := p.Outcome.Label(); ok {
+					scores = append(scores, p.RiskScore)
+					labels = append(labels, label)
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load predictions: %w", err)
+	}
+
+	report := &models.ModelMonitoringReport{
+		Date:           day,
+		ReferenceStart: referenceStart,
+		ReferenceEnd:   day,
+		SampleCount:    len(current[0]),
+		ReferenceCount: len(reference[0]),
+		LabeledCount:   len(scores),
+	}
+
+	enough := report.SampleCount >= m.cfg.MinSamples && report.ReferenceCount >= m.cfg.MinSamples
+	for f, name := range names {
+		drift := models.FeatureDrift{
+			Feature:       name,
+			PSI:           monitoring.PSI(reference[f], current[f], 10),
+			KS:            monitoring.KS(reference[f], current[f]),
+			ReferenceMean: monitoring.Mean(reference[f]),
+			CurrentMean:   monitoring.Mean(current[f]),
+		}
+		ksSignificant := drift.KS >= m.cfg.KSThreshold &&
+			drift.KS >= monitoring.KSCritical(len(reference[f]), len(current[f]))
+		drift.Drifted = enough && (drift.PSI >= m.cfg.PSIThreshold || ksSignificant)
+		if drift.Drifted {
+			report.DriftDetected = true
+		}
+		report.Features = append(report.Features, drift)
+	}
+
+	if auc, ok := monitoring.AUC(scores, labels); ok {
+		report.AUC = &auc
+	}
+	if len(scores) > 0 {
+		bins, ece := monitoring.Calibration(scores, labels, 10)
+		report.Calibration = bins
+		report.CalibrationError = &ece
+	}
+
+	if err := m.reportRepo.SaveReport(report); err != nil {
+		return nil, fmt.Errorf("failed to save monitoring report: %w", err)
+	}
+
+	m.raiseAlerts(report)
+	return report, nil
+}
+
+func (m *modelMonitor) raiseAlerts(report *models.ModelMonitoringReport) {
+	day := report.Date.Format("2006-01-02")
+
+	if report.DriftDetected {
+		severity := models.NotificationSeverityWarning
+		var drifted []string
+		for _, f := range report.Features {
+			if !f.Drifted {
+				continue
+			}
+			drifted = append(drifted, fmt.Sprintf("%s (PSI %.3f, KS %.3f, mean %.2f -> %.2f)",
+				f.Feature, f.PSI, f.KS, f.ReferenceMean, f.CurrentMean))
+			if f.Feature == riskScoreFeature || f.PSI >= 2*m.cfg.PSIThreshold {
+				severity = models.NotificationSeverityCritical
+			}
+		}
+		m.notify(&models.Notification{
+			Type:     models.NotificationTypeModelDrift,
+			Severity: severity,
+			Title:    fmt.Sprintf("Model drift detected on %s", day),
+			Message: fmt.Sprintf("Compared with the previous %d days (%d predictions against %d): %s",
+				m.cfg.ReferenceDays, report.SampleCount, report.ReferenceCount, strings.Join(drifted, "; ")),
+		})
+	}
+
+	if report.AUC != nil && report.LabeledCount >= m.cfg.MinSamples && *report.AUC < m.cfg.MinAUC {
+		m.notify(&models.Notification{
+			Type:     models.NotificationTypeModelPerformance,
+			Severity: models.NotificationSeverityWarning,
+			Title:    fmt.Sprintf("Model AUC below %.2f on %s", m.cfg.MinAUC, day),
+			Message:  fmt.Sprintf("AUC %.3f over %d labeled predictions", *report.AUC, report.LabeledCount),
+		})
+	}
+}
+
+func (m *modelMonitor) notify(notification *models.Notification) {
+	if err := m.notificationRepo.SaveNotification(notification); err != nil {
+		fmt.Printf("Warning: Failed to save notification %q: %v\n", notification.Title, err)
+	}
+}
+
+func truncateToDay(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
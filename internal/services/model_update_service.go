@@ -0,0 +1,322 @@
+package services
+
+import (
+	"context"
+	"diabetify/internal/ml"
+	"diabetify/internal/models"
+	"diabetify/internal/repository"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrModelUpdateInProgress is returned when an update is already training
+	ErrModelUpdateInProgress = errors.New("a model update is already in progress")
+	// ErrNoTrainingDataSource is returned when no labeled data source is configured
+	ErrNoTrainingDataSource = errors.New("no labeled training data source is configured")
+	// ErrInsufficientTrainingData is returned when the consented labeled data is too small to train on
+	ErrInsufficientTrainingData = errors.New("not enough consented labeled data to update the model")
+)
+
+// TrainingDataSource supplies labeled examples from users who gave research consent
+type TrainingDataSource interface {
+	GetConsentedLabeledSamples(limit int) ([]models.LabeledSample, error)
+}
+
+// ModelUpdateConfig controls batch assembly and the promotion gate
+type ModelUpdateConfig struct {
+	// MinSamples is the smallest batch worth sending, across both splits
+	MinSamples int
+	// MaxSamples caps the batch size
+	MaxSamples int
+	// ValidationFraction of each class is held out for x_val/y_val
+	ValidationFraction float64
+	// MaxMetricDrop is how far AUC or PR-AUC may fall before promotion is refused
+	MaxMetricDrop float64
+	// Timeout bounds the whole train-and-promote run
+	Timeout time.Duration
+}
+
+// ModelUpdateConfigFromEnv reads ML_UPDATE_MIN_SAMPLES, ML_UPDATE_MAX_SAMPLES,
+// ML_UPDATE_VALIDATION_FRACTION and ML_UPDATE_MAX_METRIC_DROP
+func ModelUpdateConfigFromEnv() ModelUpdateConfig {
+	cfg := ModelUpdateConfig{
+		MinSamples:         50,
+		MaxSamples:         5000,
+		ValidationFraction: 0.2,
+		MaxMetricDrop:      0,
+		Timeout:            15 * time.Minute,
+	}
+	if v, err := strconv.Atoi(os.Getenv("ML_UPDATE_MIN_SAMPLES")); err == nil && v > 0 {
+		cfg.MinSamples = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("ML_UPDATE_MAX_SAMPLES")); err == nil && v > 0 {
+		cfg.MaxSamples = v
+	}
+	if v, err := strconv.ParseFloat(os.Getenv("ML_UPDATE_VALIDATION_FRACTION"), 64); err == nil && v > 0 && v < 1 {
+		cfg.ValidationFraction = v
+	}
+	if v, err := strconv.ParseFloat(os.Getenv("ML_UPDATE_MAX_METRIC_DROP"), 64); err == nil && v >= 0 {
+		cfg.MaxMetricDrop = v
+	}
+	return cfg
+}
+
+// ModelUpdateService runs admin-triggered incremental model updates. Each run trains a
+// candidate on the ML service as a dry run and only promotes it when neither AUC nor
+// PR-AUC regresses on the held-out split.
+type ModelUpdateService interface {
+	// StartUpdate assembles the training batch and starts the run in the background
+	StartUpdate(requestedBy uint, epochs int) (*models.ModelUpdate, error)
+	GetUpdate(id uint) (*models.ModelUpdate, error)
+	ListUpdates(limit int) ([]models.ModelUpdate, error)
+	// Wait blocks until the running update, if any, has finished
+	Wait()
+}
+
+type modelUpdateService struct {
+	updateRepo repository.ModelUpdateRepository
+	jobRepo    repository.PredictionJobRepository
+	source     TrainingDataSource
+	updater    ml.ModelUpdater
+	cfg        ModelUpdateConfig
+
+	mu      sync.Mutex
+	running bool
+	wg      sync.WaitGroup
+}
+
+// NewModelUpdateService creates the service; source may be nil until labeled data exists
+func NewModelUpdateService(
+	updateRepo repository.ModelUpdateRepository,
+	jobRepo repository.PredictionJobRepository,
+	source TrainingDataSource,
+	updater ml.ModelUpdater,
+	cfg ModelUpdateConfig,
+) ModelUpdateService {
+	return &modelUpdateService{
+		updateRepo: updateRepo,
+		jobRepo:    jobRepo,
+		source:     source,
+		updater:    updater,
+		cfg:        cfg,
+	}
+}
+
+func (s *modelUpdateService) StartUpdate(requestedBy uint, epochs int) (*models.ModelUpdate, error) {
+	if s.source == nil {
+		return nil, ErrNoTrainingDataSource
+	}
+
+	s.mu.Lock()
+	if s.running {
+		s.mu.Unlock()
+		return nil, ErrModelUpdateInProgress
+	}
+	s.running = true
+	s.mu.Unlock()
+
+	started := false
+	defer func() {
+		if !started {
+			s.finish()
+		}
+	}()
+
+	samples, err := s.source.GetConsentedLabeledSamples(s.cfg.MaxSamples)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load labeled data: %w", err)
+	}
+
+	request, positives, err := s.buildRequest(samples, epochs)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	job := &models.PredictionJob{
+		ID:        uuid.New().String(),
+		UserID:    requestedBy,
+		Status:    models.JobStatusPending,
+		JobType:   models.JobTypeModelUpdate,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if err := s.jobRepo.SaveJob(job); err != nil {
+		return nil, fmt.Errorf("failed to create model update job: %w", err)
+	}
+
+	update := &models.ModelUpdate{
+		JobID:             job.ID,
+		RequestedBy:       requestedBy,
+		Status:            models.ModelUpdateStatusPending,
+		Epochs:            epochs,
+		TrainingSamples:   len(request.YNew),
+		ValidationSamples: len(request.YVal),
+		PositiveSamples:   positives,
+	}
+	if err := s.updateRepo.SaveModelUpdate(update); err != nil {
+		errMsg := fmt.Sprintf("Failed to record model update: %v", err)
+		_ = s.jobRepo.UpdateJobStatus(job.ID, models.JobStatusFailed, &errMsg)
+		return nil, fmt.Errorf("failed to record model update: %w", err)
+	}
+
+	// The run works on its own copy so the caller can serialize the returned record
+	running := *update
+	started = true
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer s.finish()
+		s.run(&running, request)
+	}()
+
+	return update, nil
+}
+
+func (s *modelUpdateService) GetUpdate(id uint) (*models.ModelUpdate, error) {
+	return s.updateRepo.GetModelUpdateByID(id)
+}
+
+func (s *modelUpdateService) ListUpdates(limit int) ([]models.ModelUpdate, error) {
+	return s.updateRepo.GetRecentModelUpdates(limit)
+}
+
+func (s *modelUpdateService) Wait() {
+	s.wg.Wait()
+}
+
+func (s *modelUpdateService) finish() {
+	s.mu.Lock()
+	s.running = false
+	s.mu.Unlock()
+}
+
+// buildRequest splits the samples into training and validation sets, stratified by
+// label so both classes appear in the validation set that AUC is computed on
+func (s *modelUpdateService) buildRequest(samples []models.LabeledSample, epochs int) (*models.UpdateModelRequest, int, error) {
+	var positives, negatives []models.LabeledSample
+	for _, sample := range samples {
+		if len(sample.Features) != len(ml.FeatureNames) {
+			continue
+		}
+		if sample.Label >= 0.5 {
+			positives = append(positives, sample)
+		} else {
+			negatives = append(negatives, sample)
+		}
+	}
+
+	total := len(positives) + len(negatives)
+	if total < s.cfg.MinSamples || len(positives) < 2 || len(negatives) < 2 {
+		return nil, 0, fmt.Errorf("%w: %d usable samples (%d positive), need at least %d with both outcomes",
+			ErrInsufficientTrainingData, total, len(positives), s.cfg.MinSamples)
+	}
+
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	request := &models.UpdateModelRequest{Epochs: epochs, DryRun: true}
+	for _, group := range [][]models.LabeledSample{positives, negatives} {
+		rng.Shuffle(len(group), func(i, j int) { group[i], group[j] = group[j], group[i] })
+
+		holdout := int(float64(len(group)) * s.cfg.ValidationFraction)
+		if holdout < 1 {
+			holdout = 1
+		}
+		for i, sample := range group {
+			if i < holdout {
+				request.XVal = append(request.XVal, sample.Features)
+				request.YVal = append(request.YVal, sample.Label)
+			} else {
+				request.XNew = append(request.XNew, sample.Features)
+				request.YNew = append(request.YNew, sample.Label)
+			}
+		}
+	}
+
+	return request, len(positives), nil
+}
+
+func (s *modelUpdateService) run(update *models.ModelUpdate, request *models.UpdateModelRequest) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.cfg.Timeout)
+	defer cancel()
+	ctx = ml.WithCallType(ctx, ml.CallModelUpdate)
+
+	_ = s.jobRepo.UpdateJobStatus(update.JobID, models.JobStatusProcessing, nil)
+	update.Status = models.ModelUpdateStatusTraining
+	s.save(update)
+
+	result, err := s.updater.UpdateModel(ctx, request)
+	if err != nil {
+		s.fail(update, fmt.Sprintf("Model update failed: %v", err))
+		return
+	}
+
+	update.AUCBefore = result.AUCBefore
+	update.AUCAfter = result.AUCAfter
+	update.PRAUCBefore = result.PRAUCBefore
+	update.PRAUCAfter = result.PRAUCAfter
+	update.ElapsedTime = result.ElapsedTime
+	update.BaseModelVersion = result.ModelVersion
+	update.CandidateVersion = result.CandidateVersion
+
+	if result.CandidateVersion == "" {
+		s.fail(update, "ML service did not return a candidate version; it may not support dry-run updates")
+		return
+	}
+
+	if reason := s.regression(result); reason != "" {
+		update.Status = models.ModelUpdateStatusRejected
+		update.Reason = &reason
+		s.complete(update)
+		return
+	}
+
+	if _, err := s.updater.PromoteModel(ctx, result.CandidateVersion); err != nil {
+		s.fail(update, fmt.Sprintf("Candidate passed evaluation but promotion failed: %v", err))
+		return
+	}
+
+	update.Status = models.ModelUpdateStatusPromoted
+	update.Promoted = true
+	s.complete(update)
+}
+
+// regression describes why the candidate must not be promoted, or returns ""
+func (s *modelUpdateService) regression(result *models.UpdateModelResponse) string {
+	if result.AUCAfter < result.AUCBefore-s.cfg.MaxMetricDrop {
+		return fmt.Sprintf("AUC regressed from %.4f to %.4f", result.AUCBefore, result.AUCAfter)
+	}
+	if result.PRAUCAfter < result.PRAUCBefore-s.cfg.MaxMetricDrop {
+		return fmt.Sprintf("PR-AUC regressed from %.4f to %.4f", result.PRAUCBefore, result.PRAUCAfter)
+	}
+	return ""
+}
+
+func (s *modelUpdateService) complete(update *models.ModelUpdate) {
+	now := time.Now()
+	update.CompletedAt = &now
+	s.save(update)
+	_ = s.jobRepo.UpdateJobStatus(update.JobID, models.JobStatusCompleted, nil)
+}
+
+func (s *modelUpdateService) fail(update *models.ModelUpdate, reason string) {
+	now := time.Now()
+	update.Status = models.ModelUpdateStatusFailed
+	update.Reason = &reason
+	update.CompletedAt = &now
+	s.save(update)
+	_ = s.jobRepo.UpdateJobStatus(update.JobID, models.JobStatusFailed, &reason)
+}
+
+func (s *modelUpdateService) save(update *models.ModelUpdate) {
+	if err := s.updateRepo.UpdateModelUpdate(update); err != nil {
+		fmt.Printf("Warning: Failed to save model update %d: %v\n", update.ID, err)
+	}
+}
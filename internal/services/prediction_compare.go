@@ -0,0 +1,162 @@
+package services
+
+import (
+	"diabetify/internal/models"
+	"diabetify/internal/openai"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+const (
+	// compareSignificantShap is the smallest SHAP change named as a driver
+	compareSignificantShap = 0.005
+	// compareStableRisk is the largest risk change described as unchanged
+	compareStableRisk = 0.005
+	compareMaxDrivers = 3
+	compareMaxOffsets = 2
+	// compareLanguage is the template catalog language of the summary
+	compareLanguage = "id"
+)
+
+// ComparePredictions lists the change of the risk score and of every factor from one
+// prediction to another, with a summary of the main drivers in plain Indonesian
+func ComparePredictions(from, to *models.Prediction) *models.PredictionComparison {
+	definitions := openai.FeatureDefinitions()
+	fromFactors := from.FactorsByImportance()
+	toFactors := to.FactorsByImportance()
+
+	comparison := &models.PredictionComparison{
+		From:           comparedPrediction(from),
+		To:             comparedPrediction(to),
+		RiskScoreDelta: to.RiskScore - from.RiskScore,
+		ModelChanged:   from.ModelVersion != to.ModelVersion,
+		Factors:        make([]models.FactorDelta, len(toFactors)),
+	}
+	for i, factor := range toFactors {
+		before := fromFactors[i]
+		comparison.Factors[i] = models.FactorDelta{
+			Factor:            factor.Factor,
+			Alias:             definitions[factor.Factor].Alias,
+			FromValue:         before.Value,
+			ToValue:           factor.Value,
+			ValueChanged:      before.Value != factor.Value,
+			FromShap:          before.Shap,
+			ToShap:            factor.Shap,
+			ShapDelta:         factor.Shap - before.Shap,
+			FromContribution:  before.Contribution,
+			ToContribution:    factor.Contribution,
+			ContributionDelta: factor.Contribution - before.Contribution,
+		}
+	}
+	// Stable so that equal changes keep the global importance order
+	sort.SliceStable(comparison.Factors, func(i, j int) bool {
+		return math.Abs(comparison.Factors[i].ShapDelta) > math.Abs(comparison.Factors[j].ShapDelta)
+	})
+
+	comparison.Summary = summarizeComparison(comparison)
+	return comparison
+}
+
+func comparedPrediction(p *models.Prediction) models.ComparedPrediction {
+	return models.ComparedPrediction{
+		ID:           p.ID,
+		CreatedAt:    p.CreatedAt,
+		RiskScore:    p.RiskScore,
+		ModelVersion: p.ModelVersion,
+	}
+}
+
+func summarizeComparison(comparison *models.PredictionComparison) string {
+	var summary strings.Builder
+	fromRisk := formatPercentID(comparison.From.RiskScore)
+	toRisk := formatPercentID(comparison.To.RiskScore)
+
+	delta := comparison.RiskScoreDelta
+	if math.Abs(delta) < compareStableRisk {
+		summary.WriteString(fmt.Sprintf("Risiko diabetes Anda relatif tetap, dari %s menjadi %s.", fromRisk, toRisk))
+	} else {
+		direction := "naik"
+		if delta < 0 {
+			direction = "turun"
+		}
+		summary.WriteString(fmt.Sprintf("Risiko diabetes Anda %s dari %s menjadi %s.", direction, fromRisk, toRisk))
+	}
+
+	var drivers, offsets []string
+	for _, factor := range comparison.Factors {
+		if math.Abs(factor.ShapDelta) < compareSignificantShap {
+			continue
+		}
+		described := fmt.Sprintf("%s (%s)", factor.Alias, describeFactorChange(factor))
+		switch {
+		case math.Abs(delta) < compareStableRisk || (factor.ShapDelta > 0) == (delta > 0):
+			if len(drivers) < compareMaxDrivers {
+				drivers = append(drivers, described)
+			}
+		default:
+			if len(offsets) < compareMaxOffsets {
+				offsets = append(offsets, described)
+			}
+		}
+	}
+
+	switch {
+	case len(drivers) == 0:
+		summary.WriteString(" Tidak ada faktor yang berubah secara berarti.")
+	case math.Abs(delta) < compareStableRisk:
+		summary.WriteString(" Faktor yang berubah saling mengimbangi: " + strings.Join(drivers, ", ") + ".")
+	case delta > 0:
+		summary.WriteString(" Pendorong utama kenaikan: " + strings.Join(drivers, ", ") + ".")
+	default:
+		summary.WriteString(" Pendorong utama penurunan: " + strings.Join(drivers, ", ") + ".")
+	}
+	if len(offsets) > 0 {
+		if delta > 0 {
+			summary.WriteString(" Faktor yang menahan kenaikan: " + strings.Join(offsets, ", ") + ".")
+		} else {
+			summary.WriteString(" Faktor yang menahan penurunan: " + strings.Join(offsets, ", ") + ".")
+		}
+	}
+
+	if comparison.ModelChanged {
+		summary.WriteString(fmt.Sprintf(" Versi model juga berbeda (%s → %s), sehingga sebagian perubahan berasal dari pembaruan model.",
+			comparison.From.ModelVersion, comparison.To.ModelVersion))
+	}
+	return summary.String()
+}
+
+func describeFactorChange(factor models.FactorDelta) string {
+	if !factor.ValueChanged {
+		return "tetap " + formatFactorValue(factor.Factor, factor.ToValue)
+	}
+	return formatFactorValue(factor.Factor, factor.FromValue) + " → " + formatFactorValue(factor.Factor, factor.ToValue)
+}
+
+// formatFactorValue writes a factor value the way the user entered it, with the labels
+// and units of the Indonesian template catalog
+func formatFactorValue(factor string, value float64) string {
+	feature, ok := models.FeatureByName(factor)
+	if !ok {
+		return formatDecimalID(value, 1)
+	}
+	text, err := openai.FormatFeatureValue(compareLanguage, feature, value)
+	if err != nil {
+		return feature.FormatValue(value)
+	}
+	if feature.Type == models.FeatureTypeFloat {
+		// The summary writes decimals with a comma, like its percentages
+		text = strings.Replace(text, ".", ",", 1)
+	}
+	return text
+}
+
+func formatPercentID(risk float64) string {
+	return formatDecimalID(risk*100, 1) + "%"
+}
+
+// formatDecimalID formats with a decimal comma, as Indonesian readers expect
+func formatDecimalID(value float64, decimals int) string {
+	return strings.Replace(fmt.Sprintf("%.*f", decimals, value), ".", ",", 1)
+}
@@ -0,0 +1,167 @@
+package services
+
+import (
+	"diabetify/internal/models"
+	"diabetify/internal/repository"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Quota names reported in QuotaExceededError
+const (
+	QuotaActiveJobs       = "active_jobs"
+	QuotaDailyPredictions = "daily_predictions"
+	QuotaDailyWhatIfs     = "daily_what_if"
+	QuotaDailyBatchItems  = "daily_batch_items"
+)
+
+// PredictionQuotaConfig limits what one user may submit; a limit of 0 disables it
+type PredictionQuotaConfig struct {
+	// MaxActiveJobs caps the user's pending, processing and submitted jobs
+	MaxActiveJobs int `json:"max_active_jobs"`
+	// DailyPredictions and DailyWhatIfs cap jobs created per UTC day. Every point of a
+	// sweep and every scenario a counterfactual search scores counts as a what-if.
+	DailyPredictions int `json:"daily_predictions"`
+	DailyWhatIfs     int `json:"daily_what_if"`
+	// DailyBatchItems caps the batch items a user submits per UTC day
+	DailyBatchItems int `json:"daily_batch_items"`
+}
+
+// PredictionQuotaConfigFromEnv reads PREDICTION_MAX_ACTIVE_JOBS, PREDICTION_DAILY_LIMIT,
+// WHAT_IF_DAILY_LIMIT and BATCH_DAILY_ITEM_LIMIT
+func PredictionQuotaConfigFromEnv() PredictionQuotaConfig {
+	cfg := PredictionQuotaConfig{
+		MaxActiveJobs:    3,
+		DailyPredictions: 20,
+		DailyWhatIfs:     100,
+		DailyBatchItems:  50000,
+	}
+	if v, err := strconv.Atoi(os.Getenv("PREDICTION_MAX_ACTIVE_JOBS")); err == nil && v >= 0 {
+		cfg.MaxActiveJobs = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("PREDICTION_DAILY_LIMIT")); err == nil && v >= 0 {
+		cfg.DailyPredictions = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("WHAT_IF_DAILY_LIMIT")); err == nil && v >= 0 {
+		cfg.DailyWhatIfs = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("BATCH_DAILY_ITEM_LIMIT")); err == nil && v >= 0 {
+		cfg.DailyBatchItems = v
+	}
+	return cfg
+}
+
+// QuotaExceededError says which quota was hit and when it frees up. For the active job
+// quota ResetAt is zero: it frees up as soon as one of the user's jobs finishes.
+type QuotaExceededError struct {
+	Quota   string
+	Limit   int
+	Used    int64
+	ResetAt time.Time
+}
+
+func (e *QuotaExceededError) Error() string {
+	return fmt.Sprintf("%s quota of %d reached", e.Quota, e.Limit)
+}
+
+// PredictionQuota enforces per-user submission limits
+type PredictionQuota interface {
+	// Check returns a *QuotaExceededError when the user may not submit a job of this type
+	Check(userID uint, jobType string) error
+	// CheckUnits is Check for a job that uses units of the daily allowance at once, such
+	// as a batch of that many items or a sweep of that many points
+	CheckUnits(userID uint, jobType string, units int) error
+	// Remaining returns what is left of today's allowance for the job type, -1 if unlimited
+	Remaining(userID uint, jobType string) (int, error)
+	Config() PredictionQuotaConfig
+}
+
+type predictionQuota struct {
+	jobRepo repository.PredictionJobRepository
+	cfg     PredictionQuotaConfig
+	now     func() time.Time
+}
+
+func NewPredictionQuota(jobRepo repository.PredictionJobRepository, cfg PredictionQuotaConfig) PredictionQuota {
+	return &predictionQuota{jobRepo: jobRepo, cfg: cfg, now: time.Now}
+}
+
+func (q *predictionQuota) Config() PredictionQuotaConfig {
+	return q.cfg
+}
+
+func (q *predictionQuota) Check(userID uint, jobType string) error {
+	return q.CheckUnits(userID, jobType, 1)
+}
+
+func (q *predictionQuota) CheckUnits(userID uint, jobType string, units int) error {
+	// Batches run in the background at their own pace; only their daily items are capped
+	if q.cfg.MaxActiveJobs > 0 && jobType != models.JobTypeBatch {
+		active, err := q.jobRepo.GetActiveJobsCount(userID)
+		if err != nil {
+			return fmt.Errorf("failed to count active jobs: %w", err)
+		}
+		if active >= int64(q.cfg.MaxActiveJobs) {
+			return &QuotaExceededError{Quota: QuotaActiveJobs, Limit: q.cfg.MaxActiveJobs, Used: active}
+		}
+	}
+
+	quota, limit, jobTypes := q.dailyQuota(jobType)
+	if limit <= 0 {
+		return nil
+	}
+
+	today := truncateToDay(q.now())
+	used, err := q.jobRepo.CountQuotaUnitsSince(userID, jobTypes, today)
+	if err != nil {
+		return fmt.Errorf("failed to count today's jobs: %w", err)
+	}
+	if used+int64(units) > int64(limit) {
+		return &QuotaExceededError{Quota: quota, Limit: limit, Used: used, ResetAt: today.AddDate(0, 0, 1)}
+	}
+	return nil
+}
+
+func (q *predictionQuota) Remaining(userID uint, jobType string) (int, error) {
+	_, limit, jobTypes := q.dailyQuota(jobType)
+	if limit <= 0 {
+		return -1, nil
+	}
+	used, err := q.jobRepo.CountQuotaUnitsSince(userID, jobTypes, truncateToDay(q.now()))
+	if err != nil {
+		return 0, fmt.Errorf("failed to count today's jobs: %w", err)
+	}
+	if used >= int64(limit) {
+		return 0, nil
+	}
+	return limit - int(used), nil
+}
+
+// dailyQuota returns the daily allowance a job type draws from and every job type
+// sharing it. Sweeps and counterfactual searches are what-ifs scored many times over.
+func (q *predictionQuota) dailyQuota(jobType string) (string, int, []string) {
+	switch jobType {
+	case models.JobTypeWhatIf, models.JobTypeWhatIfSweep, models.JobTypeCounterfactual:
+		return QuotaDailyWhatIfs, q.cfg.DailyWhatIfs,
+			[]string{models.JobTypeWhatIf, models.JobTypeWhatIfSweep, models.JobTypeCounterfactual}
+	case models.JobTypeBatch:
+		return QuotaDailyBatchItems, q.cfg.DailyBatchItems, []string{models.JobTypeBatch}
+	default:
+		return QuotaDailyPredictions, q.cfg.DailyPredictions, []string{jobType}
+	}
+}
+
+// checkQuotaUnits returns a *QuotaExceededError from the quota and lets the job through
+// when the count itself fails, like the prediction endpoints do
+func checkQuotaUnits(quota PredictionQuota, userID uint, jobType string, units int) error {
+	err := quota.CheckUnits(userID, jobType, units)
+	var exceeded *QuotaExceededError
+	if err != nil && !errors.As(err, &exceeded) {
+		fmt.Printf("Warning: Quota check failed for user %d: %v\n", userID, err)
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,105 @@
+package services
+
+import (
+	"context"
+	"diabetify/internal/ml"
+	"diabetify/internal/models"
+	"errors"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// jobTypeQueuedScore is the worker job type of a QueuedScorer call. These jobs only live
+// in the scheduler; the sweep or search that makes the call keeps its own job record.
+const jobTypeQueuedScore = "queued_score"
+
+// QueuedScorer scores features on the worker queue at background priority, so sweeps and
+// counterfactual searches share the workers with every other job instead of calling
+// the ML service next to them
+type QueuedScorer interface {
+	Score(ctx context.Context, userID uint, features []float64) (*ml.ScoreResult, error)
+}
+
+type queuedScoreCall struct {
+	ctx      context.Context
+	features []float64
+	done     chan queuedScoreResult
+}
+
+type queuedScoreResult struct {
+	score *ml.ScoreResult
+	err   error
+}
+
+type queuedScorer struct {
+	queue     JobQueue
+	predictor ml.SyncPredictor
+
+	mu      sync.Mutex
+	pending map[string]*queuedScoreCall
+}
+
+func NewQueuedScorer(queue JobQueue, predictor ml.SyncPredictor) QueuedScorer {
+	s := &queuedScorer{
+		queue:     queue,
+		predictor: predictor,
+		pending:   make(map[string]*queuedScoreCall),
+	}
+	queue.RegisterJobHandler(jobTypeQueuedScore, s.handle)
+	return s
+}
+
+// Score waits for a worker to score the features or for ctx to end. ErrJobQueueFull is
+// returned as is when the scheduler has no room.
+func (s *queuedScorer) Score(ctx context.Context, userID uint, features []float64) (*ml.ScoreResult, error) {
+	id := uuid.New().String()
+	call := &queuedScoreCall{ctx: ctx, features: features, done: make(chan queuedScoreResult, 1)}
+	s.mu.Lock()
+	s.pending[id] = call
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.pending, id)
+		s.mu.Unlock()
+	}()
+
+	err := s.queue.SubmitJob(models.PredictionJobRequest{
+		JobID:    id,
+		UserID:   userID,
+		JobType:  jobTypeQueuedScore,
+		Priority: models.JobPriorityBackground,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case result := <-call.done:
+		return result.score, result.err
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, ml.ErrSyncPredictionTimeout
+		}
+		return nil, ctx.Err()
+	}
+}
+
+func (s *queuedScorer) handle(ctx context.Context, jobRequest models.PredictionJobRequest) {
+	s.mu.Lock()
+	call, ok := s.pending[jobRequest.JobID]
+	s.mu.Unlock()
+	// Calls whose caller gave up while queued are not scored
+	if !ok || call.ctx.Err() != nil {
+		return
+	}
+
+	// Score under the caller's deadline, and stop early when the worker shuts down
+	scoreCtx, cancel := context.WithCancel(call.ctx)
+	defer cancel()
+	stop := context.AfterFunc(ctx, cancel)
+	defer stop()
+
+	score, err := s.predictor.PredictSync(scoreCtx, call.features)
+	call.done <- queuedScoreResult{score: score, err: err}
+}
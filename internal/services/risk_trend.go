@@ -0,0 +1,318 @@
+package services
+
+import (
+	"diabetify/internal/models"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+const (
+	// trendMinShift is the smallest change of the mean risk reported as a change point
+	trendMinShift = 0.05
+	// trendMinSegment is the fewest predictions on each side of a change point
+	trendMinSegment      = 2
+	trendMaxChangePoints = 5
+	// TrendActivityWindow is how far before each prediction activity is compared
+	TrendActivityWindow = 28 * 24 * time.Hour
+	// trendActivityMinChange is the relative change of a weekly activity rate worth annotating
+	trendActivityMinChange = 0.25
+)
+
+// tQuantiles95 holds the two-sided 95% quantiles of Student's t for 1 to 30 degrees of freedom
+var tQuantiles95 = []float64{
+	12.706, 4.303, 3.182, 2.776, 2.571, 2.447, 2.365, 2.306, 2.262, 2.228,
+	2.201, 2.179, 2.160, 2.145, 2.131, 2.120, 2.110, 2.101, 2.093, 2.086,
+	2.080, 2.074, 2.069, 2.064, 2.060, 2.056, 2.052, 2.048, 2.045, 2.042,
+}
+
+// RiskTrendOptions selects the range and smoothing of a trend analysis
+type RiskTrendOptions struct {
+	StartDate time.Time
+	EndDate   time.Time
+	// Interval is models.TrendIntervalWeek or models.TrendIntervalMonth
+	Interval string
+	// Window is the number of predictions in the trailing moving average
+	Window int
+}
+
+// AnalyzeRiskTrend aggregates, smooths and fits a user's predictions and finds the points
+// where the mean risk shifted. Activities should reach TrendActivityWindow before the
+// start date so the first change point can be annotated.
+func AnalyzeRiskTrend(predictions []models.Prediction, activities []models.Activity, opts RiskTrendOptions) *models.RiskTrend {
+	sorted := append([]models.Prediction{}, predictions...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].CreatedAt.Before(sorted[j].CreatedAt)
+	})
+
+	trend := &models.RiskTrend{
+		StartDate:    opts.StartDate,
+		EndDate:      opts.EndDate,
+		Interval:     opts.Interval,
+		Window:       opts.Window,
+		Points:       make([]models.RiskTrendPoint, len(sorted)),
+		Aggregates:   []models.RiskTrendAggregate{},
+		ChangePoints: []models.RiskChangePoint{},
+	}
+
+	scores := make([]float64, len(sorted))
+	sum := 0.0
+	for i, prediction := range sorted {
+		scores[i] = prediction.RiskScore
+		sum += prediction.RiskScore
+		if i >= opts.Window {
+			sum -= scores[i-opts.Window]
+		}
+		trend.Points[i] = models.RiskTrendPoint{
+			PredictionID:  prediction.ID,
+			CreatedAt:     prediction.CreatedAt,
+			RiskScore:     prediction.RiskScore,
+			MovingAverage: sum / float64(min(i+1, opts.Window)),
+		}
+	}
+
+	trend.Aggregates = aggregateRiskTrend(trend.Points, opts.Interval)
+	trend.Trend = fitRiskTrend(trend.Points)
+
+	changes := detectChangePoints(scores)
+	for c, index := range changes {
+		// The means are taken up to the neighbouring change points
+		start, end := 0, len(scores)
+		if c > 0 {
+			start = changes[c-1]
+		}
+		if c < len(changes)-1 {
+			end = changes[c+1]
+		}
+		before, after := mean(scores[start:index]), mean(scores[index:end])
+
+		previous, current := &sorted[index-1], &sorted[index]
+		trend.ChangePoints = append(trend.ChangePoints, models.RiskChangePoint{
+			PredictionID: current.ID,
+			At:           current.CreatedAt,
+			WindowStart:  previous.CreatedAt,
+			BeforeMean:   before,
+			AfterMean:    after,
+			Shift:        after - before,
+			Annotations:  annotateChangePoint(previous, current, activities),
+		})
+	}
+	return trend
+}
+
+func aggregateRiskTrend(points []models.RiskTrendPoint, interval string) []models.RiskTrendAggregate {
+	aggregates := []models.RiskTrendAggregate{}
+	for _, point := range points {
+		start, end := trendPeriod(point.CreatedAt, interval)
+		last := len(aggregates) - 1
+		if last < 0 || !aggregates[last].PeriodStart.Equal(start) {
+			aggregates = append(aggregates, models.RiskTrendAggregate{
+				PeriodStart: start,
+				PeriodEnd:   end,
+				Min:         point.RiskScore,
+				Max:         point.RiskScore,
+			})
+			last++
+		}
+		aggregate := &aggregates[last]
+		aggregate.Mean = (aggregate.Mean*float64(aggregate.Count) + point.RiskScore) / float64(aggregate.Count+1)
+		aggregate.Count++
+		aggregate.Min = math.Min(aggregate.Min, point.RiskScore)
+		aggregate.Max = math.Max(aggregate.Max, point.RiskScore)
+	}
+	return aggregates
+}
+
+// trendPeriod returns the week (from Monday) or month containing t
+func trendPeriod(t time.Time, interval string) (time.Time, time.Time) {
+	if interval == models.TrendIntervalMonth {
+		start := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+		return start, start.AddDate(0, 1, 0)
+	}
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	start := day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+	return start, start.AddDate(0, 0, 7)
+}
+
+// fitRiskTrend fits risk against days by least squares and sets the confidence band of
+// every point. It returns nil when there are fewer than three points on distinct days.
+func fitRiskTrend(points []models.RiskTrendPoint) *models.RiskTrendLine {
+	n := len(points)
+	if n < 3 {
+		return nil
+	}
+
+	xs := make([]float64, n)
+	var xMean, yMean float64
+	for i, point := range points {
+		xs[i] = point.CreatedAt.Sub(points[0].CreatedAt).Hours() / 24
+		xMean += xs[i]
+		yMean += point.RiskScore
+	}
+	xMean /= float64(n)
+	yMean /= float64(n)
+
+	var sxx, sxy float64
+	for i, point := range points {
+		sxx += (xs[i] - xMean) * (xs[i] - xMean)
+		sxy += (xs[i] - xMean) * (point.RiskScore - yMean)
+	}
+	if sxx == 0 {
+		return nil
+	}
+	slope := sxy / sxx
+	intercept := yMean - slope*xMean
+
+	var sse float64
+	for i, point := range points {
+		residual := point.RiskScore - (intercept + slope*xs[i])
+		sse += residual * residual
+	}
+	s := math.Sqrt(sse / float64(n-2))
+	t := 1.96
+	if n-2 <= len(tQuantiles95) {
+		t = tQuantiles95[n-3]
+	}
+
+	for i := range points {
+		fitted := intercept + slope*xs[i]
+		margin := t * s * math.Sqrt(1/float64(n)+(xs[i]-xMean)*(xs[i]-xMean)/sxx)
+		lower, upper := fitted-margin, fitted+margin
+		points[i].Fitted, points[i].Lower, points[i].Upper = &fitted, &lower, &upper
+	}
+
+	slopeMargin := t * s / math.Sqrt(sxx)
+	line := &models.RiskTrendLine{
+		SlopePer30Days: slope * 30,
+		SlopeLower:     (slope - slopeMargin) * 30,
+		SlopeUpper:     (slope + slopeMargin) * 30,
+		Direction:      models.TrendStable,
+	}
+	switch {
+	case line.SlopeLower > 0:
+		line.Direction = models.TrendRising
+	case line.SlopeUpper < 0:
+		line.Direction = models.TrendFalling
+	}
+	return line
+}
+
+// detectChangePoints finds shifts of the mean by binary segmentation: each segment is
+// split where the split most reduces the squared error, as long as the means on either
+// side differ by at least trendMinShift. It returns the index of the first score after
+// each change, ascending.
+func detectChangePoints(scores []float64) []int {
+	var changes []int
+	var split func(lo, hi int)
+	split = func(lo, hi int) {
+		if len(changes) >= trendMaxChangePoints || hi-lo < 2*trendMinSegment {
+			return
+		}
+		best, bestGain := -1, 0.0
+		total := sse(scores[lo:hi])
+		for k := lo + trendMinSegment; k <= hi-trendMinSegment; k++ {
+			gain := total - sse(scores[lo:k]) - sse(scores[k:hi])
+			if gain > bestGain {
+				best, bestGain = k, gain
+			}
+		}
+		if best < 0 || math.Abs(mean(scores[best:hi])-mean(scores[lo:best])) < trendMinShift {
+			return
+		}
+		changes = append(changes, best)
+		split(lo, best)
+		split(best, hi)
+	}
+	split(0, len(scores))
+	sort.Ints(changes)
+	return changes
+}
+
+func mean(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
+
+func sse(values []float64) float64 {
+	m := mean(values)
+	total := 0.0
+	for _, v := range values {
+		total += (v - m) * (v - m)
+	}
+	return total
+}
+
+// annotateChangePoint lists the inputs that differ between the predictions on either
+// side of a change, and the activity types whose weekly total in the TrendActivityWindow
+// before each prediction changed notably
+func annotateChangePoint(previous, current *models.Prediction, activities []models.Activity) []models.RiskChangeAnnotation {
+	annotations := []models.RiskChangeAnnotation{}
+
+	before, after := previous.FactorsByImportance(), current.FactorsByImportance()
+	for i, factor := range after {
+		// Age moves on its own and is not something the user edited
+		if factor.Factor == "age" || factor.Value == before[i].Value {
+			continue
+		}
+		annotations = append(annotations, models.RiskChangeAnnotation{
+			Kind:        models.AnnotationProfile,
+			Field:       factor.Factor,
+			From:        before[i].Value,
+			To:          factor.Value,
+			Description: fmt.Sprintf("%s changed from %g to %g", factor.Factor, before[i].Value, factor.Value),
+		})
+	}
+
+	beforeRates := weeklyActivityRates(activities, previous.CreatedAt)
+	afterRates := weeklyActivityRates(activities, current.CreatedAt)
+	types := make([]string, 0, len(beforeRates)+len(afterRates))
+	for activityType := range beforeRates {
+		types = append(types, activityType)
+	}
+	for activityType := range afterRates {
+		if _, ok := beforeRates[activityType]; !ok {
+			types = append(types, activityType)
+		}
+	}
+	sort.Strings(types)
+
+	for _, activityType := range types {
+		from, to := beforeRates[activityType], afterRates[activityType]
+		if from == to || (from > 0 && math.Abs(to-from)/from < trendActivityMinChange) {
+			continue
+		}
+		direction := "rose"
+		if to < from {
+			direction = "fell"
+		}
+		annotations = append(annotations, models.RiskChangeAnnotation{
+			Kind:        models.AnnotationActivity,
+			Field:       activityType,
+			From:        from,
+			To:          to,
+			Description: fmt.Sprintf("%s %s from %.1f to %.1f per week", activityType, direction, from, to),
+		})
+	}
+	return annotations
+}
+
+// weeklyActivityRates totals activity values per type in the window before t, per week
+func weeklyActivityRates(activities []models.Activity, t time.Time) map[string]float64 {
+	weeks := TrendActivityWindow.Hours() / (24 * 7)
+	start := t.Add(-TrendActivityWindow)
+	rates := map[string]float64{}
+	for _, activity := range activities {
+		if activity.ActivityDate.Before(start) || !activity.ActivityDate.Before(t) {
+			continue
+		}
+		rates[activity.ActivityType] += float64(activity.Value) / weeks
+	}
+	return rates
+}
@@ -0,0 +1,345 @@
+package services
+
+import (
+	"context"
+	"diabetify/internal/ml"
+	"diabetify/internal/models"
+	"diabetify/internal/repository"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrInvalidSweep is returned for axes that cannot be expanded into points
+	ErrInvalidSweep = errors.New("invalid sweep")
+	// ErrSweepTooLarge is returned when the axes expand into more than MaxPoints points
+	ErrSweepTooLarge = errors.New("sweep exceeds the maximum number of points")
+	// ErrSweepFeatures is returned when the user's profile cannot produce model input
+	ErrSweepFeatures = errors.New("cannot build features for sweep")
+)
+
+// WhatIfSweepConfig bounds the fan-out of a sweep
+type WhatIfSweepConfig struct {
+	// Concurrency is how many points of one sweep are scored at the same time
+	Concurrency int
+	// MaxPoints caps the number of points, the product of the axis lengths. It is lowered
+	// to what Concurrency can score within Timeout when every point takes ItemTimeout.
+	MaxPoints int
+	// ItemTimeout bounds the scoring of a single point, including its wait for a worker
+	ItemTimeout time.Duration
+	// Timeout bounds the whole sweep; it runs inside the request, so keep it under the
+	// server's write timeout
+	Timeout time.Duration
+}
+
+// WhatIfSweepConfigFromEnv reads WHAT_IF_SWEEP_CONCURRENCY, WHAT_IF_SWEEP_MAX_POINTS,
+// WHAT_IF_SWEEP_ITEM_TIMEOUT and WHAT_IF_SWEEP_TIMEOUT
+func WhatIfSweepConfigFromEnv() WhatIfSweepConfig {
+	cfg := WhatIfSweepConfig{
+		Concurrency: 4,
+		MaxPoints:   121,
+		ItemTimeout: 750 * time.Millisecond,
+		Timeout:     25 * time.Second,
+	}
+	if v, err := strconv.Atoi(os.Getenv("WHAT_IF_SWEEP_CONCURRENCY")); err == nil && v > 0 {
+		cfg.Concurrency = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("WHAT_IF_SWEEP_MAX_POINTS")); err == nil && v > 0 {
+		cfg.MaxPoints = v
+	}
+	if v, err := time.ParseDuration(os.Getenv("WHAT_IF_SWEEP_ITEM_TIMEOUT")); err == nil && v > 0 {
+		cfg.ItemTimeout = v
+	}
+	if v, err := time.ParseDuration(os.Getenv("WHAT_IF_SWEEP_TIMEOUT")); err == nil && v > 0 {
+		cfg.Timeout = v
+	}
+	return cfg
+}
+
+// WhatIfFeatureSource builds the model input a what-if job with this input would send
+type WhatIfFeatureSource interface {
+	FeaturesForWhatIf(userID uint, input *models.WhatIfInput) ([]float64, error)
+}
+
+// SweepFeatureSource builds the model input of every point of a sweep at once
+type SweepFeatureSource interface {
+	FeaturesForWhatIfs(userID uint, inputs []models.WhatIfInput) ([][]float64, error)
+}
+
+// WhatIfSweepService scores a what-if input over a range of one or two fields. The
+// sweep is a parent job and every point a child job, scored on the worker queue at
+// background priority; every point counts against the daily what-if quota.
+type WhatIfSweepService interface {
+	Run(ctx context.Context, userID uint, request *models.WhatIfSweepRequest) (*models.WhatIfSweepResult, error)
+}
+
+type whatIfSweepService struct {
+	jobRepo  repository.PredictionJobRepository
+	features SweepFeatureSource
+	scorer   QueuedScorer
+	quota    PredictionQuota
+	cfg      WhatIfSweepConfig
+}
+
+func NewWhatIfSweepService(
+	jobRepo repository.PredictionJobRepository,
+	features SweepFeatureSource,
+	scorer QueuedScorer,
+	quota PredictionQuota,
+	cfg WhatIfSweepConfig,
+) WhatIfSweepService {
+	if cfg.Concurrency <= 0 {
+		cfg.Concurrency = 1
+	}
+	if cfg.ItemTimeout <= 0 {
+		cfg.ItemTimeout = 750 * time.Millisecond
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 25 * time.Second
+	}
+	if limit := cfg.Concurrency * int(cfg.Timeout/cfg.ItemTimeout); cfg.MaxPoints > limit {
+		fmt.Printf("Warning: What-if sweeps limited to %d points to finish within %s\n", limit, cfg.Timeout)
+		cfg.MaxPoints = limit
+	}
+	return &whatIfSweepService{
+		jobRepo:  jobRepo,
+		features: features,
+		scorer:   scorer,
+		quota:    quota,
+		cfg:      cfg,
+	}
+}
+
+// sweepField describes how an axis value is written into a WhatIfInput
+type sweepField struct {
+	min, max float64
+	integral bool
+	set      func(input *models.WhatIfInput, value float64)
+}
+
+var sweepFields = map[string]sweepField{
+	models.SweepFieldWeight: {min: 1, max: 500, set: func(in *models.WhatIfInput, v float64) {
+		in.Weight = v
+	}},
+	models.SweepFieldPhysicalActivityFrequency: {min: 0, max: 7, integral: true, set: func(in *models.WhatIfInput, v float64) {
+		in.PhysicalActivityFrequency = int(v)
+	}},
+	models.SweepFieldSmokingStatus: {min: 0, max: 2, integral: true, set: func(in *models.WhatIfInput, v float64) {
+		in.SmokingStatus = int(v)
+	}},
+	models.SweepFieldYearsOfSmoking: {min: 0, max: 100, integral: true, set: func(in *models.WhatIfInput, v float64) {
+		in.YearsOfSmoking = int(v)
+	}},
+	models.SweepFieldAvgSmokeCount: {min: 0, max: 200, integral: true, set: func(in *models.WhatIfInput, v float64) {
+		in.AvgSmokeCount = int(v)
+	}},
+	models.SweepFieldIsHypertension: {min: 0, max: 1, integral: true, set: func(in *models.WhatIfInput, v float64) {
+		in.IsHypertension = v == 1
+	}},
+	models.SweepFieldIsCholesterol: {min: 0, max: 1, integral: true, set: func(in *models.WhatIfInput, v float64) {
+		in.IsCholesterol = v == 1
+	}},
+}
+
+// ExpandSweepAxis returns the values of the axis from From towards To. To is included
+// when it falls on a step.
+func ExpandSweepAxis(axis models.WhatIfSweepAxis, maxPoints int) ([]float64, error) {
+	field, ok := sweepFields[axis.Field]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q cannot be swept", ErrInvalidSweep, axis.Field)
+	}
+	if axis.Step <= 0 {
+		return nil, fmt.Errorf("%w: step of %s must be positive", ErrInvalidSweep, axis.Field)
+	}
+	for _, v := range []float64{axis.From, axis.To} {
+		if v < field.min || v > field.max {
+			return nil, fmt.Errorf("%w: %s must be between %g and %g", ErrInvalidSweep, axis.Field, field.min, field.max)
+		}
+	}
+	if field.integral && (!isWhole(axis.From) || !isWhole(axis.To) || !isWhole(axis.Step)) {
+		return nil, fmt.Errorf("%w: from, to and step of %s must be whole numbers", ErrInvalidSweep, axis.Field)
+	}
+
+	// The epsilon keeps To when rounding leaves the span a hair short of a whole step
+	count := int(math.Floor(math.Abs(axis.To-axis.From)/axis.Step+1e-9)) + 1
+	if count > maxPoints {
+		return nil, fmt.Errorf("%w (%s has %d > %d)", ErrSweepTooLarge, axis.Field, count, maxPoints)
+	}
+
+	direction := 1.0
+	if axis.To < axis.From {
+		direction = -1
+	}
+	values := make([]float64, count)
+	for i := range values {
+		values[i] = math.Round((axis.From+direction*float64(i)*axis.Step)*1e6) / 1e6
+	}
+	return values, nil
+}
+
+func isWhole(v float64) bool {
+	return v == math.Trunc(v)
+}
+
+func (s *whatIfSweepService) Run(ctx context.Context, userID uint, request *models.WhatIfSweepRequest) (*models.WhatIfSweepResult, error) {
+	axes := make([]models.WhatIfSweepAxisValues, len(request.Axes))
+	total := 1
+	for i, axis := range request.Axes {
+		if i > 0 && axis.Field == request.Axes[0].Field {
+			return nil, fmt.Errorf("%w: %s is swept twice", ErrInvalidSweep, axis.Field)
+		}
+		values, err := ExpandSweepAxis(axis, s.cfg.MaxPoints)
+		if err != nil {
+			return nil, err
+		}
+		axes[i] = models.WhatIfSweepAxisValues{Field: axis.Field, Values: values}
+		total *= len(values)
+	}
+	if total > s.cfg.MaxPoints {
+		return nil, fmt.Errorf("%w (%d > %d)", ErrSweepTooLarge, total, s.cfg.MaxPoints)
+	}
+
+	points := sweepPoints(axes)
+	if err := checkQuotaUnits(s.quota, userID, models.JobTypeWhatIfSweep, len(points)); err != nil {
+		return nil, err
+	}
+
+	// The profile is read once for every point; an incomplete one fails the whole sweep
+	inputs := make([]models.WhatIfInput, len(points))
+	for i, point := range points {
+		inputs[i] = request.Base
+		for j, axis := range axes {
+			sweepFields[axis.Field].set(&inputs[i], point.Values[j])
+		}
+	}
+	features, err := s.features.FeaturesForWhatIfs(userID, inputs)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrSweepFeatures, err)
+	}
+
+	now := time.Now()
+	job := &models.PredictionJob{
+		ID:         uuid.New().String(),
+		UserID:     userID,
+		Status:     models.JobStatusProcessing,
+		JobType:    models.JobTypeWhatIfSweep,
+		TotalItems: len(points),
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+	if err := s.jobRepo.SaveJob(job); err != nil {
+		return nil, fmt.Errorf("failed to create sweep job: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.cfg.Timeout)
+	defer cancel()
+
+	versions := make([]string, len(points))
+	indexes := make(chan int)
+	var workers sync.WaitGroup
+	for i := 0; i < s.cfg.Concurrency && i < len(points); i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for index := range indexes {
+				versions[index] = s.scorePoint(ctx, job, features[index], &points[index])
+			}
+		}()
+	}
+	for i := range points {
+		indexes <- i
+	}
+	close(indexes)
+	workers.Wait()
+
+	result := &models.WhatIfSweepResult{
+		JobID:  job.ID,
+		Base:   request.Base,
+		Axes:   axes,
+		Points: points,
+	}
+	for i, point := range points {
+		if point.RiskScore == nil {
+			result.FailedPoints++
+		} else if result.ModelVersion == "" {
+			result.ModelVersion = versions[i]
+		}
+	}
+	if len(axes) == 2 {
+		result.Grid = make([][]*float64, len(axes[0].Values))
+		for i := range result.Grid {
+			result.Grid[i] = make([]*float64, len(axes[1].Values))
+			for j := range result.Grid[i] {
+				result.Grid[i][j] = points[i*len(axes[1].Values)+j].RiskScore
+			}
+		}
+	}
+
+	if err := s.jobRepo.UpdateBatchProgress(job.ID, len(points)-result.FailedPoints, result.FailedPoints); err != nil {
+		fmt.Printf("Warning: Failed to record progress of sweep %s: %v\n", job.ID, err)
+	}
+	if result.FailedPoints == len(points) {
+		errMsg := "Every point of the sweep failed"
+		_ = s.jobRepo.UpdateJobStatus(job.ID, models.JobStatusFailed, &errMsg)
+	} else {
+		_ = s.jobRepo.UpdateJobStatus(job.ID, models.JobStatusCompleted, nil)
+	}
+	return result, nil
+}
+
+// sweepPoints lists every combination of axis values, the last axis varying fastest
+func sweepPoints(axes []models.WhatIfSweepAxisValues) []models.WhatIfSweepPoint {
+	points := []models.WhatIfSweepPoint{{Values: []float64{}}}
+	for _, axis := range axes {
+		next := make([]models.WhatIfSweepPoint, 0, len(points)*len(axis.Values))
+		for _, point := range points {
+			for _, value := range axis.Values {
+				values := append(append([]float64{}, point.Values...), value)
+				next = append(next, models.WhatIfSweepPoint{Values: values})
+			}
+		}
+		points = next
+	}
+	return points
+}
+
+// scorePoint records the point as a child job, scores it and returns the model version
+func (s *whatIfSweepService) scorePoint(ctx context.Context, parent *models.PredictionJob, features []float64, point *models.WhatIfSweepPoint) string {
+	parentID := parent.ID
+	now := time.Now()
+	child := &models.PredictionJob{
+		ID:          uuid.New().String(),
+		UserID:      parent.UserID,
+		Status:      models.JobStatusProcessing,
+		JobType:     models.JobTypeBatchItem,
+		ParentJobID: &parentID,
+		InputHash:   ml.HashFeatures(features),
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if err := s.jobRepo.SaveJob(child); err != nil {
+		point.Error = fmt.Sprintf("failed to create sweep item job: %v", err)
+		return ""
+	}
+
+	itemCtx, cancel := context.WithTimeout(ctx, s.cfg.ItemTimeout)
+	score, err := s.scorer.Score(itemCtx, parent.UserID, features)
+	cancel()
+
+	if err != nil {
+		point.Error = err.Error()
+		_ = s.jobRepo.UpdateJobStatus(child.ID, models.JobStatusFailed, &point.Error)
+		return ""
+	}
+	risk := score.Prediction
+	point.RiskScore = &risk
+	_ = s.jobRepo.UpdateJobStatus(child.ID, models.JobStatusCompleted, nil)
+	return score.ModelVersion
+}
@@ -644,6 +644,7 @@ func seedSingleUserWithGlobalID(db *gorm.DB, userID int) error {
 func clearDataFromDatabase(db *gorm.DB) error {
 	// Delete in order due to foreign key constraints
 	tables := []interface{}{
+		&models.PredictionFactor{},
 		&models.Prediction{},
 		&models.Activity{},
 		&models.UserProfile{},
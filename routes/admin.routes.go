@@ -0,0 +1,29 @@
+package routes
+
+import (
+	"diabetify/internal/controllers"
+	"diabetify/internal/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+func RegisterAdminRoutes(router *gin.Engine, adminController *controllers.AdminController) {
+	adminRoutes := router.Group("/admin")
+	adminRoutes.Use(middleware.AuthMiddleware(), middleware.AdminMiddleware())
+	{
+		adminRoutes.POST("/model-updates", adminController.StartModelUpdate)
+		adminRoutes.GET("/model-updates", adminController.GetModelUpdates)
+		adminRoutes.GET("/model-updates/:id", adminController.GetModelUpdateByID)
+
+		adminRoutes.GET("/model-monitoring", adminController.GetModelMonitoring)
+		adminRoutes.POST("/model-monitoring/run", adminController.RunModelMonitoring)
+
+		adminRoutes.GET("/model-experiments", adminController.GetModelExperimentReport)
+
+		adminRoutes.GET("/explanations/stats", adminController.GetExplanationStats)
+		adminRoutes.GET("/llm-usage", adminController.GetLLMUsageReport)
+
+		adminRoutes.GET("/notifications", adminController.GetNotifications)
+		adminRoutes.POST("/notifications/:id/read", adminController.MarkNotificationRead)
+	}
+}
@@ -0,0 +1,18 @@
+package routes
+
+import (
+	"diabetify/internal/controllers"
+	"diabetify/internal/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+func RegisterBatchPredictionRoutes(router *gin.Engine, batchController *controllers.BatchPredictionController) {
+	batchRoutes := router.Group("/admin/batch-predictions")
+	batchRoutes.Use(middleware.AuthMiddleware(), middleware.AdminMiddleware())
+	{
+		batchRoutes.POST("", batchController.StartBatchPrediction)
+		batchRoutes.GET("/:id", batchController.GetBatchPrediction)
+		batchRoutes.GET("/:id/result", batchController.DownloadBatchPredictionResult)
+	}
+}
@@ -0,0 +1,16 @@
+package routes
+
+import (
+	"diabetify/internal/controllers"
+	"diabetify/internal/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+func RegisterCounterfactualRoutes(router *gin.Engine, counterfactualController *controllers.CounterfactualController) {
+	counterfactualRoutes := router.Group("/prediction/what-if/counterfactuals")
+	counterfactualRoutes.Use(middleware.AuthMiddleware())
+	{
+		counterfactualRoutes.POST("", counterfactualController.GetCounterfactuals)
+	}
+}
@@ -0,0 +1,17 @@
+package routes
+
+import (
+	"diabetify/internal/controllers"
+	"diabetify/internal/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+func RegisterOutcomeRoutes(router *gin.Engine, outcomeController *controllers.OutcomeController) {
+	outcomeRoutes := router.Group("/users/me/outcomes")
+	outcomeRoutes.Use(middleware.AuthMiddleware())
+	{
+		outcomeRoutes.POST("", outcomeController.CreateOutcome)
+		outcomeRoutes.GET("", outcomeController.GetUserOutcomes)
+	}
+}
@@ -10,6 +10,7 @@ import (
 func RegisterPredictionRoutes(router *gin.Engine, predictionController *controllers.PredictionController) {
 	predictionRoutes := router.Group("/prediction")
 	predictionRoutes.GET("/health", predictionController.TestMLConnection)
+	predictionRoutes.GET("/ready", predictionController.GetReadiness)
 	predictionRoutes.Use(middleware.AuthMiddleware())
 	{
 		predictionRoutes.POST("/", predictionController.MakePrediction)
@@ -19,13 +20,17 @@ func RegisterPredictionRoutes(router *gin.Engine, predictionController *controll
 		predictionRoutes.GET("/job/:job_id/result", predictionController.GetJobResult)
 		predictionRoutes.POST("/job/:job_id/cancel", predictionController.CancelJob)
 		predictionRoutes.GET("/jobs", predictionController.GetUserJobs)
+		predictionRoutes.GET("/models", predictionController.GetPredictionModels)
 
+		predictionRoutes.GET("/compare", predictionController.ComparePredictions)
 		predictionRoutes.GET("/:id", predictionController.GetPredictionByID)
+		predictionRoutes.GET("/:id/snapshot", predictionController.GetPredictionSnapshot)
 		predictionRoutes.DELETE("/:id", predictionController.DeletePrediction)
 
 		predictionRoutes.GET("/me", predictionController.GetUserPredictions)
 		predictionRoutes.GET("/me/date-range", predictionController.GetPredictionsByDateRange)
 		predictionRoutes.GET("/me/score", predictionController.GetPredictionScoreByDate)
+		predictionRoutes.GET("/me/trend", predictionController.GetRiskTrend)
 		predictionRoutes.GET("/me/explanation", predictionController.GetLatestPredictionExplanation)
 	}
 }
@@ -21,5 +21,6 @@ func RegisterUserRoutes(router *gin.Engine, userController *controllers.UserCont
 		userRoutesPrivate.GET("/me", userController.GetCurrentUser)
 		userRoutesPrivate.PUT("/me", userController.UpdateUser)
 		userRoutesPrivate.PATCH("/me", userController.PatchUser)
+		userRoutesPrivate.PUT("/me/research-consent", userController.UpdateResearchConsent)
 	}
 }
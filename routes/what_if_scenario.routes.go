@@ -0,0 +1,19 @@
+package routes
+
+import (
+	"diabetify/internal/controllers"
+	"diabetify/internal/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+func RegisterWhatIfScenarioRoutes(router *gin.Engine, scenarioController *controllers.WhatIfScenarioController) {
+	scenarioRoutes := router.Group("/prediction/what-if/scenarios")
+	scenarioRoutes.Use(middleware.AuthMiddleware())
+	{
+		scenarioRoutes.GET("", scenarioController.GetScenarios)
+		scenarioRoutes.GET("/:id", scenarioController.GetScenario)
+		scenarioRoutes.PATCH("/:id", scenarioController.UpdateScenario)
+		scenarioRoutes.DELETE("/:id", scenarioController.DeleteScenario)
+	}
+}
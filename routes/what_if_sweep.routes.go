@@ -0,0 +1,16 @@
+package routes
+
+import (
+	"diabetify/internal/controllers"
+	"diabetify/internal/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+func RegisterWhatIfSweepRoutes(router *gin.Engine, sweepController *controllers.WhatIfSweepController) {
+	sweepRoutes := router.Group("/prediction/what-if/sweep")
+	sweepRoutes.Use(middleware.AuthMiddleware())
+	{
+		sweepRoutes.POST("", sweepController.RunWhatIfSweep)
+	}
+}
@@ -0,0 +1,478 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"diabetify/internal/controllers"
+	"diabetify/internal/ml"
+	"diabetify/internal/models"
+	"diabetify/internal/services"
+	"diabetify/tests/mocks"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+const batchFeatureHeader = "ref,age,smoking_status,is_cholesterol,is_macrosomic_baby,moderate_physical_activity_frequency,is_bloodline,brinkman_index,BMI,is_hypertension\n"
+
+// concurrencyProbe records the highest number of overlapping PredictSync calls
+type concurrencyProbe struct {
+	scorer   ml.SyncPredictor
+	inFlight int32
+	peak     int32
+}
+
+func (p *concurrencyProbe) PredictSync(ctx context.Context, features []float64) (*ml.ScoreResult, error) {
+	n := atomic.AddInt32(&p.inFlight, 1)
+	defer atomic.AddInt32(&p.inFlight, -1)
+	for {
+		peak := atomic.LoadInt32(&p.peak)
+		if n <= peak || atomic.CompareAndSwapInt32(&p.peak, peak, n) {
+			break
+		}
+	}
+	time.Sleep(5 * time.Millisecond)
+	return p.scorer.PredictSync(ctx, features)
+}
+
+func TestParseBatchFeatureCSV(t *testing.T) {
+	items, err := services.ParseBatchFeatureCSV(strings.NewReader(batchFeatureHeader+
+		"p-1,50,1,0,0,3,1,1,32,0\n"+
+		",40,0,1,0,2,0,0,24.5,1\n"), 10)
+	require.NoError(t, err)
+	require.Len(t, items, 2)
+	assert.Equal(t, "p-1", items[0].Ref)
+	assert.Equal(t, localScorerFeatures, items[0].Features)
+	assert.Equal(t, "2", items[1].Ref, "rows without a ref are numbered")
+	assert.Nil(t, items[1].UserID)
+
+	tests := []struct {
+		name    string
+		csv     string
+		wantErr error
+		message string
+	}{
+		{name: "missing column", csv: "age,BMI\n50,32\n", message: "missing feature columns"},
+		{name: "not a number", csv: batchFeatureHeader + "a,50,yes,0,0,3,1,1,32,0\n", message: "smoking_status must be a number"},
+		{name: "header only", csv: batchFeatureHeader, wantErr: services.ErrEmptyBatch},
+		{name: "too many rows", csv: batchFeatureHeader + strings.Repeat("x,50,1,0,0,3,1,1,32,0\n", 11), wantErr: services.ErrBatchTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := services.ParseBatchFeatureCSV(strings.NewReader(tt.csv), 10)
+			require.Error(t, err)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+			}
+			assert.Contains(t, err.Error(), tt.message)
+		})
+	}
+}
+
+func TestBatchPredictionService(t *testing.T) {
+	scorer, err := ml.NewLocalScorerFromFile("testdata/local_model_lr.json")
+	require.NoError(t, err)
+	probe := &concurrencyProbe{scorer: scorer}
+
+	jobRepo := newMemoryJobRepository()
+	queue := newGoroutineJobQueue()
+	features := new(mocks.MockPredictionJobWorker)
+	features.On("FeaturesForUser", uint(7)).Return(localScorerFeatures, nil)
+	features.On("FeaturesForUser", uint(8)).Return(nil, errors.New("profile not found"))
+
+	seven, eight := uint(7), uint(8)
+	items := []services.BatchItem{
+		{Ref: "7", UserID: &seven},
+		{Ref: "8", UserID: &eight},
+	}
+	for i := 0; i < 10; i++ {
+		items = append(items, services.BatchItem{Ref: "row", Features: localScorerFeatures})
+	}
+
+	cfg := services.BatchPredictionConfig{Concurrency: 3, MaxItems: 100, ItemTimeout: time.Second}
+	svc := services.NewBatchPredictionService(jobRepo, features, probe, queue, services.NewPredictionQuota(jobRepo, services.PredictionQuotaConfig{}), cfg)
+
+	job, err := svc.StartBatch(1, items)
+	require.NoError(t, err)
+	assert.Equal(t, 12, job.TotalItems)
+	svc.Wait()
+
+	assert.LessOrEqual(t, atomic.LoadInt32(&probe.peak), int32(3))
+	for _, request := range queue.submitted() {
+		assert.Equal(t, models.JobTypeBatchItem, request.JobType)
+		assert.Equal(t, models.JobPriorityBackground, request.Priority)
+	}
+
+	batch, err := svc.GetBatch(job.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.JobStatusCompleted, batch.Status)
+	assert.Equal(t, 11, batch.CompletedItems)
+	assert.Equal(t, 1, batch.FailedItems)
+
+	children, err := jobRepo.GetChildJobs(1, job.ID)
+	require.NoError(t, err)
+	assert.Len(t, children, 12, "items belong to the requester")
+
+	var result bytes.Buffer
+	require.NoError(t, svc.WriteResults(batch, &result))
+	rows, err := csv.NewReader(&result).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, rows, 13)
+
+	header := rows[0]
+	assert.Equal(t, []string{"ref", "user_id", "status", "risk_score", "model_version"}, header[:5])
+	assert.Equal(t, "shap_BMI", header[5+len(ml.FeatureNames)+7])
+	assert.Equal(t, "error", header[len(header)-1])
+
+	expected, err := scorer.Score(localScorerFeatures)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"7", "7", models.JobStatusCompleted}, rows[1][:3])
+	assert.Equal(t, formatCSVFloat(expected.Prediction), rows[1][3])
+	assert.Equal(t, "test-1", rows[1][4])
+	assert.Equal(t, formatCSVFloat(expected.Explanation["BMI"].Shap), rows[1][5+len(ml.FeatureNames)+7])
+	assert.Equal(t, []string{"8", "8", models.JobStatusFailed}, rows[2][:3])
+	assert.Equal(t, "profile not found", rows[2][len(header)-1])
+}
+
+func TestBatchPredictionServiceResumesUnfinishedBatch(t *testing.T) {
+	scorer, err := ml.NewLocalScorerFromFile("testdata/local_model_lr.json")
+	require.NoError(t, err)
+
+	// A batch left behind by a stopped process: one item scored, one queued and one
+	// abandoned mid-scoring
+	jobRepo := newMemoryJobRepository()
+	batchID := "batch-1"
+	score := 0.4
+	stale := time.Now().Add(-time.Hour)
+	require.NoError(t, jobRepo.SaveJobs([]*models.PredictionJob{
+		{ID: batchID, UserID: 1, Status: models.JobStatusProcessing, JobType: models.JobTypeBatch, TotalItems: 3},
+		{ID: "item-0", UserID: 1, Status: models.JobStatusCompleted, JobType: models.JobTypeBatchItem, ParentJobID: &batchID,
+			BatchItem: &models.BatchItemResult{Index: 0, Ref: "a", RiskScore: &score}},
+		{ID: "item-1", UserID: 1, Status: models.JobStatusPending, JobType: models.JobTypeBatchItem, ParentJobID: &batchID,
+			BatchItem:       &models.BatchItemResult{Index: 1, Ref: "b"},
+			FeatureSnapshot: &models.FeatureSnapshot{Features: localScorerFeatures}},
+		{ID: "item-2", UserID: 1, Status: models.JobStatusProcessing, JobType: models.JobTypeBatchItem, ParentJobID: &batchID,
+			BatchItem:       &models.BatchItemResult{Index: 2, Ref: "c"},
+			FeatureSnapshot: &models.FeatureSnapshot{Features: localScorerFeatures}},
+	}))
+	jobRepo.jobs["item-2"].UpdatedAt = stale
+
+	queue := newGoroutineJobQueue()
+	cfg := services.BatchPredictionConfig{Concurrency: 2, MaxItems: 100, ItemTimeout: time.Second, RecoveryInterval: time.Hour}
+	svc := services.NewBatchPredictionService(jobRepo, new(mocks.MockPredictionJobWorker), scorer, queue, services.NewPredictionQuota(jobRepo, services.PredictionQuotaConfig{}), cfg)
+	svc.Start()
+	defer svc.Stop()
+
+	assert.Eventually(t, func() bool {
+		batch, err := svc.GetBatch(batchID)
+		return err == nil && batch.Status == models.JobStatusCompleted
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.Len(t, queue.submitted(), 2, "only unfinished items are queued again")
+
+	batch, err := svc.GetBatch(batchID)
+	require.NoError(t, err)
+	assert.Equal(t, 3, batch.CompletedItems)
+
+	var result bytes.Buffer
+	require.NoError(t, svc.WriteResults(batch, &result))
+	rows, err := csv.NewReader(&result).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, rows, 4)
+	assert.Equal(t, []string{"a", "b", "c"}, []string{rows[1][0], rows[2][0], rows[3][0]})
+	assert.Equal(t, "0.4", rows[1][3])
+}
+
+func TestBatchPredictionController(t *testing.T) {
+	batches := &stubBatchService{jobs: map[string]*models.PredictionJob{
+		"running": {ID: "running", JobType: models.JobTypeBatch, Status: models.JobStatusProcessing, TotalItems: 4, CompletedItems: 1, FailedItems: 1},
+	}}
+	controller := controllers.NewBatchPredictionController(batches, 2)
+
+	router := setupPredictionTestRouter()
+	router.Use(addPredictionAuthMiddleware(1))
+	router.POST("/admin/batch-predictions", controller.StartBatchPrediction)
+	router.GET("/admin/batch-predictions/:id", controller.GetBatchPrediction)
+	router.GET("/admin/batch-predictions/:id/result", controller.DownloadBatchPredictionResult)
+
+	csvUpload := func(body string) (*bytes.Buffer, string) {
+		buf := &bytes.Buffer{}
+		writer := multipart.NewWriter(buf)
+		part, err := writer.CreateFormFile("file", "cohort.csv")
+		require.NoError(t, err)
+		_, _ = part.Write([]byte(body))
+		require.NoError(t, writer.Close())
+		return buf, writer.FormDataContentType()
+	}
+
+	tests := []struct {
+		name         string
+		body         func() (*bytes.Buffer, string)
+		expectedCode int
+		expectedLen  int
+	}{
+		{
+			name:         "user IDs",
+			body:         func() (*bytes.Buffer, string) { return bytes.NewBufferString(`{"user_ids":[3,4]}`), "application/json" },
+			expectedCode: http.StatusAccepted,
+			expectedLen:  2,
+		},
+		{
+			name:         "empty user IDs",
+			body:         func() (*bytes.Buffer, string) { return bytes.NewBufferString(`{"user_ids":[]}`), "application/json" },
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "feature CSV",
+			body:         func() (*bytes.Buffer, string) { return csvUpload(batchFeatureHeader + "a,50,1,0,0,3,1,1,32,0\n") },
+			expectedCode: http.StatusAccepted,
+			expectedLen:  1,
+		},
+		{
+			name: "feature CSV over the limit",
+			body: func() (*bytes.Buffer, string) {
+				return csvUpload(batchFeatureHeader + strings.Repeat("a,50,1,0,0,3,1,1,32,0\n", 3))
+			},
+			expectedCode: http.StatusRequestEntityTooLarge,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			batches.started = nil
+			body, contentType := tt.body()
+			req := httptest.NewRequest("POST", "/admin/batch-predictions", body)
+			req.Header.Set("Content-Type", contentType)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			assert.Equal(t, tt.expectedCode, w.Code, w.Body.String())
+			assert.Len(t, batches.started, tt.expectedLen)
+		})
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/admin/batch-predictions/running", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	var response map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, float64(50), response["data"].(map[string]interface{})["progress_percentage"])
+
+	for path, expected := range map[string]int{
+		"/admin/batch-predictions/running/result": http.StatusConflict,
+		"/admin/batch-predictions/missing":        http.StatusNotFound,
+	} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		assert.Equal(t, expected, w.Code, path)
+	}
+}
+
+// stubBatchService records started batches without running them
+type stubBatchService struct {
+	jobs    map[string]*models.PredictionJob
+	started []services.BatchItem
+}
+
+func (s *stubBatchService) StartBatch(requestedBy uint, items []services.BatchItem) (*models.PredictionJob, error) {
+	s.started = items
+	return &models.PredictionJob{ID: "new", UserID: requestedBy, JobType: models.JobTypeBatch, TotalItems: len(items)}, nil
+}
+
+func (s *stubBatchService) GetBatch(jobID string) (*models.PredictionJob, error) {
+	if job, ok := s.jobs[jobID]; ok {
+		return job, nil
+	}
+	return nil, errors.New("record not found")
+}
+
+func (s *stubBatchService) WriteResults(job *models.PredictionJob, w io.Writer) error {
+	return services.ErrBatchNotFinished
+}
+
+func (s *stubBatchService) Start() {}
+
+func (s *stubBatchService) Stop() {}
+
+func (s *stubBatchService) Wait() {}
+
+// goroutineJobQueue runs each submitted job on its own goroutine with the handler
+// registered for its type
+type goroutineJobQueue struct {
+	mu       sync.Mutex
+	handlers map[string]services.JobHandler
+	requests []models.PredictionJobRequest
+}
+
+func newGoroutineJobQueue() *goroutineJobQueue {
+	return &goroutineJobQueue{handlers: map[string]services.JobHandler{}}
+}
+
+func (q *goroutineJobQueue) RegisterJobHandler(jobType string, handler services.JobHandler) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.handlers[jobType] = handler
+}
+
+func (q *goroutineJobQueue) SubmitJob(request models.PredictionJobRequest) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	handler, ok := q.handlers[request.JobType]
+	if !ok {
+		return errors.New("no handler for " + request.JobType)
+	}
+	q.requests = append(q.requests, request)
+	go handler(context.Background(), request)
+	return nil
+}
+
+func (q *goroutineJobQueue) submitted() []models.PredictionJobRequest {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return append([]models.PredictionJobRequest(nil), q.requests...)
+}
+
+// memoryJobRepository keeps jobs in memory for the methods batch services use; any other
+// call fails on the embedded mock
+type memoryJobRepository struct {
+	*mocks.MockPredictionJobRepository
+	mu   sync.Mutex
+	jobs map[string]*models.PredictionJob
+}
+
+func newMemoryJobRepository() *memoryJobRepository {
+	return &memoryJobRepository{
+		MockPredictionJobRepository: new(mocks.MockPredictionJobRepository),
+		jobs:                        map[string]*models.PredictionJob{},
+	}
+}
+
+func (r *memoryJobRepository) SaveJob(job *models.PredictionJob) error {
+	return r.SaveJobs([]*models.PredictionJob{job})
+}
+
+func (r *memoryJobRepository) SaveJobs(jobs []*models.PredictionJob) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, job := range jobs {
+		stored := *job
+		r.jobs[job.ID] = &stored
+	}
+	return nil
+}
+
+func (r *memoryJobRepository) GetJobByID(id string) (*models.PredictionJob, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	job, ok := r.jobs[id]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	copied := *job
+	return &copied, nil
+}
+
+func (r *memoryJobRepository) UpdateJobStatus(jobID, status string, errorMessage *string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.jobs[jobID].Status = status
+	r.jobs[jobID].ErrorMessage = errorMessage
+	return nil
+}
+
+func (r *memoryJobRepository) UpdateBatchProgress(jobID string, completedItems, failedItems int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.jobs[jobID].CompletedItems = completedItems
+	r.jobs[jobID].FailedItems = failedItems
+	return nil
+}
+
+func (r *memoryJobRepository) SetJobInput(jobID, inputHash string, snapshot *models.FeatureSnapshot) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.jobs[jobID].InputHash = inputHash
+	r.jobs[jobID].FeatureSnapshot = snapshot
+	return nil
+}
+
+func (r *memoryJobRepository) ClaimJob(userID uint, jobID string, staleBefore time.Time) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	job := r.jobs[jobID]
+	if job.Status != models.JobStatusPending && (job.Status != models.JobStatusProcessing || !job.UpdatedAt.Before(staleBefore)) {
+		return false, nil
+	}
+	job.Status = models.JobStatusProcessing
+	job.UpdatedAt = time.Now()
+	return true, nil
+}
+
+func (r *memoryJobRepository) FinishBatchItem(userID uint, jobID string, result *models.BatchItemResult, errorMessage *string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	job := r.jobs[jobID]
+	job.Status = models.JobStatusCompleted
+	if errorMessage != nil {
+		job.Status = models.JobStatusFailed
+		job.ErrorMessage = errorMessage
+	}
+	job.BatchItem = result
+	return nil
+}
+
+func (r *memoryJobRepository) GetJobsByTypeAndStatus(jobType, status string, limit int) ([]*models.PredictionJob, error) {
+	return r.find(func(job *models.PredictionJob) bool { return job.JobType == jobType && job.Status == status }), nil
+}
+
+func (r *memoryJobRepository) GetChildJobs(userID uint, parentID string, statuses ...string) ([]*models.PredictionJob, error) {
+	return r.find(func(job *models.PredictionJob) bool {
+		if job.UserID != userID || job.ParentJobID == nil || *job.ParentJobID != parentID {
+			return false
+		}
+		for _, status := range statuses {
+			if job.Status == status {
+				return true
+			}
+		}
+		return len(statuses) == 0
+	}), nil
+}
+
+func (r *memoryJobRepository) CountChildJobsByStatus(userID uint, parentID string) (map[string]int64, error) {
+	children, _ := r.GetChildJobs(userID, parentID)
+	counts := map[string]int64{}
+	for _, child := range children {
+		counts[child.Status]++
+	}
+	return counts, nil
+}
+
+func (r *memoryJobRepository) find(match func(job *models.PredictionJob) bool) []*models.PredictionJob {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var jobs []*models.PredictionJob
+	for _, job := range r.jobs {
+		if match(job) {
+			copied := *job
+			jobs = append(jobs, &copied)
+		}
+	}
+	return jobs
+}
+
+func formatCSVFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
@@ -0,0 +1,143 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"diabetify/internal/ml"
+	"diabetify/tests/mocks"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCircuitBreakerProbeAfterTrip(t *testing.T) {
+	tests := []struct {
+		name          string
+		trip          func(cb *ml.CircuitBreakerClient)
+		expectedState ml.BreakerState
+	}{
+		{
+			name: "probe does not close a slow-call trip",
+			trip: func(cb *ml.CircuitBreakerClient) {
+				cb.RecordResponse(time.Second, nil)
+			},
+			expectedState: ml.BreakerOpen,
+		},
+		{
+			name: "probe closes a failure trip",
+			trip: func(cb *ml.CircuitBreakerClient) {
+				cb.RecordResponse(time.Second, ml.ErrResponseTimeout)
+			},
+			expectedState: ml.BreakerClosed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inner := new(mocks.MockMLClient)
+			inner.On("ProbeHealth", mock.Anything).Return(&ml.HealthStatus{Healthy: true, Status: "healthy"}, nil)
+
+			cb := ml.NewCircuitBreakerClient(inner, nil, ml.CircuitBreakerConfig{
+				FailureThreshold:  1,
+				SlowCallDuration:  100 * time.Millisecond,
+				SlowCallThreshold: 1,
+				OpenTimeout:       time.Minute,
+			})
+			tt.trip(cb)
+			assert.Equal(t, ml.BreakerOpen, cb.State())
+
+			_, err := cb.ProbeHealth(context.Background())
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedState, cb.State())
+		})
+	}
+}
+
+func TestCircuitBreakerUnavailableDuringTrial(t *testing.T) {
+	inner := new(mocks.MockMLClient)
+	inner.On("PredictAsync", mock.Anything, "job-1", mock.Anything).Return(nil)
+
+	cb := ml.NewCircuitBreakerClient(inner, nil, ml.CircuitBreakerConfig{
+		FailureThreshold: 1,
+		OpenTimeout:      time.Millisecond,
+	})
+	cb.RecordResponse(0, errors.New("ML service unreachable"))
+	time.Sleep(5 * time.Millisecond)
+
+	ok, _ := cb.Available()
+	assert.True(t, ok, "a trial call is allowed once the open timeout has passed")
+
+	assert.NoError(t, cb.PredictAsync(context.Background(), "job-1", []float64{1}))
+	assert.Equal(t, ml.BreakerHalfOpen, cb.State())
+
+	ok, retryAfter := cb.Available()
+	assert.False(t, ok)
+	assert.Greater(t, retryAfter, time.Duration(0))
+
+	cb.RecordResponse(10*time.Millisecond, nil)
+	assert.Equal(t, ml.BreakerClosed, cb.State())
+	ok, _ = cb.Available()
+	assert.True(t, ok)
+}
+
+func TestCircuitBreakerSlowTrialReopens(t *testing.T) {
+	inner := new(mocks.MockMLClient)
+	inner.On("PredictAsync", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+
+	cb := ml.NewCircuitBreakerClient(inner, nil, ml.CircuitBreakerConfig{
+		FailureThreshold:  5,
+		SlowCallDuration:  100 * time.Millisecond,
+		SlowCallThreshold: 3,
+		OpenTimeout:       time.Millisecond,
+	})
+	for i := 0; i < 3; i++ {
+		cb.RecordResponse(time.Second, nil)
+	}
+	require.Equal(t, ml.BreakerOpen, cb.State())
+	time.Sleep(5 * time.Millisecond)
+
+	// One slow trial is enough to reopen; the breaker must not wait half-open for more
+	require.NoError(t, cb.PredictAsync(context.Background(), "job-1", []float64{1}))
+	cb.RecordResponse(time.Second, nil)
+	assert.Equal(t, ml.BreakerOpen, cb.State())
+	time.Sleep(5 * time.Millisecond)
+
+	ok, _ := cb.Available()
+	assert.True(t, ok, "another trial is allowed once the open timeout has passed")
+	require.NoError(t, cb.PredictAsync(context.Background(), "job-2", []float64{1}))
+	cb.RecordResponse(10*time.Millisecond, nil)
+	assert.Equal(t, ml.BreakerClosed, cb.State())
+}
+
+func TestCircuitBreakerIgnoresCancelledProbe(t *testing.T) {
+	inner := new(mocks.MockMLClient)
+	inner.On("ProbeHealth", mock.Anything).Return(nil, context.Canceled)
+
+	cb := ml.NewCircuitBreakerClient(inner, nil, ml.CircuitBreakerConfig{FailureThreshold: 1, OpenTimeout: time.Minute})
+	_, err := cb.ProbeHealth(context.Background())
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, ml.BreakerClosed, cb.State())
+	assert.Equal(t, int64(0), cb.Stats()["failures"])
+}
+
+func TestCircuitBreakerSyncFallsBackWithoutSyncTransport(t *testing.T) {
+	scorer, err := ml.NewLocalScorerFromFile("testdata/local_model_lr.json")
+	require.NoError(t, err)
+
+	// The mock client has no PredictSync, like a transport without request/reply
+	cb := ml.NewCircuitBreakerClient(new(mocks.MockMLClient), scorer, ml.CircuitBreakerConfig{FailureThreshold: 1})
+
+	result, err := cb.PredictSync(context.Background(), []float64{45, 0, 1, 0, 3, 1, 0, 27.5, 0})
+	require.NoError(t, err)
+	assert.Greater(t, result.Prediction, 0.0)
+	assert.Equal(t, ml.BreakerClosed, cb.State())
+	assert.Equal(t, int64(1), cb.Stats()["fallback_calls"])
+
+	_, err = ml.NewCircuitBreakerClient(new(mocks.MockMLClient), nil, ml.CircuitBreakerConfig{}).
+		PredictSync(context.Background(), []float64{45, 0, 1, 0, 3, 1, 0, 27.5, 0})
+	assert.ErrorIs(t, err, ml.ErrSyncUnsupported)
+}
@@ -0,0 +1,352 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"diabetify/internal/controllers"
+	"diabetify/internal/ml"
+	"diabetify/internal/models"
+	"diabetify/internal/services"
+	"diabetify/tests/mocks"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// counterfactualSource serves a fixed current input and derives every modifiable feature
+// from the what-if input, BMI at a height of 1.7 m
+type counterfactualSource struct {
+	current models.WhatIfInput
+	err     error
+}
+
+func (s *counterfactualSource) CurrentWhatIfInput(userID uint) (*models.WhatIfInput, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	current := s.current
+	return &current, nil
+}
+
+func (s *counterfactualSource) FeaturesForWhatIf(userID uint, input *models.WhatIfInput) ([]float64, error) {
+	features := append([]float64{}, localScorerFeatures...)
+	features[1] = float64(input.SmokingStatus)
+	features[2] = boolFeature(input.IsCholesterol)
+	features[4] = float64(input.PhysicalActivityFrequency)
+	features[7] = input.Weight / (1.7 * 1.7)
+	features[8] = boolFeature(input.IsHypertension)
+	return features, nil
+}
+
+func boolFeature(v bool) float64 {
+	if v {
+		return 1
+	}
+	return 0
+}
+
+func counterfactualCurrentInput() models.WhatIfInput {
+	return models.WhatIfInput{
+		SmokingStatus:             2,
+		YearsOfSmoking:            20,
+		AvgSmokeCount:             10,
+		Weight:                    95,
+		PhysicalActivityFrequency: 1,
+		IsHypertension:            true,
+		IsCholesterol:             true,
+	}
+}
+
+func newCounterfactualService(t *testing.T, source *counterfactualSource) (services.CounterfactualService, *ml.LocalScorer) {
+	jobRepo := new(mocks.MockPredictionJobRepository)
+	jobRepo.On("SaveJob", mock.Anything).Return(nil)
+	jobRepo.On("UpdateJob", mock.Anything).Return(nil)
+	jobRepo.On("UpdateJobStatus", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	return newCounterfactualServiceWithQuota(t, source, jobRepo, services.PredictionQuotaConfig{})
+}
+
+func newCounterfactualServiceWithQuota(t *testing.T, source *counterfactualSource, jobRepo *mocks.MockPredictionJobRepository, quotaCfg services.PredictionQuotaConfig) (services.CounterfactualService, *ml.LocalScorer) {
+	scorer, err := ml.NewLocalScorerFromFile("testdata/local_model_lr.json")
+	require.NoError(t, err)
+	cfg := services.CounterfactualConfig{
+		MaxWeightLossPercent: 15,
+		MinBMI:               18.5,
+		MaxActivityFrequency: 7,
+		WeightStep:           2,
+		MaxEvaluations:       200,
+		ItemTimeout:          time.Second,
+	}
+	queued := services.NewQueuedScorer(newGoroutineJobQueue(), scorer)
+	quota := services.NewPredictionQuota(jobRepo, quotaCfg)
+	return services.NewCounterfactualService(jobRepo, source, queued, quota, cfg), scorer
+}
+
+func TestCounterfactualSuggestions(t *testing.T) {
+	source := &counterfactualSource{current: counterfactualCurrentInput()}
+	service, scorer := newCounterfactualService(t, source)
+
+	result, err := service.Suggest(context.Background(), 1, &models.CounterfactualRequest{TargetRisk: 0.5})
+	require.NoError(t, err)
+
+	assert.Greater(t, result.CurrentRiskScore, 0.5)
+	assert.False(t, result.AlreadyBelow)
+	assert.Equal(t, "test-1", result.ModelVersion)
+	require.Len(t, result.Suggestions, 3, "max_suggestions defaults to 3")
+
+	for i, suggestion := range result.Suggestions {
+		assert.Equal(t, i+1, suggestion.Rank)
+		assert.True(t, suggestion.ReachesTarget)
+		assert.Less(t, suggestion.RiskScore, 0.5)
+		assert.InDelta(t, result.CurrentRiskScore-suggestion.RiskScore, suggestion.RiskReduction, 1e-9)
+		assert.NotEmpty(t, suggestion.Changes)
+		if i > 0 {
+			assert.GreaterOrEqual(t, suggestion.Effort, result.Suggestions[i-1].Effort, "ranked by effort")
+		}
+
+		// The projected risk is what a what-if job with the suggested input would score
+		features, err := source.FeaturesForWhatIf(1, &suggestion.Input)
+		require.NoError(t, err)
+		score, err := scorer.PredictSync(context.Background(), features)
+		require.NoError(t, err)
+		assert.InDelta(t, score.Prediction, suggestion.RiskScore, 1e-9)
+
+		// Plausibility: at most 15% weight loss, never more activity than daily
+		assert.GreaterOrEqual(t, suggestion.Input.Weight, 95*0.85)
+		assert.LessOrEqual(t, suggestion.Input.PhysicalActivityFrequency, 7)
+		assert.Equal(t, 20, suggestion.Input.YearsOfSmoking, "unmodifiable fields are kept")
+	}
+
+	// No suggestion is another one plus extra changes
+	changed := func(s models.CounterfactualSuggestion) map[string]float64 {
+		fields := map[string]float64{}
+		for _, change := range s.Changes {
+			fields[change.Field] = change.To
+		}
+		return fields
+	}
+	for i := range result.Suggestions {
+		for j := range result.Suggestions {
+			if i == j {
+				continue
+			}
+			a, b := changed(result.Suggestions[i]), changed(result.Suggestions[j])
+			covers := true
+			for field, to := range a {
+				other, ok := b[field]
+				if !ok || (field == "weight" && other > to) || (field == "physical_activity_frequency" && other < to) {
+					covers = false
+				}
+			}
+			assert.False(t, covers, "suggestion %d is contained in suggestion %d", i+1, j+1)
+		}
+	}
+}
+
+func TestCounterfactualAlreadyBelowTarget(t *testing.T) {
+	current := counterfactualCurrentInput()
+	current.Weight = 60
+	current.SmokingStatus = 0
+	current.IsHypertension = false
+	current.IsCholesterol = false
+	service, _ := newCounterfactualService(t, &counterfactualSource{current: current})
+
+	result, err := service.Suggest(context.Background(), 1, &models.CounterfactualRequest{TargetRisk: 0.9})
+	require.NoError(t, err)
+
+	assert.True(t, result.AlreadyBelow)
+	assert.Empty(t, result.Suggestions)
+	assert.Equal(t, 1, result.Evaluations)
+}
+
+func TestCounterfactualUnreachableTarget(t *testing.T) {
+	service, _ := newCounterfactualService(t, &counterfactualSource{current: counterfactualCurrentInput()})
+
+	result, err := service.Suggest(context.Background(), 1, &models.CounterfactualRequest{TargetRisk: 0.01, MaxSuggestions: 2})
+	require.NoError(t, err)
+
+	require.Len(t, result.Suggestions, 2)
+	assert.False(t, result.Suggestions[0].ReachesTarget)
+	assert.Less(t, result.Suggestions[0].RiskScore, result.CurrentRiskScore)
+	assert.LessOrEqual(t, result.Suggestions[0].RiskScore, result.Suggestions[1].RiskScore, "lowest risk first")
+	assert.LessOrEqual(t, result.Evaluations, 200)
+}
+
+func TestCounterfactualChargesWhatIfQuota(t *testing.T) {
+	quotaCfg := services.PredictionQuotaConfig{DailyWhatIfs: 100}
+
+	t.Run("evaluations stop at the remaining allowance", func(t *testing.T) {
+		jobRepo := new(mocks.MockPredictionJobRepository)
+		jobRepo.On("CountQuotaUnitsSince", uint(1), mock.Anything, mock.AnythingOfType("time.Time")).Return(int64(95), nil)
+		jobRepo.On("SaveJob", mock.MatchedBy(func(j *models.PredictionJob) bool {
+			return j.JobType == models.JobTypeCounterfactual && j.TotalItems == 5
+		})).Return(nil)
+		jobRepo.On("UpdateJob", mock.Anything).Return(nil)
+		service, _ := newCounterfactualServiceWithQuota(t, &counterfactualSource{current: counterfactualCurrentInput()}, jobRepo, quotaCfg)
+
+		result, err := service.Suggest(context.Background(), 1, &models.CounterfactualRequest{TargetRisk: 0.01})
+		require.NoError(t, err)
+		assert.Equal(t, 5, result.Evaluations)
+		assert.True(t, result.Truncated)
+		assert.NotEmpty(t, result.JobID)
+		jobRepo.AssertCalled(t, "UpdateJob", mock.MatchedBy(func(j *models.PredictionJob) bool {
+			return j.ID == result.JobID && j.Status == models.JobStatusCompleted && j.TotalItems == 5 && j.CompletedItems == 5
+		}))
+	})
+
+	t.Run("no allowance left", func(t *testing.T) {
+		jobRepo := new(mocks.MockPredictionJobRepository)
+		jobRepo.On("CountQuotaUnitsSince", uint(1), mock.Anything, mock.AnythingOfType("time.Time")).Return(int64(100), nil)
+		service, _ := newCounterfactualServiceWithQuota(t, &counterfactualSource{current: counterfactualCurrentInput()}, jobRepo, quotaCfg)
+
+		_, err := service.Suggest(context.Background(), 1, &models.CounterfactualRequest{})
+		var exceeded *services.QuotaExceededError
+		require.True(t, errors.As(err, &exceeded), "expected a quota error, got %v", err)
+		jobRepo.AssertNotCalled(t, "SaveJob", mock.Anything)
+	})
+}
+
+func TestCounterfactualStopsBeforeDeadline(t *testing.T) {
+	scorer, err := ml.NewLocalScorerFromFile("testdata/local_model_lr.json")
+	require.NoError(t, err)
+	jobRepo := new(mocks.MockPredictionJobRepository)
+	jobRepo.On("SaveJob", mock.Anything).Return(nil)
+	jobRepo.On("UpdateJob", mock.Anything).Return(nil)
+	cfg := services.CounterfactualConfig{
+		MaxWeightLossPercent: 15,
+		MinBMI:               18.5,
+		MaxActivityFrequency: 7,
+		WeightStep:           2,
+		MaxEvaluations:       200,
+		ItemTimeout:          50 * time.Millisecond,
+		Timeout:              300 * time.Millisecond,
+	}
+	queued := services.NewQueuedScorer(newGoroutineJobQueue(), &slowPredictor{next: scorer, delay: 20 * time.Millisecond})
+	quota := services.NewPredictionQuota(jobRepo, services.PredictionQuotaConfig{})
+	service := services.NewCounterfactualService(jobRepo, &counterfactualSource{current: counterfactualCurrentInput()}, queued, quota, cfg)
+
+	started := time.Now()
+	result, err := service.Suggest(context.Background(), 1, &models.CounterfactualRequest{TargetRisk: 0.01})
+	require.NoError(t, err)
+	assert.Less(t, time.Since(started), 300*time.Millisecond)
+	assert.True(t, result.Truncated)
+	assert.Less(t, result.Evaluations, 200)
+}
+
+// slowPredictor delays every prediction of the wrapped predictor
+type slowPredictor struct {
+	next  ml.SyncPredictor
+	delay time.Duration
+}
+
+func (p *slowPredictor) PredictSync(ctx context.Context, features []float64) (*ml.ScoreResult, error) {
+	select {
+	case <-time.After(p.delay):
+		return p.next.PredictSync(ctx, features)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// flakyPredictor fails the prediction calls listed in fail, counted from 1
+type flakyPredictor struct {
+	next  ml.SyncPredictor
+	fail  map[int]bool
+	calls int
+}
+
+func (p *flakyPredictor) PredictSync(ctx context.Context, features []float64) (*ml.ScoreResult, error) {
+	p.calls++
+	if p.fail[p.calls] {
+		return nil, ml.ErrSyncPredictionTimeout
+	}
+	return p.next.PredictSync(ctx, features)
+}
+
+func TestCounterfactualSkipsScenariosThatFail(t *testing.T) {
+	scorer, err := ml.NewLocalScorerFromFile("testdata/local_model_lr.json")
+	require.NoError(t, err)
+	cfg := services.CounterfactualConfig{
+		MaxWeightLossPercent: 15,
+		MinBMI:               18.5,
+		MaxActivityFrequency: 7,
+		WeightStep:           2,
+		MaxEvaluations:       200,
+		ItemTimeout:          time.Second,
+	}
+	newService := func(fail map[int]bool) services.CounterfactualService {
+		jobRepo := new(mocks.MockPredictionJobRepository)
+		jobRepo.On("SaveJob", mock.Anything).Return(nil)
+		jobRepo.On("UpdateJob", mock.Anything).Return(nil)
+		jobRepo.On("UpdateJobStatus", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+		queued := services.NewQueuedScorer(newGoroutineJobQueue(), &flakyPredictor{next: scorer, fail: fail})
+		quota := services.NewPredictionQuota(jobRepo, services.PredictionQuotaConfig{})
+		return services.NewCounterfactualService(jobRepo, &counterfactualSource{current: counterfactualCurrentInput()}, queued, quota, cfg)
+	}
+
+	t.Run("a failed scenario is skipped", func(t *testing.T) {
+		result, err := newService(map[int]bool{2: true, 4: true}).Suggest(context.Background(), 1, &models.CounterfactualRequest{TargetRisk: 0.3})
+		require.NoError(t, err)
+		assert.NotEmpty(t, result.Suggestions)
+		assert.Equal(t, 2, result.FailedEvaluations)
+		assert.True(t, result.Truncated)
+	})
+
+	t.Run("a failed baseline fails the search", func(t *testing.T) {
+		_, err := newService(map[int]bool{1: true}).Suggest(context.Background(), 1, &models.CounterfactualRequest{TargetRisk: 0.3})
+		assert.Error(t, err)
+	})
+}
+
+func TestCounterfactualIncompleteProfile(t *testing.T) {
+	service, _ := newCounterfactualService(t, &counterfactualSource{err: errors.New("weight and height are required but not found")})
+
+	_, err := service.Suggest(context.Background(), 1, &models.CounterfactualRequest{})
+	assert.ErrorIs(t, err, services.ErrCounterfactualInput)
+}
+
+func TestGetCounterfactuals(t *testing.T) {
+	tests := []struct {
+		name           string
+		body           string
+		source         *counterfactualSource
+		expectedStatus int
+	}{
+		{name: "default target", body: "", source: &counterfactualSource{current: counterfactualCurrentInput()}, expectedStatus: http.StatusOK},
+		{name: "custom target", body: `{"target_risk": 0.5, "max_suggestions": 1}`, source: &counterfactualSource{current: counterfactualCurrentInput()}, expectedStatus: http.StatusOK},
+		{name: "target out of range", body: `{"target_risk": 1.5}`, source: &counterfactualSource{current: counterfactualCurrentInput()}, expectedStatus: http.StatusBadRequest},
+		{name: "too many suggestions", body: `{"max_suggestions": 10}`, source: &counterfactualSource{current: counterfactualCurrentInput()}, expectedStatus: http.StatusBadRequest},
+		{name: "incomplete profile", body: "", source: &counterfactualSource{err: errors.New("profile not found")}, expectedStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, _ := newCounterfactualService(t, tt.source)
+			controller := controllers.NewCounterfactualController(service)
+			router := setupPredictionTestRouter()
+			router.Use(addPredictionAuthMiddleware(1))
+			router.POST("/prediction/what-if/counterfactuals", controller.GetCounterfactuals)
+
+			req := httptest.NewRequest("POST", "/prediction/what-if/counterfactuals", bytes.NewBufferString(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code, w.Body.String())
+			if tt.expectedStatus == http.StatusOK {
+				var response struct {
+					Data models.CounterfactualResult `json:"data"`
+				}
+				require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+				assert.NotEmpty(t, response.Data.Suggestions)
+			}
+		})
+	}
+}
@@ -0,0 +1,297 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"diabetify/internal/openai"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stubExplainer fails with err, or blocks until the context is done when block is set
+type stubExplainer struct {
+	err   error
+	block bool
+}
+
+func (s *stubExplainer) Name() string {
+	return "stub"
+}
+
+func (s *stubExplainer) Explain(ctx context.Context, prediction float64, factors map[string]openai.FactorInput) (*openai.Explanation, error) {
+	if s.block {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	if s.err != nil {
+		return nil, s.err
+	}
+	return &openai.Explanation{Summary: "from stub", Provider: s.Name()}, nil
+}
+
+// idDisclaimer is the disclaimer of the Indonesian catalog that LLM summaries end with
+const idDisclaimer = "Hasil ini bukan diagnosis medis. Konsultasikan dengan tenaga kesehatan untuk pemeriksaan lebih lanjut."
+
+// validReply explains every factor of templateFactors, the BMI by its alias
+const validReply = `{"summary": "Ringkasan. ` + idDisclaimer + `", "features": [
+	{"feature_name": "age", "explanation": "Usia Anda."},
+	{"feature_name": "Indeks Massa Tubuh", "explanation": "BMI Anda."},
+	{"feature_name": "smoking_status", "explanation": "Anda tidak merokok."},
+	{"feature_name": "is_bloodline", "explanation": "Tidak ada riwayat keluarga."}
+]}`
+
+// replyServer is an OpenAI-compatible endpoint that answers with replies in turn,
+// repeating the last one, and keeps the requests it received
+type replyServer struct {
+	*httptest.Server
+	requests []openai.ChatCompletionRequest
+}
+
+func newReplyServer(t *testing.T, replies ...string) *replyServer {
+	server := &replyServer{}
+	server.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request openai.ChatCompletionRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+		server.requests = append(server.requests, request)
+
+		content := replies[len(replies)-1]
+		if len(server.requests) <= len(replies) {
+			content = replies[len(server.requests)-1]
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"choices": []map[string]interface{}{{"message": map[string]string{"content": content}}},
+			"usage":   map[string]int{"prompt_tokens": 900, "completion_tokens": 100, "total_tokens": 1000},
+		})
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func templateFactors() map[string]openai.FactorInput {
+	return map[string]openai.FactorInput{
+		"age":            {Value: "50 years", RawValue: 50, Shap: 0.12, Contribution: 0.30},
+		"bmi":            {Value: "31.5", RawValue: 31.5, Shap: 0.08, Contribution: 0.20},
+		"smoking_status": {Value: "0", RawValue: 0, Shap: -0.02, Contribution: 0.05},
+		"is_bloodline":   {Value: "false", RawValue: 0, Shap: 0, Contribution: 0},
+	}
+}
+
+func newTemplateExplainer(t *testing.T, language string) *openai.TemplateExplainer {
+	explainer, err := openai.NewTemplateExplainer(language)
+	require.NoError(t, err)
+	return explainer
+}
+
+func TestOpenAICompatibleClient(t *testing.T) {
+	var request openai.ChatCompletionRequest
+	var authorization string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/chat/completions", r.URL.Path)
+		authorization = r.Header.Get("Authorization")
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+
+		content := "```json\n" + validReply + "\n```"
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"choices": []map[string]interface{}{{"message": map[string]string{"content": content}}},
+			"usage":   map[string]int{"prompt_tokens": 900, "completion_tokens": 100, "total_tokens": 1000},
+		})
+	}))
+	defer server.Close()
+
+	// A local server: custom base URL and model, no API key
+	price := openai.ModelPrice{PromptPerMillion: 1, CompletionPerMillion: 2}
+	client, err := openai.NewClientWithConfig(openai.ClientConfig{BaseURL: server.URL + "/v1/", Model: "llama3.1", Price: &price})
+	require.NoError(t, err)
+
+	explanation, err := client.Explain(context.Background(), 0.4, templateFactors())
+	require.NoError(t, err)
+	assert.Equal(t, "llama3.1", request.Model)
+	assert.Empty(t, authorization)
+	assert.Equal(t, openai.ProviderOpenAI, explanation.Provider)
+	assert.Equal(t, "llama3.1", explanation.Model)
+	assert.Equal(t, "Ringkasan. "+idDisclaimer, explanation.Summary)
+	assert.Equal(t, 1000, explanation.Usage.TotalTokens)
+	assert.Equal(t, "Usia Anda.", explanation.Factors["age"].Explanation)
+	assert.Equal(t, "BMI Anda.", explanation.Factors["bmi"].Explanation, "aliases map back to feature names")
+	require.Len(t, explanation.Calls, 1)
+	assert.Equal(t, "llama3.1", explanation.Calls[0].Model)
+	assert.Equal(t, 1000, explanation.Calls[0].Usage.TotalTokens)
+	assert.InDelta(t, (900*1+100*2)/1e6, explanation.Calls[0].Cost, 1e-12)
+}
+
+func TestOpenAICompatibleClientRetriesInvalidReplies(t *testing.T) {
+	tests := []struct {
+		name           string
+		replies        []string
+		expectRequests int
+		expectRejected []string
+		expectErr      bool
+	}{
+		{name: "valid reply", replies: []string{validReply}, expectRequests: 1, expectRejected: []string{}},
+		{name: "text around the JSON", replies: []string{"Berikut penjelasannya: " + validReply, validReply}, expectRequests: 2, expectRejected: []string{openai.CheckSchema}},
+		{name: "unknown field", replies: []string{`{"summary": "x", "features": [], "risk": 0.4}`, validReply}, expectRequests: 2, expectRejected: []string{openai.CheckSchema}},
+		{name: "missing factor", replies: []string{`{"summary": "Ringkasan. ` + idDisclaimer + `", "features": [{"feature_name": "age", "explanation": "Usia Anda."}]}`, validReply}, expectRequests: 2, expectRejected: []string{openai.CheckCompleteness}},
+		{name: "dosage advice", replies: []string{strings.Replace(validReply, "BMI Anda.", "Minum metformin 500 mg setiap hari.", 1), validReply}, expectRequests: 2, expectRejected: []string{openai.CheckSafety}},
+		{name: "never valid", replies: []string{strings.Replace(validReply, idDisclaimer, "", 1)}, expectRequests: 2, expectRejected: []string{openai.CheckDisclaimer, openai.CheckDisclaimer}, expectErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newReplyServer(t, tt.replies...)
+			client, err := openai.NewClientWithConfig(openai.ClientConfig{BaseURL: server.URL, Model: "llama3.1", MaxAttempts: 2})
+			require.NoError(t, err)
+
+			explanation, err := client.Explain(context.Background(), 0.4, templateFactors())
+			assert.Len(t, server.requests, tt.expectRequests)
+			if tt.expectErr {
+				var invalid *openai.InvalidReplyError
+				require.ErrorAs(t, err, &invalid)
+				assert.Equal(t, tt.expectRejected, invalid.Checks())
+				assert.Len(t, openai.CallsOf(err), tt.expectRequests, "rejected replies still used tokens")
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectRejected, explanation.Rejected)
+			assert.Len(t, explanation.Calls, tt.expectRequests)
+			assert.Equal(t, tt.expectRequests*1000, explanation.Usage.TotalTokens, "usage adds up every attempt")
+			if tt.expectRequests > 1 {
+				retry := server.requests[1].Messages
+				require.Len(t, retry, 4, "the retry shows the rejected reply and the reason")
+				assert.Equal(t, "assistant", retry[2].Role)
+				assert.Contains(t, retry[3].Content[0].Text, "rejected")
+			}
+		})
+	}
+}
+
+func TestCheckExplanation(t *testing.T) {
+	factors := templateFactors()
+	complete := func(summary string, texts map[string]string) *openai.Explanation {
+		explanation := &openai.Explanation{Summary: summary, Factors: map[string]openai.FactorExplanation{}}
+		for name := range factors {
+			explanation.Factors[name] = openai.FactorExplanation{Factor: name, Explanation: "Penjelasan " + name + "."}
+		}
+		for name, text := range texts {
+			explanation.Factors[name] = openai.FactorExplanation{Factor: name, Explanation: text}
+		}
+		return explanation
+	}
+	summary := "Ringkasan. " + idDisclaimer
+
+	tests := []struct {
+		name        string
+		explanation *openai.Explanation
+		expectCheck string
+	}{
+		{name: "valid", explanation: complete(summary, nil)},
+		{name: "english disclaimer", explanation: complete("Summary. This result is not a medical diagnosis. Consult a health professional for further evaluation.", nil)},
+		{name: "empty summary", explanation: complete(" ", nil), expectCheck: openai.CheckCompleteness},
+		{name: "empty factor", explanation: complete(summary, map[string]string{"bmi": ""}), expectCheck: openai.CheckCompleteness},
+		{name: "unexpected factor", explanation: complete(summary, map[string]string{"is_hypertension": "Hipertensi."}), expectCheck: openai.CheckCompleteness},
+		{name: "indonesian diagnosis", explanation: complete("Anda menderita diabetes. "+idDisclaimer, nil), expectCheck: openai.CheckSafety},
+		{name: "english diagnosis", explanation: complete(summary, map[string]string{"age": "You have diabetes."}), expectCheck: openai.CheckSafety},
+		{name: "dosage", explanation: complete(summary, map[string]string{"bmi": "Gunakan insulin 10 unit."}), expectCheck: openai.CheckSafety},
+		{name: "insulin resistance", explanation: complete(summary, map[string]string{"bmi": "Indeks massa tubuh yang tinggi berkaitan dengan resistensi insulin, sehingga meningkatkan risiko diabetes."})},
+		{name: "english insulin resistance", explanation: complete(summary, map[string]string{"bmi": "A high BMI is linked to insulin resistance, which raises diabetes risk."})},
+		{name: "insulin advice", explanation: complete(summary, map[string]string{"bmi": "Suntikkan insulin setiap malam."}), expectCheck: openai.CheckSafety},
+		{name: "english insulin advice", explanation: complete(summary, map[string]string{"bmi": "You should start insulin therapy."}), expectCheck: openai.CheckSafety},
+		{name: "prescription", explanation: complete(summary, map[string]string{"bmi": "Ask for a prescription."}), expectCheck: openai.CheckSafety},
+		{name: "missing disclaimer", explanation: complete("Ringkasan.", nil), expectCheck: openai.CheckDisclaimer},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := openai.CheckExplanation(tt.explanation, factors)
+			if tt.expectCheck == "" {
+				assert.NoError(t, err)
+				return
+			}
+			var checkErr *openai.CheckError
+			require.ErrorAs(t, err, &checkErr)
+			assert.Equal(t, tt.expectCheck, checkErr.Check)
+		})
+	}
+}
+
+func TestNewClientWithConfigRequiresKeyForOpenAI(t *testing.T) {
+	_, err := openai.NewClientWithConfig(openai.ClientConfig{})
+	assert.Error(t, err)
+
+	client, err := openai.NewClientWithConfig(openai.ClientConfig{APIKey: "sk-test"})
+	require.NoError(t, err)
+	assert.Equal(t, openai.DefaultModel, client.Model())
+}
+
+func TestFallbackExplainer(t *testing.T) {
+	tests := []struct {
+		name           string
+		primary        *stubExplainer
+		fallback       openai.Explainer
+		expectProvider string
+		expectFallback bool
+		expectRejected []string
+		expectCalls    int
+		expectErr      bool
+	}{
+		{name: "primary answers", primary: &stubExplainer{}, fallback: newTemplateExplainer(t, "id"), expectProvider: "stub"},
+		{name: "primary fails", primary: &stubExplainer{err: errors.New("rate limited")}, fallback: newTemplateExplainer(t, "id"), expectProvider: openai.ProviderTemplate, expectFallback: true},
+		{name: "primary times out", primary: &stubExplainer{block: true}, fallback: newTemplateExplainer(t, "id"), expectProvider: openai.ProviderTemplate, expectFallback: true},
+		{name: "primary replies are invalid", primary: &stubExplainer{err: &openai.InvalidReplyError{Rejected: []*openai.CheckError{{Check: openai.CheckSafety}, {Check: openai.CheckSchema}}}}, fallback: newTemplateExplainer(t, "id"), expectProvider: openai.ProviderTemplate, expectFallback: true, expectRejected: []string{openai.CheckSafety, openai.CheckSchema}},
+		{name: "primary calls are kept", primary: &stubExplainer{err: &openai.UsageError{Calls: []openai.Call{{Provider: "stub", Usage: openai.TokenUsage{TotalTokens: 1000}}}, Err: errors.New("rate limited")}}, fallback: newTemplateExplainer(t, "id"), expectProvider: openai.ProviderTemplate, expectFallback: true, expectCalls: 1},
+		{name: "both fail", primary: &stubExplainer{err: errors.New("rate limited")}, fallback: &stubExplainer{err: errors.New("down")}, expectErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			explainer := openai.NewFallbackExplainer(tt.primary, tt.fallback, 20*time.Millisecond)
+			assert.Equal(t, "stub", explainer.Name())
+
+			explanation, err := explainer.Explain(context.Background(), 0.4, templateFactors())
+			if tt.expectErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectProvider, explanation.Provider)
+			assert.Equal(t, tt.expectFallback, explanation.FallbackReason != "")
+			assert.Equal(t, tt.expectRejected, explanation.Rejected)
+			assert.Len(t, explanation.Calls, tt.expectCalls)
+		})
+	}
+}
+
+func TestNewExplainer(t *testing.T) {
+	tests := []struct {
+		name      string
+		cfg       openai.ExplainerConfig
+		expectErr bool
+		expectAs  interface{}
+	}{
+		{name: "template only", cfg: openai.ExplainerConfig{Provider: openai.ProviderTemplate}, expectAs: &openai.TemplateExplainer{}},
+		{name: "llm with template fallback", cfg: openai.ExplainerConfig{Provider: openai.ProviderOpenAI, Fallback: openai.ProviderTemplate, Client: openai.ClientConfig{APIKey: "sk-test"}}, expectAs: &openai.FallbackExplainer{}},
+		{name: "llm without fallback", cfg: openai.ExplainerConfig{Provider: openai.ProviderOpenAI, Fallback: openai.ProviderNone, Client: openai.ClientConfig{BaseURL: "http://localhost:11434/v1"}}, expectAs: &openai.Client{}},
+		{name: "llm without API key", cfg: openai.ExplainerConfig{Provider: openai.ProviderOpenAI, Fallback: openai.ProviderTemplate}, expectErr: true},
+		{name: "unknown provider", cfg: openai.ExplainerConfig{Provider: "mystery"}, expectErr: true},
+		{name: "unknown fallback", cfg: openai.ExplainerConfig{Provider: openai.ProviderOpenAI, Fallback: "cache", Client: openai.ClientConfig{APIKey: "sk-test"}}, expectErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			explainer, err := openai.NewExplainer(tt.cfg)
+			if tt.expectErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.IsType(t, tt.expectAs, explainer)
+		})
+	}
+}
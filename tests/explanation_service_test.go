@@ -0,0 +1,363 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"diabetify/internal/controllers"
+	"diabetify/internal/models"
+	"diabetify/internal/openai"
+	"diabetify/internal/services"
+	"diabetify/tests/mocks"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+// fakeSummary ends with the Indonesian disclaimer so it passes openai.CheckExplanation
+const fakeSummary = "Ringkasan. " + idDisclaimer
+
+// fakeUsage is the token usage of every fakeExplainer call
+var fakeUsage = openai.TokenUsage{PromptTokens: 80, CompletionTokens: 20, TotalTokens: 100}
+
+// fakeExplainer explains every factor with its value and counts its calls; advice is
+// appended to every factor explanation
+type fakeExplainer struct {
+	calls  int32
+	advice string
+}
+
+func (e *fakeExplainer) Name() string {
+	return "fake"
+}
+
+func (e *fakeExplainer) Explain(ctx context.Context, prediction float64, factors map[string]openai.FactorInput) (*openai.Explanation, error) {
+	atomic.AddInt32(&e.calls, 1)
+	explanations := make(map[string]openai.FactorExplanation, len(factors))
+	for name, factor := range factors {
+		explanations[name] = openai.FactorExplanation{Factor: name, Value: factor.Value, Explanation: name + " is " + factor.Value + e.advice}
+	}
+	return &openai.Explanation{
+		Factors:  explanations,
+		Summary:  fakeSummary,
+		Usage:    fakeUsage,
+		Provider: e.Name(),
+		Model:    "fake-1",
+		Calls:    []openai.Call{{Provider: e.Name(), Model: "fake-1", Usage: fakeUsage, Latency: 5 * time.Millisecond, Cost: 0.0004}},
+	}, nil
+}
+
+type memoryExplanationCache struct {
+	mu      sync.Mutex
+	entries map[string]*models.PredictionExplanation
+}
+
+func (c *memoryExplanationCache) GetExplanation(hash string) (*models.PredictionExplanation, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	explanation, ok := c.entries[hash]
+	return explanation, ok, nil
+}
+
+func (c *memoryExplanationCache) StoreExplanation(hash string, explanation *models.PredictionExplanation, duration time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[hash] = explanation
+	return nil
+}
+
+func explainablePrediction(id uint) *models.Prediction {
+	return &models.Prediction{
+		ID:        id,
+		UserID:    1,
+		RiskScore: 0.35,
+		Factors: []models.PredictionFactor{
+			{Factor: "age", Value: 50, Shap: 0.12},
+			{Factor: "bmi", Value: 31.5, Shap: 0.2},
+			{Factor: "smoking_status", Value: 2, Shap: 0.05},
+		},
+	}
+}
+
+func TestExplanationContentHash(t *testing.T) {
+	base := services.ExplanationContentHash(explainablePrediction(1))
+	assert.Equal(t, base, services.ExplanationContentHash(explainablePrediction(2)), "the prediction ID is not part of the content")
+
+	risk := explainablePrediction(1)
+	risk.RiskScore = 0.36
+	assert.NotEqual(t, base, services.ExplanationContentHash(risk))
+
+	shap := explainablePrediction(1)
+	shap.Factors[1].Shap = 0.21
+	assert.NotEqual(t, base, services.ExplanationContentHash(shap))
+
+	value := explainablePrediction(1)
+	value.Factors[0].Value = 51
+	assert.NotEqual(t, base, services.ExplanationContentHash(value))
+}
+
+func TestExplanationServiceReusesCachedExplanations(t *testing.T) {
+	jobRepo := new(mocks.MockPredictionJobRepository)
+	predRepo := new(mocks.MockPredictionRepository)
+	explainer := &fakeExplainer{}
+	cache := &memoryExplanationCache{entries: map[string]*models.PredictionExplanation{}}
+
+	var mu sync.Mutex
+	completed := map[string]bool{}
+	updated := map[uint]*models.Prediction{}
+	jobRepo.On("GetPendingJobs", 50).Return([]*models.PredictionJob{}, nil)
+	jobRepo.On("GetJobsByTypeAndStatus", models.JobTypeExplanation, models.JobStatusProcessing, 50).Return([]*models.PredictionJob{}, nil)
+	jobRepo.On("SaveJob", mock.AnythingOfType("*models.PredictionJob")).Return(nil)
+	jobRepo.On("UpdateJobStatus", mock.Anything, models.JobStatusProcessing, (*string)(nil)).Return(nil)
+	jobRepo.On("UpdateJobStatus", mock.Anything, models.JobStatusCompleted, (*string)(nil)).Run(func(args mock.Arguments) {
+		mu.Lock()
+		defer mu.Unlock()
+		completed[args.String(0)] = true
+	}).Return(nil)
+	predRepo.On("SaveExplanation", mock.AnythingOfType("*models.Prediction")).Run(func(args mock.Arguments) {
+		mu.Lock()
+		defer mu.Unlock()
+		prediction := args.Get(0).(*models.Prediction)
+		updated[prediction.ID] = prediction
+	}).Return(nil)
+
+	service := services.NewExplanationService(jobRepo, predRepo, nil, explainer, nil, cache, services.ExplanationConfig{
+		Concurrency: 1,
+		QueueSize:   10,
+		Timeout:     time.Second,
+		CacheTTL:    time.Hour,
+	})
+	service.Start()
+
+	first, err := service.Enqueue(explainablePrediction(1))
+	require.NoError(t, err)
+	second, err := service.Enqueue(explainablePrediction(2))
+	require.NoError(t, err)
+	assert.Equal(t, models.JobTypeExplanation, first.JobType)
+	assert.Equal(t, models.JobStatusPending, first.Status)
+	require.NotNil(t, first.PredictionID)
+	assert.Equal(t, uint(1), *first.PredictionID)
+	assert.Equal(t, first.InputHash, second.InputHash)
+
+	service.Stop()
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&explainer.calls), "identical inputs reuse the cached explanation")
+	assert.True(t, completed[first.ID])
+	assert.True(t, completed[second.ID])
+	require.Len(t, updated, 2)
+	for _, prediction := range updated {
+		assert.True(t, prediction.HasExplanations())
+		assert.Equal(t, fakeSummary, prediction.PredictionSummary)
+		assert.Equal(t, "bmi is 31.5", prediction.Factor("bmi").Explanation)
+	}
+}
+
+func TestExplanationServiceBlocksUnsafeExplanations(t *testing.T) {
+	jobRepo := new(mocks.MockPredictionJobRepository)
+	predRepo := new(mocks.MockPredictionRepository)
+	cache := &memoryExplanationCache{entries: map[string]*models.PredictionExplanation{}}
+
+	var failure string
+	jobRepo.On("GetPendingJobs", 50).Return([]*models.PredictionJob{}, nil)
+	jobRepo.On("GetJobsByTypeAndStatus", models.JobTypeExplanation, models.JobStatusProcessing, 50).Return([]*models.PredictionJob{}, nil)
+	jobRepo.On("SaveJob", mock.AnythingOfType("*models.PredictionJob")).Return(nil)
+	jobRepo.On("UpdateJobStatus", mock.Anything, models.JobStatusProcessing, (*string)(nil)).Return(nil)
+	jobRepo.On("UpdateJobStatus", mock.Anything, models.JobStatusFailed, mock.AnythingOfType("*string")).Run(func(args mock.Arguments) {
+		failure = *args.Get(2).(*string)
+	}).Return(nil)
+
+	service := services.NewExplanationService(jobRepo, predRepo, nil, &fakeExplainer{advice: ". Minum obat metformin 500 mg."}, nil, cache, services.ExplanationConfig{
+		Concurrency: 1,
+		QueueSize:   10,
+		Timeout:     time.Second,
+		CacheTTL:    time.Hour,
+	})
+	service.Start()
+	_, err := service.Enqueue(explainablePrediction(1))
+	require.NoError(t, err)
+	service.Stop()
+
+	assert.Contains(t, failure, "Explanation failed safety checks")
+	predRepo.AssertNotCalled(t, "SaveExplanation", mock.Anything)
+	assert.Empty(t, cache.entries, "blocked explanations are not cached")
+
+	stats := service.Stats()
+	assert.Equal(t, map[string]int{openai.CheckSafety: 1}, stats["blocked"])
+	assert.Equal(t, map[string]int{}, stats["stored"])
+	assert.Equal(t, 1, stats["failed"])
+}
+
+func TestExplanationServiceWithoutExplainer(t *testing.T) {
+	jobRepo := new(mocks.MockPredictionJobRepository)
+	predRepo := new(mocks.MockPredictionRepository)
+	service := services.NewExplanationService(jobRepo, predRepo, nil, nil, nil, nil, services.ExplanationConfig{Concurrency: 1, QueueSize: 1, Timeout: time.Second})
+
+	_, err := service.Enqueue(explainablePrediction(1))
+	assert.ErrorIs(t, err, services.ErrExplanationUnavailable)
+	jobRepo.AssertNotCalled(t, "SaveJob", mock.Anything)
+}
+
+func TestExplanationServiceRecoversStuckJobs(t *testing.T) {
+	jobRepo := new(mocks.MockPredictionJobRepository)
+	predRepo := new(mocks.MockPredictionRepository)
+
+	predictionID := uint(1)
+	stuck := &models.PredictionJob{ID: "explain-stuck", UserID: 1, JobType: models.JobTypeExplanation, Status: models.JobStatusProcessing, PredictionID: &predictionID, UpdatedAt: time.Now().Add(-time.Minute)}
+	recent := &models.PredictionJob{ID: "explain-recent", UserID: 1, JobType: models.JobTypeExplanation, Status: models.JobStatusProcessing, PredictionID: &predictionID, UpdatedAt: time.Now()}
+	completed := make(chan string, 1)
+	jobRepo.On("GetPendingJobs", 50).Return([]*models.PredictionJob{}, nil)
+	jobRepo.On("GetJobsByTypeAndStatus", models.JobTypeExplanation, models.JobStatusProcessing, 50).Return([]*models.PredictionJob{stuck, recent}, nil)
+	jobRepo.On("GetJobByID", "explain-stuck").Return(stuck, nil)
+	jobRepo.On("UpdateJobStatus", "explain-stuck", models.JobStatusProcessing, (*string)(nil)).Return(nil)
+	jobRepo.On("UpdateJobStatus", "explain-stuck", models.JobStatusCompleted, (*string)(nil)).Run(func(args mock.Arguments) {
+		completed <- args.String(0)
+	}).Return(nil)
+	predRepo.On("GetPredictionByID", predictionID).Return(explainablePrediction(predictionID), nil)
+	predRepo.On("SaveExplanation", mock.AnythingOfType("*models.Prediction")).Return(nil)
+
+	service := services.NewExplanationService(jobRepo, predRepo, nil, &fakeExplainer{}, nil, nil, services.ExplanationConfig{Concurrency: 1, QueueSize: 10, Timeout: 30 * time.Second})
+	service.Start()
+	defer service.Stop()
+
+	select {
+	case jobID := <-completed:
+		assert.Equal(t, "explain-stuck", jobID)
+	case <-time.After(time.Second):
+		t.Fatal("the stuck job was not recovered")
+	}
+	// A job updated within the timeout may still be running elsewhere
+	jobRepo.AssertNotCalled(t, "GetJobByID", "explain-recent")
+}
+
+func TestGetLatestPredictionExplanationStartsJob(t *testing.T) {
+	pendingJob := &models.PredictionJob{ID: "explain-1", UserID: 1, JobType: models.JobTypeExplanation, Status: models.JobStatusProcessing}
+
+	tests := []struct {
+		name           string
+		withService    bool
+		query          string
+		setupMock      func(*mocks.MockPredictionJobRepository)
+		expectedStatus int
+		expectedJobID  string
+	}{
+		{
+			name:        "running job is reported",
+			withService: true,
+			setupMock: func(jobRepo *mocks.MockPredictionJobRepository) {
+				jobRepo.On("FindLatestJobByPredictionID", uint(1), models.JobTypeExplanation, uint(5)).Return(pendingJob, nil)
+			},
+			expectedStatus: http.StatusAccepted,
+			expectedJobID:  "explain-1",
+		},
+		{
+			name:        "new job is started",
+			withService: true,
+			setupMock: func(jobRepo *mocks.MockPredictionJobRepository) {
+				jobRepo.On("FindLatestJobByPredictionID", uint(1), models.JobTypeExplanation, uint(5)).Return(nil, gorm.ErrRecordNotFound)
+				jobRepo.On("SaveJob", mock.AnythingOfType("*models.PredictionJob")).Return(nil)
+			},
+			expectedStatus: http.StatusAccepted,
+		},
+		{
+			name:        "failed job is reported",
+			withService: true,
+			setupMock: func(jobRepo *mocks.MockPredictionJobRepository) {
+				errMsg := "Failed to generate explanation: LLM token budget exceeded"
+				failed := &models.PredictionJob{ID: "explain-0", UserID: 1, JobType: models.JobTypeExplanation, Status: models.JobStatusFailed, ErrorMessage: &errMsg, UpdatedAt: time.Now()}
+				jobRepo.On("FindLatestJobByPredictionID", uint(1), models.JobTypeExplanation, uint(5)).Return(failed, nil)
+			},
+			expectedStatus: http.StatusServiceUnavailable,
+		},
+		{
+			name:        "failed job is retried on request",
+			withService: true,
+			query:       "?retry=true",
+			setupMock: func(jobRepo *mocks.MockPredictionJobRepository) {
+				failed := &models.PredictionJob{ID: "explain-0", UserID: 1, JobType: models.JobTypeExplanation, Status: models.JobStatusFailed, UpdatedAt: time.Now()}
+				jobRepo.On("FindLatestJobByPredictionID", uint(1), models.JobTypeExplanation, uint(5)).Return(failed, nil)
+				jobRepo.On("SaveJob", mock.AnythingOfType("*models.PredictionJob")).Return(nil)
+			},
+			expectedStatus: http.StatusAccepted,
+		},
+		{
+			name:        "failed job is retried after the backoff",
+			withService: true,
+			setupMock: func(jobRepo *mocks.MockPredictionJobRepository) {
+				failed := &models.PredictionJob{ID: "explain-0", UserID: 1, JobType: models.JobTypeExplanation, Status: models.JobStatusFailed, UpdatedAt: time.Now().Add(-time.Hour)}
+				jobRepo.On("FindLatestJobByPredictionID", uint(1), models.JobTypeExplanation, uint(5)).Return(failed, nil)
+				jobRepo.On("SaveJob", mock.AnythingOfType("*models.PredictionJob")).Return(nil)
+			},
+			expectedStatus: http.StatusAccepted,
+		},
+		{
+			name:           "explanations not configured",
+			setupMock:      func(jobRepo *mocks.MockPredictionJobRepository) {},
+			expectedStatus: http.StatusServiceUnavailable,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			predRepo := new(mocks.MockPredictionRepository)
+			jobRepo := new(mocks.MockPredictionJobRepository)
+			predRepo.On("GetLatestPredictionByUserID", uint(1)).Return(explainablePrediction(5), nil)
+			tt.setupMock(jobRepo)
+
+			// The service is not started, so enqueued jobs stay pending
+			var explanations services.ExplanationService
+			if tt.withService {
+				explanations = services.NewExplanationService(jobRepo, predRepo, nil, &fakeExplainer{}, nil, nil, services.ExplanationConfig{Concurrency: 1, QueueSize: 10, Timeout: time.Second})
+			}
+			controller := controllers.NewPredictionController(
+				predRepo,
+				new(mocks.MockUserRepository),
+				new(mocks.MockUserProfileRepository),
+				new(mocks.MockActivityRepository),
+				jobRepo,
+				new(mocks.MockPredictionJobWorker),
+				new(mocks.MockMLClient),
+				services.NewPredictionQuota(jobRepo, services.PredictionQuotaConfigFromEnv()),
+				explanations,
+			)
+
+			router := setupPredictionTestRouter()
+			router.Use(addPredictionAuthMiddleware(1))
+			router.GET("/prediction/me/explanation", controller.GetLatestPredictionExplanation)
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest("GET", "/prediction/me/explanation"+tt.query, nil))
+
+			assert.Equal(t, tt.expectedStatus, w.Code, w.Body.String())
+			if tt.expectedStatus == http.StatusAccepted {
+				var response struct {
+					Data struct {
+						JobID        string `json:"job_id"`
+						JobStatus    string `json:"job_status"`
+						PredictionID uint   `json:"prediction_id"`
+						PollURL      string `json:"poll_url"`
+					} `json:"data"`
+				}
+				require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+				require.NotEmpty(t, response.Data.JobID)
+				if tt.expectedJobID != "" {
+					assert.Equal(t, tt.expectedJobID, response.Data.JobID)
+				}
+				assert.Equal(t, uint(5), response.Data.PredictionID)
+				assert.Equal(t, "/prediction/job/"+response.Data.JobID+"/status", response.Data.PollURL)
+			}
+			if tt.withService && tt.expectedStatus == http.StatusServiceUnavailable {
+				assert.NotEmpty(t, w.Header().Get("Retry-After"))
+				assert.Contains(t, w.Body.String(), "LLM token budget exceeded")
+			}
+			jobRepo.AssertExpectations(t)
+		})
+	}
+}
@@ -0,0 +1,118 @@
+package tests
+
+import (
+	"encoding/json"
+	"testing"
+
+	"diabetify/internal/ml"
+	"diabetify/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFeatureRegistryMatchesModelInput(t *testing.T) {
+	require.Len(t, models.FeatureRegistry, len(ml.FeatureNames))
+	for i, feature := range models.FeatureRegistry {
+		assert.Equal(t, ml.FeatureNames[i], feature.ModelName)
+
+		byName, ok := models.FeatureByName(feature.Name)
+		assert.True(t, ok)
+		assert.Equal(t, feature, byName)
+		byModelName, ok := models.FeatureByModelName(feature.ModelName)
+		assert.True(t, ok)
+		assert.Equal(t, feature, byModelName)
+	}
+
+	_, ok := models.FeatureByName("BMI")
+	assert.False(t, ok)
+
+	ranked := models.FeaturesByImportance()
+	require.Len(t, ranked, len(models.FeatureRegistry))
+	assert.Equal(t, "age", ranked[0].Name)
+	assert.Equal(t, "bmi", ranked[1].Name)
+	assert.Equal(t, "physical_activity_frequency", ranked[len(ranked)-1].Name)
+}
+
+func TestFeatureValueTypes(t *testing.T) {
+	tests := []struct {
+		name      string
+		value     float64
+		typed     interface{}
+		formatted string
+	}{
+		{"age", 52, 52, "52 years"},
+		{"bmi", 27.44, 27.44, "27.4"},
+		{"is_hypertension", 1, true, "true"},
+		{"is_cholesterol", 0, false, "false"},
+		{"brinkman_score", 2, 2, "2"},
+		{"physical_activity_frequency", 3, 3, "3 times"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			feature, ok := models.FeatureByName(tt.name)
+			require.True(t, ok)
+			assert.Equal(t, tt.typed, feature.TypedValue(tt.value))
+			assert.Equal(t, tt.formatted, feature.FormatValue(tt.value))
+		})
+	}
+}
+
+func TestPredictionFactorsByImportance(t *testing.T) {
+	prediction := models.Prediction{
+		Factors: []models.PredictionFactor{
+			{Factor: "bmi", Value: 27.4, Shap: 0.09},
+			{Factor: "age", Value: 50, Shap: 0.04},
+		},
+	}
+
+	factors := prediction.FactorsByImportance()
+	require.Len(t, factors, len(models.FeatureRegistry))
+	assert.Equal(t, models.PredictionFactor{Factor: "age", Value: 50, Shap: 0.04}, factors[0])
+	assert.Equal(t, models.PredictionFactor{Factor: "bmi", Value: 27.4, Shap: 0.09}, factors[1])
+	// Factors without a stored row are zero
+	assert.Equal(t, models.PredictionFactor{Factor: "is_hypertension"}, factors[2])
+
+	assert.Equal(t, 27.4, prediction.FactorValue("bmi"))
+	assert.Equal(t, 0.0, prediction.FactorValue("smoking_status"))
+	assert.Nil(t, prediction.Factor("smoking_status"))
+}
+
+func TestPredictionJSONKeepsFlatFeatureFields(t *testing.T) {
+	prediction := models.Prediction{
+		ID:        7,
+		RiskScore: 0.42,
+		Factors: []models.PredictionFactor{
+			{Factor: "age", Value: 45, Shap: 0.12, Contribution: 0.3, Impact: 1, Explanation: "Age raises the risk."},
+			{Factor: "bmi", Value: 27.5, Shap: 0.05},
+			{Factor: "is_hypertension", Value: 1},
+			{Factor: "is_macrosomic_baby", Value: 2, Shap: -0.01},
+		},
+	}
+
+	for _, value := range []interface{}{prediction, &prediction} {
+		body, err := json.Marshal(value)
+		require.NoError(t, err)
+
+		var fields map[string]interface{}
+		require.NoError(t, json.Unmarshal(body, &fields))
+		assert.Equal(t, float64(7), fields["id"])
+		assert.Equal(t, float64(45), fields["age"])
+		assert.Equal(t, 0.12, fields["age_shap"])
+		assert.Equal(t, 0.3, fields["age_contribution"])
+		assert.Equal(t, float64(1), fields["age_impact"])
+		assert.Equal(t, "Age raises the risk.", fields["age_explanation"])
+		assert.Equal(t, 27.5, fields["bmi"])
+		assert.Equal(t, true, fields["is_hypertension"])
+		assert.Equal(t, float64(2), fields["macrosomic_baby"])
+		assert.Equal(t, -0.01, fields["is_macrosomic_baby_shap"])
+		assert.Equal(t, false, fields["is_cholesterol"], "features without a stored factor are zero")
+		assert.Equal(t, "", fields["smoking_status_explanation"])
+		assert.Len(t, fields["factors"], 4)
+
+		var decoded models.Prediction
+		require.NoError(t, json.Unmarshal(body, &decoded))
+		assert.Equal(t, prediction.Factors[0].Explanation, decoded.Factors[0].Explanation)
+	}
+}
@@ -0,0 +1,185 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"testing"
+
+	"diabetify/internal/ml"
+	"diabetify/internal/ml/pb"
+	"diabetify/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// startStubMLServer serves the test model over an in-memory gRPC listener
+func startStubMLServer(t *testing.T, configure func(*ml.ScorerServer)) (*ml.GRPCClient, *ml.LocalScorer) {
+	t.Helper()
+
+	scorer, err := ml.NewLocalScorerFromFile("testdata/local_model_lr.json")
+	require.NoError(t, err)
+
+	stub := ml.NewScorerServer(scorer)
+	if configure != nil {
+		configure(stub)
+	}
+
+	lis := bufconn.Listen(1024 * 1024)
+	server := grpc.NewServer()
+	pb.RegisterMLServiceServer(server, stub)
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+
+	client, err := ml.NewGRPCClient("passthrough:///bufnet", grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+		return lis.DialContext(ctx)
+	}))
+	require.NoError(t, err)
+	t.Cleanup(func() { client.Close() })
+
+	return client, scorer
+}
+
+func TestGRPCClientPredictSync(t *testing.T) {
+	client, scorer := startStubMLServer(t, nil)
+
+	result, err := client.PredictSync(context.Background(), localScorerFeatures)
+	require.NoError(t, err)
+
+	expected, err := scorer.Score(localScorerFeatures)
+	require.NoError(t, err)
+
+	assert.InDelta(t, expected.Prediction, result.Prediction, 1e-12)
+	assert.Equal(t, "diabetes-risk-lr", result.ModelName)
+	assert.Equal(t, expected.Explanation["BMI"], result.Explanation["BMI"])
+
+	_, err = client.PredictSync(context.Background(), []float64{1})
+	assert.Error(t, err)
+}
+
+func TestGRPCClientPredictAsyncDeliversResponse(t *testing.T) {
+	client, _ := startStubMLServer(t, nil)
+
+	var delivered map[string]interface{}
+	client.SetResponseHandler(func(body []byte) error {
+		return json.Unmarshal(body, &delivered)
+	})
+
+	err := client.PredictAsync(context.Background(), "job-42", localScorerFeatures)
+	require.NoError(t, err)
+
+	assert.Equal(t, "job-42", delivered["correlation_id"])
+	assert.Equal(t, ml.SourceGRPC, delivered["source"])
+	assert.Equal(t, "test-1", delivered["model_version"])
+	assert.Equal(t, ml.FeatureSchemaVersion, delivered["feature_schema_version"])
+	assert.Equal(t, ml.HashFeatures(localScorerFeatures), delivered["input_hash"])
+}
+
+func TestGRPCClientHealthAndUpdateModel(t *testing.T) {
+	client, _ := startStubMLServer(t, nil)
+
+	health, err := client.ProbeHealth(context.Background())
+	require.NoError(t, err)
+	assert.True(t, health.Healthy)
+	assert.Equal(t, "diabetes-risk-lr", health.ModelName)
+
+	_, err = client.UpdateModel(context.Background(), &models.UpdateModelRequest{Epochs: 1})
+	assert.Error(t, err, "stub without UpdateFunc should answer Unimplemented")
+
+	updating, _ := startStubMLServer(t, func(s *ml.ScorerServer) {
+		s.UpdateFunc = func(ctx context.Context, request *models.UpdateModelRequest) (*models.UpdateModelResponse, error) {
+			assert.Len(t, request.XNew, 2)
+			assert.Equal(t, []float64{1, 0}, request.YNew)
+			assert.True(t, request.DryRun)
+			return &models.UpdateModelResponse{Status: "trained", AUCBefore: 0.8, AUCAfter: 0.82, CandidateVersion: "test-2"}, nil
+		}
+		s.PromoteFunc = func(ctx context.Context, candidateVersion string) (*models.PromoteModelResponse, error) {
+			return &models.PromoteModelResponse{Status: "promoted", ModelVersion: candidateVersion}, nil
+		}
+	})
+
+	response, err := updating.UpdateModel(context.Background(), &models.UpdateModelRequest{
+		XNew:   [][]float64{localScorerFeatures, localScorerFeatures},
+		YNew:   []float64{1, 0},
+		Epochs: 3,
+		DryRun: true,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "trained", response.Status)
+	assert.Equal(t, 0.82, response.AUCAfter)
+	assert.Equal(t, "test-2", response.CandidateVersion)
+
+	promoted, err := updating.PromoteModel(context.Background(), response.CandidateVersion)
+	require.NoError(t, err)
+	assert.Equal(t, "test-2", promoted.ModelVersion)
+
+	_, err = client.PromoteModel(context.Background(), "test-2")
+	assert.Error(t, err, "stub without PromoteFunc should answer Unimplemented")
+}
+
+func TestTransportConfigFromEnv(t *testing.T) {
+	t.Setenv("ML_TRANSPORT", "")
+	t.Setenv("ML_SERVICE_ADDRESS", "")
+	t.Setenv("ML_TRANSPORT_HEALTH", "")
+	t.Setenv("ML_TRANSPORT_PREDICTION", "")
+	t.Setenv("ML_TRANSPORT_WHAT_IF", "")
+	t.Setenv("ML_TRANSPORT_MODEL_UPDATE", "")
+
+	cfg := ml.TransportConfigFromEnv()
+	assert.Equal(t, ml.TransportRabbitMQ, cfg[ml.CallPrediction])
+	assert.Equal(t, ml.TransportRabbitMQ, cfg[ml.CallWhatIf])
+	assert.Equal(t, ml.TransportGRPC, cfg[ml.CallModelUpdate])
+
+	t.Setenv("ML_SERVICE_ADDRESS", "ml:50051")
+	t.Setenv("ML_TRANSPORT_HEALTH", "GRPC")
+	cfg = ml.TransportConfigFromEnv()
+	assert.Equal(t, ml.TransportRabbitMQ, cfg[ml.CallWhatIf], "a gRPC address alone does not move what-if traffic")
+	assert.Equal(t, ml.TransportGRPC, cfg[ml.CallHealth])
+
+	t.Setenv("ML_TRANSPORT_WHAT_IF", "grpc")
+	cfg = ml.TransportConfigFromEnv()
+	assert.Equal(t, ml.TransportGRPC, cfg[ml.CallWhatIf])
+	assert.Equal(t, ml.TransportGRPC, cfg[ml.CallHealth])
+	assert.Equal(t, ml.TransportRabbitMQ, cfg[ml.CallPrediction])
+}
+
+func TestHybridClientRoutesByCallType(t *testing.T) {
+	scorer, err := ml.NewLocalScorerFromFile("testdata/local_model_lr.json")
+	require.NoError(t, err)
+
+	_, err = ml.NewHybridMLClient(ml.HybridConfig{
+		Transports: ml.TransportConfig{ml.CallPrediction: "carrier-pigeon"},
+	})
+	assert.Error(t, err)
+
+	hybrid, err := ml.NewHybridMLClient(ml.HybridConfig{
+		Transports: ml.TransportConfig{ml.CallPrediction: ml.TransportLocal, ml.CallWhatIf: ml.TransportLocal},
+		Local:      scorer,
+	})
+	require.NoError(t, err)
+
+	var sources []string
+	hybrid.SetResponseHandler(func(body []byte) error {
+		var message map[string]interface{}
+		if err := json.Unmarshal(body, &message); err != nil {
+			return err
+		}
+		sources = append(sources, message["source"].(string))
+		return nil
+	})
+
+	ctx := ml.WithCallType(context.Background(), ml.CallWhatIf)
+	require.NoError(t, hybrid.PredictAsync(ctx, "job-1", localScorerFeatures))
+	assert.Equal(t, []string{ml.SourceLocal}, sources)
+
+	result, err := hybrid.PredictSync(context.Background(), localScorerFeatures)
+	require.NoError(t, err)
+	assert.Greater(t, result.Prediction, 0.0)
+
+	// No transport configured for model updates
+	_, err = hybrid.UpdateModel(context.Background(), &models.UpdateModelRequest{})
+	assert.Error(t, err)
+}
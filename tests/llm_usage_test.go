@@ -0,0 +1,199 @@
+package tests
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"diabetify/internal/controllers"
+	"diabetify/internal/models"
+	"diabetify/internal/openai"
+	"diabetify/internal/repository"
+	"diabetify/internal/services"
+	"diabetify/tests/mocks"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// explainOnce runs one explanation job through the service and returns the prediction it
+// stored, or the failure message of the job
+func explainOnce(t *testing.T, usageRepo repository.LLMUsageRepository, explainer, budgetFallback openai.Explainer, cache services.ExplanationCache, cfg services.ExplanationConfig) (*models.Prediction, string) {
+	jobRepo := new(mocks.MockPredictionJobRepository)
+	predRepo := new(mocks.MockPredictionRepository)
+
+	var stored *models.Prediction
+	var failure string
+	jobRepo.On("GetPendingJobs", 50).Return([]*models.PredictionJob{}, nil)
+	jobRepo.On("GetJobsByTypeAndStatus", models.JobTypeExplanation, models.JobStatusProcessing, 50).Return([]*models.PredictionJob{}, nil)
+	jobRepo.On("SaveJob", mock.AnythingOfType("*models.PredictionJob")).Return(nil)
+	jobRepo.On("UpdateJobStatus", mock.Anything, models.JobStatusProcessing, (*string)(nil)).Return(nil)
+	jobRepo.On("UpdateJobStatus", mock.Anything, models.JobStatusCompleted, (*string)(nil)).Return(nil)
+	jobRepo.On("UpdateJobStatus", mock.Anything, models.JobStatusFailed, mock.AnythingOfType("*string")).Run(func(args mock.Arguments) {
+		failure = *args.Get(2).(*string)
+	}).Return(nil)
+	predRepo.On("SaveExplanation", mock.AnythingOfType("*models.Prediction")).Run(func(args mock.Arguments) {
+		stored = args.Get(0).(*models.Prediction)
+	}).Return(nil)
+
+	cfg.Concurrency, cfg.QueueSize, cfg.Timeout = 1, 10, time.Second
+	service := services.NewExplanationService(jobRepo, predRepo, usageRepo, explainer, budgetFallback, cache, cfg)
+	service.Start()
+	_, err := service.Enqueue(explainablePrediction(1))
+	require.NoError(t, err)
+	service.Stop()
+	return stored, failure
+}
+
+func TestExplanationServiceRecordsLLMUsage(t *testing.T) {
+	usageRepo := new(mocks.MockLLMUsageRepository)
+	var saved *models.LLMUsage
+	usageRepo.On("SaveUsage", mock.AnythingOfType("*models.LLMUsage")).Run(func(args mock.Arguments) {
+		saved = args.Get(0).(*models.LLMUsage)
+	}).Return(nil)
+
+	stored, failure := explainOnce(t, usageRepo, &fakeExplainer{}, nil, nil, services.ExplanationConfig{})
+	require.Empty(t, failure)
+	require.NotNil(t, stored)
+
+	require.NotNil(t, saved)
+	assert.Equal(t, uint(1), saved.UserID)
+	assert.Equal(t, uint(1), saved.PredictionID)
+	assert.NotEmpty(t, saved.JobID)
+	assert.Equal(t, "fake", saved.Provider)
+	assert.Equal(t, "fake-1", saved.Model)
+	assert.Equal(t, 80, saved.PromptTokens)
+	assert.Equal(t, 20, saved.CompletionTokens)
+	assert.Equal(t, 100, saved.TotalTokens)
+	assert.Equal(t, int64(5), saved.LatencyMs)
+	assert.Equal(t, 0.0004, saved.CostEstimate)
+	usageRepo.AssertNotCalled(t, "TotalTokensSince", mock.Anything, mock.Anything)
+}
+
+func TestExplanationServiceTokenBudgets(t *testing.T) {
+	budgets := services.ExplanationConfig{DailyTokenBudget: 1000, UserDailyTokenBudget: 200}
+
+	tests := []struct {
+		name           string
+		globalUsed     int
+		userUsed       int
+		usageErr       error
+		withFallback   bool
+		cached         bool
+		expectTemplate bool
+		expectFailure  string
+	}{
+		{name: "under budget", globalUsed: 500, userUsed: 100, withFallback: true},
+		{name: "global budget spent", globalUsed: 1000, withFallback: true, expectTemplate: true},
+		{name: "user budget spent", globalUsed: 500, userUsed: 250, withFallback: true, expectTemplate: true},
+		{name: "unreadable usage counts as spent", usageErr: errors.New("connection refused"), withFallback: true, expectTemplate: true},
+		{name: "cached explanation while over budget", globalUsed: 1000, withFallback: true, cached: true},
+		{name: "over budget without fallback", globalUsed: 1000, expectFailure: "LLM token budget exceeded"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			usageRepo := new(mocks.MockLLMUsageRepository)
+			usageRepo.On("TotalTokensSince", uint(0), mock.AnythingOfType("time.Time")).Return(tt.globalUsed, tt.usageErr).Maybe()
+			usageRepo.On("TotalTokensSince", uint(1), mock.AnythingOfType("time.Time")).Return(tt.userUsed, tt.usageErr).Maybe()
+			usageRepo.On("SaveUsage", mock.AnythingOfType("*models.LLMUsage")).Return(nil).Maybe()
+
+			cache := &memoryExplanationCache{entries: map[string]*models.PredictionExplanation{}}
+			if tt.cached {
+				cache.entries["fake:"+services.ExplanationContentHash(explainablePrediction(1))] = &models.PredictionExplanation{
+					Summary:  fakeSummary,
+					Factors:  map[string]string{"bmi": "cached"},
+					Provider: "fake",
+				}
+			}
+			var fallback openai.Explainer
+			if tt.withFallback {
+				fallback = newTemplateExplainer(t, "id")
+			}
+			explainer := &fakeExplainer{}
+
+			stored, failure := explainOnce(t, usageRepo, explainer, fallback, cache, budgets)
+			if tt.expectFailure != "" {
+				assert.Contains(t, failure, tt.expectFailure)
+				assert.Nil(t, stored)
+				assert.Zero(t, atomic.LoadInt32(&explainer.calls))
+				return
+			}
+			require.Empty(t, failure)
+			require.NotNil(t, stored)
+			// Template summaries are written from the catalog, the others are fakeSummary
+			assert.Equal(t, tt.expectTemplate, stored.PredictionSummary != fakeSummary)
+			if tt.expectTemplate || tt.cached {
+				assert.Zero(t, atomic.LoadInt32(&explainer.calls), "the provider is not called")
+				usageRepo.AssertNotCalled(t, "SaveUsage", mock.Anything)
+			}
+		})
+	}
+}
+
+func TestGetLLMUsageReport(t *testing.T) {
+	usageRepo := new(mocks.MockLLMUsageRepository)
+	since := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	until := time.Date(2024, 6, 3, 0, 0, 0, 0, time.UTC)
+	usageRepo.On("GetDailyTotals", since, until).Return([]repository.LLMUsageTotal{
+		{Day: "2024-06-01", Provider: "openai", Model: "gpt-4o", Calls: 3, PromptTokens: 2700, CompletionTokens: 300, TotalTokens: 3000, CostEstimate: 0.00975},
+		{Day: "2024-06-02", Provider: "openai", Model: "gpt-4o", Calls: 1, PromptTokens: 900, CompletionTokens: 100, TotalTokens: 1000, CostEstimate: 0.00325},
+	}, nil)
+	usageRepo.On("GetTopUsers", since, until, 10).Return([]repository.LLMUserUsage{{UserID: 7, Calls: 4, TotalTokens: 4000, CostEstimate: 0.013}}, nil)
+	usageRepo.On("TotalTokensSince", uint(0), mock.AnythingOfType("time.Time")).Return(900, nil)
+
+	explanations := services.NewExplanationService(nil, nil, usageRepo, &fakeExplainer{}, nil, nil, services.ExplanationConfig{DailyTokenBudget: 1000})
+	controller := controllers.NewAdminController(nil, nil, nil, nil)
+	controller.SetExplanations(explanations)
+	router := setupPredictionTestRouter()
+	router.GET("/admin/llm-usage", controller.GetLLMUsageReport)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/admin/llm-usage?since=2024-06-01&until=2024-06-02", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var response struct {
+		Data services.LLMUsageReport `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, int64(4), response.Data.Totals.Calls)
+	assert.Equal(t, int64(4000), response.Data.Totals.TotalTokens)
+	assert.InDelta(t, 0.013, response.Data.Totals.CostEstimate, 1e-9)
+	assert.Len(t, response.Data.Daily, 2)
+	assert.Len(t, response.Data.TopUsers, 1)
+	assert.Equal(t, 900, response.Data.Budget.UsedToday)
+	require.NotNil(t, response.Data.Budget.RemainingToday)
+	assert.Equal(t, 100, *response.Data.Budget.RemainingToday)
+	assert.False(t, response.Data.Budget.Exceeded)
+
+	for _, query := range []string{"since=June", "since=2024-06-02&until=2024-06-01"} {
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", "/admin/llm-usage?"+query, nil))
+		assert.Equal(t, http.StatusBadRequest, w.Code, query)
+	}
+	usageRepo.AssertExpectations(t)
+
+	untracked := controllers.NewAdminController(nil, nil, nil, nil)
+	untracked.SetExplanations(services.NewExplanationService(nil, nil, nil, &fakeExplainer{}, nil, nil, services.ExplanationConfig{}))
+	router = setupPredictionTestRouter()
+	router.GET("/admin/llm-usage", untracked.GetLLMUsageReport)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/admin/llm-usage", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+}
+
+func TestExplainerConfigKeepsListPriceForUnsetSide(t *testing.T) {
+	t.Setenv("LLM_MODEL", "gpt-4o-mini")
+	t.Setenv("LLM_PRICE_PROMPT_PER_1M", "0.10")
+	t.Setenv("LLM_PRICE_COMPLETION_PER_1M", "")
+
+	cfg := openai.ExplainerConfigFromEnv()
+	require.NotNil(t, cfg.Client.Price)
+	assert.Equal(t, 0.10, cfg.Client.Price.PromptPerMillion)
+	assert.Equal(t, 0.60, cfg.Client.Price.CompletionPerMillion)
+}
@@ -0,0 +1,128 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+
+	"diabetify/internal/ml"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// age, smoking, cholesterol, macrosomic, activity, bloodline, brinkman, BMI, hypertension
+var localScorerFeatures = []float64{50, 1, 0, 0, 3, 1, 1, 32, 0}
+
+func sigmoid(x float64) float64 {
+	return 1 / (1 + math.Exp(-x))
+}
+
+func TestLocalScorerLogisticRegression(t *testing.T) {
+	scorer, err := ml.NewLocalScorerFromFile("testdata/local_model_lr.json")
+	require.NoError(t, err)
+
+	result, err := scorer.Score(localScorerFeatures)
+	require.NoError(t, err)
+
+	coefficients := []float64{0.05, 0.2, 0.3, 0.25, -0.1, 0.6, 0.15, 0.12, 0.4}
+	means := []float64{45, 0.5, 0.3, 0.2, 2, 0.4, 0.5, 25, 0.3}
+	margin, bias := -7.5, -7.5
+	for i, x := range localScorerFeatures {
+		margin += coefficients[i] * x
+		bias += coefficients[i] * means[i]
+	}
+
+	assert.InDelta(t, sigmoid(margin), result.Prediction, 1e-9)
+	assert.InDelta(t, sigmoid(bias), result.BaseValue, 1e-9)
+	assert.Equal(t, "diabetes-risk-lr", result.ModelName)
+	assert.Equal(t, "test-1", result.ModelVersion)
+	assert.Len(t, result.Explanation, len(ml.FeatureNames))
+
+	var shapSum, contributionSum float64
+	for _, item := range result.Explanation {
+		shapSum += item.Shap
+		contributionSum += item.Contribution
+	}
+	assert.InDelta(t, result.Prediction-result.BaseValue, shapSum, 1e-9)
+	assert.InDelta(t, 1.0, contributionSum, 1e-9)
+
+	// Activity above the mean lowers risk, BMI above the mean raises it
+	assert.Equal(t, -1, result.Explanation["moderate_physical_activity_frequency"].Impact)
+	assert.Equal(t, 1, result.Explanation["BMI"].Impact)
+	assert.Equal(t, 32.0, result.Explanation["BMI"].Value)
+}
+
+func TestLocalScorerGradientBoosting(t *testing.T) {
+	scorer, err := ml.NewLocalScorerFromFile("testdata/local_model_gbt.json")
+	require.NoError(t, err)
+
+	result, err := scorer.Score(localScorerFeatures)
+	require.NoError(t, err)
+
+	// Leaves 1.0 and 0.3 on top of base score -1.0; root expectations -0.06 and 0.1
+	margin, bias := 0.3, -0.96
+	prediction, baseValue := sigmoid(margin), sigmoid(bias)
+	scale := (prediction - baseValue) / (margin - bias)
+
+	assert.InDelta(t, prediction, result.Prediction, 1e-9)
+	assert.InDelta(t, baseValue, result.BaseValue, 1e-9)
+	assert.InDelta(t, 0.66*scale, result.Explanation["age"].Shap, 1e-9)
+	assert.InDelta(t, 0.4*scale, result.Explanation["BMI"].Shap, 1e-9)
+	assert.InDelta(t, 0.2*scale, result.Explanation["is_bloodline"].Shap, 1e-9)
+	assert.Equal(t, 0.0, result.Explanation["smoking_status"].Shap)
+	assert.Equal(t, 0, result.Explanation["smoking_status"].Impact)
+}
+
+func TestLocalScorerPredictAsync(t *testing.T) {
+	scorer, err := ml.NewLocalScorerFromFile("testdata/local_model_lr.json")
+	require.NoError(t, err)
+
+	err = scorer.PredictAsync(context.Background(), "job-1", localScorerFeatures)
+	assert.ErrorIs(t, err, ml.ErrNoResponseHandler)
+
+	var delivered map[string]interface{}
+	scorer.SetResponseHandler(func(body []byte) error {
+		return json.Unmarshal(body, &delivered)
+	})
+
+	err = scorer.PredictAsync(context.Background(), "job-1", localScorerFeatures)
+	require.NoError(t, err)
+
+	assert.Equal(t, "job-1", delivered["correlation_id"])
+	assert.Equal(t, ml.SourceLocal, delivered["source"])
+	assert.Nil(t, delivered["error"])
+
+	explanation, ok := delivered["explanation"].(map[string]interface{})
+	require.True(t, ok)
+	age, ok := explanation["age"].(map[string]interface{})
+	require.True(t, ok)
+	for _, key := range []string{"value", "shap", "contribution", "impact"} {
+		assert.Contains(t, age, key)
+	}
+
+	// Invalid feature vectors are rejected before delivery
+	err = scorer.PredictAsync(context.Background(), "job-2", []float64{1, 2, 3})
+	assert.Error(t, err)
+}
+
+func TestLocalScorerRejectsInvalidModels(t *testing.T) {
+	tests := []struct {
+		name  string
+		model string
+	}{
+		{"unknown model type", `{"model_type": "svm"}`},
+		{"wrong coefficient count", `{"model_type": "logistic_regression", "coefficients": [1, 2]}`},
+		{"feature order mismatch", `{"model_type": "logistic_regression", "feature_names": ["BMI","age","smoking_status","is_cholesterol","is_macrosomic_baby","moderate_physical_activity_frequency","is_bloodline","brinkman_index","is_hypertension"], "coefficients": [0,0,0,0,0,0,0,0,0]}`},
+		{"tree child before parent", `{"model_type": "gradient_boosting", "trees": [[{"feature": 0, "threshold": 1, "left": 0, "right": 1}, {"feature": -1, "value": 1}]]}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ml.NewLocalScorer(strings.NewReader(tt.model))
+			assert.Error(t, err)
+		})
+	}
+}
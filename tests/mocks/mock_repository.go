@@ -2,8 +2,10 @@ package mocks
 
 import (
 	"context"
+	"diabetify/internal/ml"
 	"diabetify/internal/models"
 	"diabetify/internal/repository"
+	"diabetify/internal/services"
 	"time"
 
 	"github.com/stretchr/testify/mock"
@@ -164,6 +166,11 @@ func (m *MockUserRepository) UpdateLastPredictionTime(userID uint, timestamp *ti
 	return args.Error(0)
 }
 
+func (m *MockUserRepository) UpdateResearchConsent(userID uint, consent bool, at time.Time) error {
+	args := m.Called(userID, consent, at)
+	return args.Error(0)
+}
+
 // Shared MockPredictionRepository
 type MockPredictionRepository struct {
 	mock.Mock
@@ -179,6 +186,16 @@ func (m *MockPredictionRepository) GetPredictionsByUserID(userID uint, limit int
 	return args.Get(0).([]models.Prediction), args.Error(1)
 }
 
+func (m *MockPredictionRepository) GetPredictionsByUserIDAndModelVersion(userID uint, modelVersion string, limit int) ([]models.Prediction, error) {
+	args := m.Called(userID, modelVersion, limit)
+	return args.Get(0).([]models.Prediction), args.Error(1)
+}
+
+func (m *MockPredictionRepository) GetModelVersionsByUserID(userID uint) ([]repository.ModelVersionSummary, error) {
+	args := m.Called(userID)
+	return args.Get(0).([]repository.ModelVersionSummary), args.Error(1)
+}
+
 func (m *MockPredictionRepository) GetPredictionsByUserIDAndDateRange(userID uint, startDate, endDate time.Time) ([]models.Prediction, error) {
 	args := m.Called(userID, startDate, endDate)
 	return args.Get(0).([]models.Prediction), args.Error(1)
@@ -215,6 +232,11 @@ func (m *MockPredictionRepository) UpdatePrediction(prediction *models.Predictio
 	return args.Error(0)
 }
 
+func (m *MockPredictionRepository) SaveExplanation(prediction *models.Prediction) error {
+	args := m.Called(prediction)
+	return args.Error(0)
+}
+
 // Shared MockMLClient
 type MockMLClient struct {
 	mock.Mock
@@ -332,6 +354,73 @@ func (m *MockPredictionJobRepository) UpdateJobStatusWithResult(jobID, status st
 }
 
 // Query operations
+func (m *MockPredictionJobRepository) SetJobInput(jobID, inputHash string, snapshot *models.FeatureSnapshot) error {
+	args := m.Called(jobID, inputHash, snapshot)
+	return args.Error(0)
+}
+
+func (m *MockPredictionJobRepository) GetJobByIdempotencyKey(userID uint, key string) (*models.PredictionJob, error) {
+	args := m.Called(userID, key)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.PredictionJob), args.Error(1)
+}
+
+func (m *MockPredictionJobRepository) FindRecentJobByInputHash(userID uint, jobType, inputHash string, since time.Time) (*models.PredictionJob, error) {
+	args := m.Called(userID, jobType, inputHash, since)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.PredictionJob), args.Error(1)
+}
+
+func (m *MockPredictionJobRepository) FindLatestJobByPredictionID(userID uint, jobType string, predictionID uint) (*models.PredictionJob, error) {
+	args := m.Called(userID, jobType, predictionID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.PredictionJob), args.Error(1)
+}
+
+func (m *MockPredictionJobRepository) UpdateBatchProgress(jobID string, completedItems, failedItems int) error {
+	args := m.Called(jobID, completedItems, failedItems)
+	return args.Error(0)
+}
+
+func (m *MockPredictionJobRepository) SaveJobs(jobs []*models.PredictionJob) error {
+	args := m.Called(jobs)
+	return args.Error(0)
+}
+
+func (m *MockPredictionJobRepository) ClaimJob(userID uint, jobID string, staleBefore time.Time) (bool, error) {
+	args := m.Called(userID, jobID, staleBefore)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockPredictionJobRepository) FinishBatchItem(userID uint, jobID string, result *models.BatchItemResult, errorMessage *string) error {
+	args := m.Called(userID, jobID, result, errorMessage)
+	return args.Error(0)
+}
+
+func (m *MockPredictionJobRepository) GetJobsByTypeAndStatus(jobType, status string, limit int) ([]*models.PredictionJob, error) {
+	args := m.Called(jobType, status, limit)
+	return args.Get(0).([]*models.PredictionJob), args.Error(1)
+}
+
+func (m *MockPredictionJobRepository) GetChildJobs(userID uint, parentID string, statuses ...string) ([]*models.PredictionJob, error) {
+	args := m.Called(userID, parentID, statuses)
+	return args.Get(0).([]*models.PredictionJob), args.Error(1)
+}
+
+func (m *MockPredictionJobRepository) CountChildJobsByStatus(userID uint, parentID string) (map[string]int64, error) {
+	args := m.Called(userID, parentID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(map[string]int64), args.Error(1)
+}
+
 func (m *MockPredictionJobRepository) GetJobsByUserID(userID uint, limit int) ([]*models.PredictionJob, error) {
 	args := m.Called(userID, limit)
 	return args.Get(0).([]*models.PredictionJob), args.Error(1)
@@ -368,6 +457,11 @@ func (m *MockPredictionJobRepository) GetActiveJobsCount(userID uint) (int64, er
 	return args.Get(0).(int64), args.Error(1)
 }
 
+func (m *MockPredictionJobRepository) CountQuotaUnitsSince(userID uint, jobTypes []string, since time.Time) (int64, error) {
+	args := m.Called(userID, jobTypes, since)
+	return args.Get(0).(int64), args.Error(1)
+}
+
 func (m *MockPredictionJobRepository) CleanupOldJobs(olderThan time.Time) error {
 	args := m.Called(olderThan)
 	return args.Error(0)
@@ -394,6 +488,14 @@ func (m *MockMLClient) HealthCheckAsync(ctx context.Context) error {
 	return args.Error(0)
 }
 
+func (m *MockMLClient) ProbeHealth(ctx context.Context) (*ml.HealthStatus, error) {
+	args := m.Called(ctx)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*ml.HealthStatus), args.Error(1)
+}
+
 type MockPredictionJobWorker struct {
 	mock.Mock
 }
@@ -411,12 +513,270 @@ func (m *MockPredictionJobWorker) SubmitJob(jobRequest models.PredictionJobReque
 	return args.Error(0)
 }
 
+func (m *MockPredictionJobWorker) RegisterJobHandler(jobType string, handler services.JobHandler) {
+	m.Called(jobType, handler)
+}
+
 func (m *MockPredictionJobWorker) GetWhatIfResult(jobID string) (map[string]interface{}, bool, error) {
 	args := m.Called(jobID)
 	return args.Get(0).(map[string]interface{}), args.Bool(1), args.Error(2)
 }
 
+func (m *MockPredictionJobWorker) InvalidateWhatIfResult(jobID string) {
+	m.Called(jobID)
+}
+
 func (m *MockPredictionJobWorker) GetStatus() map[string]interface{} {
 	args := m.Called()
 	return args.Get(0).(map[string]interface{})
 }
+
+func (m *MockPredictionJobWorker) FeaturesForUser(userID uint) ([]float64, error) {
+	args := m.Called(userID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]float64), args.Error(1)
+}
+
+func (m *MockPredictionJobWorker) FeaturesForWhatIf(userID uint, input *models.WhatIfInput) ([]float64, error) {
+	args := m.Called(userID, input)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]float64), args.Error(1)
+}
+
+func (m *MockPredictionJobWorker) FeaturesForWhatIfs(userID uint, inputs []models.WhatIfInput) ([][]float64, error) {
+	args := m.Called(userID, inputs)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([][]float64), args.Error(1)
+}
+
+func (m *MockPredictionJobWorker) CurrentWhatIfInput(userID uint) (*models.WhatIfInput, error) {
+	args := m.Called(userID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.WhatIfInput), args.Error(1)
+}
+
+// MockModelUpdateRepository is a mock implementation of ModelUpdateRepository
+type MockModelUpdateRepository struct {
+	mock.Mock
+}
+
+func (m *MockModelUpdateRepository) SaveModelUpdate(update *models.ModelUpdate) error {
+	args := m.Called(update)
+	return args.Error(0)
+}
+
+func (m *MockModelUpdateRepository) UpdateModelUpdate(update *models.ModelUpdate) error {
+	args := m.Called(update)
+	return args.Error(0)
+}
+
+func (m *MockModelUpdateRepository) GetModelUpdateByID(id uint) (*models.ModelUpdate, error) {
+	args := m.Called(id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.ModelUpdate), args.Error(1)
+}
+
+func (m *MockModelUpdateRepository) GetRecentModelUpdates(limit int) ([]models.ModelUpdate, error) {
+	args := m.Called(limit)
+	return args.Get(0).([]models.ModelUpdate), args.Error(1)
+}
+
+// MockTrainingDataSource is a mock implementation of services.TrainingDataSource
+type MockTrainingDataSource struct {
+	mock.Mock
+}
+
+func (m *MockTrainingDataSource) GetConsentedLabeledSamples(limit int) ([]models.LabeledSample, error) {
+	args := m.Called(limit)
+	return args.Get(0).([]models.LabeledSample), args.Error(1)
+}
+
+// MockModelUpdater is a mock implementation of ml.ModelUpdater
+type MockModelUpdater struct {
+	mock.Mock
+}
+
+func (m *MockModelUpdater) UpdateModel(ctx context.Context, request *models.UpdateModelRequest) (*models.UpdateModelResponse, error) {
+	args := m.Called(ctx, request)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.UpdateModelResponse), args.Error(1)
+}
+
+func (m *MockModelUpdater) PromoteModel(ctx context.Context, candidateVersion string) (*models.PromoteModelResponse, error) {
+	args := m.Called(ctx, candidateVersion)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.PromoteModelResponse), args.Error(1)
+}
+
+// MockOutcomeRepository is a mock implementation of OutcomeRepository
+type MockOutcomeRepository struct {
+	mock.Mock
+}
+
+func (m *MockOutcomeRepository) SaveOutcome(outcome *models.Outcome) (int64, error) {
+	args := m.Called(outcome)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockOutcomeRepository) GetOutcomesByUserID(userID uint) ([]models.Outcome, error) {
+	args := m.Called(userID)
+	return args.Get(0).([]models.Outcome), args.Error(1)
+}
+
+func (m *MockOutcomeRepository) GetConsentedLabeledSamples(limit int) ([]models.LabeledSample, error) {
+	args := m.Called(limit)
+	return args.Get(0).([]models.LabeledSample), args.Error(1)
+}
+
+// EachPredictionCreatedBetween passes the predictions returned by the expectation to fn
+// in batches of batchSize
+func (m *MockPredictionRepository) EachPredictionCreatedBetween(start, end time.Time, batchSize int, fn func([]models.Prediction) error) error {
+	args := m.Called(start, end, batchSize)
+	predictions := args.Get(0).([]models.Prediction)
+	for len(predictions) > 0 {
+		n := min(batchSize, len(predictions))
+		if err := fn(predictions[:n]); err != nil {
+			return err
+		}
+		predictions = predictions[n:]
+	}
+	return args.Error(1)
+}
+
+// MockModelMonitoringRepository is a mock implementation of ModelMonitoringRepository
+type MockModelMonitoringRepository struct {
+	mock.Mock
+}
+
+func (m *MockModelMonitoringRepository) SaveReport(report *models.ModelMonitoringReport) error {
+	args := m.Called(report)
+	return args.Error(0)
+}
+
+func (m *MockModelMonitoringRepository) GetReportByDate(date time.Time) (*models.ModelMonitoringReport, error) {
+	args := m.Called(date)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.ModelMonitoringReport), args.Error(1)
+}
+
+func (m *MockModelMonitoringRepository) GetRecentReports(limit int) ([]models.ModelMonitoringReport, error) {
+	args := m.Called(limit)
+	return args.Get(0).([]models.ModelMonitoringReport), args.Error(1)
+}
+
+// MockNotificationRepository is a mock implementation of NotificationRepository
+type MockNotificationRepository struct {
+	mock.Mock
+}
+
+func (m *MockNotificationRepository) SaveNotification(notification *models.Notification) error {
+	args := m.Called(notification)
+	return args.Error(0)
+}
+
+func (m *MockNotificationRepository) GetNotifications(limit int, unreadOnly bool) ([]models.Notification, error) {
+	args := m.Called(limit, unreadOnly)
+	return args.Get(0).([]models.Notification), args.Error(1)
+}
+
+func (m *MockNotificationRepository) MarkNotificationRead(id uint) error {
+	args := m.Called(id)
+	return args.Error(0)
+}
+
+// MockModelExperimentRepository is a mock implementation of ModelExperimentRepository
+type MockModelExperimentRepository struct {
+	mock.Mock
+}
+
+func (m *MockModelExperimentRepository) SaveResult(result *models.ModelExperimentResult) error {
+	args := m.Called(result)
+	return args.Error(0)
+}
+
+func (m *MockModelExperimentRepository) GetResults(experiment string, since time.Time) ([]models.ModelExperimentResult, error) {
+	args := m.Called(experiment, since)
+	return args.Get(0).([]models.ModelExperimentResult), args.Error(1)
+}
+
+// MockLLMUsageRepository is a mock implementation of LLMUsageRepository
+type MockLLMUsageRepository struct {
+	mock.Mock
+}
+
+func (m *MockLLMUsageRepository) SaveUsage(usage *models.LLMUsage) error {
+	args := m.Called(usage)
+	return args.Error(0)
+}
+
+func (m *MockLLMUsageRepository) TotalTokensSince(userID uint, since time.Time) (int, error) {
+	args := m.Called(userID, since)
+	return args.Int(0), args.Error(1)
+}
+
+func (m *MockLLMUsageRepository) GetDailyTotals(since, until time.Time) ([]repository.LLMUsageTotal, error) {
+	args := m.Called(since, until)
+	return args.Get(0).([]repository.LLMUsageTotal), args.Error(1)
+}
+
+func (m *MockLLMUsageRepository) GetTopUsers(since, until time.Time, limit int) ([]repository.LLMUserUsage, error) {
+	args := m.Called(since, until, limit)
+	return args.Get(0).([]repository.LLMUserUsage), args.Error(1)
+}
+
+// MockWhatIfScenarioRepository is a mock implementation of WhatIfScenarioRepository
+type MockWhatIfScenarioRepository struct {
+	mock.Mock
+}
+
+func (m *MockWhatIfScenarioRepository) SaveScenario(scenario *models.WhatIfScenario) error {
+	args := m.Called(scenario)
+	return args.Error(0)
+}
+
+func (m *MockWhatIfScenarioRepository) GetScenarioByID(userID, id uint) (*models.WhatIfScenario, error) {
+	args := m.Called(userID, id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.WhatIfScenario), args.Error(1)
+}
+
+func (m *MockWhatIfScenarioRepository) GetScenarioByJobID(userID uint, jobID string) (*models.WhatIfScenario, error) {
+	args := m.Called(userID, jobID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.WhatIfScenario), args.Error(1)
+}
+
+func (m *MockWhatIfScenarioRepository) GetScenariosByUserID(userID uint, pinnedOnly bool, limit int) ([]models.WhatIfScenario, error) {
+	args := m.Called(userID, pinnedOnly, limit)
+	return args.Get(0).([]models.WhatIfScenario), args.Error(1)
+}
+
+func (m *MockWhatIfScenarioRepository) DeleteScenario(userID, id uint) error {
+	args := m.Called(userID, id)
+	return args.Error(0)
+}
+
+func (m *MockWhatIfScenarioRepository) DeleteIncompleteScenarios(createdBefore time.Time) error {
+	args := m.Called(createdBefore)
+	return args.Error(0)
+}
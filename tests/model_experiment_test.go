@@ -0,0 +1,177 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"diabetify/internal/controllers"
+	"diabetify/internal/ml"
+	"diabetify/internal/models"
+	"diabetify/internal/services"
+	"diabetify/tests/mocks"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExperimentAssignment(t *testing.T) {
+	ab := ml.ExperimentConfig{Name: "v2-rollout", Mode: ml.ExperimentAB, CandidateModel: "v2", Percent: 30}
+
+	candidates := 0
+	for userID := uint(1); userID <= 2000; userID++ {
+		assignment := ab.Assign(userID)
+		assert.Equal(t, assignment, ab.Assign(userID), "assignment must be sticky")
+		assert.False(t, assignment.Shadow)
+		if assignment.Variant == "v2" {
+			candidates++
+		} else {
+			assert.Equal(t, ml.ControlVariant, assignment.Variant)
+		}
+	}
+	assert.InDelta(t, 600, candidates, 100, "roughly 30% of users get the candidate")
+
+	shadow := ab
+	shadow.Mode = ml.ExperimentShadow
+	shadow.Percent = 100
+	assert.Equal(t, ml.Assignment{Variant: ml.ControlVariant, Shadow: true}, shadow.Assign(42))
+
+	off := ab
+	off.Mode = ml.ExperimentOff
+	assert.Equal(t, ml.Assignment{Variant: ml.ControlVariant}, off.Assign(42))
+	assert.Empty(t, off.ModelVariants())
+	assert.Equal(t, []string{"v2"}, ab.ModelVariants())
+}
+
+func TestExperimentConfigFromEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		want    ml.ExperimentConfig
+		wantErr bool
+	}{
+		{
+			name: "off by default",
+			env:  map[string]string{},
+			want: ml.ExperimentConfig{Mode: ml.ExperimentOff},
+		},
+		{
+			name: "name defaults to candidate model",
+			env:  map[string]string{"ML_EXPERIMENT_MODE": "Shadow", "ML_CANDIDATE_MODEL": "xgb-2024-06", "ML_CANDIDATE_PERCENT": "50"},
+			want: ml.ExperimentConfig{Name: "xgb-2024-06", Mode: ml.ExperimentShadow, CandidateModel: "xgb-2024-06", Percent: 50},
+		},
+		{
+			name:    "percent out of range",
+			env:     map[string]string{"ML_EXPERIMENT_MODE": "ab", "ML_CANDIDATE_MODEL": "v2", "ML_CANDIDATE_PERCENT": "150"},
+			wantErr: true,
+		},
+		{
+			name:    "candidate name unsafe for a queue",
+			env:     map[string]string{"ML_EXPERIMENT_MODE": "ab", "ML_CANDIDATE_MODEL": "v2 beta", "ML_CANDIDATE_PERCENT": "10"},
+			wantErr: true,
+		},
+		{
+			name:    "unknown mode",
+			env:     map[string]string{"ML_EXPERIMENT_MODE": "canary"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, key := range []string{"ML_EXPERIMENT_NAME", "ML_EXPERIMENT_MODE", "ML_CANDIDATE_MODEL", "ML_CANDIDATE_PERCENT"} {
+				t.Setenv(key, tt.env[key])
+			}
+			cfg, err := ml.ExperimentConfigFromEnv()
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, cfg)
+		})
+	}
+}
+
+func TestModelVariantRouting(t *testing.T) {
+	assert.Equal(t, "ml.prediction.request", ml.RequestQueueFor(""))
+	assert.Equal(t, "ml.prediction.request", ml.RequestQueueFor(ml.ControlVariant))
+	assert.Equal(t, "ml.prediction.request.v2", ml.RequestQueueFor("v2"))
+
+	ctx := ml.WithModelVariant(context.Background(), ml.ControlVariant)
+	assert.Equal(t, "", ml.ModelVariantFromContext(ctx))
+	assert.Equal(t, "v2", ml.ModelVariantFromContext(ml.WithModelVariant(ctx, "v2")))
+
+	jobID, ok := ml.ParseShadowCorrelationID(ml.ShadowCorrelationID("job-1"))
+	assert.True(t, ok)
+	assert.Equal(t, "job-1", jobID)
+	_, ok = ml.ParseShadowCorrelationID("job-1")
+	assert.False(t, ok)
+}
+
+func TestModelExperimentReport(t *testing.T) {
+	results := []models.ModelExperimentResult{
+		{JobID: "a", Variant: ml.ControlVariant, Shown: true, ModelVersion: "1.0", RiskScore: 0.2},
+		{JobID: "a", Variant: "v2", ModelVersion: "2.0", RiskScore: 0.3},
+		{JobID: "b", Variant: ml.ControlVariant, Shown: true, ModelVersion: "1.0", RiskScore: 0.6},
+		{JobID: "b", Variant: "v2", ModelVersion: "2.0", RiskScore: 0.4},
+		// Shadow response never arrived
+		{JobID: "c", Variant: ml.ControlVariant, Shown: true, ModelVersion: "1.0", RiskScore: 0.9},
+	}
+
+	report := services.BuildModelExperimentReport(results)
+
+	require.Len(t, report.Variants, 2)
+	control, candidate := report.Variants[0], report.Variants[1]
+	assert.Equal(t, ml.ControlVariant, control.Variant)
+	assert.Equal(t, 3, control.Count)
+	assert.InDelta(t, 0.6, control.P50, 1e-9)
+	assert.InDelta(t, 2.0/3, control.HighRiskRate, 1e-9)
+	assert.Equal(t, []string{"1.0"}, control.ModelVersions)
+	assert.Equal(t, "v2", candidate.Variant)
+	assert.False(t, candidate.Shown)
+	assert.Equal(t, 1, candidate.Histogram[3])
+	assert.Equal(t, 1, candidate.Histogram[4])
+
+	require.NotNil(t, report.KS)
+	require.NotNil(t, report.Shadow)
+	assert.Equal(t, 2, report.Shadow.Pairs)
+	// (0.1 - 0.2) / 2
+	assert.InDelta(t, -0.05, report.Shadow.MeanDifference, 1e-9)
+	assert.InDelta(t, 0.15, report.Shadow.MeanAbsDifference, 1e-9)
+	assert.InDelta(t, 0.5, report.Shadow.Agreement, 1e-9, "job b crosses the 0.5 threshold")
+}
+
+func TestGetModelExperimentReport(t *testing.T) {
+	repo := new(mocks.MockModelExperimentRepository)
+	cfg := ml.ExperimentConfig{Name: "v2-rollout", Mode: ml.ExperimentAB, CandidateModel: "v2", Percent: 20}
+	since := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	repo.On("GetResults", "v2-rollout", since).Return([]models.ModelExperimentResult{
+		{JobID: "a", Variant: ml.ControlVariant, Shown: true, RiskScore: 0.2},
+		{JobID: "b", Variant: "v2", Shown: true, RiskScore: 0.7},
+	}, nil)
+
+	controller := controllers.NewAdminController(nil, nil, nil, services.NewModelExperimentService(repo, cfg))
+	router := setupPredictionTestRouter()
+	router.GET("/admin/model-experiments", controller.GetModelExperimentReport)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/admin/model-experiments?since=2024-06-01", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var response struct {
+		Data services.ModelExperimentReport `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, cfg, response.Data.Experiment)
+	assert.Len(t, response.Data.Variants, 2)
+	assert.Nil(t, response.Data.Shadow, "A/B jobs are scored by one model only")
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/admin/model-experiments?since=June", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	repo.AssertExpectations(t)
+}
@@ -0,0 +1,166 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"diabetify/internal/controllers"
+	"diabetify/internal/models"
+	"diabetify/internal/monitoring"
+	"diabetify/internal/services"
+	"diabetify/tests/mocks"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func TestDriftStatistics(t *testing.T) {
+	reference := make([]float64, 0, 1000)
+	shifted := make([]float64, 0, 1000)
+	for i := 0; i < 1000; i++ {
+		reference = append(reference, float64(i%50))
+		shifted = append(shifted, float64(i%50)+15)
+	}
+
+	assert.InDelta(t, 0, monitoring.PSI(reference, reference, 10), 1e-9)
+	assert.Greater(t, monitoring.PSI(reference, shifted, 10), 0.2)
+	assert.InDelta(t, 0, monitoring.KS(reference, reference), 1e-9)
+	assert.InDelta(t, 0.3, monitoring.KS(reference, shifted), 1e-9)
+
+	// Binary feature: 10% -> 40% positive
+	var refBinary, curBinary []float64
+	for i := 0; i < 100; i++ {
+		refBinary = append(refBinary, boolFloat(i < 10))
+		curBinary = append(curBinary, boolFloat(i < 40))
+	}
+	assert.Greater(t, monitoring.PSI(refBinary, curBinary, 10), 0.2)
+	assert.InDelta(t, 0.3, monitoring.KS(refBinary, curBinary), 1e-9)
+}
+
+func TestAUCAndCalibration(t *testing.T) {
+	auc, ok := monitoring.AUC([]float64{0.1, 0.4, 0.35, 0.8}, []float64{0, 0, 1, 1})
+	require.True(t, ok)
+	assert.InDelta(t, 0.75, auc, 1e-9)
+
+	auc, ok = monitoring.AUC([]float64{0.5, 0.5}, []float64{0, 1})
+	require.True(t, ok)
+	assert.InDelta(t, 0.5, auc, 1e-9, "tied scores count as half")
+
+	_, ok = monitoring.AUC([]float64{0.2, 0.9}, []float64{1, 1})
+	assert.False(t, ok, "AUC needs both classes")
+
+	bins, ece := monitoring.Calibration([]float64{0.05, 0.15, 0.95, 0.95}, []float64{0, 0, 1, 0}, 10)
+	require.Len(t, bins, 3)
+	assert.Equal(t, 2, bins[2].Count)
+	assert.InDelta(t, 0.5, bins[2].ObservedRate, 1e-9)
+	// (0.05 + 0.15 + 2*0.45) / 4
+	assert.InDelta(t, 0.275, ece, 1e-9)
+}
+
+func TestModelMonitorRaisesDriftNotification(t *testing.T) {
+	predRepo := new(mocks.MockPredictionRepository)
+	reportRepo := new(mocks.MockModelMonitoringRepository)
+	notificationRepo := new(mocks.MockNotificationRepository)
+
+	day := time.Date(2024, 6, 10, 0, 0, 0, 0, time.UTC)
+	diabetic := &models.Outcome{DiagnosisType: models.DiagnosisType2Diabetes}
+	healthy := &models.Outcome{DiagnosisType: models.DiagnosisNoDiabetes}
+
+	var predictions []models.Prediction
+	for i := 0; i < 100; i++ {
+		// Reference window: BMI around 24, labeled; report day: BMI around 33
+		reference := models.Prediction{CreatedAt: day.AddDate(0, 0, -1-i%20), Factors: monitoredFactors(40+i%20, 22+float64(i%5)), RiskScore: 0.2 + float64(i%5)/10}
+		if i%2 == 0 {
+			reference.Outcome = healthy
+		} else {
+			reference.Outcome = diabetic
+		}
+		current := models.Prediction{CreatedAt: day.Add(time.Duration(i) * time.Minute), Factors: monitoredFactors(40+i%20, 31+float64(i%5)), RiskScore: 0.2 + float64(i%5)/10}
+		predictions = append(predictions, reference, current)
+	}
+
+	predRepo.On("EachPredictionCreatedBetween", day.AddDate(0, 0, -28), day.AddDate(0, 0, 1), mock.Anything).Return(predictions, nil)
+	reportRepo.On("SaveReport", mock.Anything).Return(nil)
+	notificationRepo.On("SaveNotification", mock.MatchedBy(func(n *models.Notification) bool {
+		return n.Type == models.NotificationTypeModelDrift && n.Title == "Model drift detected on 2024-06-10"
+	})).Return(nil)
+	notificationRepo.On("SaveNotification", mock.MatchedBy(func(n *models.Notification) bool {
+		return n.Type == models.NotificationTypeModelPerformance
+	})).Return(nil)
+
+	cfg := services.ModelMonitorConfig{ReferenceDays: 28, PSIThreshold: 0.2, KSThreshold: 0.1, MinSamples: 50, MinAUC: 0.7, CheckInterval: time.Hour}
+	monitor := services.NewModelMonitor(predRepo, reportRepo, notificationRepo, cfg)
+
+	report, err := monitor.RunForDate(day.Add(15 * time.Hour))
+	require.NoError(t, err)
+
+	assert.Equal(t, day, report.Date)
+	assert.Equal(t, 100, report.SampleCount)
+	assert.Equal(t, 100, report.ReferenceCount)
+	assert.True(t, report.DriftDetected)
+
+	drift := map[string]models.FeatureDrift{}
+	for _, f := range report.Features {
+		drift[f.Feature] = f
+	}
+	assert.Len(t, drift, 10, "9 model inputs plus risk_score")
+	assert.True(t, drift["BMI"].Drifted)
+	assert.False(t, drift["age"].Drifted)
+	assert.False(t, drift["risk_score"].Drifted)
+
+	// Labels are unrelated to the score, so AUC is poor and a performance alert is raised too
+	assert.Equal(t, 100, report.LabeledCount)
+	require.NotNil(t, report.AUC)
+	assert.Less(t, *report.AUC, 0.7)
+	require.NotNil(t, report.CalibrationError)
+
+	notificationRepo.AssertNumberOfCalls(t, "SaveNotification", 2)
+}
+
+func TestMarkNotificationRead(t *testing.T) {
+	notificationRepo := new(mocks.MockNotificationRepository)
+	notificationRepo.On("MarkNotificationRead", uint(1)).Return(nil)
+	notificationRepo.On("MarkNotificationRead", uint(2)).Return(gorm.ErrRecordNotFound)
+	notificationRepo.On("GetNotifications", 50, true).Return([]models.Notification{{ID: 1, Type: models.NotificationTypeModelDrift}}, nil)
+
+	controller := controllers.NewAdminController(nil, nil, notificationRepo, nil)
+	router := setupPredictionTestRouter()
+	router.GET("/admin/notifications", controller.GetNotifications)
+	router.POST("/admin/notifications/:id/read", controller.MarkNotificationRead)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/admin/notifications?unread=true", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	var response map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, float64(1), response["data"].(map[string]interface{})["count"])
+
+	for path, expected := range map[string]int{
+		"/admin/notifications/1/read": http.StatusOK,
+		"/admin/notifications/2/read": http.StatusNotFound,
+		"/admin/notifications/x/read": http.StatusBadRequest,
+	} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("POST", path, nil))
+		assert.Equal(t, expected, w.Code, path)
+	}
+}
+
+func boolFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func monitoredFactors(age int, bmi float64) []models.PredictionFactor {
+	return []models.PredictionFactor{
+		{Factor: "age", Value: float64(age)},
+		{Factor: "bmi", Value: bmi},
+	}
+}
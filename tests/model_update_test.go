@@ -0,0 +1,241 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"diabetify/internal/controllers"
+	"diabetify/internal/middleware"
+	"diabetify/internal/models"
+	"diabetify/internal/services"
+	"diabetify/tests/mocks"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func labeledSamples(positives, negatives int) []models.LabeledSample {
+	samples := make([]models.LabeledSample, 0, positives+negatives)
+	for i := 0; i < positives+negatives; i++ {
+		label := 0.0
+		if i < positives {
+			label = 1
+		}
+		samples = append(samples, models.LabeledSample{
+			UserID:   uint(i + 1),
+			Features: []float64{float64(40 + i%20), 0, 1, 0, 3, 1, 0, 27.5, label},
+			Label:    label,
+		})
+	}
+	return samples
+}
+
+func setupModelUpdateService(samples []models.LabeledSample) (services.ModelUpdateService, *mocks.MockModelUpdateRepository, *mocks.MockPredictionJobRepository, *mocks.MockModelUpdater) {
+	updateRepo := new(mocks.MockModelUpdateRepository)
+	jobRepo := new(mocks.MockPredictionJobRepository)
+	source := new(mocks.MockTrainingDataSource)
+	updater := new(mocks.MockModelUpdater)
+
+	source.On("GetConsentedLabeledSamples", 5000).Return(samples, nil)
+
+	cfg := services.ModelUpdateConfig{
+		MinSamples:         20,
+		MaxSamples:         5000,
+		ValidationFraction: 0.2,
+		Timeout:            time.Minute,
+	}
+	return services.NewModelUpdateService(updateRepo, jobRepo, source, updater, cfg), updateRepo, jobRepo, updater
+}
+
+// captureModelUpdates records the last state the service saved
+func captureModelUpdates(updateRepo *mocks.MockModelUpdateRepository) func() *models.ModelUpdate {
+	var last models.ModelUpdate
+	updateRepo.On("UpdateModelUpdate", mock.Anything).Run(func(args mock.Arguments) {
+		last = *args.Get(0).(*models.ModelUpdate)
+	}).Return(nil)
+	return func() *models.ModelUpdate { return &last }
+}
+
+func TestModelUpdateServicePromotesImprovedCandidate(t *testing.T) {
+	svc, updateRepo, jobRepo, updater := setupModelUpdateService(labeledSamples(20, 30))
+
+	jobRepo.On("SaveJob", mock.MatchedBy(func(job *models.PredictionJob) bool {
+		return job.JobType == models.JobTypeModelUpdate && job.UserID == 7
+	})).Return(nil)
+	jobRepo.On("UpdateJobStatus", mock.Anything, models.JobStatusProcessing, (*string)(nil)).Return(nil)
+	jobRepo.On("UpdateJobStatus", mock.Anything, models.JobStatusCompleted, (*string)(nil)).Return(nil)
+	updateRepo.On("SaveModelUpdate", mock.Anything).Return(nil)
+	saved := captureModelUpdates(updateRepo)
+
+	updater.On("UpdateModel", mock.Anything, mock.MatchedBy(func(request *models.UpdateModelRequest) bool {
+		// 20% of each class is held out: 4 positives and 6 negatives
+		return request.DryRun && request.Epochs == 3 && len(request.XVal) == 10 && len(request.XNew) == 40 && len(request.YNew) == 40
+	})).Return(&models.UpdateModelResponse{
+		Status:           "trained",
+		AUCBefore:        0.81,
+		AUCAfter:         0.84,
+		PRAUCBefore:      0.62,
+		PRAUCAfter:       0.66,
+		ModelVersion:     "2024.06.1",
+		CandidateVersion: "2024.07.0",
+	}, nil)
+	updater.On("PromoteModel", mock.Anything, "2024.07.0").Return(&models.PromoteModelResponse{Status: "promoted", ModelVersion: "2024.07.0"}, nil)
+
+	update, err := svc.StartUpdate(7, 3)
+	require.NoError(t, err)
+	assert.Equal(t, 40, update.TrainingSamples)
+	assert.Equal(t, 10, update.ValidationSamples)
+	assert.Equal(t, 20, update.PositiveSamples)
+
+	svc.Wait()
+
+	update = saved()
+	assert.Equal(t, models.ModelUpdateStatusPromoted, update.Status)
+	assert.True(t, update.Promoted)
+	assert.Equal(t, 0.84, update.AUCAfter)
+	assert.Equal(t, "2024.06.1", update.BaseModelVersion)
+	assert.NotNil(t, update.CompletedAt)
+	updater.AssertExpectations(t)
+	jobRepo.AssertExpectations(t)
+}
+
+func TestModelUpdateServiceRefusesRegression(t *testing.T) {
+	tests := []struct {
+		name     string
+		response *models.UpdateModelResponse
+		reason   string
+	}{
+		{
+			name:     "AUC regressed",
+			response: &models.UpdateModelResponse{AUCBefore: 0.81, AUCAfter: 0.79, PRAUCBefore: 0.6, PRAUCAfter: 0.65, CandidateVersion: "c1"},
+			reason:   "AUC regressed from 0.8100 to 0.7900",
+		},
+		{
+			name:     "PR-AUC regressed",
+			response: &models.UpdateModelResponse{AUCBefore: 0.81, AUCAfter: 0.82, PRAUCBefore: 0.6, PRAUCAfter: 0.55, CandidateVersion: "c1"},
+			reason:   "PR-AUC regressed from 0.6000 to 0.5500",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc, updateRepo, jobRepo, updater := setupModelUpdateService(labeledSamples(20, 30))
+
+			jobRepo.On("SaveJob", mock.Anything).Return(nil)
+			jobRepo.On("UpdateJobStatus", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+			updateRepo.On("SaveModelUpdate", mock.Anything).Return(nil)
+			saved := captureModelUpdates(updateRepo)
+			updater.On("UpdateModel", mock.Anything, mock.Anything).Return(tt.response, nil)
+
+			_, err := svc.StartUpdate(1, 5)
+			require.NoError(t, err)
+			svc.Wait()
+
+			update := saved()
+			assert.Equal(t, models.ModelUpdateStatusRejected, update.Status)
+			assert.False(t, update.Promoted)
+			require.NotNil(t, update.Reason)
+			assert.Equal(t, tt.reason, *update.Reason)
+			updater.AssertNotCalled(t, "PromoteModel", mock.Anything, mock.Anything)
+		})
+	}
+}
+
+func TestModelUpdateServiceRejectsInsufficientData(t *testing.T) {
+	svc, _, jobRepo, _ := setupModelUpdateService(labeledSamples(0, 40))
+
+	_, err := svc.StartUpdate(1, 5)
+	assert.ErrorIs(t, err, services.ErrInsufficientTrainingData)
+	jobRepo.AssertNotCalled(t, "SaveJob", mock.Anything)
+
+	noSource := services.NewModelUpdateService(nil, nil, nil, nil, services.ModelUpdateConfigFromEnv())
+	_, err = noSource.StartUpdate(1, 5)
+	assert.ErrorIs(t, err, services.ErrNoTrainingDataSource)
+}
+
+func TestStartModelUpdateRequiresAdmin(t *testing.T) {
+	t.Setenv("ADMIN_EMAILS", "ops@diabetify.id, admin@diabetify.id")
+
+	svc, _, _, _ := setupModelUpdateService(labeledSamples(0, 40))
+	controller := controllers.NewAdminController(svc, nil, nil, nil)
+
+	tests := []struct {
+		name           string
+		email          string
+		body           string
+		expectedStatus int
+		expectedMsg    string
+	}{
+		{
+			name:           "not an admin",
+			email:          "user@example.com",
+			expectedStatus: http.StatusForbidden,
+			expectedMsg:    "Admin access required",
+		},
+		{
+			name:           "invalid epochs",
+			email:          "Admin@Diabetify.id",
+			body:           `{"epochs": 0.5}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedMsg:    "Invalid request data",
+		},
+		{
+			name:           "admin without enough labeled data",
+			email:          "admin@diabetify.id",
+			expectedStatus: http.StatusUnprocessableEntity,
+			expectedMsg:    "Not enough labeled data to update the model",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := setupPredictionTestRouter()
+			router.Use(func(c *gin.Context) {
+				c.Set("user_id", uint(1))
+				c.Set("email", tt.email)
+				c.Next()
+			}, middleware.AdminMiddleware())
+			router.POST("/admin/model-updates", controller.StartModelUpdate)
+
+			req := httptest.NewRequest("POST", "/admin/model-updates", bytes.NewBufferString(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+
+			var response map[string]interface{}
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+			assert.Equal(t, tt.expectedMsg, response["message"])
+		})
+	}
+}
+
+func TestGetModelUpdateByID(t *testing.T) {
+	svc, updateRepo, _, _ := setupModelUpdateService(nil)
+	controller := controllers.NewAdminController(svc, nil, nil, nil)
+
+	reason := "AUC regressed from 0.8100 to 0.7900"
+	updateRepo.On("GetModelUpdateByID", uint(3)).Return(&models.ModelUpdate{ID: 3, Status: models.ModelUpdateStatusRejected, Reason: &reason}, nil)
+	updateRepo.On("GetModelUpdateByID", uint(4)).Return(nil, errors.New("record not found"))
+
+	router := setupPredictionTestRouter()
+	router.GET("/admin/model-updates/:id", controller.GetModelUpdateByID)
+
+	for path, expected := range map[string]int{
+		"/admin/model-updates/3":   http.StatusOK,
+		"/admin/model-updates/4":   http.StatusNotFound,
+		"/admin/model-updates/abc": http.StatusBadRequest,
+	} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		assert.Equal(t, expected, w.Code, path)
+	}
+}
@@ -0,0 +1,149 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"diabetify/internal/controllers"
+	"diabetify/internal/models"
+	"diabetify/tests/mocks"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateOutcome(t *testing.T) {
+	tomorrow := time.Now().AddDate(0, 0, 1).Format("2006-01-02")
+
+	tests := []struct {
+		name           string
+		body           string
+		setupMock      func(*mocks.MockOutcomeRepository)
+		expectedStatus int
+		expectedMsg    string
+	}{
+		{
+			name: "diagnosis recorded and predictions linked",
+			body: `{"diagnosis_type": "type2_diabetes", "diagnosis_date": "2024-05-01", "hba1c": 6.9}`,
+			setupMock: func(repo *mocks.MockOutcomeRepository) {
+				repo.On("SaveOutcome", mock.MatchedBy(func(outcome *models.Outcome) bool {
+					return outcome.UserID == 1 &&
+						outcome.DiagnosisType == models.DiagnosisType2Diabetes &&
+						outcome.DiagnosisDate.Equal(time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)) &&
+						outcome.HbA1c != nil && *outcome.HbA1c == 6.9 &&
+						outcome.FastingGlucose == nil
+				})).Return(int64(3), nil)
+			},
+			expectedStatus: http.StatusCreated,
+			expectedMsg:    "Outcome recorded successfully",
+		},
+		{
+			name:           "unknown diagnosis type",
+			body:           `{"diagnosis_type": "diabetes", "diagnosis_date": "2024-05-01"}`,
+			setupMock:      func(repo *mocks.MockOutcomeRepository) {},
+			expectedStatus: http.StatusBadRequest,
+			expectedMsg:    "Invalid request data",
+		},
+		{
+			name:           "implausible HbA1c",
+			body:           `{"diagnosis_type": "type2_diabetes", "diagnosis_date": "2024-05-01", "hba1c": 68}`,
+			setupMock:      func(repo *mocks.MockOutcomeRepository) {},
+			expectedStatus: http.StatusBadRequest,
+			expectedMsg:    "Invalid request data",
+		},
+		{
+			name:           "malformed date",
+			body:           `{"diagnosis_type": "no_diabetes", "diagnosis_date": "01/05/2024"}`,
+			setupMock:      func(repo *mocks.MockOutcomeRepository) {},
+			expectedStatus: http.StatusBadRequest,
+			expectedMsg:    "Invalid diagnosis date",
+		},
+		{
+			name:           "future date",
+			body:           `{"diagnosis_type": "no_diabetes", "diagnosis_date": "` + tomorrow + `"}`,
+			setupMock:      func(repo *mocks.MockOutcomeRepository) {},
+			expectedStatus: http.StatusBadRequest,
+			expectedMsg:    "Invalid diagnosis date",
+		},
+		{
+			name: "database error",
+			body: `{"diagnosis_type": "prediabetes", "diagnosis_date": "2024-05-01", "fasting_glucose": 110}`,
+			setupMock: func(repo *mocks.MockOutcomeRepository) {
+				repo.On("SaveOutcome", mock.Anything).Return(int64(0), errors.New("database error"))
+			},
+			expectedStatus: http.StatusInternalServerError,
+			expectedMsg:    "Failed to record outcome",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := new(mocks.MockOutcomeRepository)
+			tt.setupMock(repo)
+			controller := controllers.NewOutcomeController(repo)
+
+			router := setupPredictionTestRouter()
+			router.Use(addPredictionAuthMiddleware(1))
+			router.POST("/users/me/outcomes", controller.CreateOutcome)
+
+			req := httptest.NewRequest("POST", "/users/me/outcomes", bytes.NewBufferString(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+
+			var response map[string]interface{}
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+			assert.Equal(t, tt.expectedMsg, response["message"])
+
+			if tt.expectedStatus == http.StatusCreated {
+				data := response["data"].(map[string]interface{})
+				assert.Equal(t, float64(3), data["linked_predictions"])
+			}
+
+			repo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestOutcomeLabelAndFeatureVector(t *testing.T) {
+	for diagnosis, expected := range map[string]struct {
+		label float64
+		ok    bool
+	}{
+		models.DiagnosisType2Diabetes:       {1, true},
+		models.DiagnosisNoDiabetes:          {0, true},
+		models.DiagnosisPrediabetes:         {0, false},
+		models.DiagnosisType1Diabetes:       {0, false},
+		models.DiagnosisGestationalDiabetes: {0, false},
+	} {
+		outcome := models.Outcome{DiagnosisType: diagnosis}
+		label, ok := outcome.Label()
+		assert.Equal(t, expected.label, label, diagnosis)
+		assert.Equal(t, expected.ok, ok, diagnosis)
+	}
+
+	// Stored in a different order than the model input
+	prediction := models.Prediction{
+		Factors: []models.PredictionFactor{
+			{Factor: "bmi", Value: 31.2},
+			{Factor: "age", Value: 52},
+			{Factor: "smoking_status", Value: 2},
+			{Factor: "is_cholesterol", Value: 1},
+			{Factor: "is_macrosomic_baby", Value: 1},
+			{Factor: "physical_activity_frequency", Value: 3},
+			{Factor: "is_bloodline", Value: 0},
+			{Factor: "brinkman_score", Value: 2},
+			{Factor: "is_hypertension", Value: 1},
+		},
+	}
+	// Same order as the job worker's feature vector (see ml.FeatureNames)
+	assert.Equal(t, []float64{52, 2, 1, 1, 3, 0, 2, 31.2, 1}, prediction.FeatureVector())
+}
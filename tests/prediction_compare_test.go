@@ -0,0 +1,184 @@
+package tests
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"diabetify/internal/models"
+	"diabetify/internal/services"
+	"diabetify/tests/mocks"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func comparedPredictions() (*models.Prediction, *models.Prediction) {
+	from := &models.Prediction{
+		ID:        10,
+		UserID:    1,
+		RiskScore: 0.32,
+		Factors: []models.PredictionFactor{
+			{Factor: "age", Value: 50, Shap: 0.04},
+			{Factor: "bmi", Value: 24.1, Shap: 0.01, Contribution: 0.08},
+			{Factor: "smoking_status", Value: 0, Shap: -0.01},
+			{Factor: "physical_activity_frequency", Value: 2, Shap: 0.01},
+		},
+		ModelVersion: "v1",
+	}
+	to := &models.Prediction{
+		ID:        11,
+		UserID:    1,
+		RiskScore: 0.45,
+		Factors: []models.PredictionFactor{
+			{Factor: "age", Value: 50, Shap: 0.04},
+			{Factor: "bmi", Value: 27.4, Shap: 0.09, Contribution: 0.21},
+			{Factor: "smoking_status", Value: 2, Shap: 0.04},
+			{Factor: "physical_activity_frequency", Value: 4, Shap: -0.01},
+		},
+		ModelVersion: "v1",
+	}
+	return from, to
+}
+
+func TestComparePredictions(t *testing.T) {
+	from, to := comparedPredictions()
+
+	comparison := services.ComparePredictions(from, to)
+
+	assert.InDelta(t, 0.13, comparison.RiskScoreDelta, 1e-9)
+	assert.False(t, comparison.ModelChanged)
+	require.Len(t, comparison.Factors, 9)
+
+	bmi := comparison.Factors[0]
+	assert.Equal(t, "bmi", bmi.Factor, "largest SHAP change first")
+	assert.Equal(t, "Indeks Massa Tubuh", bmi.Alias)
+	assert.True(t, bmi.ValueChanged)
+	assert.InDelta(t, 0.08, bmi.ShapDelta, 1e-9)
+	assert.InDelta(t, 0.13, bmi.ContributionDelta, 1e-9)
+
+	age := comparison.Factors[3]
+	assert.Equal(t, "age", age.Factor)
+	assert.False(t, age.ValueChanged)
+	assert.Zero(t, age.ShapDelta)
+
+	assert.Equal(t,
+		"Risiko diabetes Anda naik dari 32,0% menjadi 45,0%. "+
+			"Pendorong utama kenaikan: Indeks Massa Tubuh (24,1 → 27,4), Status Merokok (tidak pernah merokok → perokok aktif). "+
+			"Faktor yang menahan kenaikan: Frekuensi Aktivitas Fisik Sedang (2 kali per minggu → 4 kali per minggu).",
+		comparison.Summary)
+}
+
+func TestComparePredictionsSummary(t *testing.T) {
+	t.Run("model update", func(t *testing.T) {
+		from, to := comparedPredictions()
+		to.ModelVersion = "v2"
+
+		comparison := services.ComparePredictions(from, to)
+
+		assert.True(t, comparison.ModelChanged)
+		assert.Contains(t, comparison.Summary, "Versi model juga berbeda (v1 → v2)")
+	})
+
+	t.Run("decrease", func(t *testing.T) {
+		from, to := comparedPredictions()
+
+		comparison := services.ComparePredictions(to, from)
+
+		assert.Contains(t, comparison.Summary, "Risiko diabetes Anda turun dari 45,0% menjadi 32,0%.")
+		assert.Contains(t, comparison.Summary, "Pendorong utama penurunan: Indeks Massa Tubuh (27,4 → 24,1)")
+		assert.Contains(t, comparison.Summary, "Faktor yang menahan penurunan: Frekuensi Aktivitas Fisik Sedang")
+	})
+
+	t.Run("unchanged", func(t *testing.T) {
+		from, _ := comparedPredictions()
+		same := *from
+		same.ID = 12
+
+		comparison := services.ComparePredictions(from, &same)
+
+		assert.Equal(t, "Risiko diabetes Anda relatif tetap, dari 32,0% menjadi 32,0%. Tidak ada faktor yang berubah secara berarti.", comparison.Summary)
+	})
+}
+
+func TestComparePredictionsEndpoint(t *testing.T) {
+	tests := []struct {
+		name           string
+		query          string
+		setupMock      func(*mocks.MockPredictionRepository)
+		expectedStatus int
+	}{
+		{
+			name:  "success",
+			query: "?from=10&to=11",
+			setupMock: func(repo *mocks.MockPredictionRepository) {
+				from, to := comparedPredictions()
+				repo.On("GetPredictionByID", uint(10)).Return(from, nil)
+				repo.On("GetPredictionByID", uint(11)).Return(to, nil)
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "missing to",
+			query:          "?from=10",
+			setupMock:      func(*mocks.MockPredictionRepository) {},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "same prediction",
+			query:          "?from=10&to=10",
+			setupMock:      func(*mocks.MockPredictionRepository) {},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:  "not found",
+			query: "?from=10&to=99",
+			setupMock: func(repo *mocks.MockPredictionRepository) {
+				from, _ := comparedPredictions()
+				repo.On("GetPredictionByID", uint(10)).Return(from, nil)
+				repo.On("GetPredictionByID", uint(99)).Return(nil, errors.New("record not found"))
+			},
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:  "prediction of another user",
+			query: "?from=10&to=11",
+			setupMock: func(repo *mocks.MockPredictionRepository) {
+				from, to := comparedPredictions()
+				to.UserID = 2
+				repo.On("GetPredictionByID", uint(10)).Return(from, nil)
+				repo.On("GetPredictionByID", uint(11)).Return(to, nil)
+			},
+			expectedStatus: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			controller, predRepo, _, _, _, _, _, _ := setupPredictionControllerWithMocks()
+			tt.setupMock(predRepo)
+			router := setupPredictionTestRouter()
+			router.Use(addPredictionAuthMiddleware(1))
+			router.GET("/prediction/compare", controller.ComparePredictions)
+			router.GET("/prediction/:id", controller.GetPredictionByID)
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest("GET", "/prediction/compare"+tt.query, nil))
+
+			assert.Equal(t, tt.expectedStatus, w.Code, w.Body.String())
+			if tt.expectedStatus == http.StatusOK {
+				var response struct {
+					Data models.PredictionComparison `json:"data"`
+				}
+				require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+				assert.Equal(t, uint(10), response.Data.From.ID)
+				assert.Equal(t, uint(11), response.Data.To.ID)
+				assert.Len(t, response.Data.Factors, 9)
+				assert.NotEmpty(t, response.Data.Summary)
+			}
+			predRepo.AssertExpectations(t)
+		})
+	}
+}
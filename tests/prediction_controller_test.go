@@ -6,17 +6,21 @@ import (
 	"errors"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"testing"
 	"time"
 
 	"diabetify/internal/controllers"
+	"diabetify/internal/ml"
 	"diabetify/internal/models"
 	"diabetify/internal/repository"
+	"diabetify/internal/services"
 	"diabetify/tests/mocks"
 
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"gorm.io/gorm"
 )
 
 func setupPredictionTestRouter() *gin.Engine {
@@ -25,6 +29,22 @@ func setupPredictionTestRouter() *gin.Engine {
 	return router
 }
 
+// expectNoDuplicateJob lets a submission through the idempotency and coalescing checks
+func expectNoDuplicateJob(jobRepo *mocks.MockPredictionJobRepository, jobWorker *mocks.MockPredictionJobWorker, jobType string) {
+	if jobType == models.JobTypeWhatIf {
+		jobWorker.On("FeaturesForWhatIf", uint(1), mock.AnythingOfType("*models.WhatIfInput")).Return(localScorerFeatures, nil)
+	} else {
+		jobWorker.On("FeaturesForUser", uint(1)).Return(localScorerFeatures, nil)
+	}
+	jobRepo.On("FindRecentJobByInputHash", uint(1), jobType, ml.HashFeatures(localScorerFeatures), mock.AnythingOfType("time.Time")).Return(nil, gorm.ErrRecordNotFound)
+}
+
+// expectWithinQuota lets a submission through the active job and daily quotas
+func expectWithinQuota(jobRepo *mocks.MockPredictionJobRepository, jobType string) {
+	jobRepo.On("GetActiveJobsCount", uint(1)).Return(int64(0), nil)
+	jobRepo.On("CountQuotaUnitsSince", uint(1), mock.MatchedBy(func(jobTypes []string) bool { return jobTypes[0] == jobType }), mock.AnythingOfType("time.Time")).Return(int64(0), nil)
+}
+
 func setupPredictionControllerWithMocks() (*controllers.PredictionController, *mocks.MockPredictionRepository, *mocks.MockUserRepository, *mocks.MockUserProfileRepository, *mocks.MockActivityRepository, *mocks.MockPredictionJobRepository, *mocks.MockPredictionJobWorker, *mocks.MockMLClient) {
 	mockPredRepo := new(mocks.MockPredictionRepository)
 	mockUserRepo := new(mocks.MockUserRepository)
@@ -42,6 +62,8 @@ func setupPredictionControllerWithMocks() (*controllers.PredictionController, *m
 		mockJobRepo,
 		mockJobWorker,
 		mockMLClient,
+		services.NewPredictionQuota(mockJobRepo, services.PredictionQuotaConfigFromEnv()),
+		nil,
 	)
 
 	return controller, mockPredRepo, mockUserRepo, mockProfileRepo, mockActivityRepo, mockJobRepo, mockJobWorker, mockMLClient
@@ -95,6 +117,8 @@ func TestMakePrediction(t *testing.T) {
 				profileRepo.On("FindByUserID", uint(1)).Return(profile, nil)
 
 				// Mock job creation - SUCCESS
+				expectNoDuplicateJob(jobRepo, jobWorker, models.JobTypePrediction)
+				expectWithinQuota(jobRepo, models.JobTypePrediction)
 				jobRepo.On("SaveJob", mock.AnythingOfType("*models.PredictionJob")).Return(nil)
 
 				// Mock job worker - SUCCESS (this is key)
@@ -173,6 +197,8 @@ func TestMakePrediction(t *testing.T) {
 				profileRepo.On("FindByUserID", uint(1)).Return(profile, nil)
 
 				// Mock job save success but worker submission failure
+				expectNoDuplicateJob(jobRepo, jobWorker, models.JobTypePrediction)
+				expectWithinQuota(jobRepo, models.JobTypePrediction)
 				jobRepo.On("SaveJob", mock.AnythingOfType("*models.PredictionJob")).Return(nil)
 				jobWorker.On("SubmitJob", mock.AnythingOfType("models.PredictionJobRequest")).Return(errors.New("job queue unavailable"))
 				jobRepo.On("UpdateJobStatus", mock.AnythingOfType("string"), models.JobStatusFailed, mock.AnythingOfType("*string")).Return(nil)
@@ -234,6 +260,107 @@ func TestMakePredictionUnauthorized(t *testing.T) {
 	assert.Equal(t, "Unauthorized access", response["message"])
 }
 
+func TestMakePredictionCircuitBreaker(t *testing.T) {
+	tests := []struct {
+		name           string
+		withFallback   bool
+		expectedStatus int
+		expectedMsg    string
+	}{
+		{
+			name:           "breaker open without fallback",
+			withFallback:   false,
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedMsg:    "Prediction service is temporarily unavailable",
+		},
+		{
+			name:           "breaker open with fallback scorer",
+			withFallback:   true,
+			expectedStatus: http.StatusAccepted,
+			expectedMsg:    "Prediction job submitted successfully",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockPredRepo := new(mocks.MockPredictionRepository)
+			mockUserRepo := new(mocks.MockUserRepository)
+			mockProfileRepo := new(mocks.MockUserProfileRepository)
+			mockActivityRepo := new(mocks.MockActivityRepository)
+			mockJobRepo := new(mocks.MockPredictionJobRepository)
+			mockJobWorker := new(mocks.MockPredictionJobWorker)
+
+			var fallback ml.MLClient
+			if tt.withFallback {
+				fallback = new(mocks.MockMLClient)
+			}
+			breaker := ml.NewCircuitBreakerClient(new(mocks.MockMLClient), fallback, ml.CircuitBreakerConfig{
+				FailureThreshold: 1,
+				OpenTimeout:      time.Minute,
+			})
+			breaker.RecordResponse(2*time.Minute, ml.ErrResponseTimeout)
+			assert.Equal(t, ml.BreakerOpen, breaker.State())
+
+			dob := "2000-01-01"
+			mockUserRepo.On("GetUserByID", uint(1)).Return(&models.User{ID: 1, DOB: &dob}, nil)
+
+			bmi := 25.0
+			hypertension := false
+			cholesterol := false
+			macrosomicBaby := 0
+			bloodline := false
+			mockProfileRepo.On("FindByUserID", uint(1)).Return(&models.UserProfile{
+				BMI:            &bmi,
+				Hypertension:   &hypertension,
+				Cholesterol:    &cholesterol,
+				MacrosomicBaby: &macrosomicBaby,
+				Bloodline:      &bloodline,
+			}, nil)
+
+			if tt.withFallback {
+				expectNoDuplicateJob(mockJobRepo, mockJobWorker, models.JobTypePrediction)
+				expectWithinQuota(mockJobRepo, models.JobTypePrediction)
+				mockJobRepo.On("SaveJob", mock.AnythingOfType("*models.PredictionJob")).Return(nil)
+				mockJobWorker.On("SubmitJob", mock.AnythingOfType("models.PredictionJobRequest")).Return(nil)
+			}
+
+			controller := controllers.NewPredictionController(
+				mockPredRepo,
+				mockUserRepo,
+				mockProfileRepo,
+				mockActivityRepo,
+				mockJobRepo,
+				mockJobWorker,
+				breaker,
+				services.NewPredictionQuota(mockJobRepo, services.PredictionQuotaConfigFromEnv()),
+				nil,
+			)
+
+			router := setupPredictionTestRouter()
+			router.Use(addPredictionAuthMiddleware(1))
+			router.POST("/prediction", controller.MakePrediction)
+
+			req := httptest.NewRequest("POST", "/prediction", nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+
+			var response map[string]interface{}
+			err := json.Unmarshal(w.Body.Bytes(), &response)
+			assert.NoError(t, err)
+			assert.Contains(t, response["message"], tt.expectedMsg)
+
+			if tt.expectedStatus == http.StatusServiceUnavailable {
+				assert.NotEmpty(t, w.Header().Get("Retry-After"))
+			}
+
+			mockJobRepo.AssertExpectations(t)
+			mockJobWorker.AssertExpectations(t)
+		})
+	}
+}
+
 func TestWhatIfPrediction(t *testing.T) {
 	tests := []struct {
 		name           string
@@ -281,6 +408,8 @@ func TestWhatIfPrediction(t *testing.T) {
 				profileRepo.On("FindByUserID", uint(1)).Return(profile, nil)
 
 				// Mock job creation and submission - SUCCESS
+				expectNoDuplicateJob(jobRepo, jobWorker, models.JobTypeWhatIf)
+				expectWithinQuota(jobRepo, models.JobTypeWhatIf)
 				jobRepo.On("SaveJob", mock.AnythingOfType("*models.PredictionJob")).Return(nil)
 				jobWorker.On("SubmitJob", mock.AnythingOfType("models.PredictionJobRequest")).Return(nil)
 			},
@@ -383,11 +512,26 @@ func TestTestMLConnection(t *testing.T) {
 					"rabbitmq_connected": true,
 				}
 				jobWorker.On("GetStatus").Return(status)
-				mlClient.On("HealthCheckAsync", mock.Anything).Return(nil)
+				health := &ml.HealthStatus{Healthy: true, Status: "healthy", ModelVersion: "1.0.0", LatencyMs: 12.5}
+				mlClient.On("ProbeHealth", mock.Anything).Return(health, nil)
 			},
 			expectedStatus: http.StatusOK,
 			expectedMsg:    "Async ML service is healthy via RabbitMQ",
 		},
+		{
+			name: "ML consumer not answering health probe",
+			setupMock: func(jobWorker *mocks.MockPredictionJobWorker, mlClient *mocks.MockMLClient) {
+				status := map[string]interface{}{
+					"running":            true,
+					"rabbitmq_connected": true,
+				}
+				jobWorker.On("GetStatus").Return(status)
+				health := &ml.HealthStatus{Healthy: false, Status: "timeout", Error: ml.ErrHealthProbeTimeout.Error()}
+				mlClient.On("ProbeHealth", mock.Anything).Return(health, ml.ErrHealthProbeTimeout)
+			},
+			expectedStatus: http.StatusInternalServerError,
+			expectedMsg:    "ML service did not respond to the health probe",
+		},
 		{
 			name: "ML service unhealthy - worker not running",
 			setupMock: func(jobWorker *mocks.MockPredictionJobWorker, mlClient *mocks.MockMLClient) {
@@ -428,6 +572,65 @@ func TestTestMLConnection(t *testing.T) {
 	}
 }
 
+func TestGetReadiness(t *testing.T) {
+	tests := []struct {
+		name           string
+		setupMock      func(*mocks.MockPredictionJobWorker, *mocks.MockMLClient)
+		expectedStatus int
+		expectedMsg    string
+	}{
+		{
+			name: "ready",
+			setupMock: func(jobWorker *mocks.MockPredictionJobWorker, mlClient *mocks.MockMLClient) {
+				jobWorker.On("GetStatus").Return(map[string]interface{}{
+					"running":            true,
+					"rabbitmq_connected": true,
+				})
+				mlClient.On("ProbeHealth", mock.Anything).Return(&ml.HealthStatus{Healthy: true, Status: "healthy"}, nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedMsg:    "Service is ready",
+		},
+		{
+			name: "ML service not answering",
+			setupMock: func(jobWorker *mocks.MockPredictionJobWorker, mlClient *mocks.MockMLClient) {
+				jobWorker.On("GetStatus").Return(map[string]interface{}{
+					"running":            true,
+					"rabbitmq_connected": true,
+				})
+				mlClient.On("ProbeHealth", mock.Anything).Return(&ml.HealthStatus{Status: "timeout"}, ml.ErrHealthProbeTimeout)
+			},
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedMsg:    "Service is not ready",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			controller, _, _, _, _, _, jobWorker, mlClient := setupPredictionControllerWithMocks()
+			tt.setupMock(jobWorker, mlClient)
+
+			router := setupPredictionTestRouter()
+			router.GET("/prediction/ready", controller.GetReadiness)
+
+			req := httptest.NewRequest("GET", "/prediction/ready", nil)
+			w := httptest.NewRecorder()
+
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+
+			var response map[string]interface{}
+			err := json.Unmarshal(w.Body.Bytes(), &response)
+			assert.NoError(t, err)
+			assert.Contains(t, response["message"], tt.expectedMsg)
+
+			jobWorker.AssertExpectations(t)
+			mlClient.AssertExpectations(t)
+		})
+	}
+}
+
 func TestGetJobStatus(t *testing.T) {
 	tests := []struct {
 		name           string
@@ -511,6 +714,7 @@ func TestGetUserPredictions(t *testing.T) {
 		name           string
 		userID         uint
 		limit          string
+		modelVersion   string
 		setupMock      func(*mocks.MockPredictionRepository)
 		expectedStatus int
 		expectedMsg    string
@@ -540,6 +744,19 @@ func TestGetUserPredictions(t *testing.T) {
 			expectedStatus: http.StatusOK,
 			expectedMsg:    "Prediction history retrieved successfully",
 		},
+		{
+			name:         "filtered by model version",
+			userID:       1,
+			modelVersion: "2024.06.1",
+			setupMock: func(predRepo *mocks.MockPredictionRepository) {
+				predictions := []models.Prediction{
+					{ID: 3, UserID: 1, RiskScore: 0.30, ModelVersion: "2024.06.1"},
+				}
+				predRepo.On("GetPredictionsByUserIDAndModelVersion", uint(1), "2024.06.1", 10).Return(predictions, nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedMsg:    "Prediction history retrieved successfully",
+		},
 		{
 			name:           "invalid limit",
 			userID:         1,
@@ -559,10 +776,14 @@ func TestGetUserPredictions(t *testing.T) {
 			router.Use(addPredictionAuthMiddleware(tt.userID))
 			router.GET("/prediction/me", controller.GetUserPredictions)
 
-			url := "/prediction/me"
+			query := url.Values{}
 			if tt.limit != "" {
-				url += "?limit=" + tt.limit
+				query.Set("limit", tt.limit)
 			}
+			if tt.modelVersion != "" {
+				query.Set("model_version", tt.modelVersion)
+			}
+			url := "/prediction/me?" + query.Encode()
 
 			req := httptest.NewRequest("GET", url, nil)
 			w := httptest.NewRecorder()
@@ -581,6 +802,67 @@ func TestGetUserPredictions(t *testing.T) {
 	}
 }
 
+func TestGetPredictionModels(t *testing.T) {
+	tests := []struct {
+		name           string
+		setupMock      func(*mocks.MockPredictionRepository)
+		expectedStatus int
+		expectedMsg    string
+	}{
+		{
+			name: "versions listed",
+			setupMock: func(predRepo *mocks.MockPredictionRepository) {
+				versions := []repository.ModelVersionSummary{
+					{ModelName: "diabetes-risk-xgb", ModelVersion: "2024.06.1", FeatureSchemaVersion: "v1", PredictionCount: 4},
+					{ModelName: "unknown", ModelVersion: "unknown", FeatureSchemaVersion: "v1", PredictionCount: 12},
+				}
+				predRepo.On("GetModelVersionsByUserID", uint(1)).Return(versions, nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedMsg:    "Model versions retrieved successfully",
+		},
+		{
+			name: "database error",
+			setupMock: func(predRepo *mocks.MockPredictionRepository) {
+				predRepo.On("GetModelVersionsByUserID", uint(1)).Return([]repository.ModelVersionSummary{}, errors.New("database error"))
+			},
+			expectedStatus: http.StatusInternalServerError,
+			expectedMsg:    "Failed to retrieve model versions",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			controller, predRepo, _, _, _, _, _, _ := setupPredictionControllerWithMocks()
+			tt.setupMock(predRepo)
+
+			router := setupPredictionTestRouter()
+			router.Use(addPredictionAuthMiddleware(1))
+			router.GET("/prediction/models", controller.GetPredictionModels)
+
+			req := httptest.NewRequest("GET", "/prediction/models", nil)
+			w := httptest.NewRecorder()
+
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+
+			var response map[string]interface{}
+			err := json.Unmarshal(w.Body.Bytes(), &response)
+			assert.NoError(t, err)
+			assert.Contains(t, response["message"], tt.expectedMsg)
+
+			if tt.expectedStatus == http.StatusOK {
+				data := response["data"].(map[string]interface{})
+				assert.Equal(t, ml.FeatureSchemaVersion, data["current_feature_schema_version"])
+				assert.Len(t, data["models"], 2)
+			}
+
+			predRepo.AssertExpectations(t)
+		})
+	}
+}
+
 func TestGetPredictionByID(t *testing.T) {
 	tests := []struct {
 		name           string
@@ -890,18 +1172,20 @@ func TestGetLatestPredictionExplanation(t *testing.T) {
 			userID: 1,
 			setupMock: func(predRepo *mocks.MockPredictionRepository) {
 				prediction := &models.Prediction{
-					ID:                                   1,
-					UserID:                               1,
-					RiskScore:                            0.15,
-					AgeExplanation:                       "Age factor explanation",
-					BMIExplanation:                       "BMI factor explanation",
-					BrinkmanScoreExplanation:             "Brinkman score explanation",
-					IsHypertensionExplanation:            "Hypertension explanation",
-					IsCholesterolExplanation:             "Cholesterol explanation",
-					IsBloodlineExplanation:               "Bloodline explanation",
-					IsMacrosomicBabyExplanation:          "Macrosomic baby explanation",
-					SmokingStatusExplanation:             "Smoking status explanation",
-					PhysicalActivityFrequencyExplanation: "Physical activity explanation",
+					ID:        1,
+					UserID:    1,
+					RiskScore: 0.15,
+					Factors: []models.PredictionFactor{
+						{Factor: "age", Explanation: "Age factor explanation"},
+						{Factor: "bmi", Explanation: "BMI factor explanation"},
+						{Factor: "brinkman_score", Explanation: "Brinkman score explanation"},
+						{Factor: "is_hypertension", Explanation: "Hypertension explanation"},
+						{Factor: "is_cholesterol", Explanation: "Cholesterol explanation"},
+						{Factor: "is_bloodline", Explanation: "Bloodline explanation"},
+						{Factor: "is_macrosomic_baby", Explanation: "Macrosomic baby explanation"},
+						{Factor: "smoking_status", Explanation: "Smoking status explanation"},
+						{Factor: "physical_activity_frequency", Explanation: "Physical activity explanation"},
+					},
 				}
 				predRepo.On("GetLatestPredictionByUserID", uint(1)).Return(prediction, nil)
 			},
@@ -1181,7 +1465,7 @@ func TestGetJobResult(t *testing.T) {
 				jobWorker.On("GetWhatIfResult", "test-job-id").Return(map[string]interface{}{}, false, nil)
 			},
 			expectedStatus: http.StatusNotFound,
-			expectedMsg:    "What-if result has expired or not found",
+			expectedMsg:    "What-if result not found",
 		},
 		{
 			name:   "job not completed yet",
@@ -1227,3 +1511,128 @@ func TestGetJobResult(t *testing.T) {
 		})
 	}
 }
+
+func TestPredictionSubmissionDeduplication(t *testing.T) {
+	inputHash := ml.HashFeatures(localScorerFeatures)
+	existing := &models.PredictionJob{ID: "existing-job", UserID: 1, JobType: models.JobTypePrediction, Status: models.JobStatusSubmitted}
+
+	tests := []struct {
+		name           string
+		idempotencyKey string
+		setupMocks     func(*mocks.MockPredictionJobRepository, *mocks.MockPredictionJobWorker)
+		expectedStatus int
+		expectedReason string
+		// attemptsSave is set when the reused job is found only after saving failed
+		attemptsSave bool
+	}{
+		{
+			name:           "repeated idempotency key returns the first job",
+			idempotencyKey: "tap-1",
+			setupMocks: func(jobRepo *mocks.MockPredictionJobRepository, jobWorker *mocks.MockPredictionJobWorker) {
+				jobRepo.On("GetJobByIdempotencyKey", uint(1), "tap-1").Return(existing, nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedReason: "idempotency_key",
+		},
+		{
+			name:           "idempotency key of a what-if job",
+			idempotencyKey: "tap-2",
+			setupMocks: func(jobRepo *mocks.MockPredictionJobRepository, jobWorker *mocks.MockPredictionJobWorker) {
+				jobRepo.On("GetJobByIdempotencyKey", uint(1), "tap-2").Return(&models.PredictionJob{ID: "what-if-job", JobType: models.JobTypeWhatIf}, nil)
+			},
+			expectedStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name:           "idempotency key reused with a different request",
+			idempotencyKey: "tap-4",
+			setupMocks: func(jobRepo *mocks.MockPredictionJobRepository, jobWorker *mocks.MockPredictionJobWorker) {
+				jobRepo.On("GetJobByIdempotencyKey", uint(1), "tap-4").Return(&models.PredictionJob{ID: "other-job", JobType: models.JobTypePrediction, RequestHash: "other-request"}, nil)
+			},
+			expectedStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name:           "concurrent request with the same key wins the unique index",
+			idempotencyKey: "tap-5",
+			setupMocks: func(jobRepo *mocks.MockPredictionJobRepository, jobWorker *mocks.MockPredictionJobWorker) {
+				jobRepo.On("GetJobByIdempotencyKey", uint(1), "tap-5").Return(nil, gorm.ErrRecordNotFound).Once()
+				expectNoDuplicateJob(jobRepo, jobWorker, models.JobTypePrediction)
+				expectWithinQuota(jobRepo, models.JobTypePrediction)
+				jobRepo.On("SaveJob", mock.Anything).Return(errors.New("duplicate key value violates unique constraint")).Once()
+				jobRepo.On("GetJobByIdempotencyKey", uint(1), "tap-5").Return(existing, nil).Once()
+			},
+			expectedStatus: http.StatusOK,
+			expectedReason: "idempotency_key",
+			attemptsSave:   true,
+		},
+		{
+			name:           "idempotency key too long",
+			idempotencyKey: string(bytes.Repeat([]byte("k"), 101)),
+			setupMocks:     func(*mocks.MockPredictionJobRepository, *mocks.MockPredictionJobWorker) {},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name: "same input as a running job is coalesced",
+			setupMocks: func(jobRepo *mocks.MockPredictionJobRepository, jobWorker *mocks.MockPredictionJobWorker) {
+				jobWorker.On("FeaturesForUser", uint(1)).Return(localScorerFeatures, nil)
+				jobRepo.On("FindRecentJobByInputHash", uint(1), models.JobTypePrediction, inputHash, mock.AnythingOfType("time.Time")).Return(existing, nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedReason: "same_input",
+		},
+		{
+			name:           "new key and new input create a job",
+			idempotencyKey: "tap-3",
+			setupMocks: func(jobRepo *mocks.MockPredictionJobRepository, jobWorker *mocks.MockPredictionJobWorker) {
+				jobRepo.On("GetJobByIdempotencyKey", uint(1), "tap-3").Return(nil, gorm.ErrRecordNotFound)
+				expectNoDuplicateJob(jobRepo, jobWorker, models.JobTypePrediction)
+				expectWithinQuota(jobRepo, models.JobTypePrediction)
+				jobRepo.On("SaveJob", mock.MatchedBy(func(job *models.PredictionJob) bool {
+					return job.IdempotencyKey != nil && *job.IdempotencyKey == "tap-3" && job.InputHash == inputHash && job.RequestHash != ""
+				})).Return(nil)
+				jobWorker.On("SubmitJob", mock.AnythingOfType("models.PredictionJobRequest")).Return(nil)
+			},
+			expectedStatus: http.StatusAccepted,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			controller, _, userRepo, profileRepo, _, jobRepo, jobWorker, _ := setupPredictionControllerWithMocks()
+			dob := "2000-01-01"
+			bmi := 25.0
+			no := false
+			macrosomicBaby := 0
+			userRepo.On("GetUserByID", uint(1)).Return(&models.User{ID: 1, DOB: &dob}, nil)
+			profileRepo.On("FindByUserID", uint(1)).Return(&models.UserProfile{
+				BMI: &bmi, Hypertension: &no, Cholesterol: &no, MacrosomicBaby: &macrosomicBaby, Bloodline: &no,
+			}, nil)
+			tt.setupMocks(jobRepo, jobWorker)
+
+			router := setupPredictionTestRouter()
+			router.Use(addPredictionAuthMiddleware(1))
+			router.POST("/prediction", controller.MakePrediction)
+
+			req := httptest.NewRequest("POST", "/prediction", nil)
+			if tt.idempotencyKey != "" {
+				req.Header.Set(controllers.IdempotencyKeyHeader, tt.idempotencyKey)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code, w.Body.String())
+			if tt.expectedReason != "" {
+				var response map[string]interface{}
+				assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+				data := response["data"].(map[string]interface{})
+				assert.Equal(t, "existing-job", data["job_id"])
+				assert.Equal(t, tt.expectedReason, data["reuse_reason"])
+				assert.Equal(t, tt.expectedReason == "idempotency_key", w.Header().Get("Idempotent-Replayed") == "true")
+				if !tt.attemptsSave {
+					jobRepo.AssertNotCalled(t, "SaveJob", mock.Anything)
+				}
+			}
+			jobRepo.AssertExpectations(t)
+			jobWorker.AssertExpectations(t)
+		})
+	}
+}
@@ -0,0 +1,183 @@
+package tests
+
+import (
+	"diabetify/internal/models"
+	"diabetify/internal/services"
+	"diabetify/tests/mocks"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPredictionQuotaCheck(t *testing.T) {
+	cfg := services.PredictionQuotaConfig{MaxActiveJobs: 3, DailyPredictions: 20, DailyWhatIfs: 100}
+
+	tests := []struct {
+		name          string
+		cfg           services.PredictionQuotaConfig
+		jobType       string
+		units         int
+		active        int64
+		today         int64
+		expectedQuota string
+	}{
+		{name: "within every quota", cfg: cfg, jobType: models.JobTypePrediction, active: 2, today: 19},
+		{name: "too many active jobs", cfg: cfg, jobType: models.JobTypePrediction, active: 3, expectedQuota: services.QuotaActiveJobs},
+		{name: "daily predictions used up", cfg: cfg, jobType: models.JobTypePrediction, active: 0, today: 20, expectedQuota: services.QuotaDailyPredictions},
+		{name: "what-if has its own allowance", cfg: cfg, jobType: models.JobTypeWhatIf, active: 0, today: 99},
+		{name: "daily what-ifs used up", cfg: cfg, jobType: models.JobTypeWhatIf, active: 0, today: 100, expectedQuota: services.QuotaDailyWhatIfs},
+		{name: "zero disables a limit", cfg: services.PredictionQuotaConfig{DailyPredictions: 0}, jobType: models.JobTypePrediction},
+		{name: "every sweep point is a what-if", cfg: cfg, jobType: models.JobTypeWhatIfSweep, units: 11, active: 0, today: 90, expectedQuota: services.QuotaDailyWhatIfs},
+		{name: "sweep within the what-if allowance", cfg: cfg, jobType: models.JobTypeWhatIfSweep, units: 10, active: 0, today: 90},
+		{name: "batches skip the active job cap", cfg: services.PredictionQuotaConfig{MaxActiveJobs: 3, DailyBatchItems: 1000}, jobType: models.JobTypeBatch, units: 500, active: 3, today: 500},
+		{name: "daily batch items used up", cfg: services.PredictionQuotaConfig{MaxActiveJobs: 3, DailyBatchItems: 1000}, jobType: models.JobTypeBatch, units: 501, active: 0, today: 500, expectedQuota: services.QuotaDailyBatchItems},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jobRepo := new(mocks.MockPredictionJobRepository)
+			jobRepo.On("GetActiveJobsCount", uint(1)).Return(tt.active, nil)
+			jobRepo.On("CountQuotaUnitsSince", uint(1), mock.Anything, mock.AnythingOfType("time.Time")).Return(tt.today, nil)
+
+			quota := services.NewPredictionQuota(jobRepo, tt.cfg)
+			err := quota.Check(1, tt.jobType)
+			if tt.units > 0 {
+				err = quota.CheckUnits(1, tt.jobType, tt.units)
+			}
+			if tt.expectedQuota == "" {
+				assert.NoError(t, err)
+				return
+			}
+
+			var exceeded *services.QuotaExceededError
+			require.True(t, errors.As(err, &exceeded), "expected a quota error, got %v", err)
+			assert.Equal(t, tt.expectedQuota, exceeded.Quota)
+			if tt.expectedQuota == services.QuotaActiveJobs {
+				assert.True(t, exceeded.ResetAt.IsZero())
+			} else {
+				tomorrow := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, 1)
+				assert.Equal(t, tomorrow, exceeded.ResetAt)
+			}
+		})
+	}
+}
+
+func TestPredictionQuotaConfigFromEnv(t *testing.T) {
+	t.Setenv("PREDICTION_MAX_ACTIVE_JOBS", "5")
+	t.Setenv("PREDICTION_DAILY_LIMIT", "0")
+	t.Setenv("WHAT_IF_DAILY_LIMIT", "not-a-number")
+
+	cfg := services.PredictionQuotaConfigFromEnv()
+	assert.Equal(t, 5, cfg.MaxActiveJobs)
+	assert.Equal(t, 0, cfg.DailyPredictions)
+	assert.Equal(t, 100, cfg.DailyWhatIfs)
+}
+
+func TestMakePredictionQuotaExceeded(t *testing.T) {
+	tests := []struct {
+		name          string
+		active        int64
+		today         int64
+		expectedQuota string
+		retryAfter    bool
+	}{
+		{name: "active jobs", active: 3, expectedQuota: services.QuotaActiveJobs},
+		{name: "daily limit", active: 1, today: 20, expectedQuota: services.QuotaDailyPredictions, retryAfter: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			controller, _, userRepo, profileRepo, _, jobRepo, jobWorker, _ := setupPredictionControllerWithMocks()
+			dob := "2000-01-01"
+			bmi := 25.0
+			no := false
+			macrosomicBaby := 0
+			userRepo.On("GetUserByID", uint(1)).Return(&models.User{ID: 1, DOB: &dob}, nil)
+			profileRepo.On("FindByUserID", uint(1)).Return(&models.UserProfile{
+				BMI: &bmi, Hypertension: &no, Cholesterol: &no, MacrosomicBaby: &macrosomicBaby, Bloodline: &no,
+			}, nil)
+			expectNoDuplicateJob(jobRepo, jobWorker, models.JobTypePrediction)
+			jobRepo.On("GetActiveJobsCount", uint(1)).Return(tt.active, nil)
+			jobRepo.On("CountQuotaUnitsSince", uint(1), []string{models.JobTypePrediction}, mock.AnythingOfType("time.Time")).Return(tt.today, nil)
+
+			router := setupPredictionTestRouter()
+			router.Use(addPredictionAuthMiddleware(1))
+			router.POST("/prediction", controller.MakePrediction)
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest("POST", "/prediction", nil))
+
+			assert.Equal(t, http.StatusTooManyRequests, w.Code, w.Body.String())
+			var response map[string]interface{}
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+			data := response["data"].(map[string]interface{})
+			assert.Equal(t, tt.expectedQuota, data["quota"])
+
+			if tt.retryAfter {
+				seconds, err := strconv.Atoi(w.Header().Get("Retry-After"))
+				require.NoError(t, err)
+				assert.True(t, seconds > 0 && seconds <= 24*60*60)
+				assert.NotEmpty(t, data["reset_at"])
+			} else {
+				assert.Empty(t, w.Header().Get("Retry-After"))
+				assert.NotContains(t, data, "reset_at")
+			}
+			jobRepo.AssertNotCalled(t, "SaveJob", mock.Anything)
+			jobWorker.AssertNotCalled(t, "SubmitJob", mock.Anything)
+		})
+	}
+}
+
+func TestJobSchedulerPriorityAndFairness(t *testing.T) {
+	scheduler := services.NewJobScheduler(10)
+	stop := make(chan struct{})
+
+	// User 1 floods the standard class before users 2 and 3 submit anything
+	for _, job := range []models.PredictionJobRequest{
+		{JobID: "recovered", UserID: 4, Priority: models.JobPriorityBackground},
+		{JobID: "u1-a", UserID: 1},
+		{JobID: "u1-b", UserID: 1},
+		{JobID: "u1-c", UserID: 1},
+		{JobID: "u2-a", UserID: 2, Priority: models.JobPriorityStandard},
+		{JobID: "u3-a", UserID: 3, Priority: models.JobPriorityStandard},
+		{JobID: "u1-d", UserID: 1},
+		{JobID: "what-if", UserID: 5, Priority: models.JobPriorityInteractive},
+	} {
+		require.NoError(t, scheduler.Push(job))
+	}
+
+	sizes := scheduler.Sizes()
+	assert.Equal(t, 1, sizes[models.JobPriorityInteractive])
+	assert.Equal(t, 6, sizes[models.JobPriorityStandard])
+	assert.Equal(t, 1, sizes[models.JobPriorityBackground])
+
+	var order []string
+	for scheduler.Len() > 0 {
+		job, ok := scheduler.Next(stop)
+		require.True(t, ok)
+		order = append(order, job.JobID)
+	}
+	assert.Equal(t, []string{"what-if", "u1-a", "u2-a", "u3-a", "u1-b", "u1-c", "u1-d", "recovered"}, order)
+
+	close(stop)
+	_, ok := scheduler.Next(stop)
+	assert.False(t, ok)
+}
+
+func TestJobSchedulerCapacity(t *testing.T) {
+	scheduler := services.NewJobScheduler(2)
+	require.NoError(t, scheduler.Push(models.PredictionJobRequest{JobID: "a", UserID: 1}))
+	require.NoError(t, scheduler.Push(models.PredictionJobRequest{JobID: "b", UserID: 2}))
+
+	err := scheduler.Push(models.PredictionJobRequest{JobID: "c", UserID: 3, Priority: models.JobPriorityInteractive})
+	assert.ErrorIs(t, err, services.ErrJobQueueFull)
+	assert.Equal(t, 2, scheduler.Len())
+}
@@ -0,0 +1,136 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"diabetify/internal/ml"
+	"diabetify/internal/models"
+	"diabetify/tests/mocks"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func snapshotPrediction() *models.Prediction {
+	computedAt := time.Date(2024, 6, 10, 10, 0, 0, 0, time.UTC)
+	return &models.Prediction{
+		ID:        20,
+		UserID:    1,
+		RiskScore: 0.4,
+		// The stored factors are rounded; the snapshot holds the exact input
+		Factors: []models.PredictionFactor{
+			{Factor: "age", Value: 50},
+			{Factor: "bmi", Value: 32},
+		},
+		ModelVersion: "test-1",
+		InputHash:    ml.HashFeatures(localScorerFeatures),
+		FeatureSnapshot: &models.FeatureSnapshot{
+			Features: localScorerFeatures,
+			Trace: []models.FeatureDerivation{
+				{
+					Feature:    "smoking_status",
+					Value:      1,
+					Source:     models.DerivationActivities,
+					Inputs:     map[string]interface{}{"age_of_smoking": 17, "age_of_stop_smoking": 40},
+					Activities: []models.ActivityWindow{{ActivityType: "smoke", Start: computedAt.AddDate(0, 0, -56), End: computedAt}},
+					Rule:       "stopped smoking before the current age: former smoker",
+				},
+			},
+			ComputedAt: computedAt,
+		},
+	}
+}
+
+func TestPredictionFeatureVectorPrefersSnapshot(t *testing.T) {
+	prediction := snapshotPrediction()
+	assert.Equal(t, localScorerFeatures, prediction.FeatureVector())
+
+	prediction.FeatureSnapshot = nil
+	assert.Equal(t, []float64{50, 0, 0, 0, 0, 0, 0, 32, 0}, prediction.FeatureVector())
+}
+
+func TestGetPredictionSnapshot(t *testing.T) {
+	tests := []struct {
+		name           string
+		setupMock      func(*mocks.MockPredictionRepository)
+		expectedStatus int
+		expectedMatch  bool
+	}{
+		{
+			name: "success",
+			setupMock: func(repo *mocks.MockPredictionRepository) {
+				repo.On("GetPredictionByID", uint(20)).Return(snapshotPrediction(), nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedMatch:  true,
+		},
+		{
+			name: "hash mismatch",
+			setupMock: func(repo *mocks.MockPredictionRepository) {
+				prediction := snapshotPrediction()
+				prediction.InputHash = ml.HashFeatures([]float64{1, 2, 3})
+				repo.On("GetPredictionByID", uint(20)).Return(prediction, nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedMatch:  false,
+		},
+		{
+			name: "prediction without snapshot",
+			setupMock: func(repo *mocks.MockPredictionRepository) {
+				prediction := snapshotPrediction()
+				prediction.FeatureSnapshot = nil
+				repo.On("GetPredictionByID", uint(20)).Return(prediction, nil)
+			},
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name: "prediction of another user",
+			setupMock: func(repo *mocks.MockPredictionRepository) {
+				prediction := snapshotPrediction()
+				prediction.UserID = 2
+				repo.On("GetPredictionByID", uint(20)).Return(prediction, nil)
+			},
+			expectedStatus: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			controller, predRepo, _, _, _, _, _, _ := setupPredictionControllerWithMocks()
+			tt.setupMock(predRepo)
+			router := setupPredictionTestRouter()
+			router.Use(addPredictionAuthMiddleware(1))
+			router.GET("/prediction/:id/snapshot", controller.GetPredictionSnapshot)
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest("GET", "/prediction/20/snapshot", nil))
+
+			assert.Equal(t, tt.expectedStatus, w.Code, w.Body.String())
+			if tt.expectedStatus == http.StatusOK {
+				var response struct {
+					Data struct {
+						InputHashMatches bool                   `json:"input_hash_matches"`
+						FeatureNames     []string               `json:"feature_names"`
+						Snapshot         models.FeatureSnapshot `json:"snapshot"`
+					} `json:"data"`
+				}
+				require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+				assert.Equal(t, tt.expectedMatch, response.Data.InputHashMatches)
+				assert.Equal(t, ml.FeatureNames, response.Data.FeatureNames)
+				assert.Equal(t, localScorerFeatures, response.Data.Snapshot.Features)
+
+				derivation := response.Data.Snapshot.Derivation("smoking_status")
+				require.NotNil(t, derivation)
+				assert.Equal(t, models.DerivationActivities, derivation.Source)
+				assert.Equal(t, 40.0, derivation.Inputs["age_of_stop_smoking"])
+				require.Len(t, derivation.Activities, 1)
+				assert.Equal(t, "smoke", derivation.Activities[0].ActivityType)
+			}
+			predRepo.AssertExpectations(t)
+		})
+	}
+}
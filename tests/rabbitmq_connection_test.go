@@ -0,0 +1,357 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"diabetify/internal/ml"
+
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeBroker is an in-memory broker; publishes are confirmed with the acks queued on
+// it, or acked straight away when autoAck is set. Published messages are handed to
+// onPublish, which may answer through deliver.
+type fakeBroker struct {
+	mu        sync.Mutex
+	dials     int
+	conns     []*fakeBrokerConnection
+	autoAck   bool
+	acks      chan bool
+	consumers map[string]chan amqp.Delivery
+	onPublish func(queue string, msg amqp.Publishing)
+}
+
+func newFakeBroker(autoAck bool) *fakeBroker {
+	return &fakeBroker{autoAck: autoAck, acks: make(chan bool, 16), consumers: make(map[string]chan amqp.Delivery)}
+}
+
+// deliver hands a message to the consumer of the queue, if there is one
+func (b *fakeBroker) deliver(queue string, msg amqp.Delivery) {
+	b.mu.Lock()
+	deliveries, ok := b.consumers[queue]
+	b.mu.Unlock()
+	if ok {
+		go func() {
+			defer func() { _ = recover() }() // the consumer channel closed on a disconnect
+			deliveries <- msg
+		}()
+	}
+}
+
+func (b *fakeBroker) Dial(string) (ml.BrokerConnection, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.dials++
+	conn := &fakeBrokerConnection{broker: b}
+	b.conns = append(b.conns, conn)
+	return conn, nil
+}
+
+func (b *fakeBroker) dialCount() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.dials
+}
+
+// kill closes the current connection from the broker side
+func (b *fakeBroker) kill() {
+	b.mu.Lock()
+	conn := b.conns[len(b.conns)-1]
+	b.mu.Unlock()
+	conn.shutdown(&amqp.Error{Code: amqp.ConnectionForced, Reason: "broker restarted"})
+}
+
+type fakeBrokerConnection struct {
+	broker   *fakeBroker
+	mu       sync.Mutex
+	closed   bool
+	notify   []chan *amqp.Error
+	channels []*fakeBrokerChannel
+}
+
+func (c *fakeBrokerConnection) Channel() (ml.BrokerChannel, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil, amqp.ErrClosed
+	}
+	ch := &fakeBrokerChannel{conn: c}
+	c.channels = append(c.channels, ch)
+	return ch, nil
+}
+
+func (c *fakeBrokerConnection) NotifyClose(receiver chan *amqp.Error) chan *amqp.Error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		close(receiver)
+		return receiver
+	}
+	c.notify = append(c.notify, receiver)
+	return receiver
+}
+
+func (c *fakeBrokerConnection) IsClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
+}
+
+func (c *fakeBrokerConnection) Close() error {
+	c.shutdown(nil)
+	return nil
+}
+
+func (c *fakeBrokerConnection) shutdown(reason *amqp.Error) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return
+	}
+	c.closed = true
+	notify, channels := c.notify, c.channels
+	c.mu.Unlock()
+
+	for _, ch := range channels {
+		ch.shutdown(reason)
+	}
+	for _, receiver := range notify {
+		if reason != nil {
+			receiver <- reason
+		}
+		close(receiver)
+	}
+}
+
+type fakeBrokerChannel struct {
+	conn       *fakeBrokerConnection
+	mu         sync.Mutex
+	closed     bool
+	confirms   chan amqp.Confirmation
+	notify     []chan *amqp.Error
+	deliveries []chan amqp.Delivery
+	tag        uint64
+}
+
+func (ch *fakeBrokerChannel) QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error) {
+	return amqp.Queue{Name: name}, nil
+}
+
+func (ch *fakeBrokerChannel) Qos(prefetchCount, prefetchSize int, global bool) error {
+	return nil
+}
+
+func (ch *fakeBrokerChannel) Confirm(noWait bool) error {
+	return nil
+}
+
+func (ch *fakeBrokerChannel) NotifyPublish(confirm chan amqp.Confirmation) chan amqp.Confirmation {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	ch.confirms = confirm
+	return confirm
+}
+
+func (ch *fakeBrokerChannel) NotifyClose(receiver chan *amqp.Error) chan *amqp.Error {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	ch.notify = append(ch.notify, receiver)
+	return receiver
+}
+
+func (ch *fakeBrokerChannel) Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	ch.mu.Lock()
+	if ch.closed {
+		ch.mu.Unlock()
+		return amqp.ErrClosed
+	}
+	ch.tag++
+	tag, confirms := ch.tag, ch.confirms
+	ch.mu.Unlock()
+
+	broker := ch.conn.broker
+	broker.mu.Lock()
+	autoAck, onPublish := broker.autoAck, broker.onPublish
+	broker.mu.Unlock()
+	if onPublish != nil {
+		onPublish(key, msg)
+	}
+
+	go func() {
+		ack := true
+		if !autoAck {
+			ack = <-broker.acks
+		}
+		confirms <- amqp.Confirmation{DeliveryTag: tag, Ack: ack}
+	}()
+	return nil
+}
+
+func (ch *fakeBrokerChannel) Consume(queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args amqp.Table) (<-chan amqp.Delivery, error) {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	deliveries := make(chan amqp.Delivery)
+	ch.deliveries = append(ch.deliveries, deliveries)
+
+	broker := ch.conn.broker
+	broker.mu.Lock()
+	broker.consumers[queue] = deliveries
+	broker.mu.Unlock()
+	return deliveries, nil
+}
+
+func (ch *fakeBrokerChannel) Close() error {
+	ch.shutdown(nil)
+	return nil
+}
+
+func (ch *fakeBrokerChannel) shutdown(reason *amqp.Error) {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	if ch.closed {
+		return
+	}
+	ch.closed = true
+	for _, deliveries := range ch.deliveries {
+		close(deliveries)
+	}
+	for _, receiver := range ch.notify {
+		close(receiver)
+	}
+}
+
+func TestConnectionManagerReconnectsAndRestartsConsumers(t *testing.T) {
+	broker := newFakeBroker(true)
+	cm := ml.NewConnectionManagerWithDialer("amqp://fake", []string{"prediction_jobs"}, broker.Dial, 10*time.Millisecond)
+	defer cm.Close()
+
+	var started int32
+	require.NoError(t, cm.Consume("prediction_jobs", "worker", 1, func(deliveries <-chan amqp.Delivery) {
+		atomic.AddInt32(&started, 1)
+		for range deliveries {
+		}
+	}))
+	require.NoError(t, cm.Publish(context.Background(), "prediction_jobs", amqp.Publishing{Body: []byte("before")}))
+
+	broker.kill()
+
+	require.Eventually(t, func() bool {
+		return broker.dialCount() == 2 && cm.IsConnected() && atomic.LoadInt32(&started) == 2
+	}, 2*time.Second, 5*time.Millisecond)
+	require.NoError(t, cm.Publish(context.Background(), "prediction_jobs", amqp.Publishing{Body: []byte("after")}))
+
+	stats := cm.Stats()
+	assert.Equal(t, int64(1), stats["reconnects"])
+	assert.Equal(t, int64(2), stats["messages_confirmed"])
+}
+
+func TestConnectionManagerCancelledPublishKeepsConnection(t *testing.T) {
+	broker := newFakeBroker(false)
+	cm := ml.NewConnectionManagerWithDialer("amqp://fake", []string{"prediction_jobs"}, broker.Dial, 10*time.Millisecond)
+	defer cm.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := cm.Publish(ctx, "prediction_jobs", amqp.Publishing{Body: []byte("abandoned")})
+	require.True(t, errors.Is(err, context.DeadlineExceeded), "got %v", err)
+
+	// The next publish must get its own confirmation, not the one of the abandoned publish
+	result := make(chan error, 1)
+	go func() {
+		result <- cm.Publish(context.Background(), "prediction_jobs", amqp.Publishing{Body: []byte("next")})
+	}()
+	broker.acks <- false
+	broker.acks <- true
+
+	select {
+	case err := <-result:
+		require.NoError(t, err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("publish after a cancelled publish did not complete")
+	}
+
+	assert.True(t, cm.IsConnected())
+	assert.Equal(t, 1, broker.dialCount())
+	stats := cm.Stats()
+	assert.Equal(t, int64(0), stats["reconnects"])
+	assert.Equal(t, int64(1), stats["publishes_abandoned"])
+	assert.Equal(t, int64(1), stats["messages_nacked"])
+	assert.Equal(t, int64(1), stats["messages_confirmed"])
+}
+
+func TestHybridClientPredictSyncOverRabbitMQ(t *testing.T) {
+	broker := newFakeBroker(true)
+	var requests int32
+	// The ML service answers every prediction request on its reply_to queue
+	broker.onPublish = func(queue string, msg amqp.Publishing) {
+		if queue != ml.PredictionRequestQueue {
+			return
+		}
+		atomic.AddInt32(&requests, 1)
+		var request ml.PredictionRequest
+		if err := json.Unmarshal(msg.Body, &request); err != nil {
+			return
+		}
+		reply, _ := json.Marshal(map[string]interface{}{
+			"prediction":     0.42,
+			"correlation_id": request.CorrelationID,
+			"model_name":     "diabetes-risk-xgb",
+			"model_version":  "2024.06.1",
+			"explanation": map[string]interface{}{
+				"BMI": map[string]interface{}{"shap": 0.3, "contribution": 0.25, "impact": 1},
+			},
+		})
+		broker.deliver(msg.ReplyTo, amqp.Delivery{CorrelationId: msg.CorrelationId, Body: reply})
+	}
+
+	// No gRPC address: every call type goes over RabbitMQ
+	hybrid, err := ml.NewHybridMLClient(ml.HybridConfig{
+		Transports: ml.TransportConfig{
+			ml.CallPrediction: ml.TransportRabbitMQ,
+			ml.CallWhatIf:     ml.TransportRabbitMQ,
+			ml.CallHealth:     ml.TransportRabbitMQ,
+		},
+		RabbitMQURL:    "amqp://fake",
+		RabbitMQDialer: broker.Dial,
+	})
+	require.NoError(t, err)
+	breaker := ml.NewCircuitBreakerClient(hybrid, nil, ml.CircuitBreakerConfig{FailureThreshold: 5})
+	defer breaker.Close()
+
+	features := []float64{45, 0, 1, 0, 3, 1, 0, 27.5, 0}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	result, err := breaker.PredictSync(ctx, features)
+	require.NoError(t, err)
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&requests))
+	assert.InDelta(t, 0.42, result.Prediction, 1e-9)
+	assert.Equal(t, "2024.06.1", result.ModelVersion)
+	assert.Equal(t, ml.HashFeatures(features), result.InputHash)
+	assert.InDelta(t, 27.5, result.Explanation["BMI"].Value, 1e-9)
+	assert.InDelta(t, 0.3, result.Explanation["BMI"].Shap, 1e-9)
+	assert.Equal(t, ml.BreakerClosed, breaker.State())
+}
+
+func TestRabbitMQPredictSyncTimesOut(t *testing.T) {
+	broker := newFakeBroker(true)
+	client, err := ml.NewAsyncMLClientWithDialer("amqp://fake", "", broker.Dial)
+	require.NoError(t, err)
+	defer client.Close()
+
+	predictor, ok := client.(ml.SyncPredictor)
+	require.True(t, ok, "the RabbitMQ client scores synchronously")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = predictor.PredictSync(ctx, []float64{45, 0, 1, 0, 3, 1, 0, 27.5, 0})
+	assert.ErrorIs(t, err, ml.ErrSyncPredictionTimeout)
+}
@@ -0,0 +1,212 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"diabetify/internal/models"
+	"diabetify/internal/services"
+	"diabetify/tests/mocks"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// trendPredictions are weekly predictions from Monday 2024-01-01 whose risk jumps on
+// 2024-01-29, when the user's BMI rose and they started smoking
+func trendPredictions() []models.Prediction {
+	risks := []float64{0.30, 0.31, 0.29, 0.30, 0.42, 0.41, 0.43, 0.42}
+	start := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+	predictions := make([]models.Prediction, len(risks))
+	// Newest first, as the repository returns them
+	for i, risk := range risks {
+		prediction := models.Prediction{
+			ID:        uint(i + 1),
+			UserID:    1,
+			CreatedAt: start.AddDate(0, 0, 7*i),
+			RiskScore: risk,
+			Factors: []models.PredictionFactor{
+				{Factor: "age", Value: 50},
+				{Factor: "bmi", Value: 24},
+				{Factor: "smoking_status", Value: 0},
+			},
+		}
+		if i >= 4 {
+			prediction.Factors[1].Value = 27.5
+			prediction.Factors[2].Value = 2
+		}
+		predictions[len(risks)-1-i] = prediction
+	}
+	return predictions
+}
+
+func trendActivities() []models.Activity {
+	day := func(month time.Month, d int) time.Time { return time.Date(2024, month, d, 7, 0, 0, 0, time.UTC) }
+	var activities []models.Activity
+	// Windows are the 28 days before each prediction at 09:00: the earlier one holds all five
+	// workouts, the later one only those of 8 and 15 January
+	for _, date := range []time.Time{day(12, 26).AddDate(-1, 0, 0), day(12, 27).AddDate(-1, 0, 0), day(1, 1), day(1, 8), day(1, 15)} {
+		activities = append(activities, models.Activity{UserID: 1, ActivityType: "workout", ActivityDate: date, Value: 90})
+	}
+	for _, date := range []time.Time{day(1, 23), day(1, 24)} {
+		activities = append(activities, models.Activity{UserID: 1, ActivityType: "smoke", ActivityDate: date, Value: 10})
+	}
+	return activities
+}
+
+func TestAnalyzeRiskTrend(t *testing.T) {
+	trend := services.AnalyzeRiskTrend(trendPredictions(), trendActivities(), services.RiskTrendOptions{
+		Interval: models.TrendIntervalWeek,
+		Window:   3,
+	})
+
+	require.Len(t, trend.Points, 8)
+	assert.Equal(t, uint(1), trend.Points[0].PredictionID, "oldest first")
+	assert.InDelta(t, 0.30, trend.Points[0].MovingAverage, 1e-9)
+	assert.InDelta(t, 0.30, trend.Points[2].MovingAverage, 1e-9)
+	assert.InDelta(t, (0.30+0.42+0.41)/3, trend.Points[5].MovingAverage, 1e-9)
+	for _, point := range trend.Points {
+		require.NotNil(t, point.Fitted)
+		assert.LessOrEqual(t, *point.Lower, *point.Fitted)
+		assert.GreaterOrEqual(t, *point.Upper, *point.Fitted)
+	}
+
+	assert.Len(t, trend.Aggregates, 8, "one prediction per week")
+	assert.Equal(t, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), trend.Aggregates[0].PeriodStart)
+
+	require.NotNil(t, trend.Trend)
+	assert.Greater(t, trend.Trend.SlopePer30Days, 0.0)
+	assert.LessOrEqual(t, trend.Trend.SlopeLower, trend.Trend.SlopePer30Days)
+	assert.Equal(t, models.TrendRising, trend.Trend.Direction)
+
+	require.Len(t, trend.ChangePoints, 1)
+	change := trend.ChangePoints[0]
+	assert.Equal(t, uint(5), change.PredictionID)
+	assert.Equal(t, time.Date(2024, 1, 22, 9, 0, 0, 0, time.UTC), change.WindowStart)
+	assert.InDelta(t, 0.30, change.BeforeMean, 1e-9)
+	assert.InDelta(t, 0.42, change.AfterMean, 1e-9)
+	assert.InDelta(t, 0.12, change.Shift, 1e-9)
+
+	assert.Equal(t, []models.RiskChangeAnnotation{
+		{Kind: models.AnnotationProfile, Field: "bmi", From: 24, To: 27.5, Description: "bmi changed from 24 to 27.5"},
+		{Kind: models.AnnotationProfile, Field: "smoking_status", From: 0, To: 2, Description: "smoking_status changed from 0 to 2"},
+		{Kind: models.AnnotationActivity, Field: "smoke", From: 0, To: 5, Description: "smoke rose from 0.0 to 5.0 per week"},
+		{Kind: models.AnnotationActivity, Field: "workout", From: 112.5, To: 45, Description: "workout fell from 112.5 to 45.0 per week"},
+	}, change.Annotations)
+}
+
+func TestAnalyzeRiskTrendMonthly(t *testing.T) {
+	trend := services.AnalyzeRiskTrend(trendPredictions(), nil, services.RiskTrendOptions{
+		Interval: models.TrendIntervalMonth,
+		Window:   3,
+	})
+
+	require.Len(t, trend.Aggregates, 2)
+	assert.Equal(t, 5, trend.Aggregates[0].Count)
+	assert.InDelta(t, (0.30+0.31+0.29+0.30+0.42)/5, trend.Aggregates[0].Mean, 1e-9)
+	assert.InDelta(t, 0.29, trend.Aggregates[0].Min, 1e-9)
+	assert.InDelta(t, 0.42, trend.Aggregates[0].Max, 1e-9)
+	assert.Equal(t, time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC), trend.Aggregates[1].PeriodStart)
+	assert.Equal(t, 3, trend.Aggregates[1].Count)
+}
+
+func TestAnalyzeRiskTrendFlatAndSparse(t *testing.T) {
+	t.Run("flat series has no change point", func(t *testing.T) {
+		predictions := trendPredictions()
+		for i := range predictions {
+			predictions[i].RiskScore = 0.3
+		}
+
+		trend := services.AnalyzeRiskTrend(predictions, nil, services.RiskTrendOptions{Interval: models.TrendIntervalWeek, Window: 3})
+
+		assert.Empty(t, trend.ChangePoints)
+		require.NotNil(t, trend.Trend)
+		assert.Equal(t, models.TrendStable, trend.Trend.Direction)
+	})
+
+	t.Run("two points have no fit", func(t *testing.T) {
+		trend := services.AnalyzeRiskTrend(trendPredictions()[:2], nil, services.RiskTrendOptions{Interval: models.TrendIntervalWeek, Window: 3})
+
+		assert.Len(t, trend.Points, 2)
+		assert.Nil(t, trend.Trend)
+		assert.Nil(t, trend.Points[0].Fitted)
+		assert.Empty(t, trend.ChangePoints)
+	})
+}
+
+func TestGetRiskTrend(t *testing.T) {
+	tests := []struct {
+		name           string
+		query          string
+		setupMocks     func(*mocks.MockPredictionRepository, *mocks.MockActivityRepository)
+		expectedStatus int
+	}{
+		{
+			name:  "success",
+			query: "?start_date=2024-01-01&end_date=2024-02-29&interval=month",
+			setupMocks: func(predRepo *mocks.MockPredictionRepository, activityRepo *mocks.MockActivityRepository) {
+				start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+				end := time.Date(2024, 2, 29, 23, 59, 59, 0, time.UTC)
+				predRepo.On("GetPredictionsByUserIDAndDateRange", uint(1), start, end).Return(trendPredictions(), nil)
+				activityRepo.On("FindByUserIDAndActivityDateRange", uint(1), start.Add(-services.TrendActivityWindow), end).Return(trendActivities(), nil)
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:  "default range",
+			query: "",
+			setupMocks: func(predRepo *mocks.MockPredictionRepository, activityRepo *mocks.MockActivityRepository) {
+				predRepo.On("GetPredictionsByUserIDAndDateRange", uint(1), mock.AnythingOfType("time.Time"), mock.AnythingOfType("time.Time")).Return([]models.Prediction{}, nil)
+				activityRepo.On("FindByUserIDAndActivityDateRange", uint(1), mock.AnythingOfType("time.Time"), mock.AnythingOfType("time.Time")).Return([]models.Activity{}, nil)
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "invalid interval",
+			query:          "?interval=day",
+			setupMocks:     func(*mocks.MockPredictionRepository, *mocks.MockActivityRepository) {},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "invalid window",
+			query:          "?window=0",
+			setupMocks:     func(*mocks.MockPredictionRepository, *mocks.MockActivityRepository) {},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "start after end",
+			query:          "?start_date=2024-03-01&end_date=2024-02-01",
+			setupMocks:     func(*mocks.MockPredictionRepository, *mocks.MockActivityRepository) {},
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			controller, predRepo, _, _, activityRepo, _, _, _ := setupPredictionControllerWithMocks()
+			tt.setupMocks(predRepo, activityRepo)
+			router := setupPredictionTestRouter()
+			router.Use(addPredictionAuthMiddleware(1))
+			router.GET("/prediction/me/trend", controller.GetRiskTrend)
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest("GET", "/prediction/me/trend"+tt.query, nil))
+
+			assert.Equal(t, tt.expectedStatus, w.Code, w.Body.String())
+			if tt.name == "success" {
+				var response struct {
+					Data models.RiskTrend `json:"data"`
+				}
+				require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+				assert.Len(t, response.Data.Aggregates, 2)
+				assert.Len(t, response.Data.ChangePoints, 1)
+			}
+			predRepo.AssertExpectations(t)
+			activityRepo.AssertExpectations(t)
+		})
+	}
+}
@@ -0,0 +1,176 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"diabetify/internal/models"
+	"diabetify/internal/openai"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// templateCase is a prediction fed to the template explainer; factors map a feature
+// name to its value, SHAP value and contribution
+type templateCase struct {
+	name    string
+	risk    float64
+	factors map[string][3]float64
+}
+
+var templateCases = []templateCase{
+	{
+		name: "high_risk",
+		risk: 0.68,
+		factors: map[string][3]float64{
+			"age":                         {58, 0.11, 0.28},
+			"bmi":                         {31.2, 0.09, 0.23},
+			"is_hypertension":             {1, 0.045, 0.12},
+			"smoking_status":              {2, 0.03, 0.08},
+			"brinkman_score":              {2, 0.02, 0.05},
+			"is_bloodline":                {1, 0.015, 0.04},
+			"is_cholesterol":              {0, -0.01, 0.09},
+			"is_macrosomic_baby":          {2, 0.0005, 0.01},
+			"physical_activity_frequency": {1, 0.008, 0.02},
+		},
+	},
+	{
+		name: "low_risk",
+		risk: 0.18,
+		factors: map[string][3]float64{
+			"age":                         {28, -0.09, 0.30},
+			"bmi":                         {21.4, -0.05, 0.20},
+			"is_hypertension":             {0, -0.03, 0.12},
+			"is_macrosomic_baby":          {0, -0.02, 0.10},
+			"physical_activity_frequency": {5, -0.02, 0.08},
+			"brinkman_score":              {0, -0.012, 0.06},
+			"smoking_status":              {0, -0.01, 0.05},
+			"is_cholesterol":              {0, -0.006, 0.03},
+			"is_bloodline":                {0, -0.004, 0.02},
+		},
+	},
+	{
+		name: "partial",
+		risk: 0.45,
+		factors: map[string][3]float64{
+			"age":            {45, 0.04, 0.50},
+			"bmi":            {17.9, -0.03, 0.45},
+			"smoking_status": {1, 0, 0},
+		},
+	},
+}
+
+func (c templateCase) inputs() map[string]openai.FactorInput {
+	inputs := make(map[string]openai.FactorInput, len(c.factors))
+	for name, values := range c.factors {
+		feature, _ := models.FeatureByName(name)
+		inputs[name] = openai.FactorInput{
+			Value:        feature.FormatValue(values[0]),
+			RawValue:     values[0],
+			Shap:         values[1],
+			Contribution: values[2],
+		}
+	}
+	return inputs
+}
+
+// goldenExplanation is what the golden files hold
+type goldenExplanation struct {
+	Summary string            `json:"summary"`
+	Factors map[string]string `json:"factors"`
+}
+
+func TestTemplateExplainerGolden(t *testing.T) {
+	languages, err := openai.TemplateLanguages()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"en", "id"}, languages)
+
+	for _, language := range languages {
+		explainer := newTemplateExplainer(t, language)
+		for _, tc := range templateCases {
+			t.Run(tc.name+"_"+language, func(t *testing.T) {
+				explanation, err := explainer.Explain(context.Background(), tc.risk, tc.inputs())
+				require.NoError(t, err)
+				assert.Equal(t, openai.ProviderTemplate, explanation.Provider)
+				assert.Len(t, explanation.Factors, len(tc.factors))
+				assert.NoError(t, openai.CheckExplanation(explanation, tc.inputs()), "template explanations pass the checks")
+
+				got := goldenExplanation{Summary: explanation.Summary, Factors: map[string]string{}}
+				for name, factor := range explanation.Factors {
+					got.Factors[name] = factor.Explanation
+				}
+				data, err := json.MarshalIndent(got, "", "  ")
+				require.NoError(t, err)
+				data = append(data, '\n')
+
+				path := filepath.Join("testdata", "explanations", tc.name+"."+language+".golden.json")
+				if *updateGolden {
+					require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+					require.NoError(t, os.WriteFile(path, data, 0o644))
+				}
+				want, err := os.ReadFile(path)
+				require.NoError(t, err, "run go test ./tests -run TestTemplateExplainerGolden -update to create the golden file")
+				assert.Equal(t, string(want), string(data))
+			})
+		}
+	}
+}
+
+func TestTemplateExplainerIsDeterministic(t *testing.T) {
+	explainer := newTemplateExplainer(t, "en")
+	tc := templateCases[0]
+
+	first, err := explainer.Explain(context.Background(), tc.risk, tc.inputs())
+	require.NoError(t, err)
+	for i := 0; i < 20; i++ {
+		again, err := explainer.Explain(context.Background(), tc.risk, tc.inputs())
+		require.NoError(t, err)
+		assert.Equal(t, first.Summary, again.Summary)
+		assert.Equal(t, first.Factors, again.Factors)
+	}
+}
+
+func TestNewTemplateExplainer(t *testing.T) {
+	explainer, err := openai.NewTemplateExplainer("EN")
+	require.NoError(t, err)
+	assert.Equal(t, "en", explainer.Language())
+
+	_, err = openai.NewTemplateExplainer("fr")
+	assert.Error(t, err)
+
+	_, err = newTemplateExplainer(t, "id").Explain(context.Background(), 0.5, map[string]openai.FactorInput{"unknown": {}})
+	assert.Error(t, err)
+}
+
+func TestFormatFeatureValue(t *testing.T) {
+	tests := []struct {
+		language string
+		feature  string
+		value    float64
+		expected string
+	}{
+		{"id", "age", 58, "58 tahun"},
+		{"id", "bmi", 27.44, "27.4"},
+		{"id", "is_hypertension", 1, "ya"},
+		{"id", "brinkman_score", 2, "perokok sedang"},
+		{"en", "physical_activity_frequency", 1, "1 time per week"},
+		{"en", "physical_activity_frequency", 3, "3 times per week"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.language+" "+tt.feature, func(t *testing.T) {
+			feature, ok := models.FeatureByName(tt.feature)
+			require.True(t, ok)
+			formatted, err := openai.FormatFeatureValue(tt.language, feature, tt.value)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, formatted)
+		})
+	}
+}
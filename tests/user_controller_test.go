@@ -19,6 +19,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"gorm.io/gorm"
 )
 
 // Test helper functions
@@ -515,3 +516,111 @@ func TestResetPassword(t *testing.T) {
 		})
 	}
 }
+
+func TestUpdateResearchConsent(t *testing.T) {
+	given, withdrawn := true, false
+
+	tests := []struct {
+		name           string
+		requestBody    interface{}
+		setupMocks     func(*mocks.MockUserRepository)
+		expectedStatus int
+		expectedMsg    string
+		expectConsent  *bool
+	}{
+		{
+			name:        "give consent",
+			requestBody: map[string]interface{}{"consent": true},
+			setupMocks: func(userRepo *mocks.MockUserRepository) {
+				userRepo.On("UpdateResearchConsent", uint(1), true, mock.AnythingOfType("time.Time")).Return(nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedMsg:    "Research consent updated successfully",
+			expectConsent:  &given,
+		},
+		{
+			name:        "withdraw consent",
+			requestBody: map[string]interface{}{"consent": false},
+			setupMocks: func(userRepo *mocks.MockUserRepository) {
+				userRepo.On("UpdateResearchConsent", uint(1), false, mock.AnythingOfType("time.Time")).Return(nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedMsg:    "Research consent updated successfully",
+			expectConsent:  &withdrawn,
+		},
+		{
+			name:           "missing consent",
+			requestBody:    map[string]interface{}{},
+			setupMocks:     func(userRepo *mocks.MockUserRepository) {},
+			expectedStatus: http.StatusBadRequest,
+			expectedMsg:    "Invalid request data",
+		},
+		{
+			name:        "user not found",
+			requestBody: map[string]interface{}{"consent": true},
+			setupMocks: func(userRepo *mocks.MockUserRepository) {
+				userRepo.On("UpdateResearchConsent", uint(1), true, mock.AnythingOfType("time.Time")).Return(gorm.ErrRecordNotFound)
+			},
+			expectedStatus: http.StatusNotFound,
+			expectedMsg:    "User not found",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			controller, userRepo, resetRepo := setupUserControllerWithMocks()
+			tt.setupMocks(userRepo)
+
+			router := setupUserTestRouter()
+			router.Use(addUserAuthMiddleware(1))
+			router.PUT("/users/me/research-consent", controller.UpdateResearchConsent)
+
+			body, _ := json.Marshal(tt.requestBody)
+			req := httptest.NewRequest("PUT", "/users/me/research-consent", bytes.NewBuffer(body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+
+			var response map[string]interface{}
+			err := json.Unmarshal(w.Body.Bytes(), &response)
+			assert.NoError(t, err)
+			assert.Contains(t, response["message"], tt.expectedMsg)
+
+			if tt.expectConsent != nil {
+				data := response["data"].(map[string]interface{})
+				assert.Equal(t, *tt.expectConsent, data["research_consent"])
+				assert.NotEmpty(t, data["research_consent_at"])
+			}
+
+			userRepo.AssertExpectations(t)
+			resetRepo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestPatchUserRejectsResearchConsent(t *testing.T) {
+	for _, key := range []string{"research_consent", "ResearchConsent", "research_consent_at"} {
+		t.Run(key, func(t *testing.T) {
+			controller, userRepo, resetRepo := setupUserControllerWithMocks()
+			userRepo.On("GetUserByID", uint(1)).Return(&models.User{ID: 1, Email: "john@example.com"}, nil)
+
+			router := setupUserTestRouter()
+			router.Use(addUserAuthMiddleware(1))
+			router.PATCH("/users/me", controller.PatchUser)
+
+			body, _ := json.Marshal(map[string]interface{}{"name": "John", key: true})
+			req := httptest.NewRequest("PATCH", "/users/me", bytes.NewBuffer(body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+			userRepo.AssertNotCalled(t, "PatchUser", mock.Anything, mock.Anything)
+			resetRepo.AssertExpectations(t)
+		})
+	}
+}
@@ -0,0 +1,253 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"diabetify/internal/controllers"
+	"diabetify/internal/models"
+	"diabetify/tests/mocks"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func setupWhatIfScenarioRouter(repo *mocks.MockWhatIfScenarioRepository, jobWorker *mocks.MockPredictionJobWorker) *gin.Engine {
+	controller := controllers.NewWhatIfScenarioController(repo, jobWorker)
+	router := setupPredictionTestRouter()
+	router.Use(addPredictionAuthMiddleware(1))
+	router.GET("/prediction/what-if/scenarios", controller.GetScenarios)
+	router.GET("/prediction/what-if/scenarios/:id", controller.GetScenario)
+	router.PATCH("/prediction/what-if/scenarios/:id", controller.UpdateScenario)
+	router.DELETE("/prediction/what-if/scenarios/:id", controller.DeleteScenario)
+	return router
+}
+
+func completedScenario() *models.WhatIfScenario {
+	risk := 0.31
+	baselineRisk := 0.42
+	baselineID := uint(7)
+	completedAt := time.Date(2024, 6, 1, 10, 0, 5, 0, time.UTC)
+	return &models.WhatIfScenario{
+		ID:                   3,
+		UserID:               1,
+		JobID:                "what-if-job",
+		Input:                models.WhatIfInput{Weight: 70, PhysicalActivityFrequency: 4},
+		Features:             localScorerFeatures,
+		BaselinePredictionID: &baselineID,
+		BaselineRiskScore:    &baselineRisk,
+		RiskScore:            &risk,
+		ModelVersion:         "test-1",
+		CompletedAt:          &completedAt,
+	}
+}
+
+func TestGetWhatIfScenarios(t *testing.T) {
+	tests := []struct {
+		name           string
+		query          string
+		setupMock      func(*mocks.MockWhatIfScenarioRepository)
+		expectedStatus int
+		expectedCount  int
+	}{
+		{
+			name:  "default limit",
+			query: "",
+			setupMock: func(repo *mocks.MockWhatIfScenarioRepository) {
+				repo.On("GetScenariosByUserID", uint(1), false, 20).Return([]models.WhatIfScenario{*completedScenario()}, nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedCount:  1,
+		},
+		{
+			name:  "pinned only",
+			query: "?pinned=true&limit=5",
+			setupMock: func(repo *mocks.MockWhatIfScenarioRepository) {
+				repo.On("GetScenariosByUserID", uint(1), true, 5).Return([]models.WhatIfScenario{}, nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedCount:  0,
+		},
+		{
+			name:           "limit too large",
+			query:          "?limit=500",
+			setupMock:      func(*mocks.MockWhatIfScenarioRepository) {},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "invalid pinned",
+			query:          "?pinned=maybe",
+			setupMock:      func(*mocks.MockWhatIfScenarioRepository) {},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:  "repository error",
+			query: "",
+			setupMock: func(repo *mocks.MockWhatIfScenarioRepository) {
+				repo.On("GetScenariosByUserID", uint(1), false, 20).Return([]models.WhatIfScenario{}, errors.New("database down"))
+			},
+			expectedStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := new(mocks.MockWhatIfScenarioRepository)
+			tt.setupMock(repo)
+			router := setupWhatIfScenarioRouter(repo, new(mocks.MockPredictionJobWorker))
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest("GET", "/prediction/what-if/scenarios"+tt.query, nil))
+
+			assert.Equal(t, tt.expectedStatus, w.Code, w.Body.String())
+			if tt.expectedStatus == http.StatusOK {
+				var response struct {
+					Data []models.WhatIfScenario `json:"data"`
+				}
+				require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+				assert.Len(t, response.Data, tt.expectedCount)
+			}
+			repo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestUpdateWhatIfScenario(t *testing.T) {
+	tests := []struct {
+		name           string
+		id             string
+		body           string
+		setupMocks     func(*mocks.MockWhatIfScenarioRepository, *mocks.MockPredictionJobWorker)
+		expectedStatus int
+	}{
+		{
+			name: "name and pin",
+			id:   "3",
+			body: `{"name": "Lose 10 kg", "pinned": true}`,
+			setupMocks: func(repo *mocks.MockWhatIfScenarioRepository, jobWorker *mocks.MockPredictionJobWorker) {
+				repo.On("GetScenarioByID", uint(1), uint(3)).Return(completedScenario(), nil)
+				repo.On("SaveScenario", mock.MatchedBy(func(s *models.WhatIfScenario) bool {
+					return s.Name == "Lose 10 kg" && s.Pinned && s.ModelVersion == "test-1"
+				})).Return(nil)
+				jobWorker.On("InvalidateWhatIfResult", "what-if-job").Return()
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name: "unpin keeps the name",
+			id:   "3",
+			body: `{"pinned": false}`,
+			setupMocks: func(repo *mocks.MockWhatIfScenarioRepository, jobWorker *mocks.MockPredictionJobWorker) {
+				scenario := completedScenario()
+				scenario.Name = "Quit smoking"
+				scenario.Pinned = true
+				repo.On("GetScenarioByID", uint(1), uint(3)).Return(scenario, nil)
+				repo.On("SaveScenario", mock.MatchedBy(func(s *models.WhatIfScenario) bool {
+					return s.Name == "Quit smoking" && !s.Pinned
+				})).Return(nil)
+				jobWorker.On("InvalidateWhatIfResult", "what-if-job").Return()
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "name too long",
+			id:             "3",
+			body:           `{"name": "` + strings.Repeat("n", 101) + `"}`,
+			setupMocks:     func(*mocks.MockWhatIfScenarioRepository, *mocks.MockPredictionJobWorker) {},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "invalid id",
+			id:             "abc",
+			body:           `{"pinned": true}`,
+			setupMocks:     func(*mocks.MockWhatIfScenarioRepository, *mocks.MockPredictionJobWorker) {},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name: "scenario of another user",
+			id:   "9",
+			body: `{"pinned": true}`,
+			setupMocks: func(repo *mocks.MockWhatIfScenarioRepository, jobWorker *mocks.MockPredictionJobWorker) {
+				repo.On("GetScenarioByID", uint(1), uint(9)).Return(nil, gorm.ErrRecordNotFound)
+			},
+			expectedStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := new(mocks.MockWhatIfScenarioRepository)
+			jobWorker := new(mocks.MockPredictionJobWorker)
+			tt.setupMocks(repo, jobWorker)
+			router := setupWhatIfScenarioRouter(repo, jobWorker)
+
+			req := httptest.NewRequest("PATCH", "/prediction/what-if/scenarios/"+tt.id, bytes.NewBufferString(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code, w.Body.String())
+			if tt.expectedStatus != http.StatusOK {
+				repo.AssertNotCalled(t, "SaveScenario", mock.Anything)
+			}
+			repo.AssertExpectations(t)
+			jobWorker.AssertExpectations(t)
+		})
+	}
+}
+
+func TestDeleteWhatIfScenario(t *testing.T) {
+	t.Run("deletes and drops the cached result", func(t *testing.T) {
+		repo := new(mocks.MockWhatIfScenarioRepository)
+		jobWorker := new(mocks.MockPredictionJobWorker)
+		repo.On("GetScenarioByID", uint(1), uint(3)).Return(completedScenario(), nil)
+		repo.On("DeleteScenario", uint(1), uint(3)).Return(nil)
+		jobWorker.On("InvalidateWhatIfResult", "what-if-job").Return()
+
+		w := httptest.NewRecorder()
+		setupWhatIfScenarioRouter(repo, jobWorker).ServeHTTP(w, httptest.NewRequest("DELETE", "/prediction/what-if/scenarios/3", nil))
+
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		repo.AssertExpectations(t)
+		jobWorker.AssertExpectations(t)
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		repo := new(mocks.MockWhatIfScenarioRepository)
+		jobWorker := new(mocks.MockPredictionJobWorker)
+		repo.On("GetScenarioByID", uint(1), uint(4)).Return(nil, gorm.ErrRecordNotFound)
+
+		w := httptest.NewRecorder()
+		setupWhatIfScenarioRouter(repo, jobWorker).ServeHTTP(w, httptest.NewRequest("DELETE", "/prediction/what-if/scenarios/4", nil))
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		repo.AssertNotCalled(t, "DeleteScenario", mock.Anything, mock.Anything)
+		jobWorker.AssertNotCalled(t, "InvalidateWhatIfResult", mock.Anything)
+	})
+}
+
+func TestWhatIfScenarioResult(t *testing.T) {
+	result := completedScenario().Result()
+
+	assert.Equal(t, "what-if-job", result["job_id"])
+	assert.Equal(t, models.JobTypeWhatIf, result["job_type"])
+	assert.Equal(t, uint(3), result["scenario_id"])
+	assert.InDelta(t, 0.31, result["risk_score"], 1e-9)
+	assert.InDelta(t, 31.0, result["risk_percentage"], 1e-9)
+	assert.InDelta(t, 0.42, result["baseline_risk_score"], 1e-9)
+	assert.InDelta(t, -0.11, result["risk_change"], 1e-9)
+
+	pending := &models.WhatIfScenario{JobID: "pending-job"}
+	result = pending.Result()
+	assert.NotContains(t, result, "risk_score")
+	assert.NotContains(t, result, "risk_change")
+}
@@ -0,0 +1,331 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"diabetify/internal/controllers"
+	"diabetify/internal/ml"
+	"diabetify/internal/models"
+	"diabetify/internal/services"
+	"diabetify/tests/mocks"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// sweepFeatureSource derives BMI from the what-if weight (height 1.7 m) and takes the
+// activity frequency as is, so every point of a sweep has distinct features
+type sweepFeatureSource struct {
+	err   error
+	calls int
+}
+
+func (s *sweepFeatureSource) FeaturesForWhatIf(userID uint, input *models.WhatIfInput) ([]float64, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	features := append([]float64{}, localScorerFeatures...)
+	features[4] = float64(input.PhysicalActivityFrequency)
+	features[7] = input.Weight / (1.7 * 1.7)
+	return features, nil
+}
+
+func (s *sweepFeatureSource) FeaturesForWhatIfs(userID uint, inputs []models.WhatIfInput) ([][]float64, error) {
+	s.calls++
+	features := make([][]float64, len(inputs))
+	for i := range inputs {
+		var err error
+		if features[i], err = s.FeaturesForWhatIf(userID, &inputs[i]); err != nil {
+			return nil, err
+		}
+	}
+	return features, nil
+}
+
+func TestExpandSweepAxis(t *testing.T) {
+	tests := []struct {
+		name     string
+		axis     models.WhatIfSweepAxis
+		expected []float64
+		wantErr  error
+	}{
+		{name: "weight downwards", axis: models.WhatIfSweepAxis{Field: "weight", From: 90, To: 70, Step: 5}, expected: []float64{90, 85, 80, 75, 70}},
+		{name: "to off the step is left out", axis: models.WhatIfSweepAxis{Field: "weight", From: 70, To: 72, Step: 1.5}, expected: []float64{70, 71.5}},
+		{name: "fractional steps keep the end", axis: models.WhatIfSweepAxis{Field: "weight", From: 70, To: 70.3, Step: 0.1}, expected: []float64{70, 70.1, 70.2, 70.3}},
+		{name: "workouts per week", axis: models.WhatIfSweepAxis{Field: "physical_activity_frequency", From: 0, To: 7, Step: 1}, expected: []float64{0, 1, 2, 3, 4, 5, 6, 7}},
+		{name: "boolean", axis: models.WhatIfSweepAxis{Field: "is_hypertension", From: 0, To: 1, Step: 1}, expected: []float64{0, 1}},
+		{name: "fractional workouts", axis: models.WhatIfSweepAxis{Field: "physical_activity_frequency", From: 0, To: 3, Step: 0.5}, wantErr: services.ErrInvalidSweep},
+		{name: "smoking status out of range", axis: models.WhatIfSweepAxis{Field: "smoking_status", From: 0, To: 3, Step: 1}, wantErr: services.ErrInvalidSweep},
+		{name: "unknown field", axis: models.WhatIfSweepAxis{Field: "age", From: 30, To: 40, Step: 1}, wantErr: services.ErrInvalidSweep},
+		{name: "too many points", axis: models.WhatIfSweepAxis{Field: "weight", From: 50, To: 150, Step: 0.5}, wantErr: services.ErrSweepTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values, err := services.ExpandSweepAxis(tt.axis, 121)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, values)
+		})
+	}
+}
+
+func TestWhatIfSweepService(t *testing.T) {
+	scorer, err := ml.NewLocalScorerFromFile("testdata/local_model_lr.json")
+	require.NoError(t, err)
+	features := &sweepFeatureSource{}
+	base := models.WhatIfInput{Weight: 90, PhysicalActivityFrequency: 1}
+
+	newService := func(jobRepo *mocks.MockPredictionJobRepository, predictor ml.SyncPredictor) services.WhatIfSweepService {
+		cfg := services.WhatIfSweepConfig{Concurrency: 2, MaxPoints: 20, ItemTimeout: time.Second}
+		queued := services.NewQueuedScorer(newGoroutineJobQueue(), predictor)
+		quota := services.NewPredictionQuota(jobRepo, services.PredictionQuotaConfig{})
+		return services.NewWhatIfSweepService(jobRepo, features, queued, quota, cfg)
+	}
+	expectJobs := func(jobRepo *mocks.MockPredictionJobRepository, children *int32) {
+		jobRepo.On("SaveJob", mock.MatchedBy(func(j *models.PredictionJob) bool { return j.JobType == models.JobTypeWhatIfSweep })).Return(nil)
+		jobRepo.On("SaveJob", mock.MatchedBy(func(j *models.PredictionJob) bool {
+			return j.JobType == models.JobTypeBatchItem && j.ParentJobID != nil && j.UserID == 1
+		})).Run(func(mock.Arguments) { atomic.AddInt32(children, 1) }).Return(nil)
+		jobRepo.On("UpdateJobStatus", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+		jobRepo.On("UpdateBatchProgress", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	}
+
+	t.Run("one axis returns a series", func(t *testing.T) {
+		jobRepo := new(mocks.MockPredictionJobRepository)
+		var children int32
+		expectJobs(jobRepo, &children)
+		reads := features.calls
+
+		result, err := newService(jobRepo, scorer).Run(context.Background(), 1, &models.WhatIfSweepRequest{
+			Base: base,
+			Axes: []models.WhatIfSweepAxis{{Field: "weight", From: 90, To: 70, Step: 10}},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, reads+1, features.calls, "features of every point are built from one profile read")
+
+		require.Len(t, result.Points, 3)
+		assert.Nil(t, result.Grid)
+		assert.Equal(t, "test-1", result.ModelVersion)
+		assert.Equal(t, 0, result.FailedPoints)
+		assert.Equal(t, int32(3), atomic.LoadInt32(&children))
+		for i, weight := range []float64{90, 80, 70} {
+			assert.Equal(t, []float64{weight}, result.Points[i].Values)
+			input := base
+			input.Weight = weight
+			expectedFeatures, _ := features.FeaturesForWhatIf(1, &input)
+			expected, err := scorer.Score(expectedFeatures)
+			require.NoError(t, err)
+			require.NotNil(t, result.Points[i].RiskScore)
+			assert.InDelta(t, expected.Prediction, *result.Points[i].RiskScore, 1e-12)
+		}
+		jobRepo.AssertCalled(t, "UpdateBatchProgress", result.JobID, 3, 0)
+		jobRepo.AssertCalled(t, "UpdateJobStatus", result.JobID, models.JobStatusCompleted, (*string)(nil))
+	})
+
+	t.Run("two axes return a grid", func(t *testing.T) {
+		jobRepo := new(mocks.MockPredictionJobRepository)
+		var children int32
+		expectJobs(jobRepo, &children)
+
+		result, err := newService(jobRepo, scorer).Run(context.Background(), 1, &models.WhatIfSweepRequest{
+			Base: base,
+			Axes: []models.WhatIfSweepAxis{
+				{Field: "weight", From: 80, To: 70, Step: 10},
+				{Field: "physical_activity_frequency", From: 0, To: 4, Step: 2},
+			},
+		})
+		require.NoError(t, err)
+
+		require.Len(t, result.Points, 6)
+		require.Len(t, result.Grid, 2)
+		require.Len(t, result.Grid[0], 3)
+		assert.Equal(t, []float64{70, 4}, result.Points[5].Values)
+		assert.Equal(t, result.Points[5].RiskScore, result.Grid[1][2])
+		assert.Equal(t, result.Points[1].RiskScore, result.Grid[0][1])
+	})
+
+	t.Run("failed points are reported", func(t *testing.T) {
+		jobRepo := new(mocks.MockPredictionJobRepository)
+		var children int32
+		expectJobs(jobRepo, &children)
+		failing := &failingPredictor{}
+
+		result, err := newService(jobRepo, failing).Run(context.Background(), 1, &models.WhatIfSweepRequest{
+			Base: base,
+			Axes: []models.WhatIfSweepAxis{{Field: "is_cholesterol", From: 0, To: 1, Step: 1}},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, 2, result.FailedPoints)
+		assert.Equal(t, "model unavailable", result.Points[0].Error)
+		jobRepo.AssertCalled(t, "UpdateJobStatus", result.JobID, models.JobStatusFailed, mock.Anything)
+	})
+
+	t.Run("too many points in total", func(t *testing.T) {
+		jobRepo := new(mocks.MockPredictionJobRepository)
+		_, err := newService(jobRepo, scorer).Run(context.Background(), 1, &models.WhatIfSweepRequest{
+			Base: base,
+			Axes: []models.WhatIfSweepAxis{
+				{Field: "weight", From: 90, To: 70, Step: 5},
+				{Field: "physical_activity_frequency", From: 0, To: 7, Step: 1},
+			},
+		})
+		assert.ErrorIs(t, err, services.ErrSweepTooLarge)
+		jobRepo.AssertNotCalled(t, "SaveJob", mock.Anything)
+	})
+
+	t.Run("points count against the what-if quota", func(t *testing.T) {
+		jobRepo := new(mocks.MockPredictionJobRepository)
+		jobRepo.On("CountQuotaUnitsSince", uint(1), mock.Anything, mock.AnythingOfType("time.Time")).Return(int64(96), nil)
+		queue := newGoroutineJobQueue()
+		quota := services.NewPredictionQuota(jobRepo, services.PredictionQuotaConfig{DailyWhatIfs: 100})
+		cfg := services.WhatIfSweepConfig{Concurrency: 2, MaxPoints: 20, ItemTimeout: time.Second}
+		service := services.NewWhatIfSweepService(jobRepo, features, services.NewQueuedScorer(queue, scorer), quota, cfg)
+
+		_, err := service.Run(context.Background(), 1, &models.WhatIfSweepRequest{
+			Base: base,
+			Axes: []models.WhatIfSweepAxis{{Field: "weight", From: 90, To: 70, Step: 5}},
+		})
+		var exceeded *services.QuotaExceededError
+		require.True(t, errors.As(err, &exceeded), "expected a quota error, got %v", err)
+		assert.Equal(t, services.QuotaDailyWhatIfs, exceeded.Quota)
+		jobRepo.AssertNotCalled(t, "SaveJob", mock.Anything)
+		assert.Empty(t, queue.submitted())
+	})
+
+	t.Run("points are scored on the worker queue", func(t *testing.T) {
+		jobRepo := new(mocks.MockPredictionJobRepository)
+		var children int32
+		expectJobs(jobRepo, &children)
+		queue := newGoroutineJobQueue()
+		quota := services.NewPredictionQuota(jobRepo, services.PredictionQuotaConfig{})
+		cfg := services.WhatIfSweepConfig{Concurrency: 2, MaxPoints: 20, ItemTimeout: time.Second}
+		service := services.NewWhatIfSweepService(jobRepo, features, services.NewQueuedScorer(queue, scorer), quota, cfg)
+
+		_, err := service.Run(context.Background(), 1, &models.WhatIfSweepRequest{
+			Base: base,
+			Axes: []models.WhatIfSweepAxis{{Field: "weight", From: 90, To: 80, Step: 5}},
+		})
+		require.NoError(t, err)
+		submitted := queue.submitted()
+		require.Len(t, submitted, 3)
+		for _, request := range submitted {
+			assert.Equal(t, models.JobPriorityBackground, request.Priority)
+			assert.Equal(t, uint(1), request.UserID)
+		}
+	})
+
+	t.Run("points are capped to what fits in the timeout", func(t *testing.T) {
+		jobRepo := new(mocks.MockPredictionJobRepository)
+		quota := services.NewPredictionQuota(jobRepo, services.PredictionQuotaConfig{})
+		cfg := services.WhatIfSweepConfig{Concurrency: 2, MaxPoints: 121, ItemTimeout: time.Second, Timeout: 5 * time.Second}
+		service := services.NewWhatIfSweepService(jobRepo, features, services.NewQueuedScorer(newGoroutineJobQueue(), scorer), quota, cfg)
+
+		_, err := service.Run(context.Background(), 1, &models.WhatIfSweepRequest{
+			Base: base,
+			Axes: []models.WhatIfSweepAxis{{Field: "weight", From: 90, To: 79, Step: 1}},
+		})
+		assert.ErrorIs(t, err, services.ErrSweepTooLarge, "2 workers score at most 10 points in 5s")
+		jobRepo.AssertNotCalled(t, "SaveJob", mock.Anything)
+	})
+
+	t.Run("incomplete profile fails the whole sweep", func(t *testing.T) {
+		jobRepo := new(mocks.MockPredictionJobRepository)
+		quota := services.NewPredictionQuota(jobRepo, services.PredictionQuotaConfig{})
+		cfg := services.WhatIfSweepConfig{Concurrency: 2, MaxPoints: 20, ItemTimeout: time.Second}
+		failing := &sweepFeatureSource{err: errors.New("height is required but not found")}
+		service := services.NewWhatIfSweepService(jobRepo, failing, services.NewQueuedScorer(newGoroutineJobQueue(), scorer), quota, cfg)
+
+		_, err := service.Run(context.Background(), 1, &models.WhatIfSweepRequest{
+			Base: base,
+			Axes: []models.WhatIfSweepAxis{{Field: "weight", From: 90, To: 80, Step: 5}},
+		})
+		assert.ErrorIs(t, err, services.ErrSweepFeatures)
+		jobRepo.AssertNotCalled(t, "SaveJob", mock.Anything)
+	})
+
+	t.Run("same field twice", func(t *testing.T) {
+		jobRepo := new(mocks.MockPredictionJobRepository)
+		_, err := newService(jobRepo, scorer).Run(context.Background(), 1, &models.WhatIfSweepRequest{
+			Base: base,
+			Axes: []models.WhatIfSweepAxis{
+				{Field: "weight", From: 90, To: 80, Step: 5},
+				{Field: "weight", From: 70, To: 60, Step: 5},
+			},
+		})
+		assert.ErrorIs(t, err, services.ErrInvalidSweep)
+	})
+}
+
+type failingPredictor struct{}
+
+func (failingPredictor) PredictSync(ctx context.Context, features []float64) (*ml.ScoreResult, error) {
+	return nil, errors.New("model unavailable")
+}
+
+type stubSweepService struct {
+	err error
+}
+
+func (s *stubSweepService) Run(ctx context.Context, userID uint, request *models.WhatIfSweepRequest) (*models.WhatIfSweepResult, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	return &models.WhatIfSweepResult{JobID: "sweep-job", Base: request.Base}, nil
+}
+
+func TestRunWhatIfSweepController(t *testing.T) {
+	validBody := `{"base": {"weight": 90, "smoking_status": 0}, "axes": [{"field": "weight", "from": 90, "to": 70, "step": 5}]}`
+
+	tests := []struct {
+		name           string
+		body           string
+		serviceErr     error
+		expectedStatus int
+	}{
+		{name: "valid sweep", body: validBody, expectedStatus: http.StatusOK},
+		{name: "no axes", body: `{"base": {"weight": 90}, "axes": []}`, expectedStatus: http.StatusBadRequest},
+		{name: "three axes", body: `{"base": {"weight": 90}, "axes": [{"field": "weight", "step": 1}, {"field": "smoking_status", "step": 1}, {"field": "is_cholesterol", "step": 1}]}`, expectedStatus: http.StatusBadRequest},
+		{name: "unknown field", body: `{"base": {"weight": 90}, "axes": [{"field": "age", "from": 30, "to": 40, "step": 1}]}`, expectedStatus: http.StatusBadRequest},
+		{name: "missing base weight", body: `{"axes": [{"field": "smoking_status", "from": 0, "to": 2, "step": 1}]}`, expectedStatus: http.StatusBadRequest},
+		{name: "too large", body: validBody, serviceErr: services.ErrSweepTooLarge, expectedStatus: http.StatusRequestEntityTooLarge},
+		{name: "incomplete profile", body: validBody, serviceErr: services.ErrSweepFeatures, expectedStatus: http.StatusBadRequest},
+		{name: "job store down", body: validBody, serviceErr: errors.New("failed to create sweep job"), expectedStatus: http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			controller := controllers.NewWhatIfSweepController(&stubSweepService{err: tt.serviceErr})
+			router := setupPredictionTestRouter()
+			router.Use(addPredictionAuthMiddleware(1))
+			router.POST("/prediction/what-if/sweep", controller.RunWhatIfSweep)
+
+			req := httptest.NewRequest("POST", "/prediction/what-if/sweep", bytes.NewBufferString(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code, w.Body.String())
+			if tt.expectedStatus == http.StatusOK {
+				var response struct {
+					Data models.WhatIfSweepResult `json:"data"`
+				}
+				require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+				assert.Equal(t, "sweep-job", response.Data.JobID)
+				assert.Equal(t, 90.0, response.Data.Base.Weight)
+			}
+		})
+	}
+}